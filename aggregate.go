@@ -0,0 +1,398 @@
+package asio
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Drift is estimated from this many of each driver's most recent buffer switches.
+const clockWindow = 1024
+
+// Estimates the true sample rate of a driver's clock, in samples per second of system time, from the system
+// time and sample position pairs of its buffer switches. add is called on the driver thread; rate from anywhere.
+type clockEstimator struct {
+	times     [clockWindow]int64
+	positions [clockWindow]int64
+	next, n   int
+
+	bits atomic.Uint64 // math.Float64bits of the estimate; 0 until there is one
+}
+
+func (c *clockEstimator) add(t *ASIOTime) {
+	const valid = SystemTimeValid | SamplePositionValid
+	if t == nil || t.TimeInfo.Flags&valid != valid {
+		return
+	}
+	last := (c.next + clockWindow - 1) % clockWindow
+	if c.n > 0 && (t.TimeInfo.SamplePosition <= c.positions[last] || t.TimeInfo.SystemTime <= c.times[last]) {
+		// The driver restarted or was reset; what came before says nothing about the clock now.
+		c.n = 0
+	}
+	c.times[c.next] = t.TimeInfo.SystemTime
+	c.positions[c.next] = t.TimeInfo.SamplePosition
+	c.next = (c.next + 1) % clockWindow
+	c.n = min(c.n+1, clockWindow)
+	if c.n < 16 {
+		return
+	}
+
+	// Least squares fit of position against time. Buffer switch times are jittery, but the jitter averages
+	// out over the window; both are taken relative to the oldest pair to keep the sums precise.
+	first := (c.next + clockWindow - c.n) % clockWindow
+	var st, sp, stt, stp float64
+	for i := 0; i < c.n; i++ {
+		j := (first + i) % clockWindow
+		x := float64(c.times[j]-c.times[first]) / 1e9
+		y := float64(c.positions[j] - c.positions[first])
+		st += x
+		sp += y
+		stt += x * x
+		stp += x * y
+	}
+	n := float64(c.n)
+	if d := n*stt - st*st; d > 0 {
+		c.bits.Store(math.Float64bits((n*stp - st*sp) / d))
+	}
+}
+
+func (c *clockEstimator) rate() float64 { return math.Float64frombits(c.bits.Load()) }
+
+type AggregateOptions struct {
+	Primary, Secondary StreamOptions
+	Quality            ResampleQuality // of the resampling that carries the secondary's channels
+}
+
+// Aggregate runs two drivers that are not clocked together as one stream. Its channels are the primary's
+// followed by the secondary's, and its processors run on the primary's buffer switches. The secondary's audio
+// crosses between the two driver threads through FIFOs and adaptive resamplers, which follow the drift between
+// the clocks as estimated from the system time and sample position of each driver's buffer switches, and keep
+// the FIFOs near half full.
+//
+// The secondary's channels have no RawInput or RawOutput, and arrive later than the primary's by Latency.
+type Aggregate struct {
+	primary, secondary *Stream
+	inputs, outputs    []*ChannelInfo
+
+	processors atomic.Pointer[[]Processor]
+	taps       atomic.Pointer[[]Processor]
+
+	primaryClock, secondaryClock clockEstimator
+	drift                        atomic.Uint64 // math.Float64bits of the drift in ppm
+	secondaryTime                atomic.Int64  // system time of the secondary's latest buffer switch
+
+	// The secondary's inputs and outputs, at its rate. Each is primed once it reaches target frames.
+	inFifo, outFifo     *frameRing
+	inPrimed, outPrimed atomic.Bool
+	target              int
+
+	underruns atomic.Int64
+	overruns  atomic.Int64
+
+	// Only touched on the primary's driver thread:
+	block           Block
+	inRes, outRes   *Resampler
+	inFill, outFill float64 // smoothed FIFO levels, in the secondary's frames
+	scratch, view   [][]float32
+}
+
+// Opens streams on two initialized drivers and joins them into an Aggregate. The streams' sample rates may
+// differ, but must not change while the Aggregate is open.
+func NewAggregate(primary, secondary Driver, opts AggregateOptions) (a *Aggregate, err error) {
+	a = &Aggregate{}
+	if a.primary, err = NewStream(primary, opts.Primary); err != nil {
+		return nil, err
+	}
+	if a.secondary, err = NewStream(secondary, opts.Secondary); err != nil {
+		a.primary.Close()
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			a.Close()
+		}
+	}()
+
+	p, s := a.primary, a.secondary
+	a.inputs = append(append([]*ChannelInfo(nil), p.Inputs()...), s.Inputs()...)
+	a.outputs = append(append([]*ChannelInfo(nil), p.Outputs()...), s.Outputs()...)
+
+	// Two buffers of slack on either side of the target covers any phase between the two buffer switches.
+	primaryFrames := int(math.Ceil(float64(p.BufferSize()) * s.SampleRate() / p.SampleRate()))
+	a.target = 2 * max(primaryFrames, s.BufferSize())
+	if len(s.Inputs()) > 0 {
+		if a.inRes, err = NewResampler(len(s.Inputs()), s.SampleRate(), p.SampleRate(), opts.Quality); err != nil {
+			return nil, err
+		}
+		a.inFifo = newFrameRing(len(s.Inputs()), 2*a.target)
+	}
+	if len(s.Outputs()) > 0 {
+		if a.outRes, err = NewResampler(len(s.Outputs()), p.SampleRate(), s.SampleRate(), opts.Quality); err != nil {
+			return nil, err
+		}
+		a.outFifo = newFrameRing(len(s.Outputs()), 2*a.target)
+	}
+
+	a.block.In = append(append([][]float32(nil), p.block.In...), makeFloatBuffers(len(s.Inputs()), p.BufferSize())...)
+	a.block.Out = append(append([][]float32(nil), p.block.Out...), makeFloatBuffers(len(s.Outputs()), p.BufferSize())...)
+	a.block.Frames = p.BufferSize()
+	a.block.rawIn = make([][]byte, len(a.inputs))
+	a.block.rawOut = make([][]byte, len(a.outputs))
+	a.scratch = makeFloatBuffers(max(len(s.Inputs()), len(s.Outputs())), max(p.BufferSize(), primaryFrames))
+	// So that the resamplers never grow on the driver threads.
+	for _, r := range []*Resampler{a.inRes, a.outRes} {
+		if r != nil {
+			r.Reserve(max(p.BufferSize(), primaryFrames, s.BufferSize()))
+		}
+	}
+	a.view = make([][]float32, max(len(s.Inputs()), len(s.Outputs())))
+
+	p.AddProcessor(ProcessorFunc(a.processPrimary))
+	s.AddProcessor(ProcessorFunc(a.processSecondary))
+	return a, nil
+}
+
+func (a *Aggregate) Primary() *Stream   { return a.primary }
+func (a *Aggregate) Secondary() *Stream { return a.secondary }
+
+// Returns the primary's inputs followed by the secondary's.
+func (a *Aggregate) Inputs() []*ChannelInfo { return a.inputs }
+
+// Returns the primary's outputs followed by the secondary's.
+func (a *Aggregate) Outputs() []*ChannelInfo { return a.outputs }
+
+func (a *Aggregate) BufferSize() int     { return a.primary.BufferSize() }
+func (a *Aggregate) SampleRate() float64 { return a.primary.SampleRate() }
+
+// Returns how far the secondary's clock runs ahead of the primary's, in parts per million of their nominal
+// rates, or 0 until both have run long enough to tell.
+func (a *Aggregate) DriftPPM() float64 { return math.Float64frombits(a.drift.Load()) }
+
+// Returns how many times a FIFO ran dry and the secondary's channels dropped out until it refilled.
+func (a *Aggregate) Underruns() int64 { return a.underruns.Load() }
+
+// Returns how many times a FIFO overflowed and frames were lost.
+func (a *Aggregate) Overruns() int64 { return a.overruns.Load() }
+
+// Returns how far the secondary's channels lag behind the primary's, through a FIFO and a resampler.
+func (a *Aggregate) Latency() time.Duration {
+	latency := time.Duration(float64(a.target) / a.secondary.SampleRate() * float64(time.Second))
+	if a.inRes != nil {
+		return latency + a.inRes.Latency()
+	}
+	if a.outRes != nil {
+		return latency + a.outRes.Latency()
+	}
+	return latency
+}
+
+// Appends p to the processors, which see the aggregate's channels. See Stream.AddProcessor.
+func (a *Aggregate) AddProcessor(p Processor) { appendProcessor(&a.processors, p) }
+
+// Appends p to the taps. See Stream.AddTap.
+func (a *Aggregate) AddTap(p Processor) { appendProcessor(&a.taps, p) }
+
+func (a *Aggregate) RemoveProcessor(p Processor) { removeProcessor(&a.processors, p) }
+func (a *Aggregate) RemoveTap(p Processor)       { removeProcessor(&a.taps, p) }
+
+// Starts the secondary and then the primary, so the secondary's FIFO is filling by the time it is needed.
+func (a *Aggregate) Start() (err error) {
+	if err = a.secondary.Start(); err != nil {
+		return err
+	}
+	if err = a.primary.Start(); err != nil {
+		a.secondary.Stop()
+		return err
+	}
+	return nil
+}
+
+func (a *Aggregate) Stop() (err error) {
+	err = a.primary.Stop()
+	if serr := a.secondary.Stop(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Closes both streams, even if closing the first fails. The drivers themselves stay open. It returns the
+// errors of both, joined.
+func (a *Aggregate) Close() (err error) {
+	return errors.Join(a.primary.Close(), a.secondary.Close())
+}
+
+// Runs on the primary's driver thread.
+func (a *Aggregate) processPrimary(b *Block) {
+	a.primaryClock.add(b.Time)
+	ratio := 1 + a.updateDrift()/1e6
+	lag := a.secondaryLag(b.Time)
+
+	ab := &a.block
+	ab.Frames = b.Frames
+	ab.SampleRate = b.SampleRate
+	ab.SamplePosition = b.SamplePosition
+	ab.Time = b.Time
+	ab.DoubleBufferIndex = b.DoubleBufferIndex
+	copy(ab.rawIn, b.rawIn)
+	copy(ab.rawOut, b.rawOut)
+
+	if a.inRes != nil {
+		a.pullInputs(ab.In[len(b.In):], b.Frames, ratio, lag)
+	}
+	for _, out := range ab.Out[len(b.Out):] {
+		clear(out)
+	}
+
+	if list := a.processors.Load(); list != nil {
+		for _, p := range *list {
+			p.Process(ab)
+		}
+	}
+	if list := a.taps.Load(); list != nil {
+		for _, p := range *list {
+			p.Process(ab)
+		}
+	}
+
+	if a.outRes != nil {
+		a.pushOutputs(ab.Out[len(b.Out):], b.Frames, ratio, lag)
+	}
+}
+
+// Runs on the secondary's driver thread.
+func (a *Aggregate) processSecondary(b *Block) {
+	a.secondaryClock.add(b.Time)
+	if b.Time != nil && b.Time.TimeInfo.Flags&SystemTimeValid != 0 {
+		a.secondaryTime.Store(b.Time.TimeInfo.SystemTime)
+	}
+
+	if a.inFifo != nil && a.inFifo.write(b.In) < b.Frames && a.inPrimed.Load() {
+		a.overruns.Add(1)
+	}
+
+	if a.outFifo == nil {
+		return
+	}
+	if !a.outPrimed.Load() {
+		// Between buffer switches the FIFO also holds some of a primary buffer; leave room for half of one.
+		if a.outFifo.available() < a.target+b.Frames/2 {
+			return
+		}
+		a.outFifo.skip(a.outFifo.available() - a.target - b.Frames/2)
+		a.outPrimed.Store(true)
+	}
+	if a.outFifo.readInto(b.Out) < b.Frames {
+		a.underruns.Add(1)
+		a.outPrimed.Store(false)
+	}
+}
+
+// Re-estimates the drift from both clocks, returning it in ppm.
+func (a *Aggregate) updateDrift() float64 {
+	p, s := a.primaryClock.rate(), a.secondaryClock.rate()
+	if p == 0 || s == 0 {
+		return a.DriftPPM()
+	}
+	ppm := (s/a.secondary.SampleRate()/(p/a.primary.SampleRate()) - 1) * 1e6
+	a.drift.Store(math.Float64bits(ppm))
+	return ppm
+}
+
+// Returns how many of its frames the secondary has played and recorded since its latest buffer switch, as of the
+// primary's buffer switch at t. The secondary moves its FIFOs a whole buffer at a time; counting these frames as
+// already moved makes their levels independent of the phase between the two drivers' buffer switches.
+func (a *Aggregate) secondaryLag(t *ASIOTime) float64 {
+	last := a.secondaryTime.Load()
+	if t == nil || t.TimeInfo.Flags&SystemTimeValid == 0 || last == 0 {
+		return 0
+	}
+	lag := float64(t.TimeInfo.SystemTime-last) / 1e9 * a.secondary.SampleRate()
+	return max(0, min(float64(a.secondary.BufferSize()), lag))
+}
+
+// The smoothed FIFO level, relative to the target, scales the resampling ratio by up to this much either way.
+// It takes up whatever error is left in the drift estimate, and steers the level back after a dropout.
+const aggregateSteering = 2000e-6
+
+// Returns the factor that steers a FIFO holding level frames, whose smoothed level is *fill, to the target.
+func (a *Aggregate) steer(fill *float64, level float64) float64 {
+	const smoothing = 0.02
+	*fill += (level - *fill) * smoothing
+	e := (*fill - float64(a.target)) / float64(a.target)
+	return 1 + aggregateSteering*max(-1, min(1, e))
+}
+
+// Fills dst with frames of the secondary's inputs at the primary's rate.
+func (a *Aggregate) pullInputs(dst [][]float32, frames int, ratio, lag float64) {
+	if !a.inPrimed.Load() {
+		for _, d := range dst {
+			clear(d[:frames])
+		}
+		if a.inFifo.available() < a.target {
+			return
+		}
+		a.inFifo.skip(a.inFifo.available() - a.target + int(lag))
+		a.inPrimed.Store(true)
+		a.inFill = float64(a.target)
+	}
+	// Input the resampler has taken but not used yet is as good as still in the FIFO.
+	a.inRes.AdjustRatio(ratio * a.steer(&a.inFill, float64(a.inFifo.available())+a.inRes.Pending()+lag))
+
+	n := 0
+	for n < frames {
+		for ch := range dst {
+			a.view[ch] = dst[ch][n:frames]
+		}
+		if n += a.inRes.Read(a.view[:len(dst)]); n == frames {
+			break
+		}
+
+		in := a.scratch[:len(dst)]
+		got := a.inFifo.readInto(in)
+		if got == 0 {
+			a.underruns.Add(1)
+			a.inPrimed.Store(false)
+			for _, d := range dst {
+				clear(d[n:frames])
+			}
+			break
+		}
+		for ch := range in {
+			a.view[ch] = in[ch][:got]
+		}
+		a.inRes.Write(a.view[:len(dst)])
+	}
+}
+
+// Queues frames of the secondary's outputs for it, at its rate.
+func (a *Aggregate) pushOutputs(src [][]float32, frames int, ratio, lag float64) {
+	for ch := range src {
+		a.view[ch] = src[ch][:frames]
+	}
+	a.outRes.Write(a.view[:len(src)])
+
+	out := a.scratch[:len(src)]
+	overrun := false
+	for {
+		n := a.outRes.Read(out)
+		if n == 0 {
+			break
+		}
+		for ch := range out {
+			a.view[ch] = out[ch][:n]
+		}
+		if a.outFifo.write(a.view[:len(src)]) < n && a.outPrimed.Load() {
+			overrun = true
+		}
+	}
+	if overrun {
+		a.overruns.Add(1)
+	}
+
+	// Steers the next buffer's ratio.
+	if a.outFill == 0 {
+		a.outFill = float64(a.target)
+	}
+	a.outRes.AdjustRatio(a.steer(&a.outFill, float64(a.outFifo.available())-lag) / ratio)
+}
@@ -0,0 +1,123 @@
+package asio
+
+import (
+	"math"
+	"testing"
+)
+
+func newTestAggregate(t *testing.T, primary, secondary *SimDriver) *Aggregate {
+	primary.Manual, secondary.Manual = true, true
+	a, err := NewAggregate(primary, secondary, AggregateOptions{Quality: MediumQuality})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// Steps whichever driver's next buffer switch is due first, as their clocks would, until the primary has run
+// for the given number of seconds.
+func stepAggregate(t *testing.T, primary, secondary *SimDriver, seconds float64) {
+	start, _, _ := primary.GetSamplePosition()
+	for {
+		pos, pt, _ := primary.GetSamplePosition()
+		if float64(pos-start) >= seconds*48000 {
+			return
+		}
+		drv := primary
+		if _, st, _ := secondary.GetSamplePosition(); st < pt {
+			drv = secondary
+		}
+		if err := drv.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAggregateDrift(t *testing.T) {
+	for _, ppm := range [][2]float64{{0, 80}, {-30, 50}, {25, -120}} {
+		primary, secondary := NewSimDriver(1, 1), NewSimDriver(1, 1)
+		primary.ClockPPM, secondary.ClockPPM = ppm[0], ppm[1]
+		secondary.Input = func(channel int, pos int64, buf []float32) {
+			for i := range buf {
+				buf[i] = 0.5 * float32(math.Sin(2*math.Pi*1000*float64(pos+int64(i))/48000))
+			}
+		}
+		a := newTestAggregate(t, primary, secondary)
+		if len(a.Inputs()) != 2 || len(a.Outputs()) != 2 {
+			t.Fatalf("%d inputs, %d outputs", len(a.Inputs()), len(a.Outputs()))
+		}
+
+		var captured []float32
+		a.AddProcessor(ProcessorFunc(func(b *Block) {
+			captured = append(captured, b.In[1]...)
+			for i := range b.Out[1] {
+				b.Out[1][i] = 0.5 * float32(math.Sin(2*math.Pi*1000*float64(b.SamplePosition+int64(i))/48000))
+			}
+		}))
+		var played []float32
+		a.Secondary().AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[0]...) }))
+
+		stepAggregate(t, primary, secondary, 1)
+		underruns, overruns := a.Underruns(), a.Overruns()
+		stepAggregate(t, primary, secondary, 20)
+
+		want := ((1+ppm[1]/1e6)/(1+ppm[0]/1e6) - 1) * 1e6
+		if d := a.DriftPPM(); math.Abs(d-want) > 0.1 {
+			t.Errorf("%v: drift %.3f ppm, want %.3f", ppm, d, want)
+		}
+		if a.Underruns() != underruns || a.Overruns() != overruns {
+			t.Errorf("%v: %d underruns, %d overruns after settling", ppm, a.Underruns()-underruns, a.Overruns()-overruns)
+		}
+		for _, fill := range []float64{a.inFill, a.outFill} {
+			if math.Abs(fill-float64(a.target)) > float64(a.target)/10 {
+				t.Errorf("%v: FIFO holds %.0f frames, target %d", ppm, fill, a.target)
+			}
+		}
+
+		// The tones were made on one clock and heard on the other, so they come out shifted by the drift.
+		tail := 2 * 48000
+		if amp := toneAmplitude(captured[len(captured)-tail:], 1000*(1+want/1e6), 48000); math.Abs(amp-0.5) > 2e-3 {
+			t.Errorf("%v: captured amplitude %v", ppm, amp)
+		}
+		if amp := toneAmplitude(played[len(played)-tail:], 1000/(1+want/1e6), 48000); math.Abs(amp-0.5) > 2e-3 {
+			t.Errorf("%v: played amplitude %v", ppm, amp)
+		}
+	}
+}
+
+func TestAggregateRates(t *testing.T) {
+	primary, secondary := NewSimDriver(2, 0), NewSimDriver(0, 2)
+	secondary.SetSampleRate(44100)
+	a := newTestAggregate(t, primary, secondary)
+	if len(a.Inputs()) != 2 || len(a.Outputs()) != 2 || a.SampleRate() != 48000 {
+		t.Fatalf("%d inputs, %d outputs at %v", len(a.Inputs()), len(a.Outputs()), a.SampleRate())
+	}
+	if a.Latency() <= 0 {
+		t.Errorf("Latency = %v", a.Latency())
+	}
+
+	a.AddProcessor(ProcessorFunc(func(b *Block) {
+		for i := range b.Out[1] {
+			b.Out[0][i], b.Out[1][i] = 0.25, -0.25
+		}
+	}))
+	var played []float32
+	a.Secondary().AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[1]...) }))
+	stepAggregate(t, primary, secondary, 5)
+
+	if a.Underruns() > 1 || a.Overruns() != 0 {
+		t.Errorf("%d underruns, %d overruns", a.Underruns(), a.Overruns())
+	}
+	if want := 5 * 44100; math.Abs(float64(len(played)-want)) > 512 {
+		t.Errorf("secondary played %d frames, want about %d", len(played), want)
+	}
+	for _, x := range played[len(played)-1000:] {
+		if math.Abs(float64(x)+0.25) > 1e-4 {
+			t.Fatalf("secondary played %v, want -0.25", x)
+		}
+	}
+}
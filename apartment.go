@@ -0,0 +1,88 @@
+package asio
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// An apartment runs functions on one OS thread of its own, one at a time in the order they were submitted, as
+// COM requires of the objects a single-threaded apartment creates. Its thread is started and set up on first
+// use, and lives as long as the program.
+type apartment struct {
+	setup func() error
+	pump  func() // called on the thread between functions and every pumpInterval, e.g. to dispatch window messages
+
+	once     sync.Once
+	err      error // from setup
+	thread   int   // OS thread ID, 0 where unknown
+	requests chan func()
+}
+
+// How often an idle apartment calls its pump.
+const pumpInterval = 10 * time.Millisecond
+
+func newApartment(setup func() error, pump func()) *apartment {
+	return &apartment{setup: setup, pump: pump, requests: make(chan func())}
+}
+
+func (a *apartment) start() {
+	ready := make(chan struct{})
+	go func() {
+		// Never unlocked: the thread is the apartment.
+		runtime.LockOSThread()
+		a.thread = currentThreadID()
+		if a.setup != nil {
+			a.err = a.setup()
+		}
+		close(ready)
+		if a.err != nil {
+			return
+		}
+		if a.pump == nil {
+			for f := range a.requests {
+				f()
+			}
+			return
+		}
+		ticker := time.NewTicker(pumpInterval)
+		for {
+			select {
+			case f := <-a.requests:
+				f()
+			case <-ticker.C:
+			}
+			a.pump()
+		}
+	}()
+	<-ready
+}
+
+// Runs f on the apartment's thread and waits for it to return; a panic in f comes back to the caller. Called on
+// the thread itself, e.g. from a callback a driver makes during a call, f runs at once. Where the OS thread ID
+// is unknown, that deadlocks instead. If setting up the thread failed, f does not run and Do returns why.
+func (a *apartment) Do(f func()) error {
+	a.once.Do(a.start)
+	if a.err != nil {
+		return a.err
+	}
+	if a.thread != 0 && currentThreadID() == a.thread {
+		f()
+		return nil
+	}
+
+	done := make(chan struct{})
+	var recovered any
+	a.requests <- func() {
+		defer func() {
+			recovered = recover()
+			close(done)
+		}()
+		f()
+	}
+	<-done
+	if recovered != nil {
+		panic(recovered)
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+package asio
+
+import "syscall"
+
+func currentThreadID() int { return syscall.Gettid() }
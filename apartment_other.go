@@ -0,0 +1,6 @@
+//go:build !linux && !windows
+
+package asio
+
+// Unknown here, so an apartment cannot tell calls from its own thread.
+func currentThreadID() int { return 0 }
@@ -0,0 +1,94 @@
+package asio
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestApartment(t *testing.T) {
+	var setups, setupThread int
+	a := newApartment(func() error {
+		setups++
+		setupThread = currentThreadID()
+		return nil
+	}, nil)
+
+	// Calls from many goroutines all run on the apartment's thread, one at a time.
+	var wg sync.WaitGroup
+	threads := make([]int, 50)
+	running := 0
+	for i := range threads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Do(func() {
+				if running++; running != 1 {
+					t.Error("calls overlap")
+				}
+				threads[i] = currentThreadID()
+				running--
+			})
+		}()
+	}
+	wg.Wait()
+	if setups != 1 {
+		t.Errorf("set up %d times", setups)
+	}
+	for i, thread := range threads {
+		if thread != setupThread || thread == currentThreadID() {
+			t.Fatalf("call %d ran on thread %d, the apartment's is %d", i, thread, setupThread)
+		}
+	}
+
+	// A call from the apartment's own thread, as from a driver callback, runs at once.
+	var nested bool
+	a.Do(func() { a.Do(func() { nested = true }) })
+	if !nested {
+		t.Error("the nested call did not run")
+	}
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v", r)
+		}
+		// The apartment survives.
+		if err := a.Do(func() {}); err != nil {
+			t.Error(err)
+		}
+	}()
+	a.Do(func() { panic("boom") })
+}
+
+func TestApartmentSetupError(t *testing.T) {
+	failed := errors.New("no COM")
+	a := newApartment(func() error { return failed }, nil)
+	ran := false
+	for i := 0; i < 2; i++ {
+		if err := a.Do(func() { ran = true }); err != failed {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+	if ran {
+		t.Error("ran without an apartment")
+	}
+}
+
+func TestApartmentPump(t *testing.T) {
+	pumps := make(chan int, 100)
+	a := newApartment(nil, func() {
+		select {
+		case pumps <- currentThreadID():
+		default:
+		}
+	})
+	var thread int
+	a.Do(func() { thread = currentThreadID() })
+
+	// Pumped after the call, and then while idle.
+	for i := 0; i < 3; i++ {
+		if pumped := <-pumps; pumped != thread {
+			t.Fatalf("pumped on thread %d, the apartment's is %d", pumped, thread)
+		}
+	}
+}
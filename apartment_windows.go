@@ -0,0 +1,139 @@
+package asio
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32, _               = syscall.LoadLibrary("kernel32.dll")
+	procGetCurrentThreadId, _ = syscall.GetProcAddress(kernel32, "GetCurrentThreadId")
+)
+
+func currentThreadID() int {
+	id, _, _ := syscall.Syscall(procGetCurrentThreadId, 0, 0, 0, 0)
+	return int(id)
+}
+
+// The single-threaded apartment every driver is created and called in, so that hosts may call drivers from any
+// goroutine. Only GetSamplePosition and OutputReady are called directly, because hosts call them from the
+// buffer switch, on the driver's own thread, while a call like Stop may be waiting for it to return.
+var comApartment = newApartment(func() error {
+	// S_FALSE, already initialized, is fine.
+	if hr := CoInitialize(0); int32(hr) < 0 {
+		return syscall.Errno(hr)
+	}
+	return nil
+}, pumpMessages)
+
+// Creates a COM object in the driver apartment.
+func createInstanceInApartment(clsid *GUID, iid *GUID) (unk *IUnknown, err error) {
+	if aerr := comApartment.Do(func() { unk, err = CreateInstance(clsid, iid) }); aerr != nil {
+		return nil, aerr
+	}
+	return unk, err
+}
+
+// Releases a COM object in the driver apartment.
+func releaseInApartment(unk *IUnknown) (err error) {
+	comApartment.Do(func() { _, err = unk.Release() })
+	return err
+}
+
+// The IASIO methods below run in the driver apartment. An IASIO only comes from ASIODriver.Open once the
+// apartment is up, so the error from Do, which is only ever the apartment's, is not checked.
+
+func (drv *IASIO) Init(sysHandle uintptr) (ok bool) {
+	comApartment.Do(func() { ok = drv.init(sysHandle) })
+	return ok
+}
+
+func (drv *IASIO) GetDriverName() (name string) {
+	comApartment.Do(func() { name = drv.getDriverName() })
+	return name
+}
+
+func (drv *IASIO) GetDriverVersion() (version int32) {
+	comApartment.Do(func() { version = drv.getDriverVersion() })
+	return version
+}
+
+func (drv *IASIO) GetErrorMessage() (msg string) {
+	comApartment.Do(func() { msg = drv.getErrorMessage() })
+	return msg
+}
+
+func (drv *IASIO) Start() (err error) {
+	comApartment.Do(func() { err = drv.start() })
+	return err
+}
+
+func (drv *IASIO) Stop() (err error) {
+	comApartment.Do(func() { err = drv.stop() })
+	return err
+}
+
+func (drv *IASIO) GetChannels() (numInputChannels, numOutputChannels int, err error) {
+	comApartment.Do(func() { numInputChannels, numOutputChannels, err = drv.getChannels() })
+	return numInputChannels, numOutputChannels, err
+}
+
+func (drv *IASIO) GetLatencies() (inputLatency, outputLatency int, err error) {
+	comApartment.Do(func() { inputLatency, outputLatency, err = drv.getLatencies() })
+	return inputLatency, outputLatency, err
+}
+
+func (drv *IASIO) GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
+	comApartment.Do(func() { minSize, maxSize, preferredSize, granularity, err = drv.getBufferSize() })
+	return minSize, maxSize, preferredSize, granularity, err
+}
+
+func (drv *IASIO) CanSampleRate(sampleRate float64) (err error) {
+	comApartment.Do(func() { err = drv.canSampleRate(sampleRate) })
+	return err
+}
+
+func (drv *IASIO) GetSampleRate() (sampleRate float64, err error) {
+	comApartment.Do(func() { sampleRate, err = drv.getSampleRate() })
+	return sampleRate, err
+}
+
+func (drv *IASIO) SetSampleRate(sampleRate float64) (err error) {
+	comApartment.Do(func() { err = drv.setSampleRate(sampleRate) })
+	return err
+}
+
+func (drv *IASIO) GetClockSources() (sources []ClockSource, err error) {
+	comApartment.Do(func() { sources, err = drv.getClockSources() })
+	return sources, err
+}
+
+func (drv *IASIO) SetClockSource(reference int) (err error) {
+	comApartment.Do(func() { err = drv.setClockSource(reference) })
+	return err
+}
+
+func (drv *IASIO) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
+	comApartment.Do(func() { info, err = drv.getChannelInfo(channel, isInput) })
+	return info, err
+}
+
+func (drv *IASIO) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+	comApartment.Do(func() { err = drv.createBuffers(bufferDescriptors, bufferSize, callbacks) })
+	return err
+}
+
+func (drv *IASIO) DisposeBuffers() (err error) {
+	comApartment.Do(func() { err = drv.disposeBuffers() })
+	return err
+}
+
+func (drv *IASIO) ControlPanel() (err error) {
+	comApartment.Do(func() { err = drv.controlPanel() })
+	return err
+}
+
+func (drv *IASIO) Future(selector int32, opt unsafe.Pointer) (err error) {
+	comApartment.Do(func() { err = drv.future(selector, opt) })
+	return err
+}
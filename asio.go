@@ -1,7 +1,12 @@
+//go:build windows
+
 package asio
 
 import (
 	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
@@ -110,10 +115,13 @@ typedef void (*sampleRateDidChange) (ASIOSampleRate sRate);
 typedef long (*asioMessage) (long selector, long value, void* message, double* opt);
 typedef ASIOTime* (*bufferSwitchTimeInfo) (ASIOTime* params, long doubleBufferIndex, ASIOBool directProcess);
 
-// Pointer to Go function impl:
-bufferSwitchTimeInfo go_BufferSwitchTimeInfo;
+// Go function impls (see callbacks_windows.go); slot says which driver the callback came from:
+extern void goBufferSwitch(int slot, long doubleBufferIndex, long directProcess);
+extern void *goBufferSwitchTimeInfo(int slot, void *params, long doubleBufferIndex, long directProcess);
+extern void goSampleRateDidChange(int slot, double sRate);
+extern long goAsioMessage(int slot, long selector, long value, void *message, double *opt);
 
-long tramp_asioMessage(long selector, long value, void* message, double* opt)
+static long hostAsioMessage(int slot, long selector, long value, void* message, double* opt)
 {
     // currently the parameters "value", "message" and "opt" are not used.
     long ret = 0;
@@ -135,6 +143,7 @@ long tramp_asioMessage(long selector, long value, void* message, double* opt)
         // You cannot reset the driver right now, as this code is called from the driver.
         // Reset the driver is done by completely destruct is. I.e. ASIOStop(), ASIODisposeBuffers(), Destruction
         // Afterwards you initialize the driver again.
+        goAsioMessage(slot, selector, value, message, opt);
         ret = 1L;
         break;
     case kAsioResyncRequest:
@@ -144,12 +153,14 @@ long tramp_asioMessage(long selector, long value, void* message, double* opt)
         // Windows Multimedia system, which could loose data because the Mutex was hold too long
         // by another thread.
         // However a driver can issue it in other situations, too.
+        goAsioMessage(slot, selector, value, message, opt);
         ret = 1L;
         break;
     case kAsioLatenciesChanged:
         // This will inform the host application that the drivers were latencies changed.
         // Beware, it this does not mean that the buffer sizes have changed!
         // You might need to update internal delay data.
+        goAsioMessage(slot, selector, value, message, opt);
         ret = 1L;
         break;
     case kAsioEngineVersion:
@@ -168,87 +179,64 @@ long tramp_asioMessage(long selector, long value, void* message, double* opt)
     case kAsioSupportsTimeCode:
         // informs the driver wether application is interested in time code info.
         // If an application does not need to know about time code, the driver has less work
-        // to do.
-        ret = 0;
+        // to do. The stream says yes if it was opened with timecode enabled.
+        ret = goAsioMessage(slot, selector, value, message, opt);
+        break;
+    case kAsioOverload:
+        // the driver detected an overload; let the host count it.
+        goAsioMessage(slot, selector, value, message, opt);
+        ret = 1L;
         break;
     }
     return ret;
 }
 
-// Main audio processing callback.
+// ASIO callbacks carry no context, so every driver with buffers gets a set of trampolines of its own that
+// tells Go which slot it belongs to. ASIO_SLOTS must match asioSlots.
 // NOTE: Called on a separate thread from main() thread.
-ASIOTime *tramp_bufferSwitchTimeInfo(ASIOTime *timeInfo, long index, ASIOBool processNow)
-{
-	return timeInfo;
-	//return go_BufferSwitchTimeInfo(timeInfo, index, processNow);
-}
-
-// Trampoline to jump to Go function:
-void tramp_bufferSwitch(long index, ASIOBool processNow)
+#define ASIO_SLOTS 4
+
+#define ASIO_SLOT(n) \
+static void tramp_bufferSwitch##n(long index, ASIOBool processNow) \
+{ \
+    goBufferSwitch(n, index, processNow); \
+} \
+static void tramp_sampleRateDidChange##n(ASIOSampleRate sRate) \
+{ \
+    goSampleRateDidChange(n, sRate); \
+} \
+static long tramp_asioMessage##n(long selector, long value, void* message, double* opt) \
+{ \
+    return hostAsioMessage(n, selector, value, message, opt); \
+} \
+static ASIOTime *tramp_bufferSwitchTimeInfo##n(ASIOTime *timeInfo, long index, ASIOBool processNow) \
+{ \
+    return (ASIOTime *)goBufferSwitchTimeInfo(n, timeInfo, index, processNow); \
+}
+
+ASIO_SLOT(0)
+ASIO_SLOT(1)
+ASIO_SLOT(2)
+ASIO_SLOT(3)
+
+typedef struct ASIOCallbacks
 {
-    // the actual processing callback.
-    // Beware that this is normally in a seperate thread, hence be sure that you take care
-    // about thread synchronization. This is omitted here for simplicity.
-
-    // as this is a "back door" into the bufferSwitchTimeInfo a timeInfo needs to be created
-    // though it will only set the timeInfo.samplePosition and timeInfo.systemTime fields and the according flags
-    ASIOTime  timeInfo;
-    memset (&timeInfo, 0, sizeof (timeInfo));
-
-    // get the time stamp of the buffer, not necessary if no
-    // synchronization to other media is required
-    //if (ASIOGetSamplePosition(&timeInfo.timeInfo.samplePosition, &timeInfo.timeInfo.systemTime) == 0)
-    //    timeInfo.timeInfo.flags = kSystemTimeValid | kSamplePositionValid;
-
-    tramp_bufferSwitchTimeInfo(&timeInfo, index, processNow);
-}
+	bufferSwitch bufferSwitch;
+	sampleRateDidChange sampleRateDidChange;
+	asioMessage asioMessage;
+	bufferSwitchTimeInfo bufferSwitchTimeInfo;
+} ASIOCallbacks;
+
+// Static, so the driver can keep pointers to them for as long as it likes.
+ASIOCallbacks asioSlotCallbacks[ASIO_SLOTS] = {
+	{tramp_bufferSwitch0, tramp_sampleRateDidChange0, tramp_asioMessage0, tramp_bufferSwitchTimeInfo0},
+	{tramp_bufferSwitch1, tramp_sampleRateDidChange1, tramp_asioMessage1, tramp_bufferSwitchTimeInfo1},
+	{tramp_bufferSwitch2, tramp_sampleRateDidChange2, tramp_asioMessage2, tramp_bufferSwitchTimeInfo2},
+	{tramp_bufferSwitch3, tramp_sampleRateDidChange3, tramp_asioMessage3, tramp_bufferSwitchTimeInfo3},
+};
 */
 import "C"
 
-// Special ASIO error values:
-const (
-	ASE_OK      = 0          // This value will be returned whenever the call succeeded
-	ASE_SUCCESS = 0x3f4847a0 // unique success return value for ASIOFuture calls
-)
-
-// Known ASIO error values:
-const (
-	ASE_NotPresent       = -1000 + iota // hardware input or output is not present or available
-	ASE_HWMalfunction                   // hardware is malfunctioning (can be returned by any ASIO function)
-	ASE_InvalidParameter                // input parameter invalid
-	ASE_InvalidMode                     // hardware is in a bad mode or used in a bad mode
-	ASE_SPNotAdvancing                  // hardware is not running when sample position is inquired
-	ASE_NoClock                         // sample clock or rate cannot be determined or is not present
-	ASE_NoMemory                        // not enough memory for completing the request
-)
-
-type Error struct {
-	errno int32
-	msg   string
-}
-
-// Fixed instances of errors:
-var (
-	ErrorNotPresent       = &Error{errno: ASE_NotPresent, msg: "hardware input or output is not present or available"}
-	ErrorHWMalfunction    = &Error{errno: ASE_HWMalfunction, msg: "hardware is malfunctioning (can be returned by any ASIO function)"}
-	ErrorInvalidParameter = &Error{errno: ASE_InvalidParameter, msg: "input parameter invalid"}
-	ErrorInvalidMode      = &Error{errno: ASE_InvalidMode, msg: "hardware is in a bad mode or used in a bad mode"}
-	ErrorSPNotAdvancing   = &Error{errno: ASE_SPNotAdvancing, msg: "hardware is not running when sample position is inquired"}
-	ErrorNoClock          = &Error{errno: ASE_NoClock, msg: "sample clock or rate cannot be determined or is not present"}
-	ErrorNoMemory         = &Error{errno: ASE_NoMemory, msg: "not enough memory for completing the request"}
-)
-
-// Mapping of known ASIO error values to Errors:
-var knownErrors map[int32]*Error = map[int32]*Error{
-	ASE_NotPresent:       ErrorNotPresent,
-	ASE_HWMalfunction:    ErrorHWMalfunction,
-	ASE_InvalidParameter: ErrorInvalidParameter,
-	ASE_InvalidMode:      ErrorInvalidMode,
-	ASE_SPNotAdvancing:   ErrorSPNotAdvancing,
-	ASE_NoClock:          ErrorNoClock,
-	ASE_NoMemory:         ErrorNoMemory,
-}
-
 func (drv *IASIO) asError(ase uintptr) *Error {
 	errno := int32(ase)
 
@@ -263,118 +251,76 @@ func (drv *IASIO) asError(ase uintptr) *Error {
 	}
 
 	// This rarely seems to return anything useful
-	return &Error{errno: errno, msg: drv.GetErrorMessage()}
+	return &Error{errno: errno, msg: drv.getErrorMessage()}
 }
 
-func (err *Error) Error() string {
-	return err.msg
-}
+// Each driver with buffers holds one of the trampoline sets in asioSlotCallbacks, and the callbacks it was
+// given sit at the same index here.
+const asioSlots = 4
 
-type SampleType int32
-
-const (
-	ASIOSTInt16MSB   SampleType = 0
-	ASIOSTInt24MSB   SampleType = 1 // used for 20 bits as well
-	ASIOSTInt32MSB   SampleType = 2
-	ASIOSTFloat32MSB SampleType = 3 // IEEE 754 32 bit float
-	ASIOSTFloat64MSB SampleType = 4 // IEEE 754 64 bit double float
-
-	// these are used for 32 bit data buffer, with different alignment of the data inside
-	// 32 bit PCI bus systems can be more easily used with these
-	ASIOSTInt32MSB16 SampleType = 8  // 32 bit data with 16 bit alignment
-	ASIOSTInt32MSB18 SampleType = 9  // 32 bit data with 18 bit alignment
-	ASIOSTInt32MSB20 SampleType = 10 // 32 bit data with 20 bit alignment
-	ASIOSTInt32MSB24 SampleType = 11 // 32 bit data with 24 bit alignment
-
-	ASIOSTInt16LSB   SampleType = 16
-	ASIOSTInt24LSB   SampleType = 17 // used for 20 bits as well
-	ASIOSTInt32LSB   SampleType = 18
-	ASIOSTFloat32LSB SampleType = 19 // IEEE 754 32 bit float, as found on Intel x86 architecture
-	ASIOSTFloat64LSB SampleType = 20 // IEEE 754 64 bit double float, as found on Intel x86 architecture
-
-	// these are used for 32 bit data buffer, with different alignment of the data inside
-	// 32 bit PCI bus systems can more easily used with these
-	ASIOSTInt32LSB16 SampleType = 24 // 32 bit data with 18 bit alignment
-	ASIOSTInt32LSB18 SampleType = 25 // 32 bit data with 18 bit alignment
-	ASIOSTInt32LSB20 SampleType = 26 // 32 bit data with 20 bit alignment
-	ASIOSTInt32LSB24 SampleType = 27 // 32 bit data with 24 bit alignment
-
-	//	ASIO DSD format.
-	ASIOSTDSDInt8LSB1 SampleType = 32 // DSD 1 bit data, 8 samples per byte. First sample in Least significant bit.
-	ASIOSTDSDInt8MSB1 SampleType = 33 // DSD 1 bit data, 8 samples per byte. First sample in Most significant bit.
-	ASIOSTDSDInt8NER8 SampleType = 40 // DSD 8 bit data, 1 sample per byte. No Endianness required.
+var (
+	slotsMu       sync.Mutex
+	slotDrivers   [asioSlots]*IASIO
+	slotCallbacks [asioSlots]atomic.Pointer[Callbacks] // read on the driver thread without locking
 )
 
-type rawChannelInfo struct {
-	Channel      int32
-	IsInput      int32
-	IsActive     int32
-	ChannelGroup int32
-	SampleType   SampleType
-	Name         [32]byte
-
-	// NOTE(jsd): for struct layout, `long` is `int32` regardless of `uintptr` size.
-
-	//	long channel;			// on input, channel index
-	//	ASIOBool isInput;		// on input
-	//	ASIOBool isActive;		// on exit
-	//	long channelGroup;		// dto
-	//	ASIOSampleType type;	// dto
-	//	char name[32];			// dto
-}
-
-type ChannelInfo struct {
-	Channel      int
-	IsInput      bool
-	IsActive     bool
-	ChannelGroup int
-	SampleType   int
-	Name         string
+// Returned by CreateBuffers when more drivers have buffers at once than there are callback slots.
+var ErrTooManyDrivers = errors.New("asio: too many drivers with buffers")
+
+// Claims a slot for drv, or returns the one it already holds.
+func acquireSlot(drv *IASIO, callbacks Callbacks) (int, error) {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+
+	free := -1
+	for i, d := range slotDrivers {
+		if d == drv {
+			free = i
+			break
+		}
+		if d == nil && free < 0 {
+			free = i
+		}
+	}
+	if free < 0 {
+		return -1, ErrTooManyDrivers
+	}
+	slotDrivers[free] = drv
+	slotCallbacks[free].Store(&callbacks)
+	return free, nil
 }
 
-type rawBufferInfo struct {
-	isInput int32     // input
-	channel int32     // input
-	buffers [2]*int32 // output
+// Reports whether drv holds a slot, i.e. has buffers.
+func (drv *IASIO) hasBuffers() bool {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
 
-	//	ASIOBool isInput;			// on input:  ASIOTrue: input, else output
-	//	long channelNum;			// on input:  channel index
-	//	void *buffers[2];			// on output: double buffer addresses
-}
-
-type BufferInfo struct {
-	Channel int
-	IsInput bool
-	Buffers [2]*int32 // double buffers - may need to recast based on sample type (int32 most popular; ASIOSTInt32LSB)
+	for _, d := range slotDrivers {
+		if d == drv {
+			return true
+		}
+	}
+	return false
 }
 
-type rawASIOTime struct { // both input/output
-	//	long reserved[4];                       // must be 0
-	//	struct AsioTimeInfo     timeInfo;       // required
-	//	struct ASIOTimeCode     timeCode;       // optional, evaluated if (timeCode.flags & kTcValid)
-}
+func releaseSlot(drv *IASIO) {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
 
-type ASIOTime struct {
+	for i, d := range slotDrivers {
+		if d == drv {
+			slotDrivers[i] = nil
+			slotCallbacks[i].Store(nil)
+		}
+	}
 }
 
-//export go_BufferSwitch
-var go_BufferSwitch func(doubleBufferIndex int, directProcess bool)
-
-//export go_SampleRateDidChange
-var go_SampleRateDidChange func(rate float64)
-
-var go_Message func(selector, value int32, message uintptr, opt *float64) int32
-
-var go_BufferSwitchTimeInfo func(params *ASIOTime, doubleBufferIndex int32, directProcess bool) *ASIOTime
-
-type Callbacks struct {
-	BufferSwitch func(doubleBufferIndex int, directProcess bool)
-
-	SampleRateDidChange func(rate float64)
-
-	Message func(selector, value int32, message uintptr, opt *float64) int32
-
-	BufferSwitchTimeInfo func(params *ASIOTime, doubleBufferIndex int32, directProcess bool) *ASIOTime
+// Returns the callbacks of a slot, which are empty once its driver has disposed of its buffers.
+func slotCallbacksFor(slot C.int) Callbacks {
+	if cb := slotCallbacks[slot].Load(); cb != nil {
+		return *cb
+	}
+	return Callbacks{}
 }
 
 // interface IASIO : public IUnknown {
@@ -432,11 +378,13 @@ type IASIO struct {
 	vtbl_asio *pIASIOVtbl
 }
 
+var _ Driver = (*IASIO)(nil)
+
 // Cast to *IUnknown.
 func (drv *IASIO) AsIUnknown() *IUnknown { return (*IUnknown)(unsafe.Pointer(drv)) }
 
 //virtual ASIOBool init(void *sysHandle) = 0;
-func (drv *IASIO) Init(sysHandle uintptr) (ok bool) {
+func (drv *IASIO) init(sysHandle uintptr) (ok bool) {
 	r1, _, _ := syscall.Syscall(drv.vtbl_asio.pInit, 2,
 		uintptr(unsafe.Pointer(drv)),
 		sysHandle,
@@ -446,7 +394,7 @@ func (drv *IASIO) Init(sysHandle uintptr) (ok bool) {
 }
 
 //virtual void getDriverName(char *name) = 0;
-func (drv *IASIO) GetDriverName() string {
+func (drv *IASIO) getDriverName() string {
 	name := [128]byte{0}
 	syscall.Syscall(drv.vtbl_asio.pGetDriverName, 2,
 		uintptr(unsafe.Pointer(drv)),
@@ -458,7 +406,7 @@ func (drv *IASIO) GetDriverName() string {
 }
 
 //virtual long getDriverVersion() = 0;
-func (drv *IASIO) GetDriverVersion() int32 {
+func (drv *IASIO) getDriverVersion() int32 {
 	r1, _, _ := syscall.Syscall(drv.vtbl_asio.pGetDriverVersion, 2,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(0),
@@ -467,7 +415,7 @@ func (drv *IASIO) GetDriverVersion() int32 {
 }
 
 //virtual void getErrorMessage(char *string) = 0;
-func (drv *IASIO) GetErrorMessage() string {
+func (drv *IASIO) getErrorMessage() string {
 	str := [128]byte{0}
 
 	_, _, _ = syscall.Syscall(drv.vtbl_asio.pGetErrorMessage, 2,
@@ -480,7 +428,7 @@ func (drv *IASIO) GetErrorMessage() string {
 }
 
 //virtual ASIOError start() = 0;
-func (drv *IASIO) Start() (err error) {
+func (drv *IASIO) start() (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pStart, 1,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(0),
@@ -493,7 +441,7 @@ func (drv *IASIO) Start() (err error) {
 }
 
 //virtual ASIOError stop() = 0;
-func (drv *IASIO) Stop() (err error) {
+func (drv *IASIO) stop() (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pStop, 1,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(0),
@@ -506,7 +454,7 @@ func (drv *IASIO) Stop() (err error) {
 }
 
 //virtual ASIOError getChannels(long *numInputChannels, long *numOutputChannels) = 0;
-func (drv *IASIO) GetChannels() (numInputChannels, numOutputChannels int, err error) {
+func (drv *IASIO) getChannels() (numInputChannels, numOutputChannels int, err error) {
 	var tmpInputChannels, tmpOutputChannels uintptr
 
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pGetChannels, 3,
@@ -522,7 +470,7 @@ func (drv *IASIO) GetChannels() (numInputChannels, numOutputChannels int, err er
 }
 
 //virtual ASIOError getLatencies(long *inputLatency, long *outputLatency) = 0;
-func (drv *IASIO) GetLatencies() (inputLatency, outputLatency int, err error) {
+func (drv *IASIO) getLatencies() (inputLatency, outputLatency int, err error) {
 	var tmpInputLatency, tmpOutputLatency uintptr
 
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pGetLatencies, 3,
@@ -538,7 +486,7 @@ func (drv *IASIO) GetLatencies() (inputLatency, outputLatency int, err error) {
 }
 
 //virtual ASIOError getBufferSize(long *minSize, long *maxSize, long *preferredSize, long *granularity) = 0;
-func (drv *IASIO) GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
+func (drv *IASIO) getBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
 	var tmpminSize, tmpmaxSize, tmppreferredSize, tmpgranularity uintptr
 
 	ase, _, _ := syscall.Syscall6(drv.vtbl_asio.pGetBufferSize, 5,
@@ -560,7 +508,7 @@ func (drv *IASIO) GetBufferSize() (minSize, maxSize, preferredSize, granularity
 // typedef double ASIOSampleRate;
 
 //virtual ASIOError canSampleRate(ASIOSampleRate sampleRate) = 0;
-func (drv *IASIO) CanSampleRate(sampleRate float64) (err error) {
+func (drv *IASIO) canSampleRate(sampleRate float64) (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pCanSampleRate, 2,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(unsafe.Pointer(&sampleRate)),
@@ -573,7 +521,7 @@ func (drv *IASIO) CanSampleRate(sampleRate float64) (err error) {
 }
 
 //virtual ASIOError getSampleRate(ASIOSampleRate *sampleRate) = 0;
-func (drv *IASIO) GetSampleRate() (sampleRate float64, err error) {
+func (drv *IASIO) getSampleRate() (sampleRate float64, err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pGetSampleRate, 2,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(unsafe.Pointer(&sampleRate)),
@@ -586,7 +534,7 @@ func (drv *IASIO) GetSampleRate() (sampleRate float64, err error) {
 }
 
 //virtual ASIOError setSampleRate(ASIOSampleRate sampleRate) = 0;
-func (drv *IASIO) SetSampleRate(sampleRate float64) (err error) {
+func (drv *IASIO) setSampleRate(sampleRate float64) (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pSetSampleRate, 2,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(unsafe.Pointer(&sampleRate)),
@@ -598,27 +546,63 @@ func (drv *IASIO) SetSampleRate(sampleRate float64) (err error) {
 	return nil
 }
 
-////virtual ASIOError getClockSources(ASIOClockSource *clocks, long *numSources) = 0;
-//pGetClockSources uintptr
-////virtual ASIOError setClockSource(long reference) = 0;
-//pSetClockSource uintptr
+//virtual ASIOError getClockSources(ASIOClockSource *clocks, long *numSources) = 0;
+func (drv *IASIO) getClockSources() (sources []ClockSource, err error) {
+	raw := [32]rawClockSource{}
+	numSources := int32(len(raw))
+
+	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pGetClockSources, 3,
+		uintptr(unsafe.Pointer(drv)),
+		uintptr(unsafe.Pointer(&raw[0])),
+		uintptr(unsafe.Pointer(&numSources)))
 
-////virtual ASIOError getSamplePosition(ASIOSamples *sPos, ASIOTimeStamp *tStamp) = 0;
-//pGetSamplePosition uintptr
+	if derr := drv.asError(ase); derr != nil {
+		return nil, derr
+	}
 
-func bool_int32(a bool) int32 {
-	if a {
-		return 1
+	sources = make([]ClockSource, 0, numSources)
+	for _, r := range raw[:numSources] {
+		sources = append(sources, ClockSource{
+			Index:             int(r.Index),
+			AssociatedChannel: int(r.AssociatedChannel),
+			AssociatedGroup:   int(r.AssociatedGroup),
+			IsCurrentSource:   int32_bool(r.IsCurrentSource),
+			Name:              cstring(r.Name[:]),
+		})
 	}
-	return 0
+	return sources, nil
 }
 
-func int32_bool(a int32) bool {
-	return a != 0
+//virtual ASIOError setClockSource(long reference) = 0;
+func (drv *IASIO) setClockSource(reference int) (err error) {
+	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pSetClockSource, 2,
+		uintptr(unsafe.Pointer(drv)),
+		uintptr(reference),
+		uintptr(0))
+
+	if derr := drv.asError(ase); derr != nil {
+		return derr
+	}
+	return nil
+}
+
+//virtual ASIOError getSamplePosition(ASIOSamples *sPos, ASIOTimeStamp *tStamp) = 0;
+func (drv *IASIO) GetSamplePosition() (samplePosition, systemTime int64, err error) {
+	var sPos, tStamp rawASIOSamples
+
+	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pGetSamplePosition, 3,
+		uintptr(unsafe.Pointer(drv)),
+		uintptr(unsafe.Pointer(&sPos)),
+		uintptr(unsafe.Pointer(&tStamp)))
+
+	if derr := drv.asError(ase); derr != nil {
+		return 0, 0, derr
+	}
+	return sPos.int64(), tStamp.int64(), nil
 }
 
 //virtual ASIOError getChannelInfo(ASIOChannelInfo *info) = 0;
-func (drv *IASIO) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
+func (drv *IASIO) getChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
 	raw := &rawChannelInfo{
 		Channel: int32(channel),
 		IsInput: bool_int32(isInput),
@@ -637,24 +621,14 @@ func (drv *IASIO) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo,
 		IsInput:      int32_bool(raw.IsInput),
 		IsActive:     int32_bool(raw.IsActive),
 		ChannelGroup: int(raw.ChannelGroup),
-		SampleType:   int(raw.SampleType),
-		Name:         string(raw.Name[:]),
+		SampleType:   raw.SampleType,
+		Name:         cstring(raw.Name[:]),
 	}
 	return info, nil
 }
 
-type callbacks struct {
-	pBufferSwitch         uintptr
-	pSampleRateDidChange  uintptr
-	pASIOMessage          uintptr
-	pBufferSwitchTimeInfo uintptr
-}
-
-// Create a GC root so this does not get collected.
-var the_callbacks = &callbacks{}
-
 //virtual ASIOError createBuffers(ASIOBufferInfo *bufferInfos, long numChannels, long bufferSize, ASIOCallbacks *callbacks) = 0;
-func (drv *IASIO) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+func (drv *IASIO) createBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
 	// Prepare the raw struct for holding ASIOBufferInfos:
 	rawBufferInfos := make([]rawBufferInfo, len(bufferDescriptors))
 	for i, desc := range bufferDescriptors {
@@ -663,24 +637,25 @@ func (drv *IASIO) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int,
 		rawBufferInfos[i].buffers = [2]*int32{nil, nil}
 	}
 
-	// Set global callbacks.
-	// NOTE: ASIO callbacks do not supply a context argument and so cannot generally be made driver-specific.
-	go_BufferSwitchTimeInfo = callbacks.BufferSwitchTimeInfo
-
-	the_callbacks.pBufferSwitch = uintptr(unsafe.Pointer(C.tramp_bufferSwitch))
-	the_callbacks.pSampleRateDidChange = uintptr(0)
-	the_callbacks.pASIOMessage = uintptr(unsafe.Pointer(C.tramp_asioMessage))
-	the_callbacks.pBufferSwitchTimeInfo = uintptr(unsafe.Pointer(C.tramp_bufferSwitchTimeInfo))
+	slot, err := acquireSlot(drv, callbacks)
+	if err != nil {
+		return err
+	}
 
+	var first *rawBufferInfo
+	if len(rawBufferInfos) > 0 {
+		first = &rawBufferInfos[0]
+	}
 	ase, _, _ := syscall.Syscall6(drv.vtbl_asio.pCreateBuffers, 5,
 		uintptr(unsafe.Pointer(drv)),
-		uintptr(unsafe.Pointer(&rawBufferInfos[0])),
+		uintptr(unsafe.Pointer(first)),
 		uintptr(len(bufferDescriptors)),
 		uintptr(bufferSize),
-		uintptr(unsafe.Pointer(the_callbacks)),
+		uintptr(unsafe.Pointer(&C.asioSlotCallbacks[slot])),
 		uintptr(0))
 
 	if derr := drv.asError(ase); derr != nil {
+		releaseSlot(drv)
 		return derr
 	}
 
@@ -693,11 +668,12 @@ func (drv *IASIO) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int,
 }
 
 //virtual ASIOError disposeBuffers() = 0;
-func (drv *IASIO) DisposeBuffers() (err error) {
+func (drv *IASIO) disposeBuffers() (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pDisposeBuffers, 1,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(0),
 		uintptr(0))
+	releaseSlot(drv)
 
 	if derr := drv.asError(ase); derr != nil {
 		return derr
@@ -706,7 +682,7 @@ func (drv *IASIO) DisposeBuffers() (err error) {
 }
 
 //virtual ASIOError controlPanel() = 0;
-func (drv *IASIO) ControlPanel() (err error) {
+func (drv *IASIO) controlPanel() (err error) {
 	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pControlPanel, 1,
 		uintptr(unsafe.Pointer(drv)),
 		uintptr(0),
@@ -718,8 +694,18 @@ func (drv *IASIO) ControlPanel() (err error) {
 	return nil
 }
 
-////virtual ASIOError future(long selector,void *opt) = 0;
-//pFuture uintptr
+//virtual ASIOError future(long selector,void *opt) = 0;
+func (drv *IASIO) future(selector int32, opt unsafe.Pointer) (err error) {
+	ase, _, _ := syscall.Syscall(drv.vtbl_asio.pFuture, 3,
+		uintptr(unsafe.Pointer(drv)),
+		uintptr(selector),
+		uintptr(opt))
+
+	if derr := drv.asError(ase); derr != nil {
+		return derr
+	}
+	return nil
+}
 
 //virtual ASIOError outputReady() = 0;
 func (drv *IASIO) OutputReady() bool {
@@ -0,0 +1,60 @@
+package asio
+
+import "C"
+
+import (
+	"unsafe"
+)
+
+// These are called from the C trampolines in asio.go on the driver's thread and forward to the Go callbacks
+// that CreateBuffers registered in the trampoline's slot.
+
+//export goBufferSwitch
+func goBufferSwitch(slot C.int, doubleBufferIndex C.long, directProcess C.long) {
+	cb := slotCallbacksFor(slot)
+	if cb.BufferSwitch != nil {
+		cb.BufferSwitch(int(doubleBufferIndex), directProcess != 0)
+		return
+	}
+	if cb.BufferSwitchTimeInfo != nil {
+		var params ASIOTime
+		cb.BufferSwitchTimeInfo(&params, int32(doubleBufferIndex), directProcess != 0)
+	}
+}
+
+//export goBufferSwitchTimeInfo
+func goBufferSwitchTimeInfo(slot C.int, params unsafe.Pointer, doubleBufferIndex C.long, directProcess C.long) unsafe.Pointer {
+	cb := slotCallbacksFor(slot)
+	raw := (*rawASIOTime)(params)
+	if cb.BufferSwitchTimeInfo == nil {
+		if cb.BufferSwitch != nil {
+			cb.BufferSwitch(int(doubleBufferIndex), directProcess != 0)
+		}
+		return params
+	}
+
+	var t ASIOTime
+	if raw != nil {
+		raw.toASIOTime(&t)
+	}
+	if ret := cb.BufferSwitchTimeInfo(&t, int32(doubleBufferIndex), directProcess != 0); ret != nil && raw != nil {
+		raw.fromASIOTime(ret)
+	}
+	return params
+}
+
+//export goSampleRateDidChange
+func goSampleRateDidChange(slot C.int, sRate C.double) {
+	if cb := slotCallbacksFor(slot); cb.SampleRateDidChange != nil {
+		cb.SampleRateDidChange(float64(sRate))
+	}
+}
+
+//export goAsioMessage
+func goAsioMessage(slot C.int, selector, value C.long, message unsafe.Pointer, opt *C.double) C.long {
+	cb := slotCallbacksFor(slot)
+	if cb.Message == nil {
+		return 0
+	}
+	return C.long(cb.Message(int32(selector), int32(value), uintptr(message), (*float64)(unsafe.Pointer(opt))))
+}
@@ -0,0 +1,77 @@
+package asio
+
+import (
+	"testing"
+)
+
+func TestSampleTypes(t *testing.T) {
+	tests := []struct {
+		st                SampleType
+		bits, bytes       int
+		isFloat, isBigEnd bool
+		name              string
+	}{
+		{ASIOSTInt16MSB, 16, 2, false, true, "ASIOSTInt16MSB"},
+		{ASIOSTInt24LSB, 24, 3, false, false, "ASIOSTInt24LSB"},
+		{ASIOSTInt32LSB, 32, 4, false, false, "ASIOSTInt32LSB"},
+		{ASIOSTInt32MSB20, 20, 4, false, true, "ASIOSTInt32MSB20"},
+		{ASIOSTInt32LSB24, 24, 4, false, false, "ASIOSTInt32LSB24"},
+		{ASIOSTFloat32MSB, 32, 4, true, true, "ASIOSTFloat32MSB"},
+		{ASIOSTFloat64LSB, 64, 8, true, false, "ASIOSTFloat64LSB"},
+		{ASIOSTDSDInt8MSB1, 1, 1, false, false, "ASIOSTDSDInt8MSB1"},
+		{ASIOSTDSDInt8NER8, 8, 1, false, false, "ASIOSTDSDInt8NER8"},
+	}
+	for _, tt := range tests {
+		if tt.st.BitsPerSample() != tt.bits || tt.st.BytesPerSample() != tt.bytes ||
+			tt.st.IsFloat() != tt.isFloat || tt.st.IsBigEndian() != tt.isBigEnd || tt.st.String() != tt.name {
+			t.Errorf("%v: got %d bits, %d bytes, float %v, big endian %v", tt.st,
+				tt.st.BitsPerSample(), tt.st.BytesPerSample(), tt.st.IsFloat(), tt.st.IsBigEndian())
+		}
+	}
+	if s := SampleType(99).String(); s != "SampleType(99)" {
+		t.Errorf("unknown type prints as %q", s)
+	}
+}
+
+// Every PCM type must survive a float round trip to within its resolution.
+func TestSampleConversion(t *testing.T) {
+	src := []float32{0, 0.5, -0.5, 0.25, -1, 0.999}
+	for st := range sampleTypeNames {
+		if st.IsDSD() {
+			continue
+		}
+		raw := make([]byte, len(src)*st.BytesPerSample())
+		encodeSamples(raw, src, st)
+		got := make([]float32, len(src))
+		decodeSamples(got, raw, st)
+
+		tolerance := float32(1) / float32(int64(1)<<(min(st.BitsPerSample(), 24)-1))
+		for i := range src {
+			if d := got[i] - src[i]; d > tolerance || d < -tolerance {
+				t.Errorf("%v: %v round trips to %v", st, src[i], got[i])
+			}
+		}
+	}
+}
+
+func TestGetAllChannels(t *testing.T) {
+	drv := NewSimDriver(6, 4)
+	drv.GroupSize = 4
+
+	groups, err := GetAllChannels(drv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if g := groups[0]; g.Group != 0 || len(g.Inputs) != 4 || len(g.Outputs) != 4 {
+		t.Errorf("group 0 = %d in, %d out", len(g.Inputs), len(g.Outputs))
+	}
+	if g := groups[1]; g.Group != 1 || len(g.Inputs) != 2 || len(g.Outputs) != 0 {
+		t.Errorf("group 1 = %d in, %d out", len(g.Inputs), len(g.Outputs))
+	}
+	if s := groups[1].Inputs[1].String(); s != `IN6 "In 6" (group 1, ASIOSTFloat32LSB)` {
+		t.Errorf("String() = %s", s)
+	}
+}
@@ -0,0 +1,708 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ConformanceStatus is the outcome of one conformance check.
+type ConformanceStatus int
+
+const (
+	ConformancePass ConformanceStatus = iota
+	ConformanceWarn                   // allowed by the ASIO spec, but a quirk hosts have to work around
+	ConformanceFail
+	ConformanceSkip // could not be checked, e.g. because a check it depends on failed
+)
+
+func (s ConformanceStatus) String() string {
+	switch s {
+	case ConformancePass:
+		return "PASS"
+	case ConformanceWarn:
+		return "WARN"
+	case ConformanceFail:
+		return "FAIL"
+	case ConformanceSkip:
+		return "SKIP"
+	}
+	return fmt.Sprintf("ConformanceStatus(%d)", int(s))
+}
+
+type ConformanceResult struct {
+	Check  string
+	Status ConformanceStatus
+	Detail string
+}
+
+// A ConformanceReport lists the outcome of every check in the order they ran.
+type ConformanceReport struct {
+	Driver  string
+	Results []ConformanceResult
+}
+
+// Reports whether no check failed. Warnings and skipped checks do not count as failures.
+func (r *ConformanceReport) Passed() bool {
+	return r.Count(ConformanceFail) == 0
+}
+
+// Returns the number of checks with the given status.
+func (r *ConformanceReport) Count(status ConformanceStatus) (n int) {
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the result of the named check, or nil if it did not run.
+func (r *ConformanceReport) Result(check string) *ConformanceResult {
+	for i := range r.Results {
+		if r.Results[i].Check == check {
+			return &r.Results[i]
+		}
+	}
+	return nil
+}
+
+func (r *ConformanceReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d passed, %d warnings, %d failed, %d skipped\n", r.Driver, r.Count(ConformancePass),
+		r.Count(ConformanceWarn), r.Count(ConformanceFail), r.Count(ConformanceSkip))
+	for _, res := range r.Results {
+		fmt.Fprintf(&b, "%s  %s", res.Status, res.Check)
+		if res.Detail != "" {
+			fmt.Fprintf(&b, ": %s", res.Detail)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+type ConformanceOptions struct {
+	// Performs one buffer switch, for drivers that only switch when asked, like a Manual SimDriver or
+	// ReplayDriver. Nil waits for the driver's own buffer switches.
+	Step func() error
+
+	// How long to wait for buffer switches; 0 waits 2 seconds.
+	Timeout time.Duration
+
+	// The sample rate to change to while running; 0 picks a standard rate the driver can do.
+	SampleRate float64
+}
+
+// Runs the call sequences and edge cases the ASIO spec defines against drv, and reports how it behaved. drv
+// must be initialized, e.g. by ASIODriver.Open, and have no buffers. Checks that need a running driver create
+// buffers for every channel at the preferred size; drv is left stopped, without buffers and at its original
+// sample rate.
+func CheckConformance(drv Driver, opts ConformanceOptions) *ConformanceReport {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	c := &conformance{drv: drv, opts: opts, report: &ConformanceReport{Driver: drv.GetDriverName()}}
+	c.lastIndex.Store(-1)
+	c.lastPosition.Store(-1)
+	c.callbacks = Callbacks{
+		BufferSwitch:         c.bufferSwitch,
+		BufferSwitchTimeInfo: c.bufferSwitchTimeInfo,
+		SampleRateDidChange:  func(rate float64) { c.rateChanges.Add(1) },
+		Message:              c.message,
+	}
+	c.run()
+	return c.report
+}
+
+type conformance struct {
+	drv       Driver
+	opts      ConformanceOptions
+	report    *ConformanceReport
+	callbacks Callbacks
+
+	numIn, numOut int
+	bufferSize    int
+	sampleRate    float64
+	buffers       []BufferInfo // nil without buffers
+	running       bool
+
+	// Written by the callbacks.
+	switches       atomic.Int64
+	timeInfo       atomic.Int64 // buffer switches through bufferSwitchTimeInfo
+	lastIndex      atomic.Int32
+	indexRepeats   atomic.Int64
+	lastPosition   atomic.Int64
+	positionErrors atomic.Int64
+	rateChanges    atomic.Int64
+	resets         atomic.Int64
+}
+
+func (c *conformance) bufferSwitch(index int, direct bool) {
+	c.switched(index)
+}
+
+func (c *conformance) bufferSwitchTimeInfo(params *ASIOTime, index int32, direct bool) *ASIOTime {
+	c.timeInfo.Add(1)
+	c.switched(int(index))
+	if params.TimeInfo.Flags&SamplePositionValid != 0 {
+		pos := params.TimeInfo.SamplePosition
+		if last := c.lastPosition.Swap(pos); last >= 0 && pos-last != int64(c.bufferSize) {
+			c.positionErrors.Add(1)
+		}
+	}
+	return params
+}
+
+func (c *conformance) switched(index int) {
+	if c.lastIndex.Swap(int32(index)) == int32(index) || index&^1 != 0 {
+		c.indexRepeats.Add(1)
+	}
+	c.switches.Add(1)
+}
+
+// Answers as Stream does.
+func (c *conformance) message(selector, value int32, message uintptr, opt *float64) int32 {
+	switch selector {
+	case AsioSelectorSupported:
+		switch value {
+		case AsioResetRequest, AsioEngineVersion, AsioResyncRequest, AsioLatenciesChanged,
+			AsioSupportsTimeInfo, AsioOverload:
+			return 1
+		}
+	case AsioEngineVersion:
+		return 2
+	case AsioResetRequest:
+		c.resets.Add(1)
+		return 1
+	case AsioResyncRequest, AsioLatenciesChanged, AsioSupportsTimeInfo, AsioOverload:
+		return 1
+	}
+	return 0
+}
+
+// Runs a check and records its outcome.
+func (c *conformance) check(name string, f func() (ConformanceStatus, string)) ConformanceStatus {
+	status, detail := f()
+	c.report.Results = append(c.report.Results, ConformanceResult{name, status, detail})
+	return status
+}
+
+func (c *conformance) skip(name, needs string) {
+	c.report.Results = append(c.report.Results, ConformanceResult{name, ConformanceSkip, "needs " + needs})
+}
+
+func checkPass(format string, args ...any) (ConformanceStatus, string) {
+	return ConformancePass, fmt.Sprintf(format, args...)
+}
+
+func checkWarn(format string, args ...any) (ConformanceStatus, string) {
+	return ConformanceWarn, fmt.Sprintf(format, args...)
+}
+
+func checkFail(format string, args ...any) (ConformanceStatus, string) {
+	return ConformanceFail, fmt.Sprintf(format, args...)
+}
+
+// Waits for n more buffer switches, stepping the driver if it needs it. It returns how many came.
+func (c *conformance) await(n int) int {
+	start := c.switches.Load()
+	if c.opts.Step != nil {
+		for i := 0; i < n; i++ {
+			if c.opts.Step() != nil {
+				break
+			}
+		}
+		return int(c.switches.Load() - start)
+	}
+	deadline := time.Now().Add(c.opts.Timeout)
+	for c.switches.Load()-start < int64(n) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return int(c.switches.Load() - start)
+}
+
+// Reports whether buffer switches keep coming, giving them a few buffers' time.
+func (c *conformance) stillSwitching() bool {
+	start := c.switches.Load()
+	if c.opts.Step != nil {
+		c.opts.Step()
+	} else {
+		period := time.Duration(float64(c.bufferSize) / c.sampleRate * float64(time.Second))
+		time.Sleep(max(3*period, 50*time.Millisecond))
+	}
+	return c.switches.Load() != start
+}
+
+// Creates buffers for every channel, or one channel if all is false.
+func (c *conformance) createBuffers(all bool, size int) error {
+	var descs []BufferInfo
+	for i := 0; i < c.numIn; i++ {
+		descs = append(descs, BufferInfo{Channel: i, IsInput: true})
+	}
+	for i := 0; i < c.numOut; i++ {
+		descs = append(descs, BufferInfo{Channel: i})
+	}
+	if !all {
+		descs = descs[len(descs)-1:]
+	}
+	c.bufferSize = size
+	if err := c.drv.CreateBuffers(descs, size, c.callbacks); err != nil {
+		return err
+	}
+	c.buffers = descs
+	return nil
+}
+
+func (c *conformance) start() error {
+	c.lastIndex.Store(-1)
+	c.lastPosition.Store(-1)
+	if err := c.drv.Start(); err != nil {
+		return err
+	}
+	c.running = true
+	return nil
+}
+
+func (c *conformance) run() {
+	c.check("GetDriverName", func() (ConformanceStatus, string) {
+		if c.report.Driver == "" {
+			return checkFail("empty")
+		}
+		return checkPass("%s, version %d", c.report.Driver, c.drv.GetDriverVersion())
+	})
+
+	channels := c.check("GetChannels", func() (ConformanceStatus, string) {
+		var err error
+		if c.numIn, c.numOut, err = c.drv.GetChannels(); err != nil {
+			return checkFail("%v", err)
+		}
+		if c.numIn < 0 || c.numOut < 0 || c.numIn+c.numOut == 0 {
+			return checkFail("%d inputs and %d outputs", c.numIn, c.numOut)
+		}
+		return checkPass("%d inputs, %d outputs", c.numIn, c.numOut)
+	})
+	if channels == ConformanceFail {
+		c.numIn, c.numOut = 0, 0
+	}
+
+	c.check("GetChannelInfo", func() (ConformanceStatus, string) {
+		var active []string
+		for _, isInput := range []bool{true, false} {
+			count := c.numOut
+			if isInput {
+				count = c.numIn
+			}
+			for i := 0; i < count; i++ {
+				info, err := c.drv.GetChannelInfo(i, isInput)
+				if err != nil {
+					return checkFail("channel %d: %v", i, err)
+				}
+				if info.Channel != i || info.IsInput != isInput {
+					return checkFail("asked for channel %d, got %s", i, info)
+				}
+				if info.SampleType.BytesPerSample() == 0 {
+					return checkFail("%s: unknown sample type %d", info.Name, info.SampleType)
+				}
+				if info.IsActive {
+					active = append(active, info.Name)
+				}
+			}
+		}
+		if len(active) > 0 {
+			return checkWarn("active before CreateBuffers: %s", strings.Join(active, ", "))
+		}
+		return checkPass("")
+	})
+
+	c.check("GetChannelInfo out of range", func() (ConformanceStatus, string) {
+		if _, err := c.drv.GetChannelInfo(c.numIn, true); err == nil {
+			return checkFail("input %d of %d exists", c.numIn, c.numIn)
+		}
+		if _, err := c.drv.GetChannelInfo(-1, false); err == nil {
+			return checkFail("output -1 exists")
+		}
+		return checkPass("")
+	})
+
+	sizes := c.check("GetBufferSize", func() (ConformanceStatus, string) {
+		minSize, maxSize, preferred, granularity, err := c.drv.GetBufferSize()
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		c.bufferSize = preferred
+		detail := fmt.Sprintf("min %d, max %d, preferred %d, granularity %d", minSize, maxSize, preferred, granularity)
+		if minSize <= 0 || minSize > preferred || preferred > maxSize {
+			return checkFail("%s", detail)
+		}
+		powerOf2 := func(n int) bool { return n&(n-1) == 0 }
+		switch {
+		case granularity == -1 && !(powerOf2(minSize) && powerOf2(maxSize) && powerOf2(preferred)):
+			return checkFail("%s: not powers of 2", detail)
+		case granularity == 0 && (minSize != maxSize || minSize != preferred):
+			return checkWarn("%s: sizes differ though there is only one", detail)
+		case granularity > 0 && ((preferred-minSize)%granularity != 0 || (maxSize-minSize)%granularity != 0):
+			return checkFail("%s: not in steps of the granularity", detail)
+		case granularity < -1:
+			return checkFail("%s", detail)
+		}
+		return checkPass("%s", detail)
+	})
+
+	rate := c.check("GetSampleRate", func() (ConformanceStatus, string) {
+		var err error
+		if c.sampleRate, err = c.drv.GetSampleRate(); err != nil {
+			return checkFail("%v", err)
+		}
+		if !(c.sampleRate > 0) || math.IsInf(c.sampleRate, 0) {
+			return checkFail("%v", c.sampleRate)
+		}
+		if err = c.drv.CanSampleRate(c.sampleRate); err != nil {
+			return checkFail("%v, which CanSampleRate refuses: %v", c.sampleRate, err)
+		}
+		return checkPass("%v", c.sampleRate)
+	})
+
+	if rate == ConformanceFail {
+		c.skip("SetSampleRate to the current rate", "GetSampleRate")
+	} else {
+		c.check("SetSampleRate to the current rate", func() (ConformanceStatus, string) {
+			if err := c.drv.SetSampleRate(c.sampleRate); err != nil {
+				return checkFail("%v", err)
+			}
+			return checkPass("")
+		})
+	}
+
+	c.check("GetClockSources", func() (ConformanceStatus, string) {
+		sources, err := c.drv.GetClockSources()
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		var names, current []string
+		for _, src := range sources {
+			names = append(names, src.Name)
+			if src.IsCurrentSource {
+				current = append(current, src.Name)
+			}
+		}
+		if len(sources) == 0 {
+			return checkFail("none")
+		}
+		if len(current) != 1 {
+			return checkWarn("%d current among %s", len(current), strings.Join(names, ", "))
+		}
+		return checkPass("%s; current %s", strings.Join(names, ", "), current[0])
+	})
+
+	c.check("GetLatencies before CreateBuffers", func() (ConformanceStatus, string) {
+		in, out, err := c.drv.GetLatencies()
+		if err != nil {
+			return checkWarn("%v", err)
+		}
+		if in < 0 || out < 0 {
+			return checkFail("input %d, output %d", in, out)
+		}
+		return checkPass("input %d, output %d", in, out)
+	})
+
+	c.check("GetSamplePosition before Start", func() (ConformanceStatus, string) {
+		if pos, _, err := c.drv.GetSamplePosition(); err == nil {
+			return checkWarn("at %d, without an error", pos)
+		}
+		return checkPass("")
+	})
+
+	c.check("Stop without Start", func() (ConformanceStatus, string) {
+		if err := c.drv.Stop(); err != nil {
+			return checkWarn("%v", err)
+		}
+		return checkPass("")
+	})
+
+	c.check("Start without buffers", func() (ConformanceStatus, string) {
+		if err := c.drv.Start(); err == nil {
+			c.drv.Stop()
+			return checkFail("started")
+		}
+		return checkPass("")
+	})
+
+	if sizes == ConformanceFail || channels == ConformanceFail {
+		c.skip("CreateBuffers", "GetChannels and GetBufferSize")
+		return
+	}
+
+	c.check("CreateBuffers with zero channels", func() (ConformanceStatus, string) {
+		if err := c.drv.CreateBuffers(nil, c.bufferSize, c.callbacks); err != nil {
+			return checkPass("refused: %v", err)
+		}
+		if err := c.drv.DisposeBuffers(); err != nil {
+			return checkFail("accepted, but DisposeBuffers failed: %v", err)
+		}
+		return checkPass("accepted")
+	})
+
+	c.check("CreateBuffers with an invalid channel", func() (ConformanceStatus, string) {
+		descs := []BufferInfo{{Channel: c.numIn, IsInput: true}}
+		if err := c.drv.CreateBuffers(descs, c.bufferSize, c.callbacks); err == nil {
+			c.drv.DisposeBuffers()
+			return checkFail("created input %d of %d", c.numIn, c.numIn)
+		}
+		return checkPass("")
+	})
+
+	c.check("CreateBuffers with an invalid size", func() (ConformanceStatus, string) {
+		descs := []BufferInfo{{Channel: 0, IsInput: c.numOut == 0}}
+		if err := c.drv.CreateBuffers(descs, 0, c.callbacks); err == nil {
+			c.drv.DisposeBuffers()
+			return checkWarn("created buffers of 0 frames")
+		}
+		return checkPass("")
+	})
+
+	created := c.check("CreateBuffers", func() (ConformanceStatus, string) {
+		if err := c.createBuffers(true, c.bufferSize); err != nil {
+			return checkFail("%v", err)
+		}
+		for _, buf := range c.buffers {
+			if buf.Buffers[0] == nil || buf.Buffers[1] == nil || buf.Buffers[0] == buf.Buffers[1] {
+				return checkFail("channel %d (input %v) has buffers %p and %p", buf.Channel, buf.IsInput,
+					buf.Buffers[0], buf.Buffers[1])
+			}
+		}
+		return checkPass("%d channels of %d frames", len(c.buffers), c.bufferSize)
+	})
+	if c.buffers == nil {
+		c.skip("Start", "CreateBuffers")
+		return
+	}
+	defer func() {
+		if c.running {
+			c.drv.Stop()
+		}
+		if c.buffers != nil {
+			c.drv.DisposeBuffers()
+		}
+	}()
+	if created == ConformanceFail {
+		c.skip("Start", "CreateBuffers")
+		return
+	}
+
+	c.check("GetChannelInfo after CreateBuffers", func() (ConformanceStatus, string) {
+		for _, buf := range c.buffers {
+			info, err := c.drv.GetChannelInfo(buf.Channel, buf.IsInput)
+			if err != nil {
+				return checkFail("channel %d: %v", buf.Channel, err)
+			}
+			if !info.IsActive {
+				return checkWarn("%s is not active", info.Name)
+			}
+		}
+		return checkPass("")
+	})
+
+	c.check("CreateBuffers twice", func() (ConformanceStatus, string) {
+		descs := []BufferInfo{{Channel: 0, IsInput: c.numOut == 0}}
+		if err := c.drv.CreateBuffers(descs, c.bufferSize, c.callbacks); err == nil {
+			return checkFail("created buffers over the existing ones")
+		}
+		return checkPass("")
+	})
+
+	c.check("GetLatencies after CreateBuffers", func() (ConformanceStatus, string) {
+		in, out, err := c.drv.GetLatencies()
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		if in < 0 || out < 0 {
+			return checkFail("input %d, output %d", in, out)
+		}
+		return checkPass("input %d, output %d", in, out)
+	})
+
+	c.check("OutputReady", func() (ConformanceStatus, string) {
+		if c.drv.OutputReady() {
+			return checkPass("supported")
+		}
+		return checkPass("not supported")
+	})
+
+	started := c.check("Start", func() (ConformanceStatus, string) {
+		if err := c.start(); err != nil {
+			return checkFail("%v", err)
+		}
+		return checkPass("")
+	})
+	if started == ConformanceFail {
+		c.skip("Buffer switches", "Start")
+		return
+	}
+
+	const switches = 8
+	c.check("Buffer switches", func() (ConformanceStatus, string) {
+		if n := c.await(switches); n < switches {
+			return checkFail("%d of %d", n, switches)
+		}
+		if n := c.indexRepeats.Load(); n != 0 {
+			return checkFail("the buffer index did not alternate %d times", n)
+		}
+		if n := c.positionErrors.Load(); n != 0 {
+			return checkFail("the sample position did not advance by the buffer size %d times", n)
+		}
+		return checkPass("")
+	})
+
+	c.check("bufferSwitchTimeInfo", func() (ConformanceStatus, string) {
+		if c.timeInfo.Load() == 0 {
+			return checkWarn("only bufferSwitch, though the host supports time info")
+		}
+		return checkPass("")
+	})
+
+	c.check("GetSamplePosition while running", func() (ConformanceStatus, string) {
+		pos, _, err := c.drv.GetSamplePosition()
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		if pos < 0 {
+			return checkFail("at %d", pos)
+		}
+		return checkPass("")
+	})
+
+	c.check("Start twice", func() (ConformanceStatus, string) {
+		err := c.drv.Start()
+		if n := c.await(2); n < 2 {
+			return checkFail("%d buffer switches after the second Start (%v)", n, err)
+		}
+		if err != nil {
+			return checkWarn("%v", err)
+		}
+		return checkPass("")
+	})
+
+	c.checkRateChange()
+
+	c.check("Stop", func() (ConformanceStatus, string) {
+		err := c.drv.Stop()
+		c.running = false
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		if c.stillSwitching() {
+			return checkFail("buffer switches continued")
+		}
+		return checkPass("")
+	})
+
+	c.check("Stop twice", func() (ConformanceStatus, string) {
+		if err := c.drv.Stop(); err != nil {
+			return checkWarn("%v", err)
+		}
+		return checkPass("")
+	})
+
+	c.check("DisposeBuffers", func() (ConformanceStatus, string) {
+		err := c.drv.DisposeBuffers()
+		c.buffers = nil
+		if err != nil {
+			return checkFail("%v", err)
+		}
+		return checkPass("")
+	})
+
+	c.check("DisposeBuffers twice", func() (ConformanceStatus, string) {
+		if err := c.drv.DisposeBuffers(); err == nil {
+			return checkWarn("disposed of no buffers")
+		}
+		return checkPass("")
+	})
+
+	recreated := c.check("CreateBuffers again", func() (ConformanceStatus, string) {
+		minSize, _, _, _, _ := c.drv.GetBufferSize()
+		if err := c.createBuffers(false, minSize); err != nil {
+			return checkFail("%v", err)
+		}
+		if err := c.start(); err != nil {
+			return checkFail("Start: %v", err)
+		}
+		if n := c.await(2); n < 2 {
+			return checkFail("%d buffer switches", n)
+		}
+		return checkPass("one channel of %d frames", minSize)
+	})
+	if recreated == ConformanceFail {
+		c.skip("DisposeBuffers while running", "CreateBuffers again")
+		if c.running {
+			c.drv.Stop()
+		}
+		return
+	}
+
+	c.check("DisposeBuffers while running", func() (ConformanceStatus, string) {
+		err := c.drv.DisposeBuffers()
+		if err != nil {
+			c.drv.Stop()
+			return checkFail("%v", err)
+		}
+		c.buffers = nil
+		c.running = false
+		if c.stillSwitching() {
+			return checkFail("buffer switches continued")
+		}
+		return checkPass("")
+	})
+}
+
+// Changes the sample rate while running, which the driver may refuse, or accept and tell the host about.
+func (c *conformance) checkRateChange() {
+	const name = "Sample rate change while running"
+	other := c.opts.SampleRate
+	for _, r := range []float64{44100, 48000, 88200, 96000} {
+		if other != 0 {
+			break
+		}
+		if r != c.sampleRate && c.drv.CanSampleRate(r) == nil {
+			other = r
+		}
+	}
+	if other == 0 {
+		c.skip(name, "a second sample rate")
+		return
+	}
+
+	c.check(name, func() (ConformanceStatus, string) {
+		changes, resets := c.rateChanges.Load(), c.resets.Load()
+		if err := c.drv.SetSampleRate(other); err != nil {
+			if rate, _ := c.drv.GetSampleRate(); rate != c.sampleRate {
+				return checkFail("refused %v (%v), but the rate is now %v", other, err, rate)
+			}
+			return checkPass("refused %v: %v", other, err)
+		}
+		c.await(2)
+		notified := c.rateChanges.Load() != changes || c.resets.Load() != resets
+
+		// Go back, as a host does after a reset request: stopped.
+		c.drv.Stop()
+		c.running = false
+		rate, err := c.drv.GetSampleRate()
+		if rerr := c.drv.SetSampleRate(c.sampleRate); rerr != nil {
+			return checkFail("could not go back to %v: %v", c.sampleRate, rerr)
+		}
+		switch {
+		case err != nil:
+			return checkFail("GetSampleRate after changing to %v: %v", other, err)
+		case rate != other:
+			return checkFail("reports %v after changing to %v", rate, other)
+		case !notified:
+			return checkFail("changed to %v without sampleRateDidChange or a reset request", other)
+		}
+		if err := c.start(); err != nil {
+			return checkFail("Start after going back to %v: %v", c.sampleRate, err)
+		}
+		return checkPass("changed to %v", other)
+	})
+}
@@ -0,0 +1,100 @@
+package asio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConformanceSim(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	drv.Manual = true
+	report := CheckConformance(drv, ConformanceOptions{Step: drv.Step})
+	if !report.Passed() || report.Count(ConformanceSkip) != 0 {
+		t.Errorf("%s", report)
+	}
+	if res := report.Result("Sample rate change while running"); res == nil || res.Detail != "changed to 44100" {
+		t.Errorf("rate change: %+v", res)
+	}
+	if rate, _ := drv.GetSampleRate(); rate != 48000 || drv.buffers != nil || drv.running {
+		t.Errorf("left at %v with buffers %v, running %v", rate, drv.buffers, drv.running)
+	}
+}
+
+func TestConformanceSimPaced(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.PreferredSize = 64
+	if report := CheckConformance(drv, ConformanceOptions{}); !report.Passed() {
+		t.Errorf("%s", report)
+	}
+}
+
+// A driver with the quirks hosts meet in the field.
+type quirkyDriver struct {
+	*SimDriver
+	created bool
+}
+
+func (d *quirkyDriver) GetLatencies() (inputLatency, outputLatency int, err error) {
+	if !d.created {
+		return 0, 0, ErrorNotPresent
+	}
+	return d.SimDriver.GetLatencies()
+}
+
+func (d *quirkyDriver) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+	// Never calls bufferSwitchTimeInfo, and changes rate without telling the host.
+	callbacks.BufferSwitchTimeInfo = nil
+	callbacks.SampleRateDidChange = nil
+	if err = d.SimDriver.CreateBuffers(bufferDescriptors, bufferSize, callbacks); err == nil {
+		d.created = true
+	}
+	return err
+}
+
+func TestConformanceQuirks(t *testing.T) {
+	sim := NewSimDriver(2, 2)
+	sim.Manual = true
+	report := CheckConformance(&quirkyDriver{SimDriver: sim}, ConformanceOptions{Step: sim.Step})
+	for check, want := range map[string]ConformanceStatus{
+		"GetLatencies before CreateBuffers": ConformanceWarn,
+		"bufferSwitchTimeInfo":              ConformanceWarn,
+		"Sample rate change while running":  ConformanceFail,
+		"Buffer switches":                   ConformancePass,
+		"Stop":                              ConformancePass,
+	} {
+		if res := report.Result(check); res == nil || res.Status != want {
+			t.Errorf("%s: %+v, want %v", check, res, want)
+		}
+	}
+	if report.Passed() {
+		t.Errorf("passed:\n%s", report)
+	}
+}
+
+// The suite gives the same report for a replay of its own run.
+func TestConformanceReplay(t *testing.T) {
+	sim := NewSimDriver(2, 2)
+	sim.Manual = true
+	var trace bytes.Buffer
+	drv, err := NewTraceDriver(sim, &trace, TraceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CheckConformance(drv, ConformanceOptions{Step: sim.Step})
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayDriver(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Manual = true
+	got := CheckConformance(replay, ConformanceOptions{Step: replay.Step})
+	if got.String() != want.String() {
+		t.Errorf("replayed:\n%s\ntraced:\n%s", got, want)
+	}
+	if d := replay.Divergences(); len(d) != 0 {
+		t.Errorf("divergences: %v", d)
+	}
+}
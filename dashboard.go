@@ -0,0 +1,384 @@
+package asio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dashboard is an http.Handler serving a WebSocket feed of a stream's meters and driver state to browser
+// dashboards, which may also control the crosspoints of a Matrix through it. Every message is a JSON object
+// in a text frame, with its kind in "type".
+//
+// From the dashboard:
+//
+//	{"type":"state", "driver":"Simulated ASIO", "sampleRate":48000, "bufferSize":256, "inputLatency":256,
+//	 "outputLatency":256, "clockSource":"Internal", "resetRequested":false, "overloads":0,
+//	 "inputs":["In 1", ...], "outputs":["Out 1", ...]}
+//		on connecting, and whenever any of it changes
+//	{"type":"meters", "position":123456, "inputs":[{"peak":-12.5, "hold":-10.1, "rms":-20.3,
+//	 "truePeak":-12.2, "clips":0}, ...], "outputs":[...]}
+//		at the dashboard's rate; levels are in dBFS, rounded to 0.1 dB, and null for silence
+//	{"type":"crosspoint", "in":0, "out":1, "gain":-6, "mute":false, "solo":false}
+//		whenever a client changes a crosspoint; gain is in dB, null when off
+//	{"type":"ok", "id":7}
+//	{"type":"error", "id":7, "error":"..."}
+//		in reply to each control message
+//
+// From clients:
+//
+//	{"type":"gain", "id":7, "in":0, "out":1, "gain":-6}
+//		sets a crosspoint's gain in dB; null or no gain turns it off
+//	{"type":"mute", "id":8, "in":0, "out":1, "mute":true}
+//	{"type":"solo", "id":9, "in":0, "out":1, "solo":true}
+//	{"type":"resetClips", "id":10}
+//
+// Channels are indexes into the stream's Inputs and Outputs, and "id" is optional, only echoed back. A client
+// that does not read its messages fast enough is disconnected.
+type Dashboard struct {
+	stream *Stream
+	meters *MeterBank
+	matrix *Matrix
+	period time.Duration
+
+	mu      sync.Mutex // guards clients and state
+	clients map[*dashboardClient]struct{}
+	state   []byte // the latest state message
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// DashboardOptions configure a Dashboard.
+type DashboardOptions struct {
+	Rate   float64 // meter messages per second; defaults to 10
+	Matrix *Matrix // the crosspoints clients control; without one, control messages other than resetClips fail
+}
+
+type dashboardClient struct {
+	conn *wsConn
+	send chan []byte
+	gone chan struct{}
+	once sync.Once
+}
+
+// Messages a client may have queued before it is disconnected.
+const dashboardQueue = 64
+
+// The parts of a state message that change.
+type dashboardState struct {
+	Driver         string  `json:"driver"`
+	SampleRate     float64 `json:"sampleRate"`
+	BufferSize     int     `json:"bufferSize"`
+	InputLatency   int     `json:"inputLatency"`
+	OutputLatency  int     `json:"outputLatency"`
+	ClockSource    string  `json:"clockSource"`
+	ResetRequested bool    `json:"resetRequested"`
+	Overloads      int64   `json:"overloads"`
+}
+
+type dashboardStateMessage struct {
+	Type string `json:"type"`
+	dashboardState
+	Inputs  []string `json:"inputs"`
+	Outputs []string `json:"outputs"`
+}
+
+type dashboardMeter struct {
+	Peak     decibels `json:"peak"`
+	Hold     decibels `json:"hold"`
+	RMS      decibels `json:"rms"`
+	TruePeak decibels `json:"truePeak"`
+	Clips    uint64   `json:"clips"`
+}
+
+type dashboardMetersMessage struct {
+	Type     string           `json:"type"`
+	Position int64            `json:"position"`
+	Inputs   []dashboardMeter `json:"inputs"`
+	Outputs  []dashboardMeter `json:"outputs"`
+}
+
+type dashboardCrosspoint struct {
+	Type string   `json:"type"`
+	In   int      `json:"in"`
+	Out  int      `json:"out"`
+	Gain decibels `json:"gain"`
+	Mute bool     `json:"mute"`
+	Solo bool     `json:"solo"`
+}
+
+type dashboardControl struct {
+	Type string          `json:"type"`
+	ID   json.RawMessage `json:"id,omitempty"`
+	In   int             `json:"in"`
+	Out  int             `json:"out"`
+	Gain *float64        `json:"gain"`
+	Mute bool            `json:"mute"`
+	Solo bool            `json:"solo"`
+}
+
+type dashboardReply struct {
+	Type  string          `json:"type"`
+	ID    json.RawMessage `json:"id,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// A level in dB that encodes as null when it is -Inf.
+type decibels float64
+
+func (d decibels) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(d), -1) || math.IsNaN(float64(d)) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, float64(d), 'f', -1, 64), nil
+}
+
+// Converts a linear level to dBFS, rounded to 0.1 dB.
+func meterDecibels(linear float64) decibels {
+	return decibels(math.Round(ToDBFS(linear)*10) / 10)
+}
+
+// Creates a dashboard for a stream and its meters and starts sampling them.
+func NewDashboard(s *Stream, meters *MeterBank, opts DashboardOptions) *Dashboard {
+	if opts.Rate <= 0 {
+		opts.Rate = 10
+	}
+	d := &Dashboard{
+		stream:  s,
+		meters:  meters,
+		matrix:  opts.Matrix,
+		period:  time.Duration(float64(time.Second) / opts.Rate),
+		clients: make(map[*dashboardClient]struct{}),
+		stop:    make(chan struct{}),
+	}
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// Returns the number of clients connected.
+func (d *Dashboard) Clients() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.clients)
+}
+
+func (d *Dashboard) run() {
+	defer d.wg.Done()
+
+	var names [2][]string
+	for i, infos := range [][]*ChannelInfo{d.stream.Inputs(), d.stream.Outputs()} {
+		for _, info := range infos {
+			names[i] = append(names[i], info.Name)
+		}
+	}
+	var st dashboardState
+	st.Driver = d.stream.Driver().GetDriverName()
+	st.BufferSize = d.stream.BufferSize()
+	latencyChanges := int64(-1)
+
+	ticker := time.NewTicker(d.period)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		next := st
+		next.SampleRate = d.stream.SampleRate()
+		next.ResetRequested = d.stream.ResetRequested()
+		next.Overloads = d.stream.Overloads()
+		if n := d.stream.LatencyChanges(); n != latencyChanges {
+			latencyChanges = n
+			next.InputLatency, next.OutputLatency, _ = d.stream.Latencies()
+		}
+		if first || next.SampleRate != st.SampleRate || next.ResetRequested != st.ResetRequested {
+			// The clock source usually changes with these.
+			next.ClockSource = d.clockSource()
+		}
+		if first || next != st {
+			st = next
+			msg, _ := json.Marshal(dashboardStateMessage{"state", st, names[0], names[1]})
+			d.mu.Lock()
+			d.state = msg
+			d.mu.Unlock()
+			d.broadcast(msg)
+		}
+
+		inputs, outputs := d.meters.Snapshot()
+		msg, _ := json.Marshal(dashboardMetersMessage{"meters", d.stream.Position(), dashboardMeters(inputs),
+			dashboardMeters(outputs)})
+		d.broadcast(msg)
+	}
+}
+
+func dashboardMeters(readings []MeterReading) []dashboardMeter {
+	meters := make([]dashboardMeter, len(readings))
+	for i, r := range readings {
+		meters[i] = dashboardMeter{meterDecibels(r.Peak), meterDecibels(r.PeakHold), meterDecibels(r.RMS),
+			meterDecibels(r.TruePeak), r.Clips}
+	}
+	return meters
+}
+
+func (d *Dashboard) clockSource() string {
+	sources, err := d.stream.Driver().GetClockSources()
+	if err != nil {
+		return ""
+	}
+	for _, src := range sources {
+		if src.IsCurrentSource {
+			return src.Name
+		}
+	}
+	return ""
+}
+
+func (d *Dashboard) broadcast(msg []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for c := range d.clients {
+		c.queue(msg)
+	}
+}
+
+// Queues msg for the client, dropping the client if its queue is full.
+func (c *dashboardClient) queue(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		c.drop()
+	}
+}
+
+// Disconnects the client. The connection is closed without a close frame, which could block on a client
+// that does not read.
+func (c *dashboardClient) drop() {
+	c.once.Do(func() {
+		close(c.gone)
+		c.conn.conn.Close()
+	})
+}
+
+func (c *dashboardClient) write() {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.conn.writeText(msg); err != nil {
+				c.drop()
+				return
+			}
+		case <-c.gone:
+			return
+		}
+	}
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgradeWebSocket(w, req)
+	if err != nil {
+		return
+	}
+	c := &dashboardClient{conn: conn, send: make(chan []byte, dashboardQueue), gone: make(chan struct{})}
+
+	d.mu.Lock()
+	select {
+	case <-d.stop:
+		d.mu.Unlock()
+		conn.close()
+		return
+	default:
+	}
+	d.clients[c] = struct{}{}
+	if d.state != nil {
+		c.queue(d.state)
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, c)
+		d.mu.Unlock()
+		c.drop()
+	}()
+
+	go c.write()
+	for {
+		_, msg, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		var ctl dashboardControl
+		reply := dashboardReply{Type: "ok"}
+		if err := json.Unmarshal(msg, &ctl); err != nil {
+			reply.Type, reply.Error = "error", err.Error()
+		} else if err := d.control(&ctl); err != nil {
+			reply.Type, reply.Error = "error", err.Error()
+		}
+		reply.ID = ctl.ID
+		msg, _ = json.Marshal(reply)
+		c.queue(msg)
+	}
+}
+
+var errNoMatrix = errors.New("no matrix to control")
+
+func (d *Dashboard) control(ctl *dashboardControl) error {
+	var err error
+	switch ctl.Type {
+	case "resetClips":
+		d.meters.ResetClips()
+		return nil
+	case "gain", "mute", "solo":
+		if d.matrix == nil {
+			return errNoMatrix
+		}
+	default:
+		return fmt.Errorf("unknown message type %q", ctl.Type)
+	}
+
+	switch ctl.Type {
+	case "gain":
+		gain := math.Inf(-1)
+		if ctl.Gain != nil {
+			gain = *ctl.Gain
+		}
+		err = d.matrix.SetGain(ctl.In, ctl.Out, gain)
+	case "mute":
+		err = d.matrix.SetMute(ctl.In, ctl.Out, ctl.Mute)
+	case "solo":
+		err = d.matrix.SetSolo(ctl.In, ctl.Out, ctl.Solo)
+	}
+	if err != nil {
+		return err
+	}
+
+	gain, mute, solo, _ := d.matrix.Crosspoint(ctl.In, ctl.Out)
+	msg, _ := json.Marshal(dashboardCrosspoint{"crosspoint", ctl.In, ctl.Out, decibels(gain), mute, solo})
+	d.broadcast(msg)
+	return nil
+}
+
+// Stops sampling and disconnects every client.
+func (d *Dashboard) Close() error {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		close(d.stop)
+		for c := range d.clients {
+			c.drop()
+		}
+		d.mu.Unlock()
+	})
+	d.wg.Wait()
+	return nil
+}
@@ -0,0 +1,178 @@
+package asio
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Connects a WebSocket client to the server.
+func dialWebSocket(t *testing.T, srv *httptest.Server) *wsConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return &wsConn{conn: conn, br: br, client: true}
+}
+
+type dashboardMessage struct {
+	Type     string `json:"type"`
+	Position int64  `json:"position"`
+	dashboardState
+	Inputs  json.RawMessage `json:"inputs"`
+	Outputs json.RawMessage `json:"outputs"`
+	ID      json.RawMessage `json:"id"`
+	Error   string          `json:"error"`
+	In, Out int
+	Gain    *float64
+	Mute    bool
+}
+
+// Reads messages until one of the type satisfies ok.
+func readDashboard(t *testing.T, c *wsConn, typ string, ok func(m *dashboardMessage) bool) *dashboardMessage {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, msg, err := c.readMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		var m dashboardMessage
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+		if m.Type == typ && (ok == nil || ok(&m)) {
+			return &m
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		if channel == 0 {
+			for i := range buf {
+				buf[i] = float32(0.5 * math.Sin(2*math.Pi*1000*float64(pos+int64(i))/48000))
+			}
+		}
+	}
+	s := newTestStream(t, drv)
+	meters := NewMeterBank(s, DefaultBallistics)
+	matrix := NewMatrix(s, DefaultRampTime)
+	d := NewDashboard(s, meters, DashboardOptions{Rate: 100, Matrix: matrix})
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+	defer d.Close()
+
+	c := dialWebSocket(t, srv)
+	st := readDashboard(t, c, "state", nil)
+	if st.Driver != drv.Name || st.SampleRate != 48000 || st.BufferSize != 256 || st.ClockSource != "Internal" ||
+		st.ResetRequested || string(st.Inputs) != `["In 1","In 2"]` {
+		t.Errorf("state %+v, inputs %s", st.dashboardState, st.Inputs)
+	}
+
+	stepSeconds(t, drv, 2) // long enough for the RMS to settle
+	var levels []struct{ Peak, RMS *float64 }
+	readDashboard(t, c, "meters", func(m *dashboardMessage) bool {
+		json.Unmarshal(m.Inputs, &levels)
+		return m.Position == s.Position()
+	})
+	if math.Abs(*levels[0].Peak+6) > 0.2 || math.Abs(*levels[0].RMS+9) > 0.2 || levels[1].Peak != nil {
+		t.Errorf("input meters %v %v, %+v", *levels[0].Peak, *levels[0].RMS, levels[1])
+	}
+
+	drv.Message(AsioLatenciesChanged, 0)
+	drv.Message(AsioResetRequest, 0)
+	if err := drv.SetSampleRate(96000); err != nil {
+		t.Fatal(err)
+	}
+	readDashboard(t, c, "state", func(m *dashboardMessage) bool { return m.ResetRequested && m.SampleRate == 96000 })
+}
+
+func TestDashboardControl(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	s := newTestStream(t, drv)
+	matrix := NewMatrix(s, DefaultRampTime)
+	d := NewDashboard(s, NewMeterBank(s, DefaultBallistics), DashboardOptions{Matrix: matrix})
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+	defer d.Close()
+
+	a, b := dialWebSocket(t, srv), dialWebSocket(t, srv)
+	waitFor(t, "the clients", func() bool { return d.Clients() == 2 })
+
+	// Every client hears of the change, then the one that made it gets the reply.
+	a.writeText([]byte(`{"type":"gain","id":1,"in":1,"out":0,"gain":-6}`))
+	for _, c := range []*wsConn{a, b} {
+		x := readDashboard(t, c, "crosspoint", nil)
+		if x.In != 1 || x.Out != 0 || x.Gain == nil || *x.Gain != -6 || x.Mute {
+			t.Errorf("crosspoint %+v", x)
+		}
+	}
+	if r := readDashboard(t, a, "ok", nil); string(r.ID) != "1" {
+		t.Errorf("reply to id %s", r.ID)
+	}
+	if gain, _, _, _ := matrix.Crosspoint(1, 0); gain != -6 {
+		t.Errorf("gain %v", gain)
+	}
+
+	b.writeText([]byte(`{"type":"mute","id":"m","in":1,"out":0,"mute":true}`))
+	readDashboard(t, b, "ok", func(m *dashboardMessage) bool { return string(m.ID) == `"m"` })
+	if _, mute, _, _ := matrix.Crosspoint(1, 0); !mute {
+		t.Error("not muted")
+	}
+	b.writeText([]byte(`{"type":"gain","in":0,"out":0}`))
+	readDashboard(t, a, "crosspoint", func(m *dashboardMessage) bool { return m.In == 0 && m.Gain == nil })
+
+	for _, msg := range []string{`{"type":"mute","id":2,"in":5,"out":0}`, `{"type":"jump","id":2}`, `not json`} {
+		a.writeText([]byte(msg))
+		if r := readDashboard(t, a, "error", nil); r.Error == "" {
+			t.Errorf("%s: no error", msg)
+		}
+	}
+
+	d.Close()
+	a.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := a.readMessage(); err != nil {
+			if strings.Contains(err.Error(), "timeout") {
+				t.Error("still connected after Close")
+			}
+			break
+		}
+	}
+}
+
+func TestDashboardRejectsPlainHTTP(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	s := newTestStream(t, drv)
+	d := NewDashboard(s, NewMeterBank(s, DefaultBallistics), DashboardOptions{})
+	defer d.Close()
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rec.Code)
+	}
+}
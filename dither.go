@@ -0,0 +1,123 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+)
+
+type DitherType int
+
+const (
+	NoDither   DitherType = iota // round to nearest
+	TPDFDither                   // triangular PDF dither of ±1 LSB
+)
+
+// NoiseShaping moves quantization noise away from the frequencies where hearing is most sensitive by feeding
+// the quantization error back through a filter. The weighted curves were designed for 44.1 and 48kHz.
+type NoiseShaping int
+
+const (
+	NoShaping          NoiseShaping = iota
+	FirstOrderShaping               // (1 - z⁻¹): +6dB/octave, rising towards Nyquist
+	SecondOrderShaping              // (1 - z⁻¹)²: +12dB/octave
+	EWeightedShaping                // Lipshitz et al. 5-tap E-weighted curve
+	FWeightedShaping                // Wannamaker 9-tap F-weighted curve
+)
+
+// Error feedback coefficients of each NoiseShaping; the noise transfer function is 1 - Σ c[k]z^-(k+1).
+var noiseShapingCoefs = [...][]float64{
+	NoShaping:          nil,
+	FirstOrderShaping:  {1},
+	SecondOrderShaping: {2, -1},
+	EWeightedShaping:   {2.033, -2.165, 1.959, -1.590, 0.6149},
+	FWeightedShaping:   {2.412, -3.370, 3.937, -4.174, 3.353, -2.205, 1.281, -0.569, 0.0847},
+}
+
+// Dither configures how an integer output quantizes its float samples. The noise comes from an RNG seeded with
+// Seed, so the same settings and input produce the same output.
+type Dither struct {
+	Type    DitherType
+	Shaping NoiseShaping
+	Seed    uint64
+}
+
+func (d Dither) validate() error {
+	if d.Type != NoDither && d.Type != TPDFDither {
+		return fmt.Errorf("unknown dither type %d", d.Type)
+	}
+	if d.Shaping < 0 || int(d.Shaping) >= len(noiseShapingCoefs) {
+		return fmt.Errorf("unknown noise shaping %d", d.Shaping)
+	}
+	return nil
+}
+
+// The state of one output's dither. Only touched on the driver thread.
+type ditherer struct {
+	Dither
+	rng   rng
+	coefs []float64
+	errs  []float64 // previous quantization errors in LSBs, most recent first
+}
+
+func newDitherer(d Dither) *ditherer {
+	coefs := noiseShapingCoefs[d.Shaping]
+	return &ditherer{
+		Dither: d,
+		rng:    newRNG(d.Seed),
+		coefs:  coefs,
+		errs:   make([]float64, len(coefs)),
+	}
+}
+
+// Like quantize, adding dither and shaping the error.
+func (d *ditherer) quantize(x float64, bits uint) int32 {
+	scale := float64(int64(1) << (bits - 1))
+	v := x * scale
+	for k, c := range d.coefs {
+		v -= c * d.errs[k]
+	}
+
+	y := v
+	if d.Type == TPDFDither {
+		y += (d.rng.float() + d.rng.float()) / 2
+	}
+	q := math.Floor(y + 0.5)
+	if q > scale-1 {
+		q = scale - 1
+	} else if q < -scale {
+		q = -scale
+	}
+
+	if len(d.errs) > 0 {
+		// Clipping would feed back an unbounded error and make the loop unstable.
+		e := max(-1.5, min(1.5, q-v))
+		copy(d.errs[1:], d.errs)
+		d.errs[0] = e
+	}
+	return int32(q)
+}
+
+// Sets the dither of output i, an index into Outputs(). It applies to integer sample types only and restarts
+// the dither's RNG and error feedback. The default is NoDither.
+func (s *Stream) SetDither(i int, d Dither) error {
+	if i < 0 || i >= len(s.outputs) {
+		return ErrorInvalidParameter
+	}
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if d == (Dither{}) {
+		s.dithers[i].Store(nil)
+		return nil
+	}
+	s.dithers[i].Store(newDitherer(d))
+	return nil
+}
+
+// Returns the dither of output i.
+func (s *Stream) Dither(i int) Dither {
+	if d := s.dithers[i].Load(); d != nil {
+		return d.Dither
+	}
+	return Dither{}
+}
@@ -0,0 +1,157 @@
+package asio
+
+import (
+	"bytes"
+	"math"
+	"math/cmplx"
+	"sort"
+	"testing"
+)
+
+const ditherN = 8192
+
+// Plays a 1 LSB sine (bin 171 of 8192, ~1kHz) into a 16 bit output and returns the output and its
+// quantization error, both in LSBs.
+func ditherCapture(t *testing.T, d Dither) (out, errs []float64, raw []byte) {
+	drv := NewSimDriver(0, 1)
+	drv.SampleType = ASIOSTInt16LSB
+	s := newTestStream(t, drv)
+	if err := s.SetDither(0, d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGenerator(s, NewSine(171*48000./ditherN), ToDBFS(1.0/(1<<15)), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var ideal []float64
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		for _, x := range b.Out[0] {
+			ideal = append(ideal, float64(x)*(1<<15))
+		}
+	}))
+	buf := make([]float32, 256)
+	drv.RawOutput = func(channel int, pos int64, b []byte) {
+		raw = append(raw, b...)
+		decodeSamples(buf, b, ASIOSTInt16LSB)
+		for _, x := range buf {
+			out = append(out, float64(x)*(1<<15))
+		}
+	}
+	for len(out) < ditherN {
+		drv.Step()
+	}
+	errs = make([]float64, ditherN)
+	for i := range errs {
+		errs[i] = out[i] - ideal[i]
+	}
+	return out[:ditherN], errs, raw
+}
+
+// Returns the power spectrum of x, bins 0 to N/2.
+func powerSpectrum(x []float64) []float64 {
+	c := make([]complex128, len(x))
+	for i, v := range x {
+		c[i] = complex(v, 0)
+	}
+	fft(c, false)
+	p := make([]float64, len(x)/2+1)
+	for i := range p {
+		p[i] = math.Pow(cmplx.Abs(c[i]), 2) / float64(len(x))
+	}
+	return p
+}
+
+func median(x []float64) float64 {
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+	return s[len(s)/2]
+}
+
+func bandPower(p []float64, lo, hi float64) (sum float64) {
+	for i := int(lo * ditherN / 48000); i < int(hi*ditherN/48000); i++ {
+		sum += p[i]
+	}
+	return sum
+}
+
+func meanSquare(x []float64) (sum float64) {
+	for _, v := range x {
+		sum += v * v
+	}
+	return sum / float64(len(x))
+}
+
+// Returns the power of the strongest of the 2nd to 7th harmonics.
+func maxHarmonic(p []float64) (h float64) {
+	for k := 2; k <= 7; k++ {
+		h = max(h, p[k*171])
+	}
+	return h
+}
+
+func TestDitherRemovesDistortion(t *testing.T) {
+	// Rounding a 1 LSB sine leaves strong harmonics and little else.
+	out, _, _ := ditherCapture(t, Dither{})
+	p := powerSpectrum(out)
+	if ratio := maxHarmonic(p) / median(p[1:]); ratio < 1000 {
+		t.Errorf("undithered harmonics only %.1f dB above the floor", 10*math.Log10(ratio))
+	}
+
+	// TPDF dither turns them into a flat floor of 1/4 LSB² (1/12 from rounding plus 1/6 from the dither).
+	out, errs, _ := ditherCapture(t, Dither{Type: TPDFDither, Seed: 1})
+	p = powerSpectrum(out)
+	if ratio := maxHarmonic(p) / median(p[1:]); ratio > 10 {
+		t.Errorf("dithered harmonics %.1f dB above the floor", 10*math.Log10(ratio))
+	}
+	if ms := meanSquare(errs); math.Abs(ms-0.25) > 0.02 {
+		t.Errorf("dithered noise power = %v LSB², want 0.25", ms)
+	}
+	ep := powerSpectrum(errs)
+	if low, high := bandPower(ep, 100, 12000), bandPower(ep, 12000, 23900); math.Abs(10*math.Log10(low/high)) > 0.5 {
+		t.Errorf("TPDF noise not flat: low %v, high %v", low, high)
+	}
+}
+
+func TestNoiseShaping(t *testing.T) {
+	_, flat, _ := ditherCapture(t, Dither{Type: TPDFDither, Seed: 1})
+	fp := powerSpectrum(flat)
+	for _, shaping := range []NoiseShaping{FirstOrderShaping, SecondOrderShaping, EWeightedShaping, FWeightedShaping} {
+		_, errs, _ := ditherCapture(t, Dither{Type: TPDFDither, Shaping: shaping, Seed: 1})
+		ep := powerSpectrum(errs)
+
+		// Less noise where hearing is sensitive, more near Nyquist.
+		low := bandPower(ep, 100, 5000) / bandPower(fp, 100, 5000)
+		high := bandPower(ep, 18000, 23900) / bandPower(fp, 18000, 23900)
+		if low > 0.5 || high < 2 {
+			t.Errorf("shaping %d: 0.1-5kHz %.1f dB, 18-24kHz %.1f dB relative to TPDF",
+				shaping, 10*math.Log10(low), 10*math.Log10(high))
+		}
+	}
+}
+
+func TestDitherRepeatable(t *testing.T) {
+	d := Dither{Type: TPDFDither, Shaping: EWeightedShaping, Seed: 42}
+	_, _, a := ditherCapture(t, d)
+	_, _, b := ditherCapture(t, d)
+	if !bytes.Equal(a, b) {
+		t.Error("same seed gave different output")
+	}
+	d.Seed = 43
+	if _, _, c := ditherCapture(t, d); bytes.Equal(a, c) {
+		t.Error("different seeds gave the same output")
+	}
+}
+
+func TestSetDither(t *testing.T) {
+	s := newTestStream(t, NewSimDriver(0, 2))
+	d := Dither{Type: TPDFDither, Shaping: FWeightedShaping, Seed: 7}
+	if err := s.SetDither(1, d); err != nil || s.Dither(1) != d || s.Dither(0) != (Dither{}) {
+		t.Errorf("SetDither = %v; Dither = %+v, %+v", err, s.Dither(0), s.Dither(1))
+	}
+	if err := s.SetDither(2, d); err != ErrorInvalidParameter {
+		t.Errorf("out of range: %v", err)
+	}
+	if err := s.SetDither(0, Dither{Shaping: 99}); err == nil {
+		t.Error("accepted unknown shaping")
+	}
+}
@@ -0,0 +1,102 @@
+package asio
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+)
+
+// Driver is the set of IASIO methods a host uses. *IASIO implements it on Windows; SimDriver implements it
+// everywhere so that hosts can be exercised without hardware.
+type Driver interface {
+	Init(sysHandle uintptr) (ok bool)
+	GetDriverName() string
+	GetDriverVersion() int32
+	GetErrorMessage() string
+
+	Start() (err error)
+	Stop() (err error)
+	GetChannels() (numInputChannels, numOutputChannels int, err error)
+	GetLatencies() (inputLatency, outputLatency int, err error)
+	GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error)
+	CanSampleRate(sampleRate float64) (err error)
+	GetSampleRate() (sampleRate float64, err error)
+	SetSampleRate(sampleRate float64) (err error)
+	GetClockSources() (sources []ClockSource, err error)
+	SetClockSource(reference int) (err error)
+	GetSamplePosition() (samplePosition, systemTime int64, err error)
+	GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error)
+	CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error)
+	DisposeBuffers() (err error)
+	ControlPanel() (err error)
+	Future(selector int32, opt unsafe.Pointer) (err error)
+	OutputReady() bool
+}
+
+// InitError is returned when a driver's init fails. Message is the driver's own account of why, from
+// getErrorMessage; many drivers leave it empty.
+type InitError struct {
+	Driver  string
+	Message string
+}
+
+func (e *InitError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("asio: could not init %s", e.Driver)
+	}
+	return fmt.Sprintf("asio: could not init %s: %s", e.Driver, e.Message)
+}
+
+// Initializes drv with sysHandle, returning an InitError if it fails.
+func initDriver(drv Driver, name string, sysHandle uintptr) error {
+	if drv.Init(sysHandle) {
+		return nil
+	}
+	return &InitError{Driver: name, Message: drv.GetErrorMessage()}
+}
+
+// The channels of one ChannelGroup, e.g. one port of a multi-port interface.
+type ChannelGroup struct {
+	Group   int
+	Inputs  []*ChannelInfo
+	Outputs []*ChannelInfo
+}
+
+// Returns every input and output channel of drv grouped by ChannelGroup, in ascending group order.
+func GetAllChannels(drv Driver) (groups []*ChannelGroup, err error) {
+	numIn, numOut, err := drv.GetChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	byGroup := make(map[int]*ChannelGroup)
+	group := func(n int) *ChannelGroup {
+		g, ok := byGroup[n]
+		if !ok {
+			g = &ChannelGroup{Group: n}
+			byGroup[n] = g
+			groups = append(groups, g)
+		}
+		return g
+	}
+
+	for i := 0; i < numIn; i++ {
+		info, err := drv.GetChannelInfo(i, true)
+		if err != nil {
+			return nil, err
+		}
+		g := group(info.ChannelGroup)
+		g.Inputs = append(g.Inputs, info)
+	}
+	for i := 0; i < numOut; i++ {
+		info, err := drv.GetChannelInfo(i, false)
+		if err != nil {
+			return nil, err
+		}
+		g := group(info.ChannelGroup)
+		g.Outputs = append(g.Outputs, info)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Group < groups[j].Group })
+	return groups, nil
+}
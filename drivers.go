@@ -1,6 +1,9 @@
+//go:build windows
+
 package asio
 
 import (
+	"errors"
 	"fmt"
 	"syscall"
 	"unsafe"
@@ -14,25 +17,54 @@ type ASIODriver struct {
 	ASIO *IASIO
 }
 
+// OpenOptions configure ASIODriver.OpenWithOptions.
+type OpenOptions struct {
+	// The window passed to the driver's init, which drivers parent their control panel and dialogs to. 0 passes
+	// a hidden message-only window of the package's own.
+	SysHandle uintptr
+}
+
+// Creates and initializes the driver with the default options.
 func (drv *ASIODriver) Open() (err error) {
-	disp, err := CreateInstance(drv.GUID, drv.GUID)
+	return drv.OpenWithOptions(OpenOptions{})
+}
+
+// Creates and initializes the driver. It lives in a COM apartment thread of the package's own, which every call
+// to it goes through, so callers need neither CoInitialize nor a locked OS thread. If the driver's init fails,
+// it is released again and the error is an *InitError.
+func (drv *ASIODriver) OpenWithOptions(opts OpenOptions) (err error) {
+	sysHandle := opts.SysHandle
+	if sysHandle == 0 {
+		if sysHandle, err = driverWindow(); err != nil {
+			return err
+		}
+	}
+	disp, err := createInstanceInApartment(drv.GUID, drv.GUID)
 	if err != nil {
-		return
+		return err
 	}
-	drv.ASIO = (*IASIO)(unsafe.Pointer(disp))
-
-	//drv.ASIO.AsIUnknown().AddRef()
-
-	ok := drv.ASIO.Init(uintptr(0))
-	if !ok {
-		return fmt.Errorf("Could not init ASIO driver")
+	asio := (*IASIO)(unsafe.Pointer(disp))
+	if err = initDriver(asio, drv.Name, sysHandle); err != nil {
+		releaseInApartment(disp)
+		return err
 	}
-
-	return
+	drv.ASIO = asio
+	return nil
 }
 
-func (drv *ASIODriver) Close() {
-	drv.ASIO.AsIUnknown().Release()
+// Stops the driver and disposes of its buffers if a host left it with them, then releases it. Closing a driver
+// that is not open does nothing. It returns the errors of every step, joined.
+func (drv *ASIODriver) Close() error {
+	if drv.ASIO == nil {
+		return nil
+	}
+	var errs []error
+	if drv.ASIO.hasBuffers() {
+		errs = append(errs, drv.ASIO.Stop(), drv.ASIO.DisposeBuffers())
+	}
+	errs = append(errs, releaseInApartment(drv.ASIO.AsIUnknown()))
+	drv.ASIO = nil
+	return errors.Join(errs...)
 }
 
 func newDriver(key syscall.Handle, keynameUTF16 winUTF16string) (drv *ASIODriver, err error) {
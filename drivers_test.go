@@ -1,3 +1,5 @@
+//go:build windows
+
 package asio
 
 import (
@@ -17,11 +19,6 @@ func TestListDrivers(t *testing.T) {
 	}
 
 	{
-		fmt.Printf("CoInitialize(0)\n")
-		CoInitialize(0)
-		defer fmt.Printf("CoUninitialize()\n")
-		defer CoUninitialize()
-
 		ua1000 := drivers["UA-1000"]
 
 		fmt.Printf("ua1000.Open()\n")
@@ -0,0 +1,163 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"unsafe"
+)
+
+type IoFormatType int32
+
+const (
+	FormatInvalid IoFormatType = -1
+	PCMFormat     IoFormatType = 0
+	DSDFormat     IoFormatType = 1
+)
+
+// Used with AsioSetIoFormat, AsioGetIoFormat and AsioCanDoIoFormat.
+type IoFormat struct {
+	FormatType IoFormatType
+	future     [512 - 4]byte
+}
+
+// DSD sample rates. In DSD mode the driver reports the DSD bit rate as its sample rate.
+const (
+	DSD64Rate  = 2822400.
+	DSD128Rate = 5644800.
+	DSD256Rate = 11289600.
+)
+
+// Switches drv between PCM and DSD. This must happen before CreateBuffers; afterwards GetChannelInfo reports
+// the DSD sample types and the sample rate is a DSD rate.
+func SetIoFormat(drv Driver, format IoFormatType) error {
+	f := IoFormat{FormatType: format}
+	if err := drv.Future(AsioCanDoIoFormat, unsafe.Pointer(&f)); err != nil {
+		return err
+	}
+	return drv.Future(AsioSetIoFormat, unsafe.Pointer(&f))
+}
+
+// Returns whether drv is in PCM or DSD mode. Drivers without DSD support are always in PCM mode.
+func GetIoFormat(drv Driver) (IoFormatType, error) {
+	f := IoFormat{FormatType: FormatInvalid}
+	err := drv.Future(AsioGetIoFormat, unsafe.Pointer(&f))
+	if err == ErrorNotPresent {
+		return PCMFormat, nil
+	}
+	if err != nil {
+		return FormatInvalid, err
+	}
+	return f.FormatType, nil
+}
+
+// DSD is handled in this package packed 8 samples per byte with the first sample in the most significant bit, as
+// in DSDIFF and DoP. ReadDSD and WriteDSD convert to and from the driver's buffer layouts:
+//
+//	ASIOSTDSDInt8MSB1  8 samples per byte, first sample in the most significant bit
+//	ASIOSTDSDInt8LSB1  8 samples per byte, first sample in the least significant bit
+//	ASIOSTDSDInt8NER8  1 sample per byte, in the least significant bit (0x00 or 0x01)
+
+// Converts the driver buffer src in layout st to packed DSD in dst. Returns the number of bytes written to dst.
+func ReadDSD(dst, src []byte, st SampleType) int {
+	switch st {
+	case ASIOSTDSDInt8MSB1:
+		return copy(dst, src)
+	case ASIOSTDSDInt8LSB1:
+		n := min(len(dst), len(src))
+		for i := 0; i < n; i++ {
+			dst[i] = bits.Reverse8(src[i])
+		}
+		return n
+	case ASIOSTDSDInt8NER8:
+		n := min(len(dst), len(src)/8)
+		for i := 0; i < n; i++ {
+			var b byte
+			for _, s := range src[8*i : 8*i+8] {
+				b = b<<1 | s&1
+			}
+			dst[i] = b
+		}
+		return n
+	}
+	return 0
+}
+
+// Converts packed DSD in src to layout st in the driver buffer dst. Returns the number of bytes of src used.
+func WriteDSD(dst, src []byte, st SampleType) int {
+	switch st {
+	case ASIOSTDSDInt8MSB1:
+		return copy(dst, src)
+	case ASIOSTDSDInt8LSB1:
+		n := min(len(dst), len(src))
+		for i := 0; i < n; i++ {
+			dst[i] = bits.Reverse8(src[i])
+		}
+		return n
+	case ASIOSTDSDInt8NER8:
+		n := min(len(dst)/8, len(src))
+		for i := 0; i < n; i++ {
+			for j := 0; j < 8; j++ {
+				dst[8*i+j] = src[i] >> (7 - j) & 1
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+// The DSD idle pattern: equal numbers of ones and zeroes.
+const DSDSilence = 0x69
+
+var dsdSilence = [8]byte{DSDSilence, DSDSilence, DSDSilence, DSDSilence, DSDSilence, DSDSilence, DSDSilence, DSDSilence}
+
+func fillDSDSilence(buf []byte, st SampleType) {
+	for len(buf) > 0 {
+		n := WriteDSD(buf, dsdSilence[:], st)
+		if st == ASIOSTDSDInt8NER8 {
+			n *= 8
+		}
+		if n == 0 {
+			return
+		}
+		buf = buf[n:]
+	}
+}
+
+// DoP (DSD over PCM) carries 16 DSD samples in each 24 bit PCM sample, under a marker byte that alternates
+// between DoPMarkerA and DoPMarkerB on successive frames. The PCM rate is the DSD rate / 16.
+const (
+	DoPMarkerA = 0x05
+	DoPMarkerB = 0xFA
+)
+
+// Packs DSD from src, two bytes per frame, into DoP samples in dst. The samples are float32 values that pass
+// bit-exactly through a Stream's 24 and 32 bit integer and float outputs, as long as nothing changes their gain.
+// frame is the absolute frame number of dst[0] and selects the markers, so every channel packed with the same
+// frame numbers carries the same markers. len(src) must be at least 2*len(dst).
+func EncodeDoP(dst []float32, src []byte, frame int64) {
+	for i := range dst {
+		marker := uint32(DoPMarkerA)
+		if (frame+int64(i))&1 != 0 {
+			marker = DoPMarkerB
+		}
+		v := marker<<16 | uint32(src[2*i])<<8 | uint32(src[2*i+1])
+		dst[i] = float32(int32(v<<8)>>8) / (1 << 23)
+	}
+}
+
+// Unpacks the DoP samples in src into DSD in dst, two bytes per frame. It fails at the first sample whose marker
+// is missing or does not alternate, which is how DoP is told apart from PCM.
+func DecodeDoP(dst []byte, src []float32) error {
+	var last uint32
+	for i, x := range src {
+		v := uint32(int32(math.Floor(float64(x)*(1<<23)+0.5))) & 0xffffff
+		marker := v >> 16
+		if (marker != DoPMarkerA && marker != DoPMarkerB) || marker == last {
+			return fmt.Errorf("no DoP marker at frame %d", i)
+		}
+		last = marker
+		dst[2*i], dst[2*i+1] = byte(v>>8), byte(v)
+	}
+	return nil
+}
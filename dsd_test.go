@@ -0,0 +1,158 @@
+package asio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDSDLayouts(t *testing.T) {
+	packed := []byte{0x69, 0x0f, 0x80}
+	tests := []struct {
+		st  SampleType
+		raw []byte
+	}{
+		{ASIOSTDSDInt8MSB1, []byte{0x69, 0x0f, 0x80}},
+		{ASIOSTDSDInt8LSB1, []byte{0x96, 0xf0, 0x01}},
+		{ASIOSTDSDInt8NER8, []byte{
+			0, 1, 1, 0, 1, 0, 0, 1,
+			0, 0, 0, 0, 1, 1, 1, 1,
+			1, 0, 0, 0, 0, 0, 0, 0,
+		}},
+	}
+	for _, test := range tests {
+		raw := make([]byte, len(test.raw))
+		if n := WriteDSD(raw, packed, test.st); n != len(packed) || !bytes.Equal(raw, test.raw) {
+			t.Errorf("%v: WriteDSD = %d, % x", test.st, n, raw)
+		}
+		got := make([]byte, len(packed))
+		if n := ReadDSD(got, test.raw, test.st); n != len(packed) || !bytes.Equal(got, packed) {
+			t.Errorf("%v: ReadDSD = %d, % x", test.st, n, got)
+		}
+	}
+}
+
+func TestDoP(t *testing.T) {
+	dsd := []byte{0x69, 0x96, 0x12, 0x34, 0xff, 0x00}
+	pcm := make([]float32, 3)
+	EncodeDoP(pcm, dsd, 0)
+
+	// The 24 bit samples must come out with the markers on top, bit-exactly.
+	raw := make([]byte, 9)
+	encodeSamples(raw, pcm, ASIOSTInt24LSB)
+	want := []byte{0x96, 0x69, 0x05, 0x34, 0x12, 0xfa, 0x00, 0xff, 0x05}
+	if !bytes.Equal(raw, want) {
+		t.Errorf("Int24LSB = % x, want % x", raw, want)
+	}
+	raw = make([]byte, 12)
+	encodeSamples(raw, pcm, ASIOSTInt32MSB)
+	if want := []byte{0x05, 0x69, 0x96, 0, 0xfa, 0x12, 0x34, 0, 0x05, 0xff, 0x00, 0}; !bytes.Equal(raw, want) {
+		t.Errorf("Int32MSB = % x, want % x", raw, want)
+	}
+
+	got := make([]byte, len(dsd))
+	if err := DecodeDoP(got, pcm); err != nil || !bytes.Equal(got, dsd) {
+		t.Errorf("DecodeDoP = % x, %v", got, err)
+	}
+
+	// Markers follow the absolute frame number.
+	EncodeDoP(pcm[:1], dsd, 7)
+	if err := DecodeDoP(got, pcm); err == nil {
+		t.Error("DecodeDoP accepted repeated markers")
+	}
+	if err := DecodeDoP(got, []float32{0.25, -0.25}); err == nil {
+		t.Error("DecodeDoP accepted PCM")
+	}
+}
+
+func TestDSDStream(t *testing.T) {
+	drv := NewSimDriver(1, 2)
+	drv.Manual = true
+	drv.SupportsDSD = true
+	drv.DSDSampleType = ASIOSTDSDInt8LSB1
+
+	var outputs [2][]byte
+	drv.RawOutput = func(channel int, pos int64, buf []byte) {
+		outputs[channel] = append(outputs[channel][:0], buf...)
+	}
+	drv.RawInput = func(channel int, pos int64, buf []byte) {
+		for i := range buf {
+			buf[i] = 0x0f // LSB first, so 0xf0 packed
+		}
+	}
+
+	s, err := NewStream(drv, StreamOptions{DSD: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if format, _ := GetIoFormat(drv); format != DSDFormat {
+		t.Errorf("GetIoFormat = %d", format)
+	}
+	if s.SampleRate() != DSD64Rate || s.Outputs()[0].SampleType != ASIOSTDSDInt8LSB1 {
+		t.Errorf("rate %v, type %v", s.SampleRate(), s.Outputs()[0].SampleType)
+	}
+
+	// Output 0 copies the input; output 1 is left silent.
+	s.AddProcessor(ProcessorFunc(func(b *Block) {
+		packed := make([]byte, len(b.RawInput(0)))
+		ReadDSD(packed, b.RawInput(0), ASIOSTDSDInt8LSB1)
+		if packed[0] != 0xf0 {
+			t.Errorf("input = %#x", packed[0])
+		}
+		WriteDSD(b.RawOutput(0), packed, ASIOSTDSDInt8LSB1)
+	}))
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	drv.Step()
+
+	if !bytes.Equal(outputs[0], bytes.Repeat([]byte{0x0f}, 256)) {
+		t.Errorf("output 0 = % x...", outputs[0][:4])
+	}
+	if !bytes.Equal(outputs[1], bytes.Repeat([]byte{0x96}, 256)) {
+		t.Errorf("output 1 = % x...", outputs[1][:4])
+	}
+}
+
+func TestSetIoFormatUnsupported(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	if err := SetIoFormat(drv, DSDFormat); err != ErrorNotPresent {
+		t.Errorf("err = %v", err)
+	}
+	if format, err := GetIoFormat(drv); format != PCMFormat || err != nil {
+		t.Errorf("GetIoFormat = %d, %v", format, err)
+	}
+}
+
+func TestDSDStreamRestoresPCM(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Manual = true
+	drv.SupportsDSD = true
+
+	// Neither a stream that fails to open nor one closed leaves the driver in DSD.
+	if _, err := NewStream(drv, StreamOptions{DSD: true, BufferSize: 1}); err != ErrorInvalidParameter {
+		t.Fatalf("a bad buffer size: %v", err)
+	}
+	if format, _ := GetIoFormat(drv); format != PCMFormat {
+		t.Errorf("GetIoFormat = %d after a failed DSD stream", format)
+	}
+	s, err := NewStream(drv, StreamOptions{DSD: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if format, _ := GetIoFormat(drv); format != PCMFormat {
+		t.Errorf("GetIoFormat = %d", format)
+	}
+	if st := s.Inputs()[0].SampleType; st.IsDSD() || s.SampleRate() == DSD64Rate {
+		t.Errorf("a PCM stream after a DSD one: %v at %v", st, s.SampleRate())
+	}
+}
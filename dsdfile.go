@@ -0,0 +1,781 @@
+package asio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"unicode/utf16"
+)
+
+type DSDFileFormat int
+
+const (
+	DSF DSDFileFormat = iota // Sony DSD Stream File, .dsf
+	DFF                      // Philips DSDIFF, .dff
+)
+
+func (f DSDFileFormat) String() string {
+	switch f {
+	case DSF:
+		return "DSF"
+	case DFF:
+		return "DSDIFF"
+	}
+	return fmt.Sprintf("DSDFileFormat(%d)", int(f))
+}
+
+// Describes a DSD file. Samples counts 1-bit samples per channel.
+type DSDFileInfo struct {
+	Format     DSDFileFormat
+	Channels   int
+	SampleRate int
+	Samples    int64
+
+	// Stored as ID3v2 TIT2 and TPE1 frames in DSF, and in the DIIN chunk in DSDIFF.
+	Title  string
+	Artist string
+}
+
+var ErrNotDSDFile = errors.New("not a DSF or DSDIFF file")
+
+const dsfBlockSize = 4096
+
+// DSDReader reads the audio of a DSF or DSDIFF file as packed DSD, one slice per channel. See ReadDSD for the
+// packing.
+type DSDReader struct {
+	r    io.ReadSeeker
+	info DSDFileInfo
+
+	remaining int64 // bytes per channel not yet returned
+	raw       []byte
+	block     [][]byte // the current block, per channel
+	off       int      // bytes of block already returned
+}
+
+// Reads the headers and metadata of a DSF or DSDIFF file, leaving r at the start of the audio.
+func NewDSDReader(r io.ReadSeeker) (*DSDReader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	d := &DSDReader{r: r}
+	var err error
+	switch string(magic[:]) {
+	case "DSD ":
+		err = d.readDSFHeader()
+	case "FRM8":
+		err = d.readDFFHeader()
+	default:
+		return nil, ErrNotDSDFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.info.Channels <= 0 {
+		return nil, fmt.Errorf("%v: %d channels", d.info.Format, d.info.Channels)
+	}
+	d.block = make([][]byte, d.info.Channels)
+	return d, nil
+}
+
+func (d *DSDReader) Info() DSDFileInfo { return d.info }
+
+// Reads len(dst[0]) bytes into each of dst's slices, one per channel, unless the file ends first; then it returns
+// the number of bytes read per channel and io.EOF.
+func (d *DSDReader) Read(dst [][]byte) (n int, err error) {
+	if len(dst) != d.info.Channels {
+		return 0, fmt.Errorf("reading %d channels from a %d channel file", len(dst), d.info.Channels)
+	}
+	want := len(dst[0])
+	for n < want {
+		if d.off == len(d.block[0]) {
+			if d.remaining == 0 {
+				return n, io.EOF
+			}
+			if err = d.fill(); err != nil {
+				return n, err
+			}
+		}
+		k := 0
+		for ch, block := range d.block {
+			k = copy(dst[ch][n:want], block[d.off:])
+		}
+		d.off += k
+		n += k
+	}
+	return n, nil
+}
+
+// Loads the next block of audio into d.block.
+func (d *DSDReader) fill() error {
+	channels := d.info.Channels
+	switch d.info.Format {
+	case DSF:
+		// Each block holds dsfBlockSize bytes of every channel in turn; the last one is padded.
+		if _, err := io.ReadFull(d.r, d.raw); err != nil {
+			return unexpected(err)
+		}
+		k := int(min(d.remaining, dsfBlockSize))
+		for ch := range d.block {
+			d.block[ch] = d.raw[ch*dsfBlockSize : ch*dsfBlockSize+k]
+		}
+		d.remaining -= int64(k)
+	case DFF:
+		// Bytes are interleaved by channel.
+		k := int(min(d.remaining, dsfBlockSize))
+		raw := d.raw[:k*channels]
+		if _, err := io.ReadFull(d.r, raw); err != nil {
+			return unexpected(err)
+		}
+		for ch := range d.block {
+			block := d.block[ch][:0]
+			for i := ch; i < len(raw); i += channels {
+				block = append(block, raw[i])
+			}
+			d.block[ch] = block
+		}
+		d.remaining -= int64(k)
+	}
+	d.off = 0
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *DSDReader) readDSFHeader() error {
+	var hdr struct {
+		ID            [4]byte
+		Size          uint64
+		TotalSize     uint64
+		MetadataStart uint64
+	}
+	var fmtChunk struct {
+		ID            [4]byte
+		Size          uint64
+		Version       uint32
+		FormatID      uint32
+		ChannelType   uint32
+		Channels      uint32
+		SampleRate    uint32
+		BitsPerSample uint32
+		Samples       uint64
+		BlockSize     uint32
+		Reserved      uint32
+	}
+	var data struct {
+		ID   [4]byte
+		Size uint64
+	}
+	if err := binary.Read(d.r, binary.LittleEndian, &hdr); err != nil {
+		return unexpected(err)
+	}
+	if err := binary.Read(d.r, binary.LittleEndian, &fmtChunk); err != nil {
+		return unexpected(err)
+	}
+	if string(fmtChunk.ID[:]) != "fmt " {
+		return errors.New("DSF: missing fmt chunk")
+	}
+	if fmtChunk.FormatID != 0 || fmtChunk.BlockSize != dsfBlockSize ||
+		(fmtChunk.BitsPerSample != 1 && fmtChunk.BitsPerSample != 8) {
+		return fmt.Errorf("DSF: unsupported format %d, block size %d, %d bits per sample",
+			fmtChunk.FormatID, fmtChunk.BlockSize, fmtChunk.BitsPerSample)
+	}
+	if _, err := d.r.Seek(int64(hdr.Size+fmtChunk.Size), io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Read(d.r, binary.LittleEndian, &data); err != nil {
+		return unexpected(err)
+	}
+	if string(data.ID[:]) != "data" {
+		return errors.New("DSF: missing data chunk")
+	}
+
+	d.info = DSDFileInfo{
+		Format:     DSF,
+		Channels:   int(fmtChunk.Channels),
+		SampleRate: int(fmtChunk.SampleRate),
+		Samples:    int64(fmtChunk.Samples),
+	}
+	d.remaining = (d.info.Samples + 7) / 8
+	d.raw = make([]byte, dsfBlockSize*d.info.Channels)
+	if blocks := (data.Size - 12) / uint64(len(d.raw)); uint64(d.remaining) > blocks*dsfBlockSize {
+		return errors.New("DSF: sample count exceeds data chunk")
+	}
+	lsbFirst := fmtChunk.BitsPerSample == 1
+
+	if hdr.MetadataStart != 0 {
+		start, _ := d.r.Seek(0, io.SeekCurrent)
+		if _, err := d.r.Seek(int64(hdr.MetadataStart), io.SeekStart); err != nil {
+			return err
+		}
+		d.info.Title, d.info.Artist = readID3(d.r)
+		if _, err := d.r.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if lsbFirst {
+		d.r = &bitReverser{d.r}
+	}
+	return nil
+}
+
+// Reverses the bits of every byte read, for DSF files stored least significant bit first.
+type bitReverser struct{ io.ReadSeeker }
+
+func (r *bitReverser) Read(p []byte) (n int, err error) {
+	n, err = r.ReadSeeker.Read(p)
+	for i := range p[:n] {
+		p[i] = bits.Reverse8(p[i])
+	}
+	return n, err
+}
+
+// Returns the text of the TIT2 and TPE1 frames of the ID3v2 tag at r, ignoring anything it doesn't understand.
+func readID3(r io.Reader) (title, artist string) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:3]) != "ID3" {
+		return
+	}
+	version := hdr[3]
+	tag := make([]byte, syncsafe(hdr[6:10]))
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return
+	}
+	for len(tag) >= 10 && tag[0] != 0 {
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version >= 4 {
+			size = syncsafe(tag[4:8])
+		}
+		if size > len(tag)-10 {
+			return
+		}
+		body := tag[10 : 10+size]
+		switch id {
+		case "TIT2":
+			title = id3Text(body)
+		case "TPE1":
+			artist = id3Text(body)
+		}
+		tag = tag[10+size:]
+	}
+	return
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0], b[1], b[2], b[3] = byte(n>>21)&0x7f, byte(n>>14)&0x7f, byte(n>>7)&0x7f, byte(n)&0x7f
+}
+
+func id3Text(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	enc, text := body[0], body[1:]
+	switch enc {
+	case 0: // ISO-8859-1
+		runes := make([]rune, 0, len(text))
+		for _, c := range text {
+			if c == 0 {
+				break
+			}
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(text) >= 2 && enc == 1 {
+			if text[0] == 0xff && text[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			u := order.Uint16(text[i:])
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(bytes.TrimRight(text, "\x00"))
+}
+
+// Encodes an ID3v2.4 tag with UTF-8 TIT2 and TPE1 frames, or nothing if both are empty.
+func writeID3(title, artist string) []byte {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{{"TIT2", title}, {"TPE1", artist}} {
+		if f.text == "" {
+			continue
+		}
+		var hdr [10]byte
+		copy(hdr[:], f.id)
+		putSyncsafe(hdr[4:8], 1+len(f.text))
+		frames.Write(hdr[:])
+		frames.WriteByte(3) // UTF-8
+		frames.WriteString(f.text)
+	}
+	if frames.Len() == 0 {
+		return nil
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(tag[6:10], frames.Len())
+	return append(tag, frames.Bytes()...)
+}
+
+// DSDIFF chunks are big-endian and padded to an even length; the pad byte is not counted in the size.
+type dffChunk struct {
+	ID   [4]byte
+	Size uint64
+}
+
+func (d *DSDReader) readDFFHeader() error {
+	var form dffChunk
+	var formType [4]byte
+	if err := binary.Read(d.r, binary.BigEndian, &form); err != nil {
+		return unexpected(err)
+	}
+	if _, err := io.ReadFull(d.r, formType[:]); err != nil {
+		return unexpected(err)
+	}
+	if string(formType[:]) != "DSD " {
+		return ErrNotDSDFile
+	}
+
+	d.info.Format = DFF
+	end := int64(12 + form.Size)
+	var dataStart, dataSize int64 = -1, 0
+	err := forEachDFFChunk(d.r, 16, end, func(c dffChunk, body io.Reader) error {
+		switch string(c.ID[:]) {
+		case "PROP":
+			return d.readDFFProp(c, body)
+		case "DSD ":
+			dataStart, _ = d.r.Seek(0, io.SeekCurrent)
+			dataSize = int64(c.Size)
+		case "DST ":
+			return errors.New("DSDIFF: DST compressed audio is not supported")
+		case "DIIN":
+			return forEachDFFChunk(d.r, 0, int64(c.Size), func(c dffChunk, body io.Reader) error {
+				var text string
+				var count uint32
+				if c.Size < 4 {
+					return nil
+				}
+				if err := binary.Read(body, binary.BigEndian, &count); err != nil {
+					return unexpected(err)
+				}
+				buf := make([]byte, min(uint64(count), c.Size-4))
+				if _, err := io.ReadFull(body, buf); err != nil {
+					return unexpected(err)
+				}
+				text = string(buf)
+				switch string(c.ID[:]) {
+				case "DITI":
+					d.info.Title = text
+				case "DIAR":
+					d.info.Artist = text
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if dataStart < 0 {
+		return errors.New("DSDIFF: missing DSD chunk")
+	}
+	if d.info.Channels <= 0 {
+		return errors.New("DSDIFF: missing CHNL chunk")
+	}
+
+	d.remaining = dataSize / int64(d.info.Channels)
+	d.info.Samples = d.remaining * 8
+	d.raw = make([]byte, dsfBlockSize*d.info.Channels)
+	_, err = d.r.Seek(dataStart, io.SeekStart)
+	return err
+}
+
+func (d *DSDReader) readDFFProp(c dffChunk, body io.Reader) error {
+	var propType [4]byte
+	if _, err := io.ReadFull(body, propType[:]); err != nil {
+		return unexpected(err)
+	}
+	if string(propType[:]) != "SND " {
+		return nil
+	}
+	return forEachDFFChunk(d.r, 0, int64(c.Size)-4, func(c dffChunk, body io.Reader) error {
+		switch string(c.ID[:]) {
+		case "FS  ":
+			var rate uint32
+			if err := binary.Read(body, binary.BigEndian, &rate); err != nil {
+				return unexpected(err)
+			}
+			d.info.SampleRate = int(rate)
+		case "CHNL":
+			var n uint16
+			if err := binary.Read(body, binary.BigEndian, &n); err != nil {
+				return unexpected(err)
+			}
+			d.info.Channels = int(n)
+		case "CMPR":
+			var compression [4]byte
+			if _, err := io.ReadFull(body, compression[:]); err != nil {
+				return unexpected(err)
+			}
+			if string(compression[:]) != "DSD " {
+				return fmt.Errorf("DSDIFF: unsupported compression %q", compression[:])
+			}
+		}
+		return nil
+	})
+}
+
+// Calls f for each chunk from the current position of r, which is at offset start of a region ending at end,
+// then skips to the next chunk whatever f read.
+func forEachDFFChunk(r io.ReadSeeker, start, end int64, f func(c dffChunk, body io.Reader) error) error {
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	base -= start
+	for pos := start; pos+12 <= end; {
+		var c dffChunk
+		if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+			return unexpected(err)
+		}
+		if err := f(c, io.LimitReader(r, int64(c.Size))); err != nil {
+			return err
+		}
+		pos += 12 + int64(c.Size) + int64(c.Size&1)
+		if _, err := r.Seek(base+pos, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DSDWriter writes packed DSD, one slice per channel, to a DSF or DSDIFF file. The headers are completed by
+// Close, which is why it needs an io.WriteSeeker.
+type DSDWriter struct {
+	w       io.WriteSeeker
+	info    DSDFileInfo
+	written int64 // bytes per channel
+
+	block [][]byte // DSF: the partly filled block of each channel
+	raw   []byte
+	err   error
+}
+
+// Creates a writer for a file described by info; Samples is ignored and counted as the audio is written.
+// SampleRate must be one of the DSD rates from DSD64 to DSD512, and DSF files hold at most 6 channels.
+func NewDSDWriter(w io.WriteSeeker, info DSDFileInfo) (*DSDWriter, error) {
+	switch info.SampleRate {
+	case DSD64Rate, DSD128Rate, DSD256Rate, 2 * DSD256Rate:
+	default:
+		return nil, fmt.Errorf("%d Hz is not a DSD rate", info.SampleRate)
+	}
+	if info.Channels <= 0 || (info.Format == DSF && info.Channels > 6) || info.Channels > 0xffff {
+		return nil, fmt.Errorf("%v: cannot write %d channels", info.Format, info.Channels)
+	}
+	if info.Format != DSF && info.Format != DFF {
+		return nil, fmt.Errorf("unknown format %v", info.Format)
+	}
+	info.Samples = 0
+
+	dw := &DSDWriter{w: w, info: info}
+	dw.raw = make([]byte, dsfBlockSize*info.Channels)
+	if info.Format == DSF {
+		dw.block = make([][]byte, info.Channels)
+		for ch := range dw.block {
+			dw.block[ch] = dw.raw[ch*dsfBlockSize : ch*dsfBlockSize]
+		}
+	}
+	if err := dw.writeHeader(); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+func (dw *DSDWriter) Info() DSDFileInfo { return dw.info }
+
+// Appends len(src[0]) bytes of each channel. Every slice must have the same length.
+func (dw *DSDWriter) Write(src [][]byte) error {
+	if dw.err != nil {
+		return dw.err
+	}
+	if len(src) != dw.info.Channels {
+		return fmt.Errorf("writing %d channels to a %d channel file", len(src), dw.info.Channels)
+	}
+	n := len(src[0])
+	for _, s := range src {
+		if len(s) != n {
+			return errors.New("channels differ in length")
+		}
+	}
+
+	// written counts what is in the file or buffered for it; a block that fails to be written is not.
+	switch dw.info.Format {
+	case DSF:
+		for off := 0; off < n && dw.err == nil; {
+			k := 0
+			for ch, block := range dw.block {
+				k = min(n-off, dsfBlockSize-len(block))
+				dw.block[ch] = append(block, src[ch][off:off+k]...)
+			}
+			off += k
+			dw.written += int64(k)
+			if len(dw.block[0]) == dsfBlockSize {
+				if dw.err = dw.flushDSFBlock(); dw.err != nil {
+					dw.written -= dsfBlockSize
+				}
+			}
+		}
+	case DFF:
+		for off := 0; off < n && dw.err == nil; {
+			k := min(n-off, dsfBlockSize)
+			raw := dw.raw[:k*len(src)]
+			for ch, s := range src {
+				for i, b := range s[off : off+k] {
+					raw[i*len(src)+ch] = b
+				}
+			}
+			var w int
+			w, dw.err = dw.w.Write(raw)
+			dw.written += int64(w / len(src))
+			off += k
+		}
+	}
+	return dw.err
+}
+
+func (dw *DSDWriter) flushDSFBlock() error {
+	for ch, block := range dw.block {
+		for i := range block {
+			block[i] = bits.Reverse8(block[i])
+		}
+		clear(block[len(block):dsfBlockSize])
+		dw.block[ch] = block[:0]
+	}
+	_, err := dw.w.Write(dw.raw)
+	return err
+}
+
+// Writes any buffered audio and the metadata, and completes the headers. It does not close the underlying writer.
+func (dw *DSDWriter) Close() error {
+	if dw.err != nil {
+		return dw.err
+	}
+	dw.info.Samples = dw.written * 8
+	switch dw.info.Format {
+	case DSF:
+		if len(dw.block[0]) > 0 {
+			if dw.err = dw.flushDSFBlock(); dw.err != nil {
+				return dw.err
+			}
+		}
+	case DFF:
+		if dw.written*int64(dw.info.Channels)&1 != 0 {
+			if _, dw.err = dw.w.Write([]byte{0}); dw.err != nil {
+				return dw.err
+			}
+		}
+	}
+	dw.err = dw.writeTrailer()
+	if dw.err == nil {
+		dw.err = errors.New("DSDWriter is closed")
+		return nil
+	}
+	return dw.err
+}
+
+const dffFVERVersion = 0x01050000
+
+var dsfChannelTypes = [...]uint32{1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 7}
+
+func (dw *DSDWriter) writeHeader() error {
+	if dw.info.Format == DSF {
+		return dw.writeDSFHeader(0, 0)
+	}
+	return dw.writeDFFHeader(0)
+}
+
+func (dw *DSDWriter) writeTrailer() error {
+	id3 := writeID3(dw.info.Title, dw.info.Artist)
+	end, err := dw.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if dw.info.Format == DSF {
+		var metadata uint64
+		if id3 != nil {
+			metadata = uint64(end)
+			if _, err = dw.w.Write(id3); err != nil {
+				return err
+			}
+		}
+		if _, err = dw.w.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = dw.writeDSFHeader(uint64(end)+uint64(len(id3)), metadata); err != nil {
+			return err
+		}
+	} else {
+		if diin := dffDIIN(dw.info.Title, dw.info.Artist); diin != nil {
+			if _, err = dw.w.Write(diin); err != nil {
+				return err
+			}
+			end += int64(len(diin))
+		}
+		if _, err = dw.w.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = dw.writeDFFHeader(uint64(end) - 12); err != nil {
+			return err
+		}
+	}
+	_, err = dw.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (dw *DSDWriter) writeDSFHeader(fileSize, metadata uint64) error {
+	blocks := (uint64(dw.written) + dsfBlockSize - 1) / dsfBlockSize
+	hdr := struct {
+		ID            [4]byte
+		Size          uint64
+		TotalSize     uint64
+		MetadataStart uint64
+
+		FmtID         [4]byte
+		FmtSize       uint64
+		Version       uint32
+		FormatID      uint32
+		ChannelType   uint32
+		Channels      uint32
+		SampleRate    uint32
+		BitsPerSample uint32
+		Samples       uint64
+		BlockSize     uint32
+		Reserved      uint32
+
+		DataID   [4]byte
+		DataSize uint64
+	}{
+		ID:            [4]byte{'D', 'S', 'D', ' '},
+		Size:          28,
+		TotalSize:     fileSize,
+		MetadataStart: metadata,
+
+		FmtID:         [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       52,
+		Version:       1,
+		ChannelType:   dsfChannelTypes[dw.info.Channels],
+		Channels:      uint32(dw.info.Channels),
+		SampleRate:    uint32(dw.info.SampleRate),
+		BitsPerSample: 1, // least significant bit first
+		Samples:       uint64(dw.info.Samples),
+		BlockSize:     dsfBlockSize,
+
+		DataID:   [4]byte{'d', 'a', 't', 'a'},
+		DataSize: 12 + blocks*dsfBlockSize*uint64(dw.info.Channels),
+	}
+	return binary.Write(dw.w, binary.LittleEndian, &hdr)
+}
+
+// DSDIFF channel IDs for the common layouts; others are numbered C001, C002, ...
+var dffChannelIDs = map[int][]string{
+	1: {"C   "},
+	2: {"SLFT", "SRGT"},
+	5: {"MLFT", "MRGT", "C   ", "LS  ", "RS  "},
+	6: {"MLFT", "MRGT", "C   ", "LFE ", "LS  ", "RS  "},
+}
+
+func (dw *DSDWriter) writeDFFHeader(formSize uint64) error {
+	var b bytes.Buffer
+	chunk := func(id string, size uint64) {
+		b.WriteString(id)
+		binary.Write(&b, binary.BigEndian, size)
+	}
+
+	channels := dw.info.Channels
+	ids := dffChannelIDs[channels]
+	if ids == nil {
+		for ch := 0; ch < channels; ch++ {
+			ids = append(ids, fmt.Sprintf("C%03d", ch+1))
+		}
+	}
+	const compressionName = "not compressed"
+	cmprSize := uint64(4 + 1 + len(compressionName))
+	propSize := 4 + (12 + 4) + (12 + 2 + 4*uint64(channels)) + (12 + cmprSize + cmprSize&1)
+	dataSize := uint64(dw.written) * uint64(channels)
+
+	chunk("FRM8", formSize)
+	b.WriteString("DSD ")
+	chunk("FVER", 4)
+	binary.Write(&b, binary.BigEndian, uint32(dffFVERVersion))
+	chunk("PROP", propSize)
+	b.WriteString("SND ")
+	chunk("FS  ", 4)
+	binary.Write(&b, binary.BigEndian, uint32(dw.info.SampleRate))
+	chunk("CHNL", 2+4*uint64(channels))
+	binary.Write(&b, binary.BigEndian, uint16(channels))
+	for _, id := range ids {
+		b.WriteString(id)
+	}
+	chunk("CMPR", cmprSize)
+	b.WriteString("DSD ")
+	b.WriteByte(byte(len(compressionName)))
+	b.WriteString(compressionName)
+	if cmprSize&1 != 0 {
+		b.WriteByte(0)
+	}
+	chunk("DSD ", dataSize)
+
+	_, err := dw.w.Write(b.Bytes())
+	return err
+}
+
+// Encodes a DIIN chunk holding DITI and DIAR chunks, or nothing if both are empty.
+func dffDIIN(title, artist string) []byte {
+	var body bytes.Buffer
+	for _, c := range []struct{ id, text string }{{"DITI", title}, {"DIAR", artist}} {
+		if c.text == "" {
+			continue
+		}
+		size := 4 + uint64(len(c.text))
+		body.WriteString(c.id)
+		binary.Write(&body, binary.BigEndian, size)
+		binary.Write(&body, binary.BigEndian, uint32(len(c.text)))
+		body.WriteString(c.text)
+		if size&1 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	b.WriteString("DIIN")
+	binary.Write(&b, binary.BigEndian, uint64(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
@@ -0,0 +1,365 @@
+package asio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Generates n bytes of recognisable DSD for channel ch.
+func dsdFixture(ch, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + ch*101 + i>>8)
+	}
+	return b
+}
+
+func writeDSDFile(t *testing.T, info DSDFileInfo, data [][]byte, chunk int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test."+info.Format.String())
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := NewDSDWriter(f, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(data[0]); off += chunk {
+		end := min(off+chunk, len(data[0]))
+		part := make([][]byte, len(data))
+		for ch := range data {
+			part[ch] = data[ch][off:end]
+		}
+		if err = w.Write(part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readDSDFile(t *testing.T, path string, chunk int) (DSDFileInfo, [][]byte) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := NewDSDReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := r.Info()
+	data := make([][]byte, info.Channels)
+	buf := make([][]byte, info.Channels)
+	for ch := range buf {
+		buf[ch] = make([]byte, chunk)
+	}
+	for {
+		n, err := r.Read(buf)
+		for ch := range data {
+			data[ch] = append(data[ch], buf[ch][:n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return info, data
+}
+
+func TestDSDFileRoundTrip(t *testing.T) {
+	for _, format := range []DSDFileFormat{DSF, DFF} {
+		for _, rate := range []int{DSD64Rate, DSD128Rate, DSD256Rate} {
+			for _, channels := range []int{1, 2, 6} {
+				data := make([][]byte, channels)
+				for ch := range data {
+					data[ch] = dsdFixture(ch, 10001)
+				}
+				info := DSDFileInfo{Format: format, Channels: channels, SampleRate: rate, Title: "Tëst", Artist: "Someone"}
+				path := writeDSDFile(t, info, data, 3000)
+
+				got, gotData := readDSDFile(t, path, 777)
+				info.Samples = 10001 * 8
+				if got != info {
+					t.Errorf("%v: info = %+v, want %+v", format, got, info)
+				}
+				for ch := range data {
+					if !bytes.Equal(gotData[ch], data[ch]) {
+						t.Errorf("%v %d Hz: channel %d differs", format, rate, ch)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDSFLayout(t *testing.T) {
+	data := [][]byte{{0x01, 0x80}, {0x69, 0xf0}}
+	path := writeDSDFile(t, DSDFileInfo{Format: DSF, Channels: 2, SampleRate: DSD64Rate}, data, 2)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	if len(file) != 92+2*4096 {
+		t.Fatalf("size = %d", len(file))
+	}
+	for _, field := range []struct {
+		off  int
+		want uint64
+		size int
+	}{
+		{4, 28, 8}, {12, uint64(len(file)), 8}, {20, 0, 8}, // DSD chunk, no metadata
+		{32, 52, 8}, {40, 1, 4}, {44, 0, 4}, {48, 2, 4}, {52, 2, 4}, // fmt: version, raw, stereo
+		{56, DSD64Rate, 4}, {60, 1, 4}, {64, 16, 8}, {72, 4096, 4},
+		{84, 12 + 2*4096, 8}, // data
+	} {
+		var got uint64
+		if field.size == 4 {
+			got = uint64(le.Uint32(file[field.off:]))
+		} else {
+			got = le.Uint64(file[field.off:])
+		}
+		if got != field.want {
+			t.Errorf("offset %d = %d, want %d", field.off, got, field.want)
+		}
+	}
+	if string(file[0:4]) != "DSD " || string(file[28:32]) != "fmt " || string(file[80:84]) != "data" {
+		t.Error("bad chunk IDs")
+	}
+	// Blocks of 4096 bytes per channel, least significant bit first, zero padded.
+	if !bytes.Equal(file[92:95], []byte{0x80, 0x01, 0}) || !bytes.Equal(file[92+4096:92+4099], []byte{0x96, 0x0f, 0}) {
+		t.Errorf("data = % x / % x", file[92:95], file[92+4096:92+4099])
+	}
+
+	// The same file stored most significant bit first.
+	binary.LittleEndian.PutUint32(file[60:], 8)
+	for i := 92; i < len(file); i++ {
+		file[i] = bits.Reverse8(file[i])
+	}
+	if err = os.WriteFile(path, file, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := readDSDFile(t, path, 16); !bytes.Equal(got[0], data[0]) || !bytes.Equal(got[1], data[1]) {
+		t.Errorf("MSB first = % x", got)
+	}
+}
+
+func TestDFFLayout(t *testing.T) {
+	data := [][]byte{{0x01, 0x02, 0x03}, {0x11, 0x12, 0x13}}
+	path := writeDSDFile(t, DSDFileInfo{Format: DFF, Channels: 2, SampleRate: DSD128Rate, Artist: "A"}, data, 3)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	be := binary.BigEndian
+	if string(file[0:4]) != "FRM8" || be.Uint64(file[4:]) != uint64(len(file)-12) || string(file[12:16]) != "DSD " {
+		t.Errorf("bad FRM8 header % x", file[:16])
+	}
+	if string(file[16:20]) != "FVER" || be.Uint32(file[28:]) != 0x01050000 {
+		t.Errorf("bad FVER % x", file[16:32])
+	}
+	i := bytes.Index(file, []byte("FS  "))
+	if i < 0 || be.Uint32(file[i+12:]) != DSD128Rate {
+		t.Error("bad FS chunk")
+	}
+	i = bytes.Index(file, []byte("CHNL"))
+	if i < 0 || be.Uint16(file[i+12:]) != 2 || string(file[i+14:i+22]) != "SLFTSRGT" {
+		t.Error("bad CHNL chunk")
+	}
+	i = bytes.Index(file, []byte("DSD \x00"))
+	if i < 0 || be.Uint64(file[i+4:]) != 6 || !bytes.Equal(file[i+12:i+18], []byte{1, 0x11, 2, 0x12, 3, 0x13}) {
+		t.Errorf("bad DSD chunk % x", file[i:])
+	}
+	i = bytes.Index(file, []byte("DIAR"))
+	if i < 0 || be.Uint64(file[i+4:]) != 5 || string(file[i+16:i+17]) != "A" {
+		t.Error("bad DIAR chunk")
+	}
+}
+
+func TestDSDFileRejects(t *testing.T) {
+	if _, err := NewDSDReader(bytes.NewReader([]byte("RIFF...."))); err != ErrNotDSDFile {
+		t.Errorf("err = %v", err)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "x.dsf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = NewDSDWriter(f, DSDFileInfo{Format: DSF, Channels: 2, SampleRate: 44100}); err == nil {
+		t.Error("accepted a PCM rate")
+	}
+	if _, err = NewDSDWriter(f, DSDFileInfo{Format: DSF, Channels: 8, SampleRate: DSD64Rate}); err == nil {
+		t.Error("accepted 8 channels in DSF")
+	}
+}
+
+// A WriteSeeker whose failing'th write fails.
+type failingWriter struct {
+	bytes.Buffer
+	writes, failing int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.writes == w.failing {
+		return 0, io.ErrShortWrite
+	}
+	return w.Buffer.Write(b)
+}
+
+func (w *failingWriter) Seek(offset int64, whence int) (int64, error) { return int64(w.Len()), nil }
+
+func TestDSDWriterError(t *testing.T) {
+	for _, format := range []DSDFileFormat{DSF, DFF} {
+		w := &failingWriter{}
+		dw, err := NewDSDWriter(w, DSDFileInfo{Format: format, Channels: 1, SampleRate: DSD64Rate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first block of three fails; the ones after it must neither be written nor hide the error.
+		w.failing = w.writes + 1
+		if err := dw.Write([][]byte{dsdFixture(0, 3*dsfBlockSize)}); err != io.ErrShortWrite {
+			t.Errorf("%v: Write = %v", format, err)
+		}
+		if w.writes != w.failing || dw.written != 0 {
+			t.Errorf("%v: %d writes after the failure, %d bytes counted", format, w.writes-w.failing, dw.written)
+		}
+		if err := dw.Close(); err != io.ErrShortWrite {
+			t.Errorf("%v: Close = %v", format, err)
+		}
+	}
+}
+
+func newDSDTestStream(t *testing.T, st SampleType) (*SimDriver, *Stream) {
+	drv := NewSimDriver(2, 2)
+	drv.Manual = true
+	drv.SupportsDSD = true
+	drv.DSDSampleType = st
+	s, err := NewStream(drv, StreamOptions{DSD: true, BufferSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return drv, s
+}
+
+func TestDSDPlayer(t *testing.T) {
+	drv, s := newDSDTestStream(t, ASIOSTDSDInt8NER8)
+	data := [][]byte{dsdFixture(0, 300), dsdFixture(1, 300)}
+	path := writeDSDFile(t, DSDFileInfo{Format: DFF, Channels: 2, SampleRate: DSD64Rate}, data, 300)
+
+	var played [2][]byte
+	drv.RawOutput = func(channel int, pos int64, buf []byte) {
+		packed := make([]byte, len(buf)/8)
+		ReadDSD(packed, buf, ASIOSTDSDInt8NER8)
+		played[channel] = append(played[channel], packed...)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewDSDReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// File channel 0 on output 1 and vice versa.
+	p, err := NewDSDPlayer(s, r, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for done := false; !done; {
+		drv.Step()
+		select {
+		case <-p.Done():
+			done = true
+		default:
+			if time.Now().After(deadline) {
+				t.Fatal("player did not finish")
+			}
+		}
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Underruns() != 0 {
+		t.Errorf("%d underruns", p.Underruns())
+	}
+
+	// 300 bytes take five 64 byte buffers, the last padded with silence, then one silent buffer.
+	want := func(ch int) []byte {
+		return append(append([]byte(nil), data[ch]...), bytes.Repeat([]byte{DSDSilence}, 6*64-300)...)
+	}
+	if !bytes.Equal(played[1], want(0)) || !bytes.Equal(played[0], want(1)) {
+		t.Errorf("played % x...", played[1][:8])
+	}
+}
+
+func TestDSDRecorder(t *testing.T) {
+	drv, s := newDSDTestStream(t, ASIOSTDSDInt8MSB1)
+	drv.RawInput = func(channel int, pos int64, buf []byte) {
+		for i := range buf {
+			buf[i] = byte(pos/512+int64(i)) ^ byte(channel*0xff)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "rec.dsf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewDSDWriter(f, DSDFileInfo{Format: DSF, Channels: 2, SampleRate: DSD64Rate, Title: "Take 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewDSDRecorder(s, w, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		drv.Step()
+		time.Sleep(time.Millisecond) // let the writer keep up
+	}
+	if err = rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	recorded := 5 - int(rec.Overruns())
+
+	info, data := readDSDFile(t, path, 1000)
+	if info.Title != "Take 1" || info.Samples != int64(recorded*512*8) {
+		t.Errorf("info = %+v", info)
+	}
+	if recorded == 5 {
+		for ch := range data {
+			for i, b := range data[ch] {
+				if want := byte(i/512+i%512) ^ byte(ch*0xff); b != want {
+					t.Fatalf("channel %d byte %d = %#x, want %#x", ch, i, b, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package asio
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Blocks of audio queued between the driver thread and file I/O.
+const dsdQueueBuffers = 8
+
+// Returns the packed DSD bytes held by one channel's driver buffer of bufferSize samples.
+func dsdPackedSize(bufferSize int, st SampleType) int {
+	if st == ASIOSTDSDInt8NER8 {
+		return bufferSize / 8
+	}
+	return bufferSize
+}
+
+func dsdChannels(infos []*ChannelInfo, channels []int, rate float64, file DSDFileInfo) error {
+	if len(channels) != file.Channels {
+		return fmt.Errorf("%d channel file on %d channels", file.Channels, len(channels))
+	}
+	if rate != float64(file.SampleRate) {
+		return fmt.Errorf("%d Hz file on a %v Hz stream", file.SampleRate, rate)
+	}
+	for _, ch := range channels {
+		if ch < 0 || ch >= len(infos) {
+			return ErrorInvalidParameter
+		}
+		if !infos[ch].SampleType.IsDSD() {
+			return fmt.Errorf("channel %v is not DSD", infos[ch])
+		}
+	}
+	return nil
+}
+
+func makeDSDBlocks(free chan [][]byte, channels, size int) {
+	for i := 0; i < dsdQueueBuffers; i++ {
+		free <- makeByteBuffers(channels, size)
+	}
+}
+
+func makeByteBuffers(channels, size int) [][]byte {
+	blk := make([][]byte, channels)
+	for ch := range blk {
+		blk[ch] = make([]byte, size)
+	}
+	return blk
+}
+
+// DSDPlayer plays a DSD file on outputs of a DSD stream. The file is read on its own goroutine, ahead of the
+// driver thread; if it falls behind, the outputs are silent for that buffer and Underruns counts it.
+type DSDPlayer struct {
+	stream  *Stream
+	reader  *DSDReader
+	outputs []int
+
+	full chan [][]byte
+	free chan [][]byte
+	stop chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+	err  error // read error, set before ended
+
+	underruns atomic.Int64
+	ended     atomic.Bool // the last block has been queued
+	finished  atomic.Bool // and played
+}
+
+// Starts playing r on the given outputs, file channel i on outputs[i], and adds the player to the stream's
+// processors. The file's sample rate must match the stream's. The first buffers are read before it returns.
+func NewDSDPlayer(s *Stream, r *DSDReader, outputs ...int) (*DSDPlayer, error) {
+	if err := dsdChannels(s.Outputs(), outputs, s.SampleRate(), r.Info()); err != nil {
+		return nil, err
+	}
+
+	p := &DSDPlayer{
+		stream:  s,
+		reader:  r,
+		outputs: outputs,
+		full:    make(chan [][]byte, dsdQueueBuffers),
+		free:    make(chan [][]byte, dsdQueueBuffers),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	size := dsdPackedSize(s.BufferSize(), s.Outputs()[outputs[0]].SampleType)
+	makeDSDBlocks(p.free, len(outputs), size)
+
+	// Prime the queue so playback starts without underruns.
+	eof := false
+	for i := 0; i < dsdQueueBuffers && !eof; i++ {
+		eof = p.readBlock(<-p.free)
+	}
+	p.wg.Add(1)
+	go p.read(eof)
+
+	s.AddProcessor(p)
+	return p, nil
+}
+
+// Reads into blk and queues it. Reports whether reading is over.
+func (p *DSDPlayer) readBlock(blk [][]byte) (over bool) {
+	n, err := p.reader.Read(blk)
+	if n > 0 {
+		for _, b := range blk {
+			for i := n; i < len(b); i++ {
+				b[i] = DSDSilence
+			}
+		}
+		p.full <- blk
+	}
+	if err != nil {
+		if err != io.EOF {
+			p.err = err
+		}
+		p.ended.Store(true)
+		return true
+	}
+	return false
+}
+
+func (p *DSDPlayer) read(eof bool) {
+	defer p.wg.Done()
+
+	for !eof {
+		select {
+		case <-p.stop:
+			return
+		case blk := <-p.free:
+			eof = p.readBlock(blk)
+		}
+	}
+}
+
+func (p *DSDPlayer) Process(b *Block) {
+	select {
+	case blk := <-p.full:
+		for i, o := range p.outputs {
+			WriteDSD(b.RawOutput(o), blk[i], p.stream.outputs[o].SampleType)
+		}
+		p.free <- blk
+	default:
+		// ended is only set after the last block is queued, so an empty queue then means the end of the file.
+		if !p.ended.Load() {
+			p.underruns.Add(1)
+		} else if p.finished.CompareAndSwap(false, true) {
+			close(p.done)
+		}
+	}
+}
+
+// Closed once the whole file has been played.
+func (p *DSDPlayer) Done() <-chan struct{} { return p.done }
+
+// Returns how many buffers were silent because the file could not be read fast enough.
+func (p *DSDPlayer) Underruns() int64 { return p.underruns.Load() }
+
+// Stops playback and removes the player from the stream. Returns the error that ended reading early, if any.
+// The reader is not closed.
+func (p *DSDPlayer) Close() error {
+	p.stream.RemoveProcessor(p)
+	close(p.stop)
+	p.wg.Wait()
+	return p.err
+}
+
+// DSDRecorder records inputs of a DSD stream to a DSD file. The file is written on its own goroutine; if it
+// falls behind, input buffers are dropped and Overruns counts them.
+type DSDRecorder struct {
+	stream *Stream
+	writer *DSDWriter
+	inputs []int
+	queue  recordQueue[[][]byte]
+	err    error // from writing, read once the queue is closed
+}
+
+// Starts recording the given inputs to w, inputs[i] to file channel i, and adds the recorder to the stream's taps.
+// The writer's sample rate must match the stream's.
+func NewDSDRecorder(s *Stream, w *DSDWriter, inputs ...int) (*DSDRecorder, error) {
+	if err := dsdChannels(s.Inputs(), inputs, s.SampleRate(), w.Info()); err != nil {
+		return nil, err
+	}
+
+	r := &DSDRecorder{
+		stream: s,
+		writer: w,
+		inputs: inputs,
+	}
+	size := dsdPackedSize(s.BufferSize(), s.Inputs()[inputs[0]].SampleType)
+	r.queue.start(recordQueueBuffers, func() [][]byte { return makeByteBuffers(len(inputs), size) }, r.put)
+
+	s.AddTap(r)
+	return r, nil
+}
+
+func (r *DSDRecorder) put(blk [][]byte) {
+	if r.err == nil {
+		r.err = r.writer.Write(blk)
+	}
+}
+
+func (r *DSDRecorder) Process(b *Block) {
+	r.queue.queue(func(blk [][]byte) {
+		for i, in := range r.inputs {
+			ReadDSD(blk[i], b.RawInput(in), r.stream.inputs[in].SampleType)
+		}
+	})
+}
+
+// Returns how many input buffers were dropped because the file could not be written fast enough.
+func (r *DSDRecorder) Overruns() int64 { return r.queue.overruns.Load() }
+
+// Stops recording, writes what is queued, including a buffer switch still under way, and closes the
+// DSDWriter, completing the file. The underlying file is not closed.
+func (r *DSDRecorder) Close() error {
+	r.stream.RemoveTap(r)
+	r.queue.close()
+	if r.err != nil {
+		return r.err
+	}
+	return r.writer.Close()
+}
@@ -0,0 +1,181 @@
+package asio
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A FaultScenario scripts how a SimDriver misbehaves: calls that fail, buffer switches that come late or not
+// at all, and messages and sample rate changes the driver sends on its own. Build one by chaining its methods
+// and set it as SimDriver.Faults, e.g.
+//
+//	drv.Faults = NewFaultScenario().
+//		FailCalls("Start", ErrorHWMalfunction, 0, 1).
+//		DropSwitches(100, 3).
+//		ResetRequest(200)
+//
+// Buffer switches are counted from the first Start, across restarts, and include the dropped ones; faults
+// placed "after n switches" come before the buffer switch that would make it n+1.
+type FaultScenario struct {
+	mu       sync.Mutex
+	calls    map[string]int // calls made so far, by method
+	switches int64
+	last     error // the most recent error injected
+	faults   []*fault
+}
+
+type faultKind int
+
+const (
+	faultCall faultKind = iota
+	faultCallDuring
+	faultLate
+	faultDrop
+	faultMessage
+	faultRate
+)
+
+type fault struct {
+	kind         faultKind
+	method       string
+	err          error
+	after, count int64 // count < 0 is forever
+	delay        time.Duration
+	selector     int32
+	rate         float64
+}
+
+// What happens at one buffer switch.
+type switchFaults struct {
+	drop     bool
+	delay    time.Duration
+	messages []int32
+	rate     float64 // 0 keeps the rate
+}
+
+// Creates a scenario without faults.
+func NewFaultScenario() *FaultScenario {
+	return &FaultScenario{calls: make(map[string]int)}
+}
+
+func (f *FaultScenario) add(ft *fault) *FaultScenario {
+	if ft.method != "" {
+		if _, ok := reflect.TypeFor[Driver]().MethodByName(ft.method); !ok {
+			panic(fmt.Sprintf("asio: fault in %q, which is not a Driver method", ft.method))
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, ft)
+	return f
+}
+
+// Makes count calls of method fail with err, after the first after calls succeed; a negative count fails every
+// call from then on. A failing Init returns false. GetErrorMessage then gives the message of err.
+func (f *FaultScenario) FailCalls(method string, err error, after, count int) *FaultScenario {
+	return f.add(&fault{kind: faultCall, method: method, err: err, after: int64(after), count: int64(count)})
+}
+
+// Makes every call of method fail with err after n buffer switches, until count more have passed; a negative
+// count fails every call from then on. ErrorSPNotAdvancing from GetSamplePosition simulates a stalled clock.
+func (f *FaultScenario) FailDuring(method string, err error, n, count int64) *FaultScenario {
+	return f.add(&fault{kind: faultCallDuring, method: method, err: err, after: n, count: count})
+}
+
+// Delays the buffer switch after n switches by delay. The time info still reports when it was due.
+func (f *FaultScenario) LateSwitch(n int64, delay time.Duration) *FaultScenario {
+	return f.add(&fault{kind: faultLate, after: n, count: 1, delay: delay})
+}
+
+// Skips count buffer switches after n. The sample position and buffer index still advance past them, as when a
+// driver misses its interrupts.
+func (f *FaultScenario) DropSwitches(n, count int64) *FaultScenario {
+	return f.add(&fault{kind: faultDrop, after: n, count: count})
+}
+
+// Sends AsioResetRequest to the host after n buffer switches.
+func (f *FaultScenario) ResetRequest(n int64) *FaultScenario {
+	return f.add(&fault{kind: faultMessage, after: n, count: 1, selector: AsioResetRequest})
+}
+
+// Sends AsioResyncRequest to the host after n buffer switches.
+func (f *FaultScenario) ResyncRequest(n int64) *FaultScenario {
+	return f.add(&fault{kind: faultMessage, after: n, count: 1, selector: AsioResyncRequest})
+}
+
+// Switches the driver to rate after n buffer switches, as when an external clock changes, and tells the host
+// with sampleRateDidChange.
+func (f *FaultScenario) ChangeRate(n int64, rate float64) *FaultScenario {
+	return f.add(&fault{kind: faultRate, after: n, count: 1, rate: rate})
+}
+
+// Whether [after, after+count) holds n.
+func (ft *fault) covers(n int64) bool {
+	return n >= ft.after && (ft.count < 0 || n < ft.after+ft.count)
+}
+
+// Counts a call of method and returns the error it fails with, if any.
+func (f *FaultScenario) call(method string) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := int64(f.calls[method])
+	f.calls[method]++
+	for _, ft := range f.faults {
+		if ft.method != method {
+			continue
+		}
+		if ft.kind == faultCall && ft.covers(n) || ft.kind == faultCallDuring && ft.covers(f.switches) {
+			f.last = ft.err
+			return ft.err
+		}
+	}
+	return nil
+}
+
+// The message of the most recent error injected, as a driver's getErrorMessage would give it.
+func (f *FaultScenario) errorMessage() string {
+	if f == nil {
+		return ""
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.last == nil {
+		return ""
+	}
+	return f.last.Error()
+}
+
+// Counts a buffer switch and returns what happens at it.
+func (f *FaultScenario) bufferSwitch() (sf switchFaults) {
+	if f == nil {
+		return sf
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := f.switches
+	f.switches++
+	for _, ft := range f.faults {
+		if !ft.covers(n) {
+			continue
+		}
+		switch ft.kind {
+		case faultLate:
+			sf.delay += ft.delay
+		case faultDrop:
+			sf.drop = true
+		case faultMessage:
+			sf.messages = append(sf.messages, ft.selector)
+		case faultRate:
+			sf.rate = ft.rate
+		}
+	}
+	return sf
+}
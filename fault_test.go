@@ -0,0 +1,156 @@
+package asio
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFaultCalls(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Manual = true
+	drv.Faults = NewFaultScenario().
+		FailCalls("Start", ErrorHWMalfunction, 0, 1).
+		FailCalls("Init", ErrorHWMalfunction, 1, -1).
+		FailDuring("GetSamplePosition", ErrorSPNotAdvancing, 2, 2)
+
+	if !drv.Init(0) || drv.Init(0) || drv.Init(0) {
+		t.Error("Init should fail from its second call on")
+	}
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Start(); err != ErrorHWMalfunction {
+		t.Fatalf("first Start: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("second Start: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		_, _, err := drv.GetSamplePosition()
+		if stalled := i == 2 || i == 3; (err == ErrorSPNotAdvancing) != stalled {
+			t.Errorf("after %d buffer switches: %v", i, err)
+		}
+		drv.Step()
+	}
+}
+
+func TestFaultSwitches(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Faults = NewFaultScenario().
+		DropSwitches(2, 3).
+		LateSwitch(6, 50*time.Millisecond).
+		ResetRequest(7).
+		ChangeRate(8, 44100)
+	s := newTestStream(t, drv)
+	var positions []int64
+	var rates []float64
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		positions = append(positions, b.SamplePosition)
+		rates = append(rates, b.SampleRate)
+	}))
+
+	for i := 0; i < 10; i++ {
+		start := time.Now()
+		if err := drv.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if late := time.Since(start) >= 50*time.Millisecond; late != (i == 6) {
+			t.Errorf("buffer switch %d late: %v", i, late)
+		}
+		if s.ResetRequested() != (i >= 7) {
+			t.Errorf("after buffer switch %d, reset requested: %v", i, s.ResetRequested())
+		}
+	}
+
+	want := []int64{0, 256, 1280, 1536, 1792, 2048, 2304}
+	if len(positions) != len(want) {
+		t.Fatalf("positions %v, want %v", positions, want)
+	}
+	for i := range want {
+		if positions[i] != want[i] {
+			t.Fatalf("positions %v, want %v", positions, want)
+		}
+	}
+	if rates[4] != 48000 || rates[5] != 44100 || s.SampleRate() != 44100 {
+		t.Errorf("rates %v", rates)
+	}
+	if rate, _ := drv.GetSampleRate(); rate != 44100 {
+		t.Errorf("driver at %v", rate)
+	}
+}
+
+// Runs drv for one output channel with a host that answers a reset request by stopping and disposing the
+// buffers from the callback, as hosts do, and returns what those calls returned.
+func stopOnReset(t *testing.T, drv Driver) error {
+	t.Helper()
+	stopped := make(chan error, 1)
+	err := drv.CreateBuffers([]BufferInfo{{Channel: 0}}, 256, Callbacks{
+		BufferSwitch: func(int, bool) {},
+		Message: func(selector, value int32, message uintptr, opt *float64) int32 {
+			if selector != AsioResetRequest {
+				return 0
+			}
+			stopped <- errors.Join(drv.Stop(), drv.DisposeBuffers())
+			return 1
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the host to stop")
+		return nil
+	}
+}
+
+func TestFaultStopFromCallback(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Faults = NewFaultScenario().ResetRequest(2)
+	if err := stopOnReset(t, drv); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Step(); err != ErrorInvalidMode {
+		t.Errorf("Step after stopping: %v", err)
+	}
+}
+
+func TestInitError(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	if err := initDriver(drv, "Sim", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	drv.Faults = NewFaultScenario().FailCalls("Init", ErrorHWMalfunction, 0, 1)
+	err := initDriver(drv, "Sim", 0)
+	var ierr *InitError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("got %v, want an InitError", err)
+	}
+	if ierr.Driver != "Sim" || ierr.Message != ErrorHWMalfunction.Error() {
+		t.Errorf("got %+v", ierr)
+	}
+	if want := "asio: could not init Sim: " + ErrorHWMalfunction.Error(); err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	if got := (&InitError{Driver: "Sim"}).Error(); got != "asio: could not init Sim" {
+		t.Errorf("got %q without a message", got)
+	}
+}
+
+func TestFaultUnknownMethod(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	NewFaultScenario().FailCalls("Restart", ErrorHWMalfunction, 0, 1)
+}
@@ -0,0 +1,229 @@
+package asio
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// A Source produces a test signal at full scale. Sources keep their own phase so that the signal is
+// continuous however the samples are split into buffers.
+type Source interface {
+	// Fills buf with the next len(buf) samples.
+	Generate(buf []float32, sampleRate float64)
+}
+
+// Sine is a sine wave.
+type Sine struct {
+	Frequency float64
+	phase     float64 // in cycles, [0, 1)
+}
+
+func NewSine(frequency float64) *Sine { return &Sine{Frequency: frequency} }
+
+func (g *Sine) Generate(buf []float32, sampleRate float64) {
+	step := g.Frequency / sampleRate
+	for i := range buf {
+		buf[i] = float32(math.Sin(2 * math.Pi * g.phase))
+		g.phase += step
+		g.phase -= math.Floor(g.phase)
+	}
+}
+
+// Square is a square wave with a 50% duty cycle.
+type Square struct {
+	Frequency float64
+	phase     float64 // in cycles, [0, 1)
+}
+
+func NewSquare(frequency float64) *Square { return &Square{Frequency: frequency} }
+
+func (g *Square) Generate(buf []float32, sampleRate float64) {
+	step := g.Frequency / sampleRate
+	for i := range buf {
+		if g.phase < 0.5 {
+			buf[i] = 1
+		} else {
+			buf[i] = -1
+		}
+		g.phase += step
+		g.phase -= math.Floor(g.phase)
+	}
+}
+
+// Sweep is an exponential (log) sine sweep from Start to End Hz over Duration. It restarts when Loop is set
+// and is silent after the sweep otherwise.
+type Sweep struct {
+	Start, End float64
+	Duration   time.Duration
+	Loop       bool
+
+	phase   float64 // in cycles, [0, 1)
+	elapsed int64   // samples since the start of the sweep
+}
+
+func NewSweep(start, end float64, duration time.Duration, loop bool) *Sweep {
+	return &Sweep{Start: start, End: end, Duration: duration, Loop: loop}
+}
+
+func (g *Sweep) Generate(buf []float32, sampleRate float64) {
+	length := int64(g.Duration.Seconds() * sampleRate)
+	ratio := math.Log(g.End / g.Start)
+	for i := range buf {
+		if g.elapsed >= length {
+			if !g.Loop || length == 0 {
+				buf[i] = 0
+				continue
+			}
+			g.elapsed, g.phase = 0, 0
+		}
+		buf[i] = float32(math.Sin(2 * math.Pi * g.phase))
+		f := g.Start * math.Exp(ratio*float64(g.elapsed)/float64(length))
+		g.phase += f / sampleRate
+		g.phase -= math.Floor(g.phase)
+		g.elapsed++
+	}
+}
+
+// WhiteNoise is uniformly distributed white noise.
+type WhiteNoise struct {
+	rng rng
+}
+
+// Noise sources are deterministic for a given seed.
+func NewWhiteNoise(seed uint64) *WhiteNoise { return &WhiteNoise{rng: newRNG(seed)} }
+
+func (g *WhiteNoise) Generate(buf []float32, sampleRate float64) {
+	for i := range buf {
+		buf[i] = float32(g.rng.float())
+	}
+}
+
+// PinkNoise is white noise filtered to fall 3 dB per octave (Paul Kellet's filter).
+type PinkNoise struct {
+	rng        rng
+	b0, b1, b2 float64
+}
+
+func NewPinkNoise(seed uint64) *PinkNoise { return &PinkNoise{rng: newRNG(seed)} }
+
+func (g *PinkNoise) Generate(buf []float32, sampleRate float64) {
+	for i := range buf {
+		white := g.rng.float()
+		g.b0 = 0.99765*g.b0 + white*0.0990460
+		g.b1 = 0.96300*g.b1 + white*0.2965164
+		g.b2 = 0.57000*g.b2 + white*1.0526913
+		pink := (g.b0 + g.b1 + g.b2 + white*0.1848) * 0.25
+		buf[i] = float32(math.Max(-1, math.Min(1, pink)))
+	}
+}
+
+// Impulse is a single-sample full scale click, repeated every Interval. A zero Interval fires once.
+type Impulse struct {
+	Interval time.Duration
+	elapsed  int64 // samples since the last impulse
+	fired    bool
+}
+
+func NewImpulse(interval time.Duration) *Impulse { return &Impulse{Interval: interval} }
+
+func (g *Impulse) Generate(buf []float32, sampleRate float64) {
+	period := int64(g.Interval.Seconds() * sampleRate)
+	for i := range buf {
+		buf[i] = 0
+		if !g.fired || (period > 0 && g.elapsed >= period) {
+			buf[i] = 1
+			g.fired = true
+			g.elapsed = 0
+		}
+		g.elapsed++
+	}
+}
+
+// Silence is digital silence.
+type Silence struct{}
+
+func (Silence) Generate(buf []float32, sampleRate float64) {
+	clear(buf)
+}
+
+// Generator plays a Source on a subset of a stream's outputs, mixed into whatever else is playing there.
+type Generator struct {
+	source  Source
+	outputs []int
+	level   atomic.Uint64 // linear gain, math.Float64bits
+	enabled atomic.Bool
+	buf     []float32
+}
+
+// Creates an enabled generator playing source at level dBFS (peak) on the given indices of s.Outputs(),
+// and adds it to the stream's processors.
+func NewGenerator(s *Stream, source Source, level float64, outputs ...int) (*Generator, error) {
+	for _, ch := range outputs {
+		if ch < 0 || ch >= len(s.Outputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	g := &Generator{
+		source:  source,
+		outputs: outputs,
+		buf:     make([]float32, s.BufferSize()),
+	}
+	g.SetLevel(level)
+	g.enabled.Store(true)
+
+	s.AddProcessor(g)
+	return g, nil
+}
+
+// Sets the output level in dBFS.
+func (g *Generator) SetLevel(level float64) { g.level.Store(math.Float64bits(FromDBFS(level))) }
+
+// Returns the output level in dBFS.
+func (g *Generator) Level() float64 { return ToDBFS(math.Float64frombits(g.level.Load())) }
+
+// Disabling a generator pauses its source; re-enabling resumes it where it left off.
+func (g *Generator) SetEnabled(enabled bool) { g.enabled.Store(enabled) }
+
+func (g *Generator) Enabled() bool { return g.enabled.Load() }
+
+func (g *Generator) Process(b *Block) {
+	if !g.enabled.Load() {
+		return
+	}
+
+	buf := g.buf[:b.Frames]
+	g.source.Generate(buf, b.SampleRate)
+
+	gain := float32(math.Float64frombits(g.level.Load()))
+	for _, ch := range g.outputs {
+		out := b.Out[ch]
+		for i, x := range buf {
+			out[i] += gain * x
+		}
+	}
+}
+
+// rng is a small seedable xorshift64* generator, so that noise and dither are repeatable.
+type rng struct {
+	state uint64
+}
+
+func newRNG(seed uint64) rng {
+	if seed == 0 {
+		seed = 0x9e3779b97f4a7c15
+	}
+	return rng{state: seed}
+}
+
+func (r *rng) uint64() uint64 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	return r.state * 0x2545f4914f6cdd1d
+}
+
+// Returns a uniformly distributed value in [-1, 1).
+func (r *rng) float() float64 {
+	return float64(r.uint64()>>11)/(1<<52) - 1
+}
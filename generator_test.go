@@ -0,0 +1,103 @@
+package asio
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Generating in odd-sized pieces must give exactly the same signal as generating in one go.
+func TestGeneratorPhaseContinuity(t *testing.T) {
+	sources := map[string]func() Source{
+		"sine":   func() Source { return NewSine(1001) },
+		"square": func() Source { return NewSquare(440) },
+		"sweep":  func() Source { return NewSweep(20, 20000, 50*time.Millisecond, true) },
+		"white":  func() Source { return NewWhiteNoise(7) },
+		"pink":   func() Source { return NewPinkNoise(7) },
+		"click":  func() Source { return NewImpulse(10 * time.Millisecond) },
+	}
+	for name, newSource := range sources {
+		want := make([]float32, 9600)
+		newSource().Generate(want, 48000)
+
+		got := make([]float32, len(want))
+		src := newSource()
+		for i, size := 0, 1; i < len(got); size = size*7%509 + 1 {
+			end := min(i+size, len(got))
+			src.Generate(got[i:end], 48000)
+			i = end
+		}
+
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: sample %d = %v, want %v", name, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestGeneratorOnStream(t *testing.T) {
+	drv := NewSimDriver(0, 4)
+	drv.PreferredSize = 100
+	s := newTestStream(t, drv)
+
+	g, err := NewGenerator(s, NewSine(1000), -6, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMeterBank(s, DefaultBallistics)
+	for i := 0; i < 100; i++ {
+		drv.Step()
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		peak := m.Output(ch).Peak
+		if ch == 1 || ch == 3 {
+			if math.Abs(ToDBFS(peak)+6) > 0.01 {
+				t.Errorf("out %d: peak %.2f dBFS, want -6", ch, ToDBFS(peak))
+			}
+		} else if peak != 0 {
+			t.Errorf("out %d: peak %v, want silence", ch, peak)
+		}
+	}
+
+	var last float32
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		last = 0
+		for _, x := range b.Out[1] {
+			last = max(last, x)
+		}
+	}))
+	g.SetEnabled(false)
+	drv.Step()
+	if last != 0 {
+		t.Errorf("disabled generator still playing: peak %v", last)
+	}
+}
+
+func TestGeneratorBadOutput(t *testing.T) {
+	drv := NewSimDriver(0, 2)
+	s := newTestStream(t, drv)
+	for _, ch := range []int{-1, 2} {
+		if _, err := NewGenerator(s, NewSine(1000), -6, 0, ch); err != ErrorInvalidParameter {
+			t.Errorf("output %d: %v", ch, err)
+		}
+	}
+}
+
+func TestImpulseOneShot(t *testing.T) {
+	buf := make([]float32, 1000)
+	src := NewImpulse(0)
+	src.Generate(buf[:10], 48000)
+	src.Generate(buf[10:], 48000)
+	count := 0
+	for _, x := range buf {
+		if x != 0 {
+			count++
+		}
+	}
+	if buf[0] != 1 || count != 1 {
+		t.Errorf("want a single impulse at 0, got %d impulses", count)
+	}
+}
@@ -0,0 +1,263 @@
+package asio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPMonitorOptions configure an HTTPMonitor.
+type HTTPMonitorOptions struct {
+	Inputs []int         // indexes into the stream's Inputs that listeners may choose from; defaults to all
+	Buffer time.Duration // per listener; one that falls this far behind is dropped. Defaults to a second.
+}
+
+// HTTPMonitor is an http.Handler that streams inputs of a running stream to any number of listeners, as an
+// endless WAV file or as raw PCM. The query picks what to send:
+//
+//	channels  comma-separated input indexes; defaults to all the monitor offers
+//	format    wav (the default), or pcm for bare samples
+//	sample    s16le (the default), s24le or f32le
+//
+// Each listener has a buffer of its own that the driver thread copies the inputs into, and that the
+// listener's request goroutine sends from. A listener whose buffer fills up is dropped: its response ends.
+type HTTPMonitor struct {
+	stream *Stream
+	inputs []int
+	buffer time.Duration
+
+	listeners atomic.Pointer[[]Processor] // each a *monitorListener
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	dropped atomic.Int64
+}
+
+type monitorListener struct {
+	monitor *HTTPMonitor
+	inputs  []int
+	fifo    *frameRing
+	wake    chan struct{}
+	dropped atomic.Bool
+
+	// Only touched on the driver thread:
+	view [][]float32
+}
+
+// Creates a monitor and adds it to the stream's taps.
+func NewHTTPMonitor(s *Stream, opts HTTPMonitorOptions) (*HTTPMonitor, error) {
+	if opts.Inputs == nil {
+		for i := range s.Inputs() {
+			opts.Inputs = append(opts.Inputs, i)
+		}
+	}
+	for _, ch := range opts.Inputs {
+		if ch < 0 || ch >= len(s.Inputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = time.Second
+	}
+	m := &HTTPMonitor{
+		stream: s,
+		inputs: opts.Inputs,
+		buffer: opts.Buffer,
+		closed: make(chan struct{}),
+	}
+	s.AddTap(m)
+	return m, nil
+}
+
+// Returns the number of listeners connected.
+func (m *HTTPMonitor) Listeners() int {
+	if list := m.listeners.Load(); list != nil {
+		return len(*list)
+	}
+	return 0
+}
+
+// Returns how many listeners were dropped for falling behind.
+func (m *HTTPMonitor) Dropped() int64 { return m.dropped.Load() }
+
+func (m *HTTPMonitor) Process(b *Block) {
+	if list := m.listeners.Load(); list != nil {
+		for _, l := range *list {
+			l.Process(b)
+		}
+	}
+}
+
+func (l *monitorListener) Process(b *Block) {
+	if l.dropped.Load() {
+		return
+	}
+	for ch, input := range l.inputs {
+		l.view[ch] = b.In[input]
+	}
+	if l.fifo.write(l.view) < b.Frames {
+		l.dropped.Store(true)
+		l.monitor.dropped.Add(1)
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *HTTPMonitor) offers(input int) bool {
+	for _, ch := range m.inputs {
+		if ch == input {
+			return true
+		}
+	}
+	return false
+}
+
+// The sample formats a listener can ask for.
+var monitorSamples = map[string]SampleType{
+	"s16le": ASIOSTInt16LSB,
+	"s24le": ASIOSTInt24LSB,
+	"f32le": ASIOSTFloat32LSB,
+}
+
+func (m *HTTPMonitor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	inputs := m.inputs
+	if v := query.Get("channels"); v != "" {
+		inputs = nil
+		for _, f := range strings.Split(v, ",") {
+			ch, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil || !m.offers(ch) {
+				http.Error(w, fmt.Sprintf("no input %q", f), http.StatusBadRequest)
+				return
+			}
+			inputs = append(inputs, ch)
+		}
+	}
+	if len(inputs) == 0 {
+		http.Error(w, "no inputs", http.StatusBadRequest)
+		return
+	}
+	sample := query.Get("sample")
+	if sample == "" {
+		sample = "s16le"
+	}
+	st, ok := monitorSamples[sample]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown sample format %q", sample), http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "wav" && format != "pcm" {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	sampleRate := m.stream.SampleRate()
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	if format == "pcm" {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("X-Sample-Rate", strconv.Itoa(int(sampleRate)))
+		h.Set("X-Channels", strconv.Itoa(len(inputs)))
+		h.Set("X-Sample-Format", sample)
+	} else {
+		h.Set("Content-Type", "audio/wav")
+	}
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	l := &monitorListener{
+		monitor: m,
+		inputs:  inputs,
+		fifo:    newFrameRing(len(inputs), max(int(m.buffer.Seconds()*sampleRate), 2*m.stream.BufferSize())),
+		wake:    make(chan struct{}, 1),
+		view:    make([][]float32, len(inputs)),
+	}
+	appendProcessor(&m.listeners, l)
+	defer removeProcessor(&m.listeners, l)
+
+	flusher, _ := w.(http.Flusher)
+	if format != "pcm" {
+		if _, err := w.Write(wavStreamHeader(len(inputs), sampleRate, st)); err != nil {
+			return
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	const chunk = 4096 // frames
+	frames := makeFloatBuffers(len(inputs), chunk)
+	interleaved := make([]float32, len(inputs)*chunk)
+	data := make([]byte, len(interleaved)*st.BytesPerSample())
+	for {
+		select {
+		case <-l.wake:
+		case <-req.Context().Done():
+			return
+		case <-m.closed:
+			return
+		}
+		for {
+			n := l.fifo.readInto(frames)
+			if n == 0 {
+				break
+			}
+			for ch, f := range frames {
+				for i, x := range f[:n] {
+					interleaved[i*len(inputs)+ch] = x
+				}
+			}
+			size := n * len(inputs)
+			encodeSamples(data, interleaved[:size], st)
+			if _, err := w.Write(data[:size*st.BytesPerSample()]); err != nil {
+				return
+			}
+		}
+		if l.dropped.Load() {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// Returns the header of a WAV file of unknown length: the RIFF and data chunk sizes are the largest possible.
+func wavStreamHeader(channels int, sampleRate float64, st SampleType) []byte {
+	format, bits := uint16(1), st.BitsPerSample() // PCM
+	if st.IsFloat() {
+		format = 3 // IEEE float
+	}
+	blockAlign := channels * st.BytesPerSample()
+
+	h := make([]byte, 44)
+	copy(h, "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 0xFFFFFFFF)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], format)
+	binary.LittleEndian.PutUint16(h[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(int(sampleRate)*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:], uint16(bits))
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], 0xFFFFFFFF)
+	return h
+}
+
+// Ends every listener's response and removes the monitor from the stream.
+func (m *HTTPMonitor) Close() error {
+	m.stream.RemoveTap(m)
+	m.closeOnce.Do(func() { close(m.closed) })
+	return nil
+}
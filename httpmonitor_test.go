@@ -0,0 +1,168 @@
+package asio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newMonitorStream(t *testing.T) (*SimDriver, *Stream) {
+	drv := NewSimDriver(3, 0)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = vbanRamp(pos+int64(i)) * float32(channel+1) / 4
+		}
+	}
+	return drv, newTestStream(t, drv)
+}
+
+// Starts a request and returns its response once the monitor has the listener.
+func getMonitor(t *testing.T, m *HTTPMonitor, url string) *http.Response {
+	t.Helper()
+	before := m.Listeners()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", url, resp.Status)
+	}
+	waitFor(t, "the listener", func() bool { return m.Listeners() > before })
+	return resp
+}
+
+func TestHTTPMonitorWAV(t *testing.T) {
+	drv, s := newMonitorStream(t)
+	m, err := NewHTTPMonitor(s, HTTPMonitorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	wav := getMonitor(t, m, srv.URL+"?channels=2,0")
+	pcm := getMonitor(t, m, srv.URL+"?channels=1&format=pcm&sample=f32le")
+	for i := 0; i < 16; i++ {
+		drv.Step()
+	}
+	const frames = 16 * 256
+
+	if ct := wav.Header.Get("Content-Type"); ct != "audio/wav" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	data := make([]byte, 44+frames*2*2)
+	if _, err := io.ReadFull(wav.Body, data); err != nil {
+		t.Fatal(err)
+	}
+	h := data[:44]
+	if string(h[:4]) != "RIFF" || string(h[8:16]) != "WAVEfmt " || string(h[36:40]) != "data" ||
+		binary.LittleEndian.Uint16(h[20:]) != 1 || binary.LittleEndian.Uint16(h[22:]) != 2 ||
+		binary.LittleEndian.Uint32(h[24:]) != 48000 || binary.LittleEndian.Uint16(h[34:]) != 16 {
+		t.Errorf("WAV header % x", h)
+	}
+	for i := 0; i < frames; i++ {
+		for ch, input := range []int{2, 0} {
+			got := int16(binary.LittleEndian.Uint16(data[44+4*i+2*ch:]))
+			want := int16(math.Round(float64(vbanRamp(int64(i))*float32(input+1)/4) * (1 << 15)))
+			if got != want {
+				t.Fatalf("frame %d channel %d is %d, want %d", i, ch, got, want)
+			}
+		}
+	}
+
+	if pcm.Header.Get("X-Channels") != "1" || pcm.Header.Get("X-Sample-Rate") != "48000" || pcm.Header.Get("X-Sample-Format") != "f32le" {
+		t.Errorf("PCM headers %v", pcm.Header)
+	}
+	data = make([]byte, frames*4)
+	if _, err := io.ReadFull(pcm.Body, data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < frames; i++ {
+		if got, want := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])), vbanRamp(int64(i))/2; got != want {
+			t.Fatalf("frame %d is %v, want %v", i, got, want)
+		}
+	}
+
+	m.Close()
+	if _, err := io.ReadAll(wav.Body); err != nil {
+		t.Errorf("response did not end cleanly: %v", err)
+	}
+	waitFor(t, "the listeners to go", func() bool { return m.Listeners() == 0 })
+}
+
+func TestHTTPMonitorBadRequests(t *testing.T) {
+	_, s := newMonitorStream(t)
+	m, err := NewHTTPMonitor(s, HTTPMonitorOptions{Inputs: []int{0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for _, query := range []string{"?channels=2", "?channels=x", "?format=mp3", "?sample=s8"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, rec.Code)
+		}
+	}
+	if _, err := NewHTTPMonitor(s, HTTPMonitorOptions{Inputs: []int{3}}); err == nil {
+		t.Error("missing input accepted")
+	}
+}
+
+// A ResponseWriter whose client never reads: writes block until it is released.
+type stalledWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+func (w *stalledWriter) WriteHeader(int)     {}
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestHTTPMonitorDropsSlowListener(t *testing.T) {
+	drv, s := newMonitorStream(t)
+	m, err := NewHTTPMonitor(s, HTTPMonitorOptions{Buffer: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	defer m.Close() // ends the responses the server waits for
+	fast := getMonitor(t, m, srv.URL+"?format=pcm&channels=0")
+
+	w := &stalledWriter{header: http.Header{}, release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	waitFor(t, "the slow listener", func() bool { return m.Listeners() == 2 })
+
+	// The stream keeps running while the slow listener is stuck.
+	for i := 0; i < 40; i++ {
+		drv.Step()
+		if _, err := io.ReadFull(fast.Body, make([]byte, 256*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Dropped() != 1 {
+		t.Fatalf("%d dropped, want 1", m.Dropped())
+	}
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("slow listener still served")
+	}
+	if m.Listeners() != 1 {
+		t.Errorf("%d listeners", m.Listeners())
+	}
+}
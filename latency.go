@@ -0,0 +1,213 @@
+package asio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+type LatencySignal int
+
+const (
+	MLS   LatencySignal = iota // maximum length sequence, 2^15-1 samples
+	Chirp                      // exponential sine sweep
+)
+
+type LatencyOptions struct {
+	Signal     LatencySignal
+	Level      float64       // stimulus level in dBFS
+	MaxLatency time.Duration // longest round trip to search for
+	Timeout    time.Duration // how long to wait for the capture; 0 derives it from the signal length
+}
+
+var DefaultLatencyOptions = LatencyOptions{
+	Signal:     MLS,
+	Level:      -6,
+	MaxLatency: time.Second,
+}
+
+// A LatencyReport compares a measured round trip with what the driver claims.
+type LatencyReport struct {
+	Samples      float64 // measured round trip, in samples
+	Milliseconds float64 // measured round trip
+
+	DriverInput        int     // input latency from GetLatencies, in samples
+	DriverOutput       int     // output latency from GetLatencies, in samples
+	DriverSamples      int     // DriverInput + DriverOutput
+	DriverMilliseconds float64 // DriverSamples in milliseconds
+
+	Confidence float64 // correlation peak relative to the correlation's RMS
+}
+
+func (r *LatencyReport) String() string {
+	return fmt.Sprintf("measured %.1f samples (%.3f ms), driver reports %d+%d = %d samples (%.3f ms)",
+		r.Samples, r.Milliseconds, r.DriverInput, r.DriverOutput, r.DriverSamples, r.DriverMilliseconds)
+}
+
+var ErrNoLoopback = errors.New("no loopback signal detected")
+
+// Measures the round trip from output outCh to input inCh (indices into s.Outputs() and s.Inputs()) with the
+// default options. The stream must be running and the two channels connected, e.g. by a loopback cable.
+func MeasureLatency(s *Stream, outCh, inCh int) (*LatencyReport, error) {
+	return DefaultLatencyOptions.Measure(s, outCh, inCh)
+}
+
+// Plays a test signal on outCh, captures it on inCh and cross-correlates the two to find the delay.
+func (opts LatencyOptions) Measure(s *Stream, outCh, inCh int) (*LatencyReport, error) {
+	if outCh < 0 || outCh >= len(s.Outputs()) || inCh < 0 || inCh >= len(s.Inputs()) {
+		return nil, ErrorInvalidParameter
+	}
+
+	rate := s.SampleRate()
+	var stimulus []float32
+	switch opts.Signal {
+	case MLS:
+		stimulus = mls(15)
+	case Chirp:
+		stimulus = make([]float32, int(rate/2))
+		NewSweep(20, math.Min(20000, rate*0.45), time.Second/2, false).Generate(stimulus, rate)
+	default:
+		return nil, ErrorInvalidParameter
+	}
+
+	maxLag := int(opts.MaxLatency.Seconds() * rate)
+	probe := &latencyProbe{
+		stimulus: stimulus,
+		capture:  make([]float32, len(stimulus)+maxLag),
+		outCh:    outCh,
+		inCh:     inCh,
+		gain:     float32(FromDBFS(opts.Level)),
+		start:    -1,
+		done:     make(chan struct{}),
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 2*time.Duration(float64(len(probe.capture))/rate*float64(time.Second)) + time.Second
+	}
+
+	s.AddProcessor(probe)
+	select {
+	case <-probe.done:
+		s.RemoveProcessor(probe)
+	case <-time.After(timeout):
+		s.RemoveProcessor(probe)
+		return nil, fmt.Errorf("latency measurement timed out after %v", timeout)
+	}
+
+	lag, confidence := crossCorrelate(stimulus, probe.capture, maxLag)
+	if confidence < 10 {
+		return nil, ErrNoLoopback
+	}
+
+	report := &LatencyReport{
+		Samples:      lag,
+		Milliseconds: lag / rate * 1000,
+		Confidence:   confidence,
+	}
+	if in, out, err := s.Latencies(); err == nil {
+		report.DriverInput = in
+		report.DriverOutput = out
+		report.DriverSamples = in + out
+		report.DriverMilliseconds = float64(in+out) / rate * 1000
+	}
+	return report, nil
+}
+
+// Plays the stimulus and records the input from the first buffer switch it sees.
+type latencyProbe struct {
+	stimulus []float32
+	capture  []float32
+	outCh    int
+	inCh     int
+	gain     float32
+	start    int64
+	finished bool
+	done     chan struct{}
+}
+
+func (p *latencyProbe) Process(b *Block) {
+	if p.finished {
+		return
+	}
+	if p.start < 0 {
+		p.start = b.SamplePosition
+	}
+
+	off := int(b.SamplePosition - p.start)
+	out, in := b.Out[p.outCh], b.In[p.inCh]
+	for i := 0; i < b.Frames; i++ {
+		k := off + i
+		if k < len(p.stimulus) {
+			out[i] += p.gain * p.stimulus[k]
+		}
+		if k < len(p.capture) {
+			p.capture[k] = in[i]
+		}
+	}
+
+	if off+b.Frames >= len(p.capture) {
+		p.finished = true
+		close(p.done)
+	}
+}
+
+// Returns a maximum length sequence of +/-1 from a Fibonacci LFSR. Only order 15 (x^15 + x^14 + 1) is needed.
+func mls(order uint) []float32 {
+	n := 1<<order - 1
+	seq := make([]float32, n)
+	lfsr := uint32(1)
+	for i := range seq {
+		if lfsr&1 != 0 {
+			seq[i] = 1
+		} else {
+			seq[i] = -1
+		}
+		bit := (lfsr ^ lfsr>>1) & 1
+		lfsr = lfsr>>1 | bit<<(order-1)
+	}
+	return seq
+}
+
+// Finds the lag in [0, maxLag] at which capture best matches stimulus. The peak is refined to a fraction of
+// a sample by parabolic interpolation. confidence is the peak over the RMS of all lags.
+func crossCorrelate(stimulus, capture []float32, maxLag int) (lag float64, confidence float64) {
+	n := nextPow2(len(stimulus) + len(capture))
+	a := make([]complex128, n)
+	b := make([]complex128, n)
+	for i, x := range stimulus {
+		a[i] = complex(float64(x), 0)
+	}
+	for i, x := range capture {
+		b[i] = complex(float64(x), 0)
+	}
+	fft(a, false)
+	fft(b, false)
+	for i := range a {
+		a[i] = complex(real(a[i]), -imag(a[i])) * b[i]
+	}
+	fft(a, true)
+
+	best, sumSq := 0, 0.0
+	for k := 0; k <= maxLag; k++ {
+		v := real(a[k])
+		sumSq += v * v
+		if math.Abs(v) > math.Abs(real(a[best])) {
+			best = k
+		}
+	}
+	rms := math.Sqrt(sumSq / float64(maxLag+1))
+	if rms == 0 {
+		return 0, 0
+	}
+
+	lag = float64(best)
+	if best > 0 && best < maxLag {
+		y0, y1, y2 := math.Abs(real(a[best-1])), math.Abs(real(a[best])), math.Abs(real(a[best+1]))
+		if d := y0 - 2*y1 + y2; d != 0 {
+			lag += 0.5 * (y0 - y2) / d
+		}
+	}
+	return lag, math.Abs(real(a[best])) / rms
+}
@@ -0,0 +1,85 @@
+package asio
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Steps a manual SimDriver as fast as possible until the returned func is called.
+func runSim(drv *SimDriver) (stop func()) {
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+				drv.Step()
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		wg.Wait()
+	}
+}
+
+func TestMLSPeriod(t *testing.T) {
+	seq := mls(15)
+	sum := 0.0
+	for _, x := range seq {
+		sum += float64(x)
+	}
+	// An MLS has exactly one more +1 than -1.
+	if len(seq) != 32767 || sum != 1 {
+		t.Errorf("len = %d, sum = %v", len(seq), sum)
+	}
+}
+
+func TestMeasureLatency(t *testing.T) {
+	for _, signal := range []LatencySignal{MLS, Chirp} {
+		drv := NewSimDriver(2, 2)
+		drv.Loopback = true
+		drv.LoopbackDelay = 1234
+		drv.InputLatency, drv.OutputLatency = 100, 200
+		s := newTestStream(t, drv)
+
+		stop := runSim(drv)
+		opts := DefaultLatencyOptions
+		opts.Signal = signal
+		opts.Timeout = 10 * time.Second
+		report, err := opts.Measure(s, 1, 1)
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if math.Abs(report.Samples-1234) > 0.5 {
+			t.Errorf("signal %d: measured %v samples, want 1234", signal, report.Samples)
+		}
+		if math.Abs(report.Milliseconds-1234/48.) > 0.01 {
+			t.Errorf("signal %d: measured %v ms", signal, report.Milliseconds)
+		}
+		if report.DriverSamples != 256+100+256+200 {
+			t.Errorf("driver latency = %d", report.DriverSamples)
+		}
+		t.Log(report)
+	}
+}
+
+func TestMeasureLatencyNoLoopback(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	s := newTestStream(t, drv)
+
+	stop := runSim(drv)
+	_, err := MeasureLatency(s, 0, 0)
+	stop()
+	if err != ErrNoLoopback {
+		t.Errorf("err = %v, want ErrNoLoopback", err)
+	}
+}
@@ -0,0 +1,286 @@
+package asio
+
+import "math"
+
+// LTC (SMPTE linear timecode) carries one 80 bit frame per timecode frame, biphase mark coded: the level flips
+// at the start of every bit, and again half way through a 1. Each frame ends with a sync word that also shows
+// which way the tape is playing.
+const (
+	ltcBits        = 80
+	ltcSyncForward = 0x3FFD // bits 64 to 79 in the order they arrive playing forward
+	ltcSyncReverse = 0xBFFC // the same, playing backward
+)
+
+// Where the BCD digits sit in a frame: first bit and width.
+var ltcFields = [...]struct{ bit, width int }{
+	{0, 4}, {8, 2}, // frame units, tens
+	{16, 4}, {24, 3}, // seconds
+	{32, 4}, {40, 3}, // minutes
+	{48, 4}, {56, 2}, // hours
+}
+
+const ltcDropFrameBit = 10
+
+// Returns the bit that makes the number of ones in a frame even, so every frame starts on the same level.
+// 25 fps moves it from bit 27 to bit 59.
+func ltcPolarityBit(rate FrameRate) int {
+	if rate == FrameRate25 {
+		return 59
+	}
+	return 27
+}
+
+// Builds the bits of the LTC frame for tc, bit 0 first.
+func ltcFrame(tc Timecode, userBits uint32) (bits [ltcBits]bool) {
+	put := func(bit, width, v int) {
+		for i := 0; i < width; i++ {
+			bits[bit+i] = v>>i&1 != 0
+		}
+	}
+	values := [...]int{tc.Frames, tc.Seconds, tc.Minutes, tc.Hours}
+	for i, f := range ltcFields {
+		v := values[i/2] % 10
+		if i%2 == 1 {
+			v = values[i/2] / 10
+		}
+		put(f.bit, f.width, v)
+	}
+	for i := 0; i < 8; i++ {
+		put(4+8*i, 4, int(userBits>>(4*i)&0xF))
+	}
+	bits[ltcDropFrameBit] = tc.Rate.DropFrame()
+	for i := 0; i < 16; i++ {
+		bits[64+i] = ltcSyncForward>>(15-i)&1 != 0
+	}
+
+	ones := 0
+	for _, b := range bits {
+		if b {
+			ones++
+		}
+	}
+	bits[ltcPolarityBit(tc.Rate)] = ones%2 != 0
+	return bits
+}
+
+// Reads the timecode and user bits out of an LTC frame.
+func parseLTCFrame(bits *[ltcBits]bool, rate FrameRate) (Timecode, uint32) {
+	get := func(bit, width int) (v int) {
+		for i := 0; i < width; i++ {
+			if bits[bit+i] {
+				v |= 1 << i
+			}
+		}
+		return v
+	}
+	var values [4]int
+	for i, f := range ltcFields {
+		if i%2 == 0 {
+			values[i/2] = get(f.bit, f.width)
+		} else {
+			values[i/2] += 10 * get(f.bit, f.width)
+		}
+	}
+	var userBits uint32
+	for i := 0; i < 8; i++ {
+		userBits |= uint32(get(4+8*i, 4)) << (4 * i)
+	}
+	return Timecode{Frames: values[0], Seconds: values[1], Minutes: values[2], Hours: values[3], Rate: rate}, userBits
+}
+
+// LTCEncoder is a Source of LTC, starting at a timecode and counting up a frame at a time. Its edges are
+// rendered with sub-sample accuracy, so that the start of each frame falls exactly on the sample position
+// implied by its timecode and the start.
+type LTCEncoder struct {
+	UserBits uint32 // sent with every frame
+
+	tc      Timecode
+	bits    [ltcBits]bool
+	bit     int     // index into bits
+	phase   float64 // through the current bit, [0, 1)
+	level   float64
+	started bool
+}
+
+// Creates an encoder whose first frame, start, begins with the first sample generated.
+func NewLTCEncoder(start Timecode) *LTCEncoder {
+	// Start half a sample early, in the last bit of the frame before, so that sample i covers [i-0.5, i+0.5).
+	e := &LTCEncoder{tc: start.Add(-1), bit: ltcBits - 1, level: -1}
+	e.bits = ltcFrame(e.tc, 0)
+	return e
+}
+
+// Returns the timecode of the frame being sent.
+func (e *LTCEncoder) Timecode() Timecode { return e.tc }
+
+func (e *LTCEncoder) Generate(buf []float32, sampleRate float64) {
+	step := e.tc.Rate.FPS() * ltcBits / sampleRate // bits per sample
+	if !e.started {
+		e.started = true
+		e.phase = 1 - step/2
+	}
+	for i := range buf {
+		// Average the level over the sample, so that edges between samples keep their timing.
+		sum, left := 0.0, step
+		for left > 0 {
+			edge := 1.0
+			if e.bits[e.bit] && e.phase < 0.5 {
+				edge = 0.5
+			}
+			take := min(left, edge-e.phase)
+			sum += e.level * take
+			e.phase += take
+			left -= take
+			if e.phase < edge {
+				continue
+			}
+			e.level = -e.level
+			if edge == 1 {
+				e.phase = 0
+				if e.bit++; e.bit == ltcBits {
+					e.bit = 0
+					e.tc = e.tc.Add(1)
+					e.bits = ltcFrame(e.tc, e.UserBits)
+				}
+			}
+		}
+		buf[i] = float32(sum / step)
+	}
+}
+
+// An LTCFrame is a frame read by an LTCDecoder.
+type LTCFrame struct {
+	Timecode Timecode
+	UserBits uint32
+	Position int64 // sample position of the start of the frame, or of its end when playing backward
+	Reverse  bool  // the timecode was playing backward
+}
+
+// LTCDecoder reads LTC from audio. It follows speed changes of about ±20% from the nominal frame rate.
+type LTCDecoder struct {
+	rate       FrameRate
+	sampleRate float64
+
+	period float64 // samples per bit, as measured
+	peak   float64 // decaying peak level, for the hysteresis
+	decay  float64
+	high   bool
+	prev   float32
+	zero   float64 // position of the latest zero crossing
+	edge   float64 // position of the previous edge
+
+	half      bool    // the first half of a 1 has been seen
+	halfStart float64 // where that 1 started
+
+	count  int // bits ever received
+	bits   [ltcBits]bool
+	starts [ltcBits]float64 // where each bit started, by count modulo ltcBits
+	sync   uint16           // the latest 16 bits, the newest in bit 0
+	revAt  int              // count at which a reverse frame is complete, or 0
+
+	frames []LTCFrame
+}
+
+func NewLTCDecoder(sampleRate float64, rate FrameRate) *LTCDecoder {
+	return &LTCDecoder{
+		rate:       rate,
+		sampleRate: sampleRate,
+		period:     sampleRate / (rate.FPS() * ltcBits),
+		decay:      math.Exp(-1 / (0.05 * sampleRate)),
+	}
+}
+
+// Reads the samples of buf, which start at sample position pos, and returns the frames that ended in them.
+// The slice is reused by the next call.
+func (d *LTCDecoder) Decode(buf []float32, pos int64) []LTCFrame {
+	d.frames = d.frames[:0]
+	for i, x := range buf {
+		t := float64(pos + int64(i))
+		if (x >= 0) != (d.prev >= 0) {
+			// Where the line between this sample and the one before crosses zero.
+			d.zero = t - 1 + float64(d.prev/(d.prev-x))
+		}
+		d.prev = x
+
+		a := math.Abs(float64(x))
+		d.peak = max(a, d.peak*d.decay)
+		threshold := d.peak / 4
+		if d.peak < 1e-3 {
+			continue
+		}
+		if d.high && float64(x) < -threshold || !d.high && float64(x) > threshold {
+			d.high = !d.high
+			d.transition(d.zero)
+		}
+	}
+	return d.frames
+}
+
+func (d *LTCDecoder) transition(t float64) {
+	interval := t - d.edge
+	d.edge = t
+	switch {
+	case interval < 0.3*d.period || interval > 1.5*d.period:
+		// Noise or a dropout.
+		d.half = false
+	case interval < 0.75*d.period:
+		d.period += (2*interval - d.period) / 32
+		if !d.half {
+			d.half, d.halfStart = true, t-interval
+			return
+		}
+		d.half = false
+		d.bit(true, d.halfStart)
+	default:
+		d.period += (interval - d.period) / 32
+		if d.half {
+			// A lone half bit; resynchronise on this one.
+			d.half = false
+		}
+		d.bit(false, t-interval)
+	}
+}
+
+func (d *LTCDecoder) bit(one bool, start float64) {
+	i := d.count % ltcBits
+	d.bits[i] = one
+	d.starts[i] = start
+	d.count++
+	d.sync <<= 1
+	if one {
+		d.sync |= 1
+	}
+
+	var frame [ltcBits]bool
+	switch {
+	case d.sync == ltcSyncForward && d.count >= ltcBits:
+		first := d.count - ltcBits
+		for j := range frame {
+			frame[j] = d.bits[(first+j)%ltcBits]
+		}
+		d.emit(&frame, d.starts[first%ltcBits], false)
+	case d.sync == ltcSyncReverse:
+		d.revAt = d.count + 64
+	case d.revAt != 0 && d.count == d.revAt:
+		// Bits 79 to 64 arrived first, then 63 down to 0.
+		last := d.count - 1
+		for j := range frame {
+			frame[j] = d.bits[(last-j)%ltcBits]
+		}
+		d.revAt = 0
+		d.emit(&frame, d.starts[last%ltcBits], true)
+	}
+}
+
+func (d *LTCDecoder) emit(bits *[ltcBits]bool, start float64, reverse bool) {
+	tc, userBits := parseLTCFrame(bits, d.rate)
+	if !tc.Valid() {
+		return
+	}
+	d.frames = append(d.frames, LTCFrame{
+		Timecode: tc,
+		UserBits: userBits,
+		Position: int64(math.Round(start)),
+		Reverse:  reverse,
+	})
+}
@@ -0,0 +1,132 @@
+package asio
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Encodes seconds of LTC from start and decodes it again in buffers of 256.
+func ltcRoundTrip(t *testing.T, start Timecode, sampleRate float64, seconds float64, channel func([]float32)) []LTCFrame {
+	e := NewLTCEncoder(start)
+	e.UserBits = 0x89ABCDEF
+	x := make([]float32, int(seconds*sampleRate))
+	e.Generate(x, sampleRate)
+	if channel != nil {
+		channel(x)
+	}
+
+	d := NewLTCDecoder(sampleRate, start.Rate)
+	var frames []LTCFrame
+	for pos := 0; pos < len(x); pos += 256 {
+		frames = append(frames, d.Decode(x[pos:min(pos+256, len(x))], int64(pos))...)
+	}
+	return frames
+}
+
+func TestLTCRoundTrip(t *testing.T) {
+	starts := map[FrameRate]string{
+		FrameRate24:     "23:59:58:00",
+		FrameRate25:     "01:59:59:05",
+		FrameRate2997DF: "00:00:59;10",
+		FrameRate2997:   "00:09:59:00",
+		FrameRate30:     "12:34:56:07",
+	}
+	for rate, label := range starts {
+		start, err := ParseTimecode(label, rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sampleRate := range []float64{44100, 48000, 96000} {
+			frames := ltcRoundTrip(t, start, sampleRate, 3, nil)
+			if want := int(3 * rate.FPS()); len(frames) < want-1 {
+				t.Fatalf("%v at %v: decoded %d frames, want %d", rate, sampleRate, len(frames), want)
+			}
+			for k, f := range frames {
+				if want := start.Add(int64(k)); f.Timecode != want || f.UserBits != 0x89ABCDEF || f.Reverse {
+					t.Fatalf("%v at %v: frame %d = %v %x, want %v", rate, sampleRate, k, f.Timecode, f.UserBits, want)
+				}
+				want := int64(float64(k) * sampleRate / rate.FPS())
+				if d := f.Position - want; d < -1 || d > 1 {
+					t.Errorf("%v at %v: frame %d at %d, want %d", rate, sampleRate, k, f.Position, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLTCDecodeImpaired(t *testing.T) {
+	start, _ := ParseTimecode("10:00:00:00", FrameRate25)
+	rng := rand.New(rand.NewSource(1))
+	frames := ltcRoundTrip(t, start, 48000, 2, func(x []float32) {
+		for i := range x {
+			x[i] = -0.1*x[i] + 0.01*float32(rng.NormFloat64())
+		}
+	})
+	if len(frames) < 49 {
+		t.Fatalf("decoded %d frames", len(frames))
+	}
+	for k, f := range frames {
+		if f.Timecode != start.Add(int64(k)) {
+			t.Fatalf("frame %d = %v", k, f.Timecode)
+		}
+	}
+}
+
+func TestLTCDecodeReverse(t *testing.T) {
+	start, _ := ParseTimecode("00:00:10:00", FrameRate30)
+	frames := ltcRoundTrip(t, start, 48000, 1, func(x []float32) {
+		for i, j := 0, len(x)-1; i < j; i, j = i+1, j-1 {
+			x[i], x[j] = x[j], x[i]
+		}
+	})
+	if len(frames) < 28 {
+		t.Fatalf("decoded %d frames", len(frames))
+	}
+	last := frames[0].Timecode
+	for _, f := range frames {
+		if !f.Reverse {
+			t.Fatalf("%v not reversed", f.Timecode)
+		}
+		if f != frames[0] && f.Timecode != last.Add(-1) {
+			t.Fatalf("%v after %v", f.Timecode, last)
+		}
+		last = f.Timecode
+	}
+}
+
+func TestLTCChase(t *testing.T) {
+	start, _ := ParseTimecode("01:00:00;00", FrameRate2997DF)
+	drv := NewSimDriver(1, 1)
+	drv.Loopback = true
+	drv.LoopbackDelay = 1000
+	s := newTestStream(t, drv)
+	g, err := NewGenerator(s, NewLTCEncoder(start), -12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewLTCChase(s, 0, FrameRate2997DF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stepSeconds(t, drv, 2)
+	tc, locked := c.Timecode()
+	// The LTC left at position 0 and came back 1000 samples later.
+	want := TimecodeFromSamples(start.Samples(48000)+s.Position()-1000, 48000, FrameRate2997DF)
+	if !locked || tc != want {
+		t.Errorf("Timecode = %v, %v; want %v, locked", tc, locked, want)
+	}
+	if off := c.Offset() - (start.Samples(48000) - 1000); off < -1 || off > 1 {
+		t.Errorf("Offset off by %d", off)
+	}
+	if sp := c.Speed(); sp < 0.999 || sp > 1.001 {
+		t.Errorf("Speed = %v", sp)
+	}
+
+	// Take the LTC away and the chase loses lock.
+	g.SetEnabled(false)
+	stepSeconds(t, drv, 1)
+	if c.Locked() {
+		t.Error("still locked without LTC")
+	}
+}
@@ -0,0 +1,257 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Returns the index into Inputs() of the opened input channel called name.
+func (s *Stream) FindInput(name string) (int, error) {
+	return findChannel(s.inputs, name)
+}
+
+// Returns the index into Outputs() of the opened output channel called name.
+func (s *Stream) FindOutput(name string) (int, error) {
+	return findChannel(s.outputs, name)
+}
+
+func findChannel(infos []*ChannelInfo, name string) (int, error) {
+	for i, info := range infos {
+		if info.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no channel named %q", name)
+}
+
+// Matrix mixes every input of a stream into every output through a grid of crosspoints, each with a gain,
+// a mute and a solo. Soloing a crosspoint silences the non-soloed crosspoints feeding the same output. Each
+// output of the matrix also has a gain and a mute of its own, applied to everything its crosspoints feed it.
+//
+// Changes are published to the driver thread without locking and ramped over the matrix's ramp time to
+// avoid zipper noise.
+type Matrix struct {
+	stream  *Stream
+	numIn   int
+	numOut  int
+	ramp    time.Duration
+	applied atomic.Pointer[[]float32] // effective linear gains, [out*numIn+in]
+
+	mu      sync.Mutex
+	points  []crosspoint
+	outputs []crosspoint // only gain and mute are used
+
+	// Only touched on the driver thread:
+	ramps []gainRamp
+}
+
+type crosspoint struct {
+	gain float64 // dB
+	mute bool
+	solo bool
+}
+
+type gainRamp struct {
+	current   float32
+	target    float32
+	step      float32
+	remaining int
+}
+
+const DefaultRampTime = 10 * time.Millisecond
+
+// Creates a matrix with every crosspoint off and adds it to the stream's processors. Its output is mixed
+// into whatever else is playing on the outputs.
+func NewMatrix(s *Stream, rampTime time.Duration) *Matrix {
+	m := &Matrix{
+		stream: s,
+		numIn:  len(s.Inputs()),
+		numOut: len(s.Outputs()),
+		ramp:   rampTime,
+	}
+	m.points = make([]crosspoint, m.numIn*m.numOut)
+	for i := range m.points {
+		m.points[i].gain = math.Inf(-1)
+	}
+	m.outputs = make([]crosspoint, m.numOut)
+	m.ramps = make([]gainRamp, m.numIn*m.numOut)
+	m.publish()
+
+	s.AddProcessor(m)
+	return m
+}
+
+func (m *Matrix) index(in, out int) (int, error) {
+	if in < 0 || in >= m.numIn || out < 0 || out >= m.numOut {
+		return 0, ErrorInvalidParameter
+	}
+	return out*m.numIn + in, nil
+}
+
+func (m *Matrix) names(in, out string) (i, o int, err error) {
+	if i, err = m.stream.FindInput(in); err != nil {
+		return
+	}
+	o, err = m.stream.FindOutput(out)
+	return
+}
+
+func (m *Matrix) update(in, out int, f func(p *crosspoint)) error {
+	i, err := m.index(in, out)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&m.points[i])
+	m.publish()
+	return nil
+}
+
+// Sets the gain in dB of the crosspoint from input in to output out; math.Inf(-1) turns it off.
+func (m *Matrix) SetGain(in, out int, gain float64) error {
+	return m.update(in, out, func(p *crosspoint) { p.gain = gain })
+}
+
+func (m *Matrix) SetMute(in, out int, mute bool) error {
+	return m.update(in, out, func(p *crosspoint) { p.mute = mute })
+}
+
+func (m *Matrix) SetSolo(in, out int, solo bool) error {
+	return m.update(in, out, func(p *crosspoint) { p.solo = solo })
+}
+
+func (m *Matrix) updateOutput(out int, f func(p *crosspoint)) error {
+	if out < 0 || out >= m.numOut {
+		return ErrorInvalidParameter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&m.outputs[out])
+	m.publish()
+	return nil
+}
+
+// Sets the gain in dB of output out of the matrix; it starts at 0.
+func (m *Matrix) SetOutputGain(out int, gain float64) error {
+	return m.updateOutput(out, func(p *crosspoint) { p.gain = gain })
+}
+
+func (m *Matrix) SetOutputMute(out int, mute bool) error {
+	return m.updateOutput(out, func(p *crosspoint) { p.mute = mute })
+}
+
+// Returns the gain in dB and mute of output out of the matrix.
+func (m *Matrix) Output(out int) (gain float64, mute bool, err error) {
+	if out < 0 || out >= m.numOut {
+		return 0, false, ErrorInvalidParameter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.outputs[out]
+	return p.gain, p.mute, nil
+}
+
+// Like SetGain, addressing channels by their ChannelInfo names.
+func (m *Matrix) SetGainByName(in, out string, gain float64) error {
+	i, o, err := m.names(in, out)
+	if err != nil {
+		return err
+	}
+	return m.SetGain(i, o, gain)
+}
+
+func (m *Matrix) SetMuteByName(in, out string, mute bool) error {
+	i, o, err := m.names(in, out)
+	if err != nil {
+		return err
+	}
+	return m.SetMute(i, o, mute)
+}
+
+func (m *Matrix) SetSoloByName(in, out string, solo bool) error {
+	i, o, err := m.names(in, out)
+	if err != nil {
+		return err
+	}
+	return m.SetSolo(i, o, solo)
+}
+
+// Returns the gain in dB, mute and solo of a crosspoint.
+func (m *Matrix) Crosspoint(in, out int) (gain float64, mute, solo bool, err error) {
+	i, err := m.index(in, out)
+	if err != nil {
+		return 0, false, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.points[i]
+	return p.gain, p.mute, p.solo, nil
+}
+
+// Computes the effective gains and hands them to the driver thread. Called with m.mu held.
+func (m *Matrix) publish() {
+	gains := make([]float32, len(m.points))
+	for o := 0; o < m.numOut; o++ {
+		if m.outputs[o].mute {
+			continue
+		}
+		master := FromDBFS(m.outputs[o].gain)
+		row := m.points[o*m.numIn : (o+1)*m.numIn]
+		soloed := false
+		for _, p := range row {
+			soloed = soloed || p.solo
+		}
+		for i, p := range row {
+			if !p.mute && (p.solo || !soloed) {
+				gains[o*m.numIn+i] = float32(FromDBFS(p.gain) * master)
+			}
+		}
+	}
+	m.applied.Store(&gains)
+}
+
+func (m *Matrix) Process(b *Block) {
+	gains := *m.applied.Load()
+	rampSamples := max(1, int(m.ramp.Seconds()*b.SampleRate))
+
+	for o := 0; o < m.numOut; o++ {
+		out := b.Out[o]
+		for i := 0; i < m.numIn; i++ {
+			r := &m.ramps[o*m.numIn+i]
+			if target := gains[o*m.numIn+i]; target != r.target {
+				r.target = target
+				r.remaining = rampSamples
+				r.step = (target - r.current) / float32(rampSamples)
+			}
+			if r.remaining == 0 && r.current == 0 {
+				continue
+			}
+
+			in := b.In[i]
+			if r.remaining == 0 {
+				g := r.current
+				for k, x := range in {
+					out[k] += g * x
+				}
+				continue
+			}
+			for k, x := range in {
+				if r.remaining > 0 {
+					r.current += r.step
+					if r.remaining--; r.remaining == 0 {
+						r.current = r.target
+					}
+				}
+				out[k] += r.current * x
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package asio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMatrixRouting(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = float32(channel + 1) // DC: 1 on In 1, 2 on In 2
+		}
+	}
+	s := newTestStream(t, drv)
+	m := NewMatrix(s, DefaultRampTime)
+
+	var out [2]float32
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		out[0], out[1] = b.Out[0][b.Frames-1], b.Out[1][b.Frames-1]
+	}))
+	settle := func() {
+		for i := 0; i < 4; i++ {
+			drv.Step()
+		}
+	}
+
+	if err := m.SetGainByName("In 1", "Out 2", -6); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetGain(1, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	if want := float32(FromDBFS(-6)) + 2; math.Abs(float64(out[1]-want)) > 1e-5 || out[0] != 0 {
+		t.Errorf("out = %v, want [0 %v]", out, want)
+	}
+
+	m.SetSolo(0, 1, true)
+	settle()
+	if want := float32(FromDBFS(-6)); math.Abs(float64(out[1]-want)) > 1e-5 {
+		t.Errorf("soloed: out 2 = %v, want %v", out[1], want)
+	}
+
+	m.SetMuteByName("In 1", "Out 2", true)
+	settle()
+	if out[1] != 0 {
+		t.Errorf("muted solo: out 2 = %v, want 0", out[1])
+	}
+
+	if err := m.SetGainByName("In 9", "Out 1", 0); err == nil {
+		t.Error("expected an error for an unknown channel name")
+	}
+	if gain, mute, solo, _ := m.Crosspoint(0, 1); gain != -6 || !mute || !solo {
+		t.Errorf("crosspoint = %v %v %v", gain, mute, solo)
+	}
+
+	m.SetMute(0, 1, false)
+	m.SetOutputGain(1, -6)
+	settle()
+	if want := float32(FromDBFS(-6) * FromDBFS(-6)); math.Abs(float64(out[1]-want)) > 1e-5 {
+		t.Errorf("output gain: out 2 = %v, want %v", out[1], want)
+	}
+	m.SetOutputMute(1, true)
+	settle()
+	if out[1] != 0 {
+		t.Errorf("muted output: out 2 = %v, want 0", out[1])
+	}
+	if gain, mute, err := m.Output(1); gain != -6 || !mute || err != nil {
+		t.Errorf("output = %v %v %v", gain, mute, err)
+	}
+	if err := m.SetOutputGain(2, 0); err != ErrorInvalidParameter {
+		t.Errorf("SetOutputGain(2) = %v", err)
+	}
+}
+
+// A gain change must ramp over the ramp time instead of stepping.
+func TestMatrixRamp(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = 1
+		}
+	}
+	s := newTestStream(t, drv)
+	m := NewMatrix(s, DefaultRampTime)
+
+	var samples []float32
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		samples = append(samples, b.Out[0]...)
+	}))
+
+	m.SetGain(0, 0, 0)
+	for i := 0; i < 4; i++ {
+		drv.Step()
+	}
+
+	rampSamples := int(DefaultRampTime.Seconds() * 48000)
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i] - samples[i-1]; d < 0 || d > 1.01/float32(rampSamples) {
+			t.Fatalf("step of %v at sample %d", d, i)
+		}
+	}
+	if samples[rampSamples-1] != 1 || samples[len(samples)-1] != 1 {
+		t.Errorf("ramp did not reach unity after %d samples", rampSamples)
+	}
+}
@@ -0,0 +1,289 @@
+package asio
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Converts a linear level (1 = full scale) to dBFS.
+func ToDBFS(linear float64) float64 {
+	if linear <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(linear)
+}
+
+// Converts a level in dBFS to linear (1 = full scale).
+func FromDBFS(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// Ballistics control how meter readings move over time.
+type Ballistics struct {
+	PeakHold    time.Duration // how long PeakHold keeps the highest peak
+	PeakRelease float64       // how fast Peak and TruePeak fall back, in dB per second
+	RMSWindow   time.Duration // RMS integration time constant
+	ClipLevel   float64       // linear level at or above which a sample counts as clipped
+}
+
+// Roughly IEC 60268-18 peak programme meter ballistics.
+var DefaultBallistics = Ballistics{
+	PeakHold:    2 * time.Second,
+	PeakRelease: 20,
+	RMSWindow:   300 * time.Millisecond,
+	ClipLevel:   0.9999,
+}
+
+// A MeterReading is a snapshot of one channel's meter. Levels are linear; use ToDBFS to display them. Every
+// level but DriverPeak is measured in software, so that they agree with each other.
+type MeterReading struct {
+	Peak       float64 // sample peak with release ballistics applied
+	PeakHold   float64 // highest Peak over the last PeakHold period
+	RMS        float64 // RMS over the integration window
+	TruePeak   float64 // 4x oversampled inter-sample peak with release ballistics applied
+	Clips      uint64  // number of clip events; a run of clipped samples counts once
+	DriverPeak float64 // the driver's own peak meter, with its ballistics, when Driver is set
+	Driver     bool    // the driver has a peak meter, and DriverPeak was read from it
+}
+
+// Per-channel meter. Values are published with a sequence lock so readers never block the driver thread.
+type channelMeter struct {
+	seq      atomic.Uint64
+	peak     atomic.Uint64 // math.Float64bits
+	hold     atomic.Uint64
+	rms      atomic.Uint64
+	truePeak atomic.Uint64
+	clips    atomic.Uint64
+
+	// Only touched on the driver thread:
+	state meterState
+}
+
+type meterState struct {
+	peak     float64
+	hold     float64
+	holdLeft int
+	meanSq   float64
+	truePeak float64
+	clipping bool
+	history  [truePeakTaps]float64 // most recent sample first
+}
+
+// MeterBank meters every channel of a Stream.
+type MeterBank struct {
+	stream     *Stream
+	ballistics atomic.Pointer[Ballistics]
+	inputs     []channelMeter
+	outputs    []channelMeter
+
+	// Whether the driver supports AsioGetInputMeter / AsioGetOutputMeter.
+	driverIn  bool
+	driverOut bool
+}
+
+// Creates meters for every opened channel of s and taps them into its buffers. Every level is measured from
+// the stream's float buffers; where the driver has peak meters of its own, readings carry them as DriverPeak.
+func NewMeterBank(s *Stream, ballistics Ballistics) *MeterBank {
+	m := &MeterBank{
+		stream:  s,
+		inputs:  make([]channelMeter, len(s.Inputs())),
+		outputs: make([]channelMeter, len(s.Outputs())),
+	}
+	m.ballistics.Store(&ballistics)
+	m.driverIn = s.Driver().Future(AsioCanInputMeter, nil) == nil
+	m.driverOut = s.Driver().Future(AsioCanOutputMeter, nil) == nil
+
+	s.AddTap(m)
+	return m
+}
+
+func (m *MeterBank) SetBallistics(ballistics Ballistics) {
+	m.ballistics.Store(&ballistics)
+}
+
+func (m *MeterBank) Process(b *Block) {
+	bal := m.ballistics.Load()
+	for i := range m.inputs {
+		m.inputs[i].process(b.In[i], b.SampleRate, bal)
+	}
+	for i := range m.outputs {
+		m.outputs[i].process(b.Out[i], b.SampleRate, bal)
+	}
+}
+
+// Returns the current reading of the i'th opened input channel.
+func (m *MeterBank) Input(i int) MeterReading {
+	r := m.inputs[i].read()
+	if m.driverIn {
+		m.readDriverMeter(&r, AsioGetInputMeter, m.stream.Inputs()[i])
+	}
+	return r
+}
+
+// Returns the current reading of the i'th opened output channel.
+func (m *MeterBank) Output(i int) MeterReading {
+	r := m.outputs[i].read()
+	if m.driverOut {
+		m.readDriverMeter(&r, AsioGetOutputMeter, m.stream.Outputs()[i])
+	}
+	return r
+}
+
+// Returns readings for every opened channel.
+func (m *MeterBank) Snapshot() (inputs, outputs []MeterReading) {
+	inputs = make([]MeterReading, len(m.inputs))
+	for i := range inputs {
+		inputs[i] = m.Input(i)
+	}
+	outputs = make([]MeterReading, len(m.outputs))
+	for i := range outputs {
+		outputs[i] = m.Output(i)
+	}
+	return inputs, outputs
+}
+
+// Zeroes every channel's clip counter.
+func (m *MeterBank) ResetClips() {
+	for i := range m.inputs {
+		m.inputs[i].clips.Store(0)
+	}
+	for i := range m.outputs {
+		m.outputs[i].clips.Store(0)
+	}
+}
+
+func (m *MeterBank) readDriverMeter(r *MeterReading, selector int32, info *ChannelInfo) {
+	cc := ChannelControls{
+		Channel: int32(info.Channel),
+		IsInput: bool_int32(info.IsInput),
+	}
+	if err := m.stream.Driver().Future(selector, unsafe.Pointer(&cc)); err != nil {
+		return
+	}
+	r.DriverPeak = float64(cc.Meter) / 0x7fffffff
+	r.Driver = true
+}
+
+func (c *channelMeter) read() (r MeterReading) {
+	for {
+		seq := c.seq.Load()
+		if seq&1 == 0 {
+			r.Peak = math.Float64frombits(c.peak.Load())
+			r.PeakHold = math.Float64frombits(c.hold.Load())
+			r.RMS = math.Float64frombits(c.rms.Load())
+			r.TruePeak = math.Float64frombits(c.truePeak.Load())
+			if c.seq.Load() == seq {
+				r.Clips = c.clips.Load()
+				return r
+			}
+		}
+	}
+}
+
+func (c *channelMeter) process(buf []float32, sampleRate float64, bal *Ballistics) {
+	st := &c.state
+	n := len(buf)
+
+	release := math.Pow(10, -bal.PeakRelease*float64(n)/sampleRate/20)
+	alpha := 1.0
+	if bal.RMSWindow > 0 {
+		alpha = 1 - math.Exp(-1/(bal.RMSWindow.Seconds()*sampleRate))
+	}
+
+	var peak, truePeak float64
+	meanSq := st.meanSq
+	for _, x := range buf {
+		a := math.Abs(float64(x))
+		if a > peak {
+			peak = a
+		}
+
+		meanSq += alpha * (float64(x)*float64(x) - meanSq)
+
+		if a >= bal.ClipLevel {
+			if !st.clipping {
+				c.clips.Add(1)
+			}
+			st.clipping = true
+		} else {
+			st.clipping = false
+		}
+
+		if tp := st.oversampledPeak(x); tp > truePeak {
+			truePeak = tp
+		}
+	}
+	st.meanSq = meanSq
+	if peak > truePeak {
+		truePeak = peak
+	}
+
+	st.peak = math.Max(peak, st.peak*release)
+	st.truePeak = math.Max(truePeak, st.truePeak*release)
+
+	st.holdLeft -= n
+	if st.peak >= st.hold || st.holdLeft <= 0 {
+		st.hold = st.peak
+		st.holdLeft = int(bal.PeakHold.Seconds() * sampleRate)
+	}
+
+	c.seq.Add(1)
+	c.peak.Store(math.Float64bits(st.peak))
+	c.hold.Store(math.Float64bits(st.hold))
+	c.rms.Store(math.Float64bits(math.Sqrt(meanSq)))
+	c.truePeak.Store(math.Float64bits(st.truePeak))
+	c.seq.Add(1)
+}
+
+// True peak is measured by interpolating 4x with a polyphase windowed-sinc filter, as in ITU-R BS.1770.
+const (
+	truePeakFactor = 4
+	truePeakTaps   = 12 // per phase
+)
+
+var truePeakPhases = makeTruePeakPhases()
+
+func makeTruePeakPhases() (phases [truePeakFactor][truePeakTaps]float64) {
+	const length = truePeakFactor * truePeakTaps
+	center := float64(length-1) / 2
+	for n := 0; n < length; n++ {
+		t := (float64(n) - center) / truePeakFactor
+		h := 1.0
+		if t != 0 {
+			h = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		// Blackman window:
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(n)/(length-1)) + 0.08*math.Cos(4*math.Pi*float64(n)/(length-1))
+		phases[n%truePeakFactor][n/truePeakFactor] = h * w
+	}
+	// Unity gain at DC for every phase:
+	for p := range phases {
+		sum := 0.0
+		for _, h := range phases[p] {
+			sum += h
+		}
+		for k := range phases[p] {
+			phases[p][k] /= sum
+		}
+	}
+	return phases
+}
+
+// Pushes x into the interpolator and returns the largest magnitude of the interpolated samples.
+func (st *meterState) oversampledPeak(x float32) (peak float64) {
+	copy(st.history[1:], st.history[:truePeakTaps-1])
+	st.history[0] = float64(x)
+
+	for p := range truePeakPhases {
+		y := 0.0
+		for k, h := range truePeakPhases[p] {
+			y += h * st.history[k]
+		}
+		if a := math.Abs(y); a > peak {
+			peak = a
+		}
+	}
+	return peak
+}
@@ -0,0 +1,161 @@
+package asio
+
+import (
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func newTestStream(t *testing.T, drv *SimDriver) *Stream {
+	drv.Manual = true
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func stepSeconds(t *testing.T, drv *SimDriver, seconds float64) {
+	steps := int(seconds * 48000 / float64(drv.PreferredSize))
+	for i := 0; i < steps; i++ {
+		if err := drv.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMeterSine(t *testing.T) {
+	drv := NewSimDriver(1, 0)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = float32(0.5 * math.Sin(2*math.Pi*997*float64(pos+int64(i))/48000))
+		}
+	}
+	s := newTestStream(t, drv)
+	m := NewMeterBank(s, DefaultBallistics)
+
+	stepSeconds(t, drv, 2)
+
+	r := m.Input(0)
+	if math.Abs(r.Peak-0.5) > 0.001 {
+		t.Errorf("peak = %v, want 0.5", r.Peak)
+	}
+	if math.Abs(r.RMS-0.5/math.Sqrt2) > 0.01 {
+		t.Errorf("rms = %v, want %v", r.RMS, 0.5/math.Sqrt2)
+	}
+	if math.Abs(r.TruePeak-0.5) > 0.01 {
+		t.Errorf("true peak = %v, want 0.5", r.TruePeak)
+	}
+	if r.Clips != 0 || r.Driver {
+		t.Errorf("unexpected reading %+v", r)
+	}
+}
+
+// A SimDriver with input peak meters of its own, always at a quarter of full scale.
+type meteringDriver struct {
+	*SimDriver
+}
+
+func (d meteringDriver) Future(selector int32, opt unsafe.Pointer) error {
+	switch selector {
+	case AsioCanInputMeter:
+		return nil
+	case AsioGetInputMeter:
+		(*ChannelControls)(opt).Meter = 0x7fffffff / 4
+		return nil
+	}
+	return d.SimDriver.Future(selector, opt)
+}
+
+func TestMeterDriverPeak(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Manual = true
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = 0.5
+		}
+	}
+	s, err := NewStream(meteringDriver{drv}, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Start()
+	m := NewMeterBank(s, DefaultBallistics)
+	stepSeconds(t, drv, 0.1)
+
+	r := m.Input(0)
+	if !r.Driver || math.Abs(r.DriverPeak-0.25) > 1e-6 {
+		t.Errorf("driver peak %v %v, want 0.25", r.Driver, r.DriverPeak)
+	}
+	if math.Abs(r.Peak-0.5) > 1e-6 || r.PeakHold != r.Peak {
+		t.Errorf("peak %v, hold %v, want both measured at 0.5", r.Peak, r.PeakHold)
+	}
+	if r := m.Output(0); r.Driver || r.DriverPeak != 0 {
+		t.Errorf("output without a driver meter: %+v", r)
+	}
+}
+
+func TestMeterTruePeak(t *testing.T) {
+	// A quarter sample rate sine at 45 degrees never samples its crest.
+	drv := NewSimDriver(1, 0)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = float32(math.Sin(math.Pi/2*float64(pos+int64(i)) + math.Pi/4))
+		}
+	}
+	s := newTestStream(t, drv)
+	m := NewMeterBank(s, DefaultBallistics)
+
+	stepSeconds(t, drv, 0.5)
+
+	r := m.Input(0)
+	if math.Abs(r.Peak-math.Sqrt2/2) > 0.001 {
+		t.Errorf("peak = %v, want %v", r.Peak, math.Sqrt2/2)
+	}
+	if ToDBFS(r.TruePeak) < -0.5 {
+		t.Errorf("true peak = %.2f dBFS, want about 0 dBFS", ToDBFS(r.TruePeak))
+	}
+}
+
+func TestMeterClipsAndRelease(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+
+	bursts := 0
+	s.AddProcessor(ProcessorFunc(func(b *Block) {
+		// Three full scale bursts, then silence.
+		if b.SamplePosition/int64(b.Frames)%10 == 0 && bursts < 3 {
+			bursts++
+			for i := 0; i < 16; i++ {
+				b.Out[0][i] = 1
+			}
+		}
+	}))
+	m := NewMeterBank(s, DefaultBallistics)
+
+	stepSeconds(t, drv, 1)
+	r := m.Output(0)
+	if r.Clips != 3 {
+		t.Errorf("clips = %d, want 3", r.Clips)
+	}
+	if r.PeakHold != 1 {
+		t.Errorf("peak hold = %v, want 1", r.PeakHold)
+	}
+
+	before := ToDBFS(m.Output(0).Peak)
+	stepSeconds(t, drv, 0.5)
+	after := ToDBFS(m.Output(0).Peak)
+	if fell := before - after; math.Abs(fell-10) > 0.5 {
+		t.Errorf("peak fell %.2f dB in 0.5s, want 10 dB", fell)
+	}
+
+	m.ResetClips()
+	if c := m.Output(0).Clips; c != 0 {
+		t.Errorf("clips after reset = %d", c)
+	}
+}
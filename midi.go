@@ -0,0 +1,257 @@
+package asio
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// MIDISink receives MIDI messages as they fall due. Bind it to a MIDI output of whatever library is at hand,
+// scheduling each message by its offset into the buffer to keep it sample accurate.
+type MIDISink interface {
+	// Receives a message due at sample position pos, offset frames into the current Block. It is called on the
+	// driver thread, in time order, and must not block; msg is only valid for the call.
+	SendMIDI(msg []byte, pos int64, offset int)
+}
+
+// MIDI system real-time and common messages.
+const (
+	MIDIQuarterFrame = 0xF1 // MTC quarter frame, one data byte
+	MIDISongPosition = 0xF2 // song position pointer in sixteenths, two data bytes, LSB first
+	MIDITimingClock  = 0xF8
+	MIDIStart        = 0xFA
+	MIDIContinue     = 0xFB
+	MIDIStop         = 0xFC
+)
+
+// Beat clock ticks per quarter note.
+const MIDIClocksPerBeat = 24
+
+// Transport commands wait here until the driver thread picks them up at its next buffer.
+type midiCommands struct {
+	mu      sync.Mutex // the driver thread only ever tries to take it
+	pending []midiCommand
+}
+
+type midiCommand struct {
+	kind byte // a MIDI status byte, or 0xF0 for an MTC full frame
+	arg  int
+	tc   Timecode
+}
+
+func (q *midiCommands) push(c midiCommand) {
+	q.mu.Lock()
+	q.pending = append(q.pending, c)
+	q.mu.Unlock()
+}
+
+// Moves the pending commands to buf; if they are being added to, they wait for the next buffer.
+func (q *midiCommands) take(buf []midiCommand) []midiCommand {
+	buf = buf[:0]
+	if !q.mu.TryLock() {
+		return buf
+	}
+	buf = append(buf, q.pending...)
+	q.pending = q.pending[:0]
+	q.mu.Unlock()
+	return buf
+}
+
+// MIDIClock sends MIDI beat clock, 24 ticks per quarter note at the tempo, timed by the stream's sample
+// position. Transport commands take effect at the start of the next buffer.
+type MIDIClock struct {
+	sink  MIDISink
+	tempo atomic.Uint64 // math.Float64bits of the beats per minute
+	cmds  midiCommands
+
+	// Only touched on the driver thread:
+	running bool
+	next    float64 // sample position of the next tick
+	taken   []midiCommand
+	msg     [3]byte
+}
+
+// Creates a stopped clock at bpm and adds it to the stream's taps.
+func NewMIDIClock(s *Stream, sink MIDISink, bpm float64) *MIDIClock {
+	c := &MIDIClock{sink: sink}
+	c.SetTempo(bpm)
+	s.AddTap(c)
+	return c
+}
+
+// Sets the tempo in beats per minute from the next tick on.
+func (c *MIDIClock) SetTempo(bpm float64) { c.tempo.Store(math.Float64bits(bpm)) }
+
+func (c *MIDIClock) Tempo() float64 { return math.Float64frombits(c.tempo.Load()) }
+
+// Sends Start and runs from the top of the song; the first tick goes with it.
+func (c *MIDIClock) Start() { c.cmds.push(midiCommand{kind: MIDIStart}) }
+
+// Sends Continue and runs from the song position.
+func (c *MIDIClock) Continue() { c.cmds.push(midiCommand{kind: MIDIContinue}) }
+
+// Sends Stop and stops ticking, keeping the song position.
+func (c *MIDIClock) Stop() { c.cmds.push(midiCommand{kind: MIDIStop}) }
+
+// Sends a song position pointer, in sixteenth notes, for Continue to run from. It is ignored while running.
+func (c *MIDIClock) SetSongPosition(sixteenths int) {
+	c.cmds.push(midiCommand{kind: MIDISongPosition, arg: sixteenths})
+}
+
+func (c *MIDIClock) Process(b *Block) {
+	c.taken = c.cmds.take(c.taken)
+	for _, cmd := range c.taken {
+		switch cmd.kind {
+		case MIDIStart, MIDIContinue:
+			c.running = true
+			c.next = float64(b.SamplePosition)
+		case MIDIStop:
+			c.running = false
+		case MIDISongPosition:
+			if c.running {
+				continue
+			}
+			c.send(b, 0, MIDISongPosition, byte(cmd.arg&0x7F), byte(cmd.arg>>7&0x7F))
+			continue
+		}
+		c.send(b, 0, cmd.kind)
+	}
+
+	bpm := c.Tempo()
+	if !c.running || bpm <= 0 {
+		return
+	}
+	interval := b.SampleRate * 60 / (bpm * MIDIClocksPerBeat)
+	start := float64(b.SamplePosition)
+	if c.next < start-interval {
+		// The sample position jumped; carry on from here rather than send a burst of late ticks.
+		c.next = start
+	}
+	for {
+		pos := int64(math.Round(c.next))
+		if pos >= b.SamplePosition+int64(b.Frames) {
+			break
+		}
+		c.send(b, int(max(0, pos-b.SamplePosition)), MIDITimingClock)
+		c.next += interval
+	}
+}
+
+func (c *MIDIClock) send(b *Block, offset int, msg ...byte) {
+	n := copy(c.msg[:], msg)
+	c.sink.SendMIDI(c.msg[:n], b.SamplePosition+int64(offset), offset)
+}
+
+// MTC sends MIDI time code quarter frames, four per frame, following the stream's sample position from a
+// starting timecode. Each set of eight carries the timecode of the frame its first piece was sent in, which
+// receivers correct for by adding two frames. Commands take effect at the start of the next buffer.
+type MTC struct {
+	sink MIDISink
+	rate FrameRate
+	cmds midiCommands
+
+	// Only touched on the driver thread:
+	running bool
+	base    Timecode // the timecode at basePos
+	basePos int64
+	quarter int64 // quarter frames sent since basePos
+	taken   []midiCommand
+	msg     [10]byte
+}
+
+// Creates a stopped MTC generator at rate and adds it to the stream's taps.
+func NewMTC(s *Stream, sink MIDISink, rate FrameRate) *MTC {
+	m := &MTC{sink: sink, rate: rate}
+	s.AddTap(m)
+	return m
+}
+
+// Starts quarter frames from tc, which falls on the first sample of the next buffer.
+func (m *MTC) Start(tc Timecode) { m.cmds.push(midiCommand{kind: MIDIStart, tc: tc}) }
+
+// Stops sending quarter frames.
+func (m *MTC) Stop() { m.cmds.push(midiCommand{kind: MIDIStop}) }
+
+// Sends a full frame message for tc, at the start of the next buffer, to make receivers jump there. If running,
+// quarter frames carry on from tc.
+func (m *MTC) Locate(tc Timecode) { m.cmds.push(midiCommand{kind: 0xF0, tc: tc}) }
+
+// The rate bits of the hours byte.
+func (m *MTC) rateCode() byte {
+	switch m.rate {
+	case FrameRate24:
+		return 0
+	case FrameRate25:
+		return 1
+	case FrameRate2997DF:
+		return 2
+	}
+	return 3
+}
+
+func (m *MTC) Process(b *Block) {
+	m.taken = m.cmds.take(m.taken)
+	for _, cmd := range m.taken {
+		tc := cmd.tc
+		tc.Rate = m.rate
+		switch cmd.kind {
+		case MIDIStart:
+			m.running = true
+			m.base, m.basePos, m.quarter = tc, b.SamplePosition, 0
+		case MIDIStop:
+			m.running = false
+		case 0xF0:
+			m.send(b, 0, 0xF0, 0x7F, 0x7F, 0x01, 0x01,
+				m.rateCode()<<5|byte(tc.Hours), byte(tc.Minutes), byte(tc.Seconds), byte(tc.Frames), 0xF7)
+			if m.running {
+				m.base, m.basePos, m.quarter = tc, b.SamplePosition, 0
+			}
+		}
+	}
+	if !m.running {
+		return
+	}
+
+	interval := b.SampleRate / (4 * m.rate.FPS())
+	end := b.SamplePosition + int64(b.Frames)
+	if m.basePos+int64(float64(m.quarter)*interval) < b.SamplePosition-int64(b.Frames) {
+		// The sample position jumped; resume on a new set at the timecode due now.
+		elapsed := float64(b.SamplePosition-m.basePos) / (interval * 8)
+		sets := int64(math.Ceil(elapsed))
+		m.base, m.basePos, m.quarter = m.base.Add(2*sets), m.basePos+int64(math.Round(float64(sets)*8*interval)), 0
+	}
+	for {
+		pos := m.basePos + int64(math.Round(float64(m.quarter)*interval))
+		if pos >= end {
+			break
+		}
+		piece := m.quarter % 8
+		tc := m.base.Add(m.quarter / 8 * 2)
+		var v byte
+		switch piece {
+		case 0, 1:
+			v = byte(tc.Frames)
+		case 2, 3:
+			v = byte(tc.Seconds)
+		case 4, 5:
+			v = byte(tc.Minutes)
+		default:
+			v = byte(tc.Hours)
+		}
+		if piece%2 == 0 {
+			v &= 0x0F
+		} else {
+			v >>= 4
+		}
+		if piece == 7 {
+			v = v&1 | m.rateCode()<<1
+		}
+		m.send(b, int(max(0, pos-b.SamplePosition)), MIDIQuarterFrame, byte(piece)<<4|v)
+		m.quarter++
+	}
+}
+
+func (m *MTC) send(b *Block, offset int, msg ...byte) {
+	n := copy(m.msg[:], msg)
+	m.sink.SendMIDI(m.msg[:n], b.SamplePosition+int64(offset), offset)
+}
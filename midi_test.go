@@ -0,0 +1,207 @@
+package asio
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+type midiEvent struct {
+	msg    []byte
+	pos    int64
+	offset int
+}
+
+// An in-memory MIDISink.
+type midiRecorder struct{ events []midiEvent }
+
+func (r *midiRecorder) SendMIDI(msg []byte, pos int64, offset int) {
+	r.events = append(r.events, midiEvent{append([]byte(nil), msg...), pos, offset})
+}
+
+// Returns the recorded events whose status byte is status.
+func (r *midiRecorder) only(status byte) (events []midiEvent) {
+	for _, e := range r.events {
+		if e.msg[0] == status {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func checkOffsets(t *testing.T, events []midiEvent) {
+	t.Helper()
+	for i, e := range events {
+		if e.offset != int(e.pos%256) {
+			t.Fatalf("event %d at %d has offset %d", i, e.pos, e.offset)
+		}
+		if i > 0 && e.pos < events[i-1].pos {
+			t.Fatalf("event %d at %d before %d", i, e.pos, events[i-1].pos)
+		}
+	}
+}
+
+func TestMIDIClock(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+	sink := &midiRecorder{}
+	c := NewMIDIClock(s, sink, 133)
+
+	drv.Step()
+	if len(sink.events) != 0 {
+		t.Fatalf("%d events before Start", len(sink.events))
+	}
+	c.Start()
+	stepSeconds(t, drv, 10)
+	checkOffsets(t, sink.events)
+
+	if e := sink.events[0]; !bytes.Equal(e.msg, []byte{MIDIStart}) || e.pos != 256 {
+		t.Fatalf("first event %x at %d", e.msg, e.pos)
+	}
+	ticks := sink.only(MIDITimingClock)
+	interval := 48000 * 60 / (133.0 * 24)
+	for k, e := range ticks {
+		if want := 256 + int64(math.Round(float64(k)*interval)); e.pos != want {
+			t.Fatalf("tick %d at %d, want %d", k, e.pos, want)
+		}
+	}
+	if len(ticks) < int((10*48000-512)/interval) {
+		t.Errorf("%d ticks", len(ticks))
+	}
+}
+
+func TestMIDIClockTransport(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+	sink := &midiRecorder{}
+	c := NewMIDIClock(s, sink, 120) // a tick every 1000 samples
+
+	c.Start()
+	stepSeconds(t, drv, 1)
+	c.Stop()
+	c.SetSongPosition(200)
+	drv.Step()
+	stop := len(sink.events)
+	stepSeconds(t, drv, 0.5)
+	if len(sink.events) != stop {
+		t.Fatalf("%d events while stopped", len(sink.events)-stop)
+	}
+	c.SetTempo(240)
+	c.Continue()
+	stepSeconds(t, drv, 1)
+	checkOffsets(t, sink.events)
+
+	var statuses []byte
+	for _, e := range sink.events {
+		if e.msg[0] != MIDITimingClock {
+			statuses = append(statuses, e.msg...)
+		}
+	}
+	if want := []byte{MIDIStart, MIDIStop, MIDISongPosition, 200 & 0x7F, 200 >> 7, MIDIContinue}; !bytes.Equal(statuses, want) {
+		t.Errorf("transport % x, want % x", statuses, want)
+	}
+
+	ticks := sink.only(MIDITimingClock)
+	last := ticks[len(ticks)-1]
+	if d := last.pos - ticks[len(ticks)-2].pos; d != 500 {
+		t.Errorf("ticks %d apart after the tempo change, want 500", d)
+	}
+	resumed := sink.only(MIDIContinue)[0]
+	if ticks[len(ticks)-1-int((last.pos-resumed.pos)/500)].pos != resumed.pos {
+		t.Error("no tick with Continue")
+	}
+}
+
+// Reassembles the timecode from each complete set of quarter frames.
+func mtcSets(t *testing.T, events []midiEvent) (sets []Timecode, rate byte) {
+	t.Helper()
+	var nibbles [8]byte
+	for i, e := range events {
+		piece := e.msg[1] >> 4
+		if int(piece) != i%8 {
+			t.Fatalf("quarter frame %d is piece %d", i, piece)
+		}
+		nibbles[piece] = e.msg[1] & 0x0F
+		if piece == 7 {
+			rate = nibbles[7] >> 1
+			sets = append(sets, Timecode{
+				Frames:  int(nibbles[0] | nibbles[1]<<4),
+				Seconds: int(nibbles[2] | nibbles[3]<<4),
+				Minutes: int(nibbles[4] | nibbles[5]<<4),
+				Hours:   int(nibbles[6] | (nibbles[7]&1)<<4),
+			})
+		}
+	}
+	return sets, rate
+}
+
+func TestMTC(t *testing.T) {
+	for _, rate := range []FrameRate{FrameRate24, FrameRate25, FrameRate2997DF, FrameRate30} {
+		drv := NewSimDriver(0, 1)
+		s := newTestStream(t, drv)
+		sink := &midiRecorder{}
+		m := NewMTC(s, sink, rate)
+		start, _ := ParseTimecode("01:59:59:00", rate)
+		m.Start(start)
+		stepSeconds(t, drv, 3)
+		checkOffsets(t, sink.events)
+
+		quarters := sink.only(MIDIQuarterFrame)
+		interval := 48000 / (4 * rate.FPS())
+		for k, e := range quarters {
+			if want := int64(math.Round(float64(k) * interval)); e.pos != want {
+				t.Fatalf("%v: quarter frame %d at %d, want %d", rate, k, e.pos, want)
+			}
+		}
+		sets, code := mtcSets(t, quarters)
+		if want := map[FrameRate]byte{FrameRate24: 0, FrameRate25: 1, FrameRate2997DF: 2, FrameRate30: 3}[rate]; code != want {
+			t.Errorf("%v: rate code %d, want %d", rate, code, want)
+		}
+		for k, tc := range sets {
+			want := start.Add(2 * int64(k))
+			want.Rate = 0
+			if tc != want {
+				t.Fatalf("%v: set %d is %v, want %v", rate, k, tc, want)
+			}
+		}
+	}
+}
+
+func TestMTCLocate(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+	sink := &midiRecorder{}
+	m := NewMTC(s, sink, FrameRate25)
+
+	m.Locate(Timecode{Hours: 10, Minutes: 20, Seconds: 30, Frames: 12})
+	drv.Step()
+	want := []byte{0xF0, 0x7F, 0x7F, 0x01, 0x01, 1<<5 | 10, 20, 30, 12, 0xF7}
+	if len(sink.events) != 1 || !bytes.Equal(sink.events[0].msg, want) || sink.events[0].offset != 0 {
+		t.Fatalf("Locate sent %v", sink.events)
+	}
+
+	m.Start(Timecode{Hours: 1})
+	stepSeconds(t, drv, 0.5)
+	m.Locate(Timecode{Hours: 2})
+	stepSeconds(t, drv, 0.5)
+	m.Stop()
+	drv.Step()
+	n := len(sink.events)
+	stepSeconds(t, drv, 0.2)
+	if len(sink.events) != n {
+		t.Errorf("%d quarter frames after Stop", len(sink.events)-n)
+	}
+
+	var quarters []midiEvent
+	for _, e := range sink.events[1:] {
+		if e.msg[0] == 0xF0 {
+			quarters = nil // a new run of sets starts at the locate
+			continue
+		}
+		quarters = append(quarters, e)
+	}
+	sets, _ := mtcSets(t, quarters)
+	if len(sets) == 0 || sets[0] != (Timecode{Hours: 2}) {
+		t.Errorf("sets after Locate start at %v", sets)
+	}
+}
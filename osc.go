@@ -0,0 +1,646 @@
+package asio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// OSCPacket is an Open Sound Control 1.0 packet: an *OSCMessage or an *OSCBundle.
+type OSCPacket interface {
+	MarshalBinary() ([]byte, error)
+	appendOSC(b []byte) ([]byte, error)
+}
+
+// OSCMessage is an OSC message. Its arguments are int32 (type tag i), float32 (f), string (s), []byte (b),
+// and of the nonstandard types, int64 (h), float64 (d), OSCTimeTag (t), bool (T or F) and nil (N). An int
+// is encoded as an int32.
+type OSCMessage struct {
+	Address string
+	Args    []any
+}
+
+// OSCBundle is an OSC bundle of messages and bundles, to be applied together at its time.
+type OSCBundle struct {
+	Time     OSCTimeTag
+	Elements []OSCPacket
+}
+
+// OSCTimeTag is an NTP time: seconds since 1900 in the upper 32 bits, and fractions of a second in the lower.
+type OSCTimeTag uint64
+
+// The time tag of a bundle to be applied as soon as it arrives.
+const OSCImmediately OSCTimeTag = 1
+
+var errOSCTruncated = errors.New("OSC: truncated packet")
+
+func (m *OSCMessage) MarshalBinary() ([]byte, error) { return m.appendOSC(nil) }
+
+func (m *OSCMessage) appendOSC(b []byte) ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("OSC: bad address %q", m.Address)
+	}
+	tags := make([]byte, 1, len(m.Args)+1)
+	tags[0] = ','
+	for _, arg := range m.Args {
+		switch arg := arg.(type) {
+		case int32:
+			tags = append(tags, 'i')
+		case int:
+			if int(int32(arg)) != arg {
+				return nil, fmt.Errorf("OSC: %d does not fit an int32", arg)
+			}
+			tags = append(tags, 'i')
+		case float32:
+			tags = append(tags, 'f')
+		case string:
+			tags = append(tags, 's')
+		case []byte:
+			tags = append(tags, 'b')
+		case int64:
+			tags = append(tags, 'h')
+		case float64:
+			tags = append(tags, 'd')
+		case OSCTimeTag:
+			tags = append(tags, 't')
+		case bool:
+			if arg {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		case nil:
+			tags = append(tags, 'N')
+		default:
+			return nil, fmt.Errorf("OSC: unsupported argument type %T", arg)
+		}
+	}
+
+	b = appendOSCString(b, m.Address)
+	b = appendOSCString(b, string(tags))
+	for _, arg := range m.Args {
+		switch arg := arg.(type) {
+		case int32:
+			b = binary.BigEndian.AppendUint32(b, uint32(arg))
+		case int:
+			b = binary.BigEndian.AppendUint32(b, uint32(arg))
+		case float32:
+			b = binary.BigEndian.AppendUint32(b, math.Float32bits(arg))
+		case string:
+			b = appendOSCString(b, arg)
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(arg)))
+			b = append(b, arg...)
+			b = append(b, make([]byte, -len(arg)&3)...)
+		case int64:
+			b = binary.BigEndian.AppendUint64(b, uint64(arg))
+		case float64:
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(arg))
+		case OSCTimeTag:
+			b = binary.BigEndian.AppendUint64(b, uint64(arg))
+		}
+	}
+	return b, nil
+}
+
+// Appends s, terminated by a NUL and padded with NULs to a multiple of 4 bytes.
+func appendOSCString(b []byte, s string) []byte {
+	b = append(b, s...)
+	return append(b, make([]byte, 4-len(s)&3)...)
+}
+
+func (bn *OSCBundle) MarshalBinary() ([]byte, error) { return bn.appendOSC(nil) }
+
+func (bn *OSCBundle) appendOSC(b []byte) ([]byte, error) {
+	b = append(b, "#bundle\x00"...)
+	b = binary.BigEndian.AppendUint64(b, uint64(bn.Time))
+	for _, e := range bn.Elements {
+		at := len(b)
+		b = append(b, 0, 0, 0, 0)
+		var err error
+		if b, err = e.appendOSC(b); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(b[at:], uint32(len(b)-at-4))
+	}
+	return b, nil
+}
+
+// Decodes an OSC packet.
+func ParseOSC(b []byte) (OSCPacket, error) {
+	if len(b)%4 != 0 {
+		return nil, errors.New("OSC: packet size is not a multiple of 4")
+	}
+	if len(b) > 0 && b[0] == '#' {
+		return parseOSCBundle(b)
+	}
+	return parseOSCMessage(b)
+}
+
+func parseOSCBundle(b []byte) (*OSCBundle, error) {
+	if len(b) < 16 || string(b[:8]) != "#bundle\x00" {
+		return nil, errors.New("OSC: bad bundle")
+	}
+	bn := &OSCBundle{Time: OSCTimeTag(binary.BigEndian.Uint64(b[8:]))}
+	for b = b[16:]; len(b) > 0; {
+		if len(b) < 4 {
+			return nil, errOSCTruncated
+		}
+		size := binary.BigEndian.Uint32(b)
+		if uint64(size) > uint64(len(b)-4) {
+			return nil, errOSCTruncated
+		}
+		e, err := ParseOSC(b[4 : 4+size])
+		if err != nil {
+			return nil, err
+		}
+		bn.Elements = append(bn.Elements, e)
+		b = b[4+size:]
+	}
+	return bn, nil
+}
+
+func parseOSCMessage(b []byte) (*OSCMessage, error) {
+	address, b, err := parseOSCString(b)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return nil, fmt.Errorf("OSC: bad address %q", address)
+	}
+	m := &OSCMessage{Address: address}
+	if len(b) == 0 {
+		return m, nil // from an OSC 1.0 implementation too old to send type tags, and without arguments
+	}
+	tags, b, err := parseOSCString(b)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return nil, errors.New("OSC: missing type tags")
+	}
+
+	for _, tag := range tags[1:] {
+		var arg any
+		switch tag {
+		case 'i', 'f':
+			if len(b) < 4 {
+				return nil, errOSCTruncated
+			}
+			if v := binary.BigEndian.Uint32(b); tag == 'i' {
+				arg = int32(v)
+			} else {
+				arg = math.Float32frombits(v)
+			}
+			b = b[4:]
+		case 'h', 'd', 't':
+			if len(b) < 8 {
+				return nil, errOSCTruncated
+			}
+			switch v := binary.BigEndian.Uint64(b); tag {
+			case 'h':
+				arg = int64(v)
+			case 'd':
+				arg = math.Float64frombits(v)
+			default:
+				arg = OSCTimeTag(v)
+			}
+			b = b[8:]
+		case 's', 'S':
+			if arg, b, err = parseOSCString(b); err != nil {
+				return nil, err
+			}
+		case 'b':
+			if len(b) < 4 {
+				return nil, errOSCTruncated
+			}
+			size := binary.BigEndian.Uint32(b)
+			padded := (uint64(size) + 3) &^ 3
+			if padded > uint64(len(b)-4) {
+				return nil, errOSCTruncated
+			}
+			arg = append([]byte(nil), b[4:4+size]...)
+			b = b[4+padded:]
+		case 'T':
+			arg = true
+		case 'F':
+			arg = false
+		case 'N':
+			arg = nil
+		default:
+			return nil, fmt.Errorf("OSC: unsupported type tag %q", tag)
+		}
+		m.Args = append(m.Args, arg)
+	}
+	return m, nil
+}
+
+// Parses a NUL-terminated, padded string off the front of b.
+func parseOSCString(b []byte) (string, []byte, error) {
+	n := 0
+	for n < len(b) && b[n] != 0 {
+		n++
+	}
+	padded := n + 4 - n&3
+	if padded > len(b) {
+		return "", nil, errOSCTruncated
+	}
+	return string(b[:n]), b[padded:], nil
+}
+
+// Reports whether an OSC address pattern matches an address. In each part of the pattern, ? matches any
+// character, * any run of characters, [abc] or [a-c] one of a set, [!abc] one not in it, and {foo,bar} any of
+// the strings listed.
+func oscMatch(pattern, address string) bool {
+	for pattern != "" {
+		switch c := pattern[0]; c {
+		case '*':
+			for pattern = pattern[1:]; strings.HasPrefix(pattern, "*"); pattern = pattern[1:] {
+			}
+			for i := 0; i <= len(address); i++ {
+				if oscMatch(pattern, address[i:]) {
+					return true
+				}
+				if i < len(address) && address[i] == '/' {
+					break
+				}
+			}
+			return false
+		case '?':
+			if address == "" || address[0] == '/' {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(pattern, ']')
+			if end < 0 || address == "" || address[0] == '/' {
+				return false
+			}
+			set, negate := pattern[1:end], false
+			if strings.HasPrefix(set, "!") {
+				set, negate = set[1:], true
+			}
+			in := false
+			for i := 0; i < len(set); i++ {
+				if i+2 < len(set) && set[i+1] == '-' {
+					in = in || set[i] <= address[0] && address[0] <= set[i+2]
+					i += 2
+				} else {
+					in = in || set[i] == address[0]
+				}
+			}
+			if in == negate {
+				return false
+			}
+			pattern = pattern[end:]
+		case '{':
+			end := strings.IndexByte(pattern, '}')
+			if end < 0 {
+				return false
+			}
+			for _, alt := range strings.Split(pattern[1:end], ",") {
+				if strings.HasPrefix(address, alt) && oscMatch(pattern[end+1:], address[len(alt):]) {
+					return true
+				}
+			}
+			return false
+		default:
+			if address == "" || address[0] != c {
+				return false
+			}
+		}
+		pattern, address = pattern[1:], address[1:]
+	}
+	return address == ""
+}
+
+// OSCServerOptions configure an OSCServer.
+type OSCServerOptions struct {
+	Address    string                 // local host:port to listen on; ":0" picks a port
+	Matrix     *Matrix                // controlled by /out and /matrix; without one, they fail
+	Generators []*Generator           // controlled by /generator
+	Record     func(start bool) error // starts or stops recording for /record; without it, /record fails
+}
+
+// OSCServer controls a stream over OSC, on UDP. It understands these addresses, where channels are indexes
+// into the stream's Inputs and Outputs and generators into the options' Generators:
+//
+//	/out/{out}/gain f             the gain in dB of an output of the matrix
+//	/out/{out}/mute T|F           mute an output of the matrix
+//	/matrix/{in}/{out}/gain f     the gain in dB of a crosspoint
+//	/matrix/{in}/{out}/mute T|F
+//	/matrix/{in}/{out}/solo T|F
+//	/generator/{n}/enabled T|F    turn a generator on or off
+//	/generator/{n}/level f        a generator's level in dBFS
+//	/record/start                 start recording
+//	/record/stop                  stop recording
+//	/record                       whether recording
+//	/stream/sampleRate            the sample rate, as an f
+//	/stream/bufferSize            the buffer size in frames, as an i
+//	/stream/latency               the input and output latencies in frames, as two i
+//
+// Any number type is accepted for f, and for T|F an i or f is true when it is not 0. Sent without
+// arguments, any address but /record/start and /record/stop is a query: the server replies to the sender with
+// a message to the same address holding the current value. Address patterns are matched as OSC 1.0
+// specifies, except that /record/start and /record/stop are only ever matched literally, and the messages of
+// a bundle are applied as they arrive, whatever their time tag. Messages the server does not understand, or
+// cannot apply, are counted by Errors.
+type OSCServer struct {
+	stream     *Stream
+	conn       *net.UDPConn
+	matrix     *Matrix
+	generators []*Generator
+	record     func(start bool) error
+	recording  atomic.Bool
+
+	wg sync.WaitGroup
+
+	received atomic.Int64
+	errors   atomic.Int64
+}
+
+// Creates a server listening on the options' address.
+func NewOSCServer(s *Stream, opts OSCServerOptions) (*OSCServer, error) {
+	addr, err := net.ResolveUDPAddr("udp", opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &OSCServer{
+		stream:     s,
+		conn:       conn,
+		matrix:     opts.Matrix,
+		generators: opts.Generators,
+		record:     opts.Record,
+	}
+	srv.wg.Add(1)
+	go srv.serve()
+	return srv, nil
+}
+
+// Returns the address the server listens on.
+func (srv *OSCServer) Addr() net.Addr { return srv.conn.LocalAddr() }
+
+// Returns the number of messages received.
+func (srv *OSCServer) Received() int64 { return srv.received.Load() }
+
+// Returns the number of packets that could not be decoded, and of messages that could not be applied.
+func (srv *OSCServer) Errors() int64 { return srv.errors.Load() }
+
+// Reports whether the server has started recording, and not stopped it since.
+func (srv *OSCServer) Recording() bool { return srv.recording.Load() }
+
+func (srv *OSCServer) serve() {
+	defer srv.wg.Done()
+
+	packet := make([]byte, 65536)
+	for {
+		n, from, err := srv.conn.ReadFromUDP(packet)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		p, err := ParseOSC(packet[:n])
+		if err != nil {
+			srv.errors.Add(1)
+			continue
+		}
+		srv.dispatch(p, from)
+	}
+}
+
+func (srv *OSCServer) dispatch(p OSCPacket, from *net.UDPAddr) {
+	if bn, ok := p.(*OSCBundle); ok {
+		for _, e := range bn.Elements {
+			srv.dispatch(e, from)
+		}
+		return
+	}
+	m := p.(*OSCMessage)
+	srv.received.Add(1)
+
+	addresses := []string{m.Address}
+	if strings.ContainsAny(m.Address, "*?[{") {
+		addresses = nil
+		for _, address := range srv.addresses() {
+			if oscMatch(m.Address, address) {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		srv.errors.Add(1)
+	}
+	for _, address := range addresses {
+		reply, err := srv.apply(address, m.Args)
+		if err != nil {
+			srv.errors.Add(1)
+			continue
+		}
+		if reply != nil {
+			if b, err := (&OSCMessage{address, reply}).MarshalBinary(); err == nil {
+				srv.conn.WriteToUDP(b, from)
+			}
+		}
+	}
+}
+
+// Returns every address the server understands, for matching patterns against. /record/start and /record/stop
+// are left out, so that no pattern both starts and stops recording.
+func (srv *OSCServer) addresses() []string {
+	list := []string{"/record", "/stream/sampleRate", "/stream/bufferSize", "/stream/latency"}
+	if srv.matrix != nil {
+		for o := range srv.stream.Outputs() {
+			list = append(list, fmt.Sprintf("/out/%d/gain", o), fmt.Sprintf("/out/%d/mute", o))
+			for i := range srv.stream.Inputs() {
+				for _, p := range []string{"gain", "mute", "solo"} {
+					list = append(list, fmt.Sprintf("/matrix/%d/%d/%s", i, o, p))
+				}
+			}
+		}
+	}
+	for n := range srv.generators {
+		list = append(list, fmt.Sprintf("/generator/%d/enabled", n), fmt.Sprintf("/generator/%d/level", n))
+	}
+	return list
+}
+
+var errOSCAddress = errors.New("OSC: no such address")
+
+// Applies a message to one address. For a query, it returns the arguments of the reply.
+func (srv *OSCServer) apply(address string, args []any) ([]any, error) {
+	parts := strings.Split(address[1:], "/")
+	query := len(args) == 0
+	if !query && len(args) != 1 {
+		return nil, fmt.Errorf("OSC: %d arguments to %s", len(args), address)
+	}
+	index := func(s string, n int) (int, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 || i >= n {
+			return 0, errOSCAddress
+		}
+		return i, nil
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "out" && srv.matrix != nil:
+		out, err := index(parts[1], len(srv.stream.Outputs()))
+		if err != nil {
+			return nil, err
+		}
+		gain, mute, _ := srv.matrix.Output(out)
+		switch parts[2] {
+		case "gain":
+			if query {
+				return []any{float32(gain)}, nil
+			}
+			if gain, ok := oscFloat(args[0]); ok {
+				return nil, srv.matrix.SetOutputGain(out, gain)
+			}
+		case "mute":
+			if query {
+				return []any{mute}, nil
+			}
+			if mute, ok := oscBool(args[0]); ok {
+				return nil, srv.matrix.SetOutputMute(out, mute)
+			}
+		default:
+			return nil, errOSCAddress
+		}
+
+	case len(parts) == 4 && parts[0] == "matrix" && srv.matrix != nil:
+		in, err := index(parts[1], len(srv.stream.Inputs()))
+		if err != nil {
+			return nil, err
+		}
+		out, err := index(parts[2], len(srv.stream.Outputs()))
+		if err != nil {
+			return nil, err
+		}
+		gain, mute, solo, _ := srv.matrix.Crosspoint(in, out)
+		switch parts[3] {
+		case "gain":
+			if query {
+				return []any{float32(gain)}, nil
+			}
+			if gain, ok := oscFloat(args[0]); ok {
+				return nil, srv.matrix.SetGain(in, out, gain)
+			}
+		case "mute", "solo":
+			if query {
+				return []any{parts[3] == "mute" && mute || parts[3] == "solo" && solo}, nil
+			}
+			if on, ok := oscBool(args[0]); ok && parts[3] == "mute" {
+				return nil, srv.matrix.SetMute(in, out, on)
+			} else if ok {
+				return nil, srv.matrix.SetSolo(in, out, on)
+			}
+		default:
+			return nil, errOSCAddress
+		}
+
+	case len(parts) == 3 && parts[0] == "generator":
+		n, err := index(parts[1], len(srv.generators))
+		if err != nil {
+			return nil, err
+		}
+		g := srv.generators[n]
+		switch parts[2] {
+		case "enabled":
+			if query {
+				return []any{g.Enabled()}, nil
+			}
+			if on, ok := oscBool(args[0]); ok {
+				g.SetEnabled(on)
+				return nil, nil
+			}
+		case "level":
+			if query {
+				return []any{float32(g.Level())}, nil
+			}
+			if level, ok := oscFloat(args[0]); ok {
+				g.SetLevel(level)
+				return nil, nil
+			}
+		default:
+			return nil, errOSCAddress
+		}
+
+	case address == "/record":
+		if query {
+			return []any{srv.recording.Load()}, nil
+		}
+		return nil, errors.New("OSC: /record is a query")
+
+	case address == "/record/start" || address == "/record/stop":
+		if srv.record == nil {
+			return nil, errors.New("OSC: no recorder")
+		}
+		start := address == "/record/start"
+		if err := srv.record(start); err != nil {
+			return nil, err
+		}
+		srv.recording.Store(start)
+		return nil, nil
+
+	case len(parts) == 2 && parts[0] == "stream":
+		if !query {
+			return nil, fmt.Errorf("OSC: %s is a query", address)
+		}
+		switch parts[1] {
+		case "sampleRate":
+			return []any{float32(srv.stream.SampleRate())}, nil
+		case "bufferSize":
+			return []any{int32(srv.stream.BufferSize())}, nil
+		case "latency":
+			in, out, err := srv.stream.Latencies()
+			if err != nil {
+				return nil, err
+			}
+			return []any{int32(in), int32(out)}, nil
+		}
+		return nil, errOSCAddress
+
+	default:
+		return nil, errOSCAddress
+	}
+	return nil, fmt.Errorf("OSC: bad argument %v to %s", args[0], address)
+}
+
+func oscFloat(arg any) (float64, bool) {
+	switch arg := arg.(type) {
+	case int32:
+		return float64(arg), true
+	case int64:
+		return float64(arg), true
+	case float32:
+		return float64(arg), true
+	case float64:
+		return arg, true
+	}
+	return 0, false
+}
+
+func oscBool(arg any) (bool, bool) {
+	if b, ok := arg.(bool); ok {
+		return b, true
+	}
+	f, ok := oscFloat(arg)
+	return f != 0, ok
+}
+
+// Stops the server.
+func (srv *OSCServer) Close() error {
+	err := srv.conn.Close()
+	srv.wg.Wait()
+	return err
+}
@@ -0,0 +1,233 @@
+package asio
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOSCEncoding(t *testing.T) {
+	// The example from the OSC 1.0 specification.
+	b, err := (&OSCMessage{"/oscillator/4/frequency", []any{float32(440)}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("/oscillator/4/frequency\x00,f\x00\x00\x43\xdc\x00\x00")
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x\nwant    % x", b, want)
+	}
+
+	m := &OSCMessage{"/all", []any{int32(-7), float32(0.5), "abcd", []byte{1, 2, 3, 4, 5}, int64(1) << 40, math.Pi,
+		OSCTimeTag(3 << 32), true, false, nil}}
+	bn := &OSCBundle{OSCImmediately, []OSCPacket{m, &OSCBundle{5, []OSCPacket{&OSCMessage{"/x", nil}}}}}
+	b, err = bn.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseOSC(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Messages without arguments decode with nil Args.
+	if !reflect.DeepEqual(p, bn) {
+		t.Errorf("decoded %#v\nwant    %#v", p, bn)
+	}
+
+	b, _ = m.MarshalBinary()
+	for i := 20; i < len(b); i++ { // past the address and type tags
+		if _, err := ParseOSC(b[:i]); err == nil {
+			t.Fatalf("no error for a packet truncated to %d bytes", i)
+		}
+	}
+	if _, err := (&OSCMessage{"/x", []any{1.5i}}).MarshalBinary(); err == nil {
+		t.Error("no error for a complex argument")
+	}
+	if _, err := (&OSCMessage{"x", nil}).MarshalBinary(); err == nil {
+		t.Error("no error for an address without a slash")
+	}
+}
+
+func TestOSCMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, address string
+		match            bool
+	}{
+		{"/out/0/gain", "/out/0/gain", true},
+		{"/out/0/gain", "/out/0/mute", false},
+		{"/out/*/gain", "/out/12/gain", true},
+		{"/out/*", "/out/12/gain", false},
+		{"/*/*/gain", "/out/1/gain", true},
+		{"/out/?/gain", "/out/1/gain", true},
+		{"/out/?/gain", "/out/12/gain", false},
+		{"/out/[0-2]/gain", "/out/2/gain", true},
+		{"/out/[!0-2]/gain", "/out/2/gain", false},
+		{"/out/[!0-2]/gain", "/out/3/gain", true},
+		{"/out/1/{gain,mute}", "/out/1/mute", true},
+		{"/out/1/{gain,mute}", "/out/1/solo", false},
+		{"/out/1/g*n", "/out/1/gain", true},
+		{"/out/1/g*n", "/out/1/gains", false},
+	} {
+		if got := oscMatch(c.pattern, c.address); got != c.match {
+			t.Errorf("oscMatch(%q, %q) = %v", c.pattern, c.address, got)
+		}
+	}
+}
+
+func TestOSCServer(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	s := newTestStream(t, drv)
+	matrix := NewMatrix(s, DefaultRampTime)
+	gen, err := NewGenerator(s, NewSine(1000), -20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []bool
+	var fail atomic.Bool
+	srv, err := NewOSCServer(s, OSCServerOptions{
+		Address:    "127.0.0.1:0",
+		Matrix:     matrix,
+		Generators: []*Generator{gen},
+		Record: func(start bool) error {
+			if fail.Load() {
+				return errors.New("disk full")
+			}
+			recorded = append(recorded, start)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	conn, err := net.DialUDP("udp", nil, srv.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send := func(p OSCPacket) {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply := func() *OSCMessage {
+		t.Helper()
+		b := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParseOSC(b[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.(*OSCMessage)
+	}
+	query := func(address string) []any {
+		t.Helper()
+		send(&OSCMessage{address, nil})
+		m := reply()
+		if m.Address != address {
+			t.Fatalf("reply to %s from %s", address, m.Address)
+		}
+		return m.Args
+	}
+
+	send(&OSCMessage{"/out/1/gain", []any{float32(-6)}})
+	send(&OSCMessage{"/out/0/mute", []any{int32(1)}})
+	send(&OSCBundle{OSCImmediately, []OSCPacket{
+		&OSCMessage{"/matrix/1/0/gain", []any{-3.5}},
+		&OSCMessage{"/matrix/*/1/solo", []any{true}},
+	}})
+	send(&OSCMessage{"/generator/0/enabled", []any{false}})
+	send(&OSCMessage{"/generator/0/level", []any{int32(-12)}})
+	send(&OSCMessage{"/record/start", nil})
+
+	// Replies come back in order, so the changes above have been applied once the first arrives.
+	if args := query("/out/1/gain"); !reflect.DeepEqual(args, []any{float32(-6)}) {
+		t.Errorf("/out/1/gain = %v", args)
+	}
+	if gain, mute, _ := matrix.Output(0); gain != 0 || !mute {
+		t.Errorf("output 0: %v %v", gain, mute)
+	}
+	if gain, _, solo, _ := matrix.Crosspoint(1, 0); gain != -3.5 || solo {
+		t.Errorf("crosspoint 1 0: %v %v", gain, solo)
+	}
+	for in := 0; in < 2; in++ {
+		if _, _, solo, _ := matrix.Crosspoint(in, 1); !solo {
+			t.Errorf("crosspoint %d 1 not soloed", in)
+		}
+	}
+	if gen.Enabled() || math.Abs(gen.Level()+12) > 1e-9 {
+		t.Errorf("generator %v %v", gen.Enabled(), gen.Level())
+	}
+	if args := query("/record"); !reflect.DeepEqual(args, []any{true}) || !srv.Recording() {
+		t.Errorf("/record = %v", args)
+	}
+	if args := query("/matrix/0/1/solo"); !reflect.DeepEqual(args, []any{true}) {
+		t.Errorf("/matrix/0/1/solo = %v", args)
+	}
+	if args := query("/generator/0/enabled"); !reflect.DeepEqual(args, []any{false}) {
+		t.Errorf("/generator/0/enabled = %v", args)
+	}
+
+	if args := query("/stream/sampleRate"); !reflect.DeepEqual(args, []any{float32(48000)}) {
+		t.Errorf("/stream/sampleRate = %v", args)
+	}
+	if args := query("/stream/bufferSize"); !reflect.DeepEqual(args, []any{int32(256)}) {
+		t.Errorf("/stream/bufferSize = %v", args)
+	}
+	in, out, _ := drv.GetLatencies()
+	if args := query("/stream/latency"); !reflect.DeepEqual(args, []any{int32(in), int32(out)}) {
+		t.Errorf("/stream/latency = %v", args)
+	}
+
+	// A pattern query gets a reply from every address it matches.
+	send(&OSCMessage{"/out/[0-1]/mute", nil})
+	got := map[string]any{}
+	for i := 0; i < 2; i++ {
+		m := reply()
+		got[m.Address] = m.Args[0]
+	}
+	if !reflect.DeepEqual(got, map[string]any{"/out/0/mute": true, "/out/1/mute": false}) {
+		t.Errorf("pattern query: %v", got)
+	}
+
+	// Only the literal addresses start and stop recording; a pattern matches neither.
+	errs := srv.Errors()
+	send(&OSCMessage{"/record/*", nil})
+	send(&OSCMessage{"/record/{start,stop}", nil})
+	send(&OSCMessage{"/record/st?p", nil})
+	if args := query("/record"); !reflect.DeepEqual(args, []any{true}) || !reflect.DeepEqual(recorded, []bool{true}) {
+		t.Errorf("/record = %v after patterns, recorded %v", args, recorded)
+	}
+	if n := srv.Errors() - errs; n != 3 {
+		t.Errorf("%d errors from patterns, want 3", n)
+	}
+
+	errs = srv.Errors()
+	fail.Store(true)
+	send(&OSCMessage{"/record/stop", nil})
+	send(&OSCMessage{"/nowhere", []any{int32(1)}})
+	send(&OSCMessage{"/out/2/gain", []any{float32(0)}})
+	send(&OSCMessage{"/out/0/gain", []any{"loud"}})
+	send(&OSCMessage{"/stream/sampleRate", []any{float32(96000)}})
+	conn.Write([]byte("junk"))
+	query("/record")
+	if n := srv.Errors() - errs; n != 6 {
+		t.Errorf("%d errors, want 6", n)
+	}
+	if !srv.Recording() || !reflect.DeepEqual(recorded, []bool{true}) {
+		t.Errorf("recording %v after a failed stop, recorded %v", srv.Recording(), recorded)
+	}
+}
@@ -0,0 +1,49 @@
+package asio
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// A pacer is the goroutine that runs a simulated driver's buffer switches when it is not Manual. Its thread is
+// locked, as a real driver's is, so that a host stopping the driver from one of the callbacks it makes can be
+// told apart from one stopping it from elsewhere.
+type pacer struct {
+	stop      chan struct{}
+	done      chan struct{}
+	thread    atomic.Int64 // OS thread ID, 0 where unknown
+	switching atomic.Bool
+}
+
+// Starts loop on a goroutine of its own. loop returns once p.stop is closed.
+func startPacer(loop func(p *pacer)) *pacer {
+	p := &pacer{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		p.thread.Store(int64(currentThreadID()))
+		loop(p)
+	}()
+	return p
+}
+
+// Runs one buffer switch, during which the host may call halt.
+func (p *pacer) step(f func() error) error {
+	p.switching.Store(true)
+	defer p.switching.Store(false)
+	return f()
+}
+
+// Stops the pacer and waits for its goroutine to return. Called back from a buffer switch on the goroutine
+// itself, it cannot wait; the goroutine returns once the switch is over. Where the OS thread ID is unknown,
+// a halt during any switch does not wait.
+func (p *pacer) halt() {
+	close(p.stop)
+	if p.switching.Load() {
+		if thread := int(p.thread.Load()); thread == 0 || thread == currentThreadID() {
+			return
+		}
+	}
+	<-p.done
+}
@@ -0,0 +1,84 @@
+package asio
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// The fewest blocks a recorder queues between the driver thread and file I/O.
+const recordQueueBuffers = 8
+
+// A recordQueue hands blocks of input from the driver thread to a goroutine that writes them to a file, as
+// WAVRecorder and DSDRecorder do. When the goroutine falls behind, there is no free block and the input is
+// dropped and counted as an overrun.
+type recordQueue[T any] struct {
+	full chan T
+	free chan T
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	active   atomic.Int32 // queue calls under way
+	closed   atomic.Bool
+	overruns atomic.Int64
+}
+
+// Makes n blocks with newBlock and starts a goroutine that calls put with each block queued, in order.
+func (q *recordQueue[T]) start(n int, newBlock func() T, put func(T)) {
+	q.full = make(chan T, n)
+	q.free = make(chan T, n)
+	q.stop = make(chan struct{})
+	for i := 0; i < n; i++ {
+		q.free <- newBlock()
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			select {
+			case blk := <-q.full:
+				put(blk)
+				q.free <- blk
+			case <-q.stop:
+				for {
+					select {
+					case blk := <-q.full:
+						put(blk)
+						q.free <- blk
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Called on the driver thread: fills a free block and queues it, or counts an overrun if there is none. Once
+// the queue is closed, it does nothing.
+func (q *recordQueue[T]) queue(fill func(T)) {
+	q.active.Add(1)
+	defer q.active.Add(-1)
+	if q.closed.Load() {
+		return
+	}
+	select {
+	case blk := <-q.free:
+		fill(blk)
+		q.full <- blk
+	default:
+		q.overruns.Add(1)
+	}
+}
+
+// Stops queueing and returns once every block queued has been put, including one from a queue call that was
+// under way, e.g. on a buffer switch that began before the recorder was removed from the stream's taps.
+func (q *recordQueue[T]) close() {
+	q.closed.Store(true)
+	for q.active.Load() != 0 {
+		runtime.Gosched()
+	}
+	close(q.stop)
+	q.wg.Wait()
+}
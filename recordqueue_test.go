@@ -0,0 +1,34 @@
+package asio
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRecordQueueClose(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var q recordQueue[int]
+		var queued, put int64
+		q.start(2, func() int { return 0 }, func(int) { put++ })
+
+		// Queue from another goroutine, as the driver thread would, right up to the close.
+		started := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.queue(func(int) { queued++ })
+			close(started)
+			for !q.closed.Load() {
+				runtime.Gosched()
+				q.queue(func(int) { queued++ })
+			}
+		}()
+		<-started
+		q.close()
+		<-done
+
+		if put != queued {
+			t.Fatalf("queued %d blocks, put %d", queued, put)
+		}
+	}
+}
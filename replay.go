@@ -0,0 +1,515 @@
+package asio
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// ReplayDriver is a Driver that behaves as a traced driver did, from its trace. Each call returns what the
+// traced driver returned for the same call with the same arguments: the next such call in the trace or, for
+// a query asked again before anything changes the driver's state, the last one. A call the trace has no answer
+// for fails with ErrorNotPresent, or returns the zero value, and Divergences lists it.
+//
+// Once started, it replays the trace's buffer switches with their recorded time info and, if the trace has
+// audio, inputs; paced by their recorded times or, when Manual is set, one per Step. The messages and sample
+// rate changes that the traced driver sent come in the same order; those it sent while a call was in progress
+// come while that call is replayed.
+type ReplayDriver struct {
+	// When set, buffer switches only happen on Step.
+	Manual bool
+
+	events []TraceEvent
+	queue  []int           // indexes of the callbacks Step replays
+	during map[int64][]int // indexes of the callbacks that came during each call
+
+	mu          sync.Mutex
+	cursor      int // calls are looked for from here on
+	next        int // into queue
+	callbacks   Callbacks
+	buffers     []BufferInfo
+	memory      [][2][]byte
+	inputs      []int // indexes into buffers
+	running     bool
+	started     time.Duration // the recorded time of the Start replayed
+	pacing      *pacer        // nil when Manual or stopped
+	divergences []string
+
+	// Serializes buffer switches between Step and the pacing goroutine.
+	switchMu sync.Mutex
+}
+
+var _ Driver = (*ReplayDriver)(nil)
+
+// The calls that change a driver's state, which queries are not answered across.
+var replayChanges = map[string]bool{"Init": true, "Start": true, "Stop": true, "SetSampleRate": true,
+	"SetClockSource": true, "CreateBuffers": true, "DisposeBuffers": true, "ControlPanel": true, "Future": true}
+
+// The most divergences kept.
+const maxDivergences = 100
+
+// Creates a driver replaying the trace read from r.
+func NewReplayDriver(r io.Reader) (*ReplayDriver, error) {
+	events, _, err := ReadTrace(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &ReplayDriver{events: events, during: make(map[int64][]int)}
+	for i, ev := range events {
+		if ev.Kind != TraceCallback {
+			continue
+		}
+		if ev.Call != 0 && (ev.Name == "asioMessage" || ev.Name == "sampleRateDidChange") {
+			d.during[ev.Call] = append(d.during[ev.Call], i)
+		} else {
+			d.queue = append(d.queue, i)
+		}
+	}
+	return d, nil
+}
+
+// Returns descriptions of the calls the trace had no answer for, and of the messages the host answered
+// differently than the traced host did.
+func (d *ReplayDriver) Divergences() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.divergences...)
+}
+
+func (d *ReplayDriver) diverge(format string, args ...any) {
+	if len(d.divergences) < maxDivergences {
+		d.divergences = append(d.divergences, fmt.Sprintf(format, args...))
+	}
+}
+
+// Finds the recorded call to answer a call with, and replays the callbacks that came during it. It returns the
+// index of the call's event, or -1.
+func (d *ReplayDriver) replay(name string, args ...any) int {
+	d.mu.Lock()
+	i, advance := d.find(name, args)
+	if i < 0 {
+		d.diverge("%s%v is not in the trace", name, args)
+		d.mu.Unlock()
+		return -1
+	}
+	var during []int
+	if advance {
+		if i >= d.cursor {
+			during = d.during[d.events[i].Call]
+		}
+		d.cursor = max(d.cursor, i+1)
+	}
+	cb := d.callbacks
+	d.mu.Unlock()
+
+	for _, j := range during {
+		d.deliver(&d.events[j], cb)
+	}
+	return i
+}
+
+// Returns the index of the call to answer with, and whether replaying moves past it. Called with d.mu held.
+func (d *ReplayDriver) find(name string, args []any) (int, bool) {
+	match := func(i int) bool {
+		ev := &d.events[i]
+		if ev.Kind != TraceCall || ev.Name != name || len(args) > len(ev.Args) {
+			return false
+		}
+		for k := range args {
+			if !reflect.DeepEqual(ev.Args[k], args[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	changes := replayChanges[name]
+	for i := d.cursor; i < len(d.events); i++ {
+		if match(i) {
+			return i, true
+		}
+		if ev := &d.events[i]; !changes && ev.Kind == TraceCall && replayChanges[ev.Name] {
+			break
+		}
+	}
+	if changes {
+		return -1, false
+	}
+	for i := d.cursor - 1; i >= 0; i-- {
+		if match(i) {
+			return i, false
+		}
+	}
+	// Asked before the driver got into the state the trace answers it in.
+	for i := d.cursor; i < len(d.events); i++ {
+		if match(i) {
+			return i, false
+		}
+	}
+	return -1, false
+}
+
+// Replays a message or sample rate change.
+func (d *ReplayDriver) deliver(ev *TraceEvent, cb Callbacks) {
+	switch ev.Name {
+	case "sampleRateDidChange":
+		if cb.SampleRateDidChange != nil {
+			cb.SampleRateDidChange(traceFloat(ev.Args, 0))
+		}
+	case "asioMessage":
+		if cb.Message == nil {
+			return
+		}
+		selector, value := int32(traceInt(ev.Args, 0)), int32(traceInt(ev.Args, 1))
+		var opt *float64
+		if f, ok := traceValue(ev.Args, 2).(float64); ok {
+			opt = &f
+		}
+		if ret := cb.Message(selector, value, 0, opt); int64(ret) != traceInt(ev.Results, 0) {
+			d.mu.Lock()
+			d.diverge("the host answered asioMessage(%d, %d) with %d, not %d", selector, value, ret,
+				traceInt(ev.Results, 0))
+			d.mu.Unlock()
+		}
+	}
+}
+
+func traceValue(values []any, k int) any {
+	if k < len(values) {
+		return values[k]
+	}
+	return nil
+}
+
+func traceInt(values []any, k int) int64 {
+	v, _ := traceValue(values, k).(int64)
+	return v
+}
+
+func traceFloat(values []any, k int) float64 {
+	v, _ := traceValue(values, k).(float64)
+	return v
+}
+
+func traceBool(values []any, k int) bool {
+	v, _ := traceValue(values, k).(bool)
+	return v
+}
+
+func traceString(values []any, k int) string {
+	v, _ := traceValue(values, k).(string)
+	return v
+}
+
+func traceList(values []any, k int) []any {
+	v, _ := traceValue(values, k).([]any)
+	return v
+}
+
+func (d *ReplayDriver) results(i int) []any {
+	if i < 0 {
+		return nil
+	}
+	return d.events[i].Results
+}
+
+func (d *ReplayDriver) err(i int) error {
+	if i < 0 {
+		return ErrorNotPresent
+	}
+	return d.events[i].Err
+}
+
+func (d *ReplayDriver) Init(sysHandle uintptr) (ok bool) {
+	return traceBool(d.results(d.replay("Init")), 0)
+}
+
+func (d *ReplayDriver) GetDriverName() string {
+	return traceString(d.results(d.replay("GetDriverName")), 0)
+}
+
+func (d *ReplayDriver) GetDriverVersion() int32 {
+	return int32(traceInt(d.results(d.replay("GetDriverVersion")), 0))
+}
+
+func (d *ReplayDriver) GetErrorMessage() string {
+	return traceString(d.results(d.replay("GetErrorMessage")), 0)
+}
+
+func (d *ReplayDriver) GetChannels() (numInputChannels, numOutputChannels int, err error) {
+	i := d.replay("GetChannels")
+	r := d.results(i)
+	return int(traceInt(r, 0)), int(traceInt(r, 1)), d.err(i)
+}
+
+func (d *ReplayDriver) GetLatencies() (inputLatency, outputLatency int, err error) {
+	i := d.replay("GetLatencies")
+	r := d.results(i)
+	return int(traceInt(r, 0)), int(traceInt(r, 1)), d.err(i)
+}
+
+func (d *ReplayDriver) GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
+	i := d.replay("GetBufferSize")
+	r := d.results(i)
+	return int(traceInt(r, 0)), int(traceInt(r, 1)), int(traceInt(r, 2)), int(traceInt(r, 3)), d.err(i)
+}
+
+func (d *ReplayDriver) CanSampleRate(sampleRate float64) (err error) {
+	return d.err(d.replay("CanSampleRate", sampleRate))
+}
+
+func (d *ReplayDriver) GetSampleRate() (sampleRate float64, err error) {
+	i := d.replay("GetSampleRate")
+	return traceFloat(d.results(i), 0), d.err(i)
+}
+
+func (d *ReplayDriver) SetSampleRate(sampleRate float64) (err error) {
+	return d.err(d.replay("SetSampleRate", sampleRate))
+}
+
+func (d *ReplayDriver) GetClockSources() (sources []ClockSource, err error) {
+	i := d.replay("GetClockSources")
+	for _, v := range traceList(d.results(i), 0) {
+		src, _ := v.([]any)
+		sources = append(sources, ClockSource{
+			Index:             int(traceInt(src, 0)),
+			AssociatedChannel: int(traceInt(src, 1)),
+			AssociatedGroup:   int(traceInt(src, 2)),
+			IsCurrentSource:   traceBool(src, 3),
+			Name:              traceString(src, 4),
+		})
+	}
+	return sources, d.err(i)
+}
+
+func (d *ReplayDriver) SetClockSource(reference int) (err error) {
+	return d.err(d.replay("SetClockSource", int64(reference)))
+}
+
+func (d *ReplayDriver) GetSamplePosition() (samplePosition, systemTime int64, err error) {
+	i := d.replay("GetSamplePosition")
+	r := d.results(i)
+	return traceInt(r, 0), traceInt(r, 1), d.err(i)
+}
+
+func (d *ReplayDriver) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
+	i := d.replay("GetChannelInfo", int64(channel), isInput)
+	if v := traceList(d.results(i), 0); v != nil {
+		info = &ChannelInfo{
+			Channel:      int(traceInt(v, 0)),
+			IsInput:      traceBool(v, 1),
+			IsActive:     traceBool(v, 2),
+			ChannelGroup: int(traceInt(v, 3)),
+			SampleType:   SampleType(traceInt(v, 4)),
+			Name:         traceString(v, 5),
+		}
+	}
+	return info, d.err(i)
+}
+
+func (d *ReplayDriver) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+	descs := make([]any, len(bufferDescriptors))
+	for i, desc := range bufferDescriptors {
+		descs[i] = []any{int64(desc.Channel), desc.IsInput}
+	}
+	d.mu.Lock()
+	if d.buffers != nil {
+		d.mu.Unlock()
+		return ErrorInvalidMode
+	}
+	d.callbacks = callbacks // for the messages sent during the call
+	d.mu.Unlock()
+
+	i := d.replay("CreateBuffers", descs, int64(bufferSize))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err = d.err(i); err != nil {
+		d.callbacks = Callbacks{}
+		return err
+	}
+
+	sizes := traceList(d.results(i), 0)
+	d.memory = make([][2][]byte, len(bufferDescriptors))
+	d.inputs = nil
+	for k := range bufferDescriptors {
+		size := int(traceInt(sizes, k))
+		// Back the buffers with []int32 so they are aligned like driver memory.
+		for j := 0; j < 2; j++ {
+			words := make([]int32, max(1, (size+3)/4))
+			d.memory[k][j] = unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), size)
+			bufferDescriptors[k].Buffers[j] = &words[0]
+		}
+		if bufferDescriptors[k].IsInput {
+			d.inputs = append(d.inputs, k)
+		}
+	}
+	d.buffers = append([]BufferInfo(nil), bufferDescriptors...)
+	return nil
+}
+
+func (d *ReplayDriver) DisposeBuffers() (err error) {
+	d.halt()
+	err = d.err(d.replay("DisposeBuffers"))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buffers = nil
+	d.memory = nil
+	d.callbacks = Callbacks{}
+	return err
+}
+
+func (d *ReplayDriver) Start() (err error) {
+	i := d.replay("Start")
+	if err = d.err(i); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.buffers == nil {
+		return ErrorInvalidMode
+	}
+	if d.running {
+		return nil
+	}
+	// Replay the callbacks that came after this start.
+	for d.next = 0; d.next < len(d.queue) && d.queue[d.next] < i; d.next++ {
+	}
+	d.running = true
+	d.started = d.events[i].Time
+	if !d.Manual {
+		d.pacing = startPacer(d.run)
+	}
+	return nil
+}
+
+func (d *ReplayDriver) Stop() (err error) {
+	d.halt()
+	return d.err(d.replay("Stop"))
+}
+
+// Stops replaying buffer switches.
+func (d *ReplayDriver) halt() {
+	d.mu.Lock()
+	d.running = false
+	pacing := d.pacing
+	d.pacing = nil
+	d.mu.Unlock()
+
+	if pacing != nil {
+		pacing.halt()
+	}
+}
+
+func (d *ReplayDriver) ControlPanel() (err error) {
+	return d.err(d.replay("ControlPanel"))
+}
+
+func (d *ReplayDriver) Future(selector int32, opt unsafe.Pointer) (err error) {
+	var format any
+	ioFormat := selector == AsioCanDoIoFormat || selector == AsioSetIoFormat || selector == AsioGetIoFormat
+	if ioFormat && opt != nil {
+		format = int64((*IoFormat)(opt).FormatType)
+	}
+	i := d.replay("Future", int64(selector), format)
+	if v, ok := traceValue(d.results(i), 0).(int64); ok && ioFormat && opt != nil {
+		(*IoFormat)(opt).FormatType = IoFormatType(v)
+	}
+	return d.err(i)
+}
+
+func (d *ReplayDriver) OutputReady() bool {
+	return traceBool(d.results(d.replay("OutputReady")), 0)
+}
+
+func (d *ReplayDriver) run(p *pacer) {
+	begin := time.Now()
+	for {
+		d.mu.Lock()
+		// Due with the next buffer switch or, after the last, the last of the callbacks, e.g. a reset request.
+		at := time.Duration(-1)
+		for _, i := range d.queue[d.next:] {
+			ev := &d.events[i]
+			at = ev.Time - d.started
+			if ev.Name == "bufferSwitch" || ev.Name == "bufferSwitchTimeInfo" {
+				break
+			}
+		}
+		d.mu.Unlock()
+		if at < 0 {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(begin.Add(at)))
+		select {
+		case <-p.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if p.step(d.Step) != nil {
+			return
+		}
+	}
+}
+
+// Replays the trace's callbacks up to and including its next buffer switch. It returns io.EOF once the trace
+// has no more.
+func (d *ReplayDriver) Step() (err error) {
+	d.switchMu.Lock()
+	defer d.switchMu.Unlock()
+
+	for {
+		d.mu.Lock()
+		if !d.running {
+			d.mu.Unlock()
+			return ErrorInvalidMode
+		}
+		if d.next >= len(d.queue) {
+			d.mu.Unlock()
+			return io.EOF
+		}
+		ev := &d.events[d.queue[d.next]]
+		d.next++
+		cb := d.callbacks
+		if ev.Name != "bufferSwitch" && ev.Name != "bufferSwitchTimeInfo" {
+			d.mu.Unlock()
+			d.deliver(ev, cb)
+			continue
+		}
+
+		index := int(traceInt(ev.Args, 0)) & 1
+		for k, b := range d.inputs {
+			if k < len(ev.In) {
+				copy(d.memory[b][index], ev.In[k])
+			}
+		}
+		d.mu.Unlock()
+
+		direct := traceBool(ev.Args, 1)
+		var params ASIOTime
+		if t := traceList(ev.Args, 2); t != nil {
+			params.TimeInfo = TimeInfo{
+				Speed:          traceFloat(t, 0),
+				SystemTime:     traceInt(t, 1),
+				SamplePosition: traceInt(t, 2),
+				SampleRate:     traceFloat(t, 3),
+				Flags:          TimeInfoFlags(traceInt(t, 4)),
+			}
+			params.TimeCode = TimeCode{
+				Speed:           traceFloat(t, 5),
+				TimeCodeSamples: traceInt(t, 6),
+				Flags:           TimeCodeFlags(traceInt(t, 7)),
+			}
+		}
+		if cb.BufferSwitchTimeInfo != nil && (ev.Name == "bufferSwitchTimeInfo" || cb.BufferSwitch == nil) {
+			cb.BufferSwitchTimeInfo(&params, int32(index), direct)
+		} else if cb.BufferSwitch != nil {
+			cb.BufferSwitch(index, direct)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,459 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+type ResampleQuality int
+
+const (
+	LowQuality    ResampleQuality = iota // 16 zero crossings, 60dB stopband
+	MediumQuality                        // 32 zero crossings, 90dB stopband
+	HighQuality                          // 64 zero crossings, 110dB stopband
+	BestQuality                          // 128 zero crossings, 140dB stopband
+)
+
+var resampleQualities = [...]struct {
+	zeros       int     // zero crossings of the sinc on either side of its centre
+	attenuation float64 // stopband attenuation in dB
+	resolution  int     // kernel table entries per zero crossing
+}{
+	LowQuality:    {16, 60, 128},
+	MediumQuality: {32, 90, 256},
+	HighQuality:   {64, 110, 512},
+	BestQuality:   {128, 140, 1024},
+}
+
+// Kernel tables are shared by every Resampler of the same quality.
+var resampleKernels [len(resampleQualities)]atomic.Pointer[resampleKernel]
+
+type resampleKernel struct {
+	table  []float64 // Kaiser windowed sinc at 0, 1/resolution, ... zero crossings
+	cutoff float64   // centre of the transition band, relative to Nyquist
+}
+
+func kernelFor(q ResampleQuality) *resampleKernel {
+	if k := resampleKernels[q].Load(); k != nil {
+		return k
+	}
+
+	p := resampleQualities[q]
+	beta := 0.1102 * (p.attenuation - 8.7)
+	// Kaiser's estimate of the transition width of a filter with 2*zeros taps, relative to Nyquist:
+	width := (p.attenuation - 7.95) / (2.285 * float64(2*p.zeros)) / math.Pi
+
+	k := &resampleKernel{
+		table:  make([]float64, p.zeros*p.resolution+2),
+		cutoff: 1 - width/2,
+	}
+	for j := 0; j < p.zeros*p.resolution; j++ {
+		u := float64(j) / float64(p.resolution)
+		h := 1.0
+		if j != 0 {
+			h = math.Sin(math.Pi*u) / (math.Pi * u)
+		}
+		x := u / float64(p.zeros)
+		k.table[j] = h * bessel0(beta*math.Sqrt(1-x*x)) / bessel0(beta)
+	}
+	resampleKernels[q].Store(k)
+	return k
+}
+
+// The zeroth order modified Bessel function of the first kind, for the Kaiser window.
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-17; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// Resampler converts multichannel audio between two sample rates with a Kaiser windowed sinc filter, evaluated
+// for any ratio by interpolating a finely sampled kernel. Input is pushed with Write and output pulled with
+// Read, in buffers of any size. It is not safe for concurrent use.
+type Resampler struct {
+	inRate, outRate float64
+	quality         ResampleQuality
+
+	kernel *resampleKernel
+	zeros  float64
+	res    float64
+	fc     float64 // cutoff relative to the input Nyquist
+	half   int     // input samples either side of an output needed to compute it
+	step   float64 // input samples per output sample
+
+	hist  [][]float32 // input history per channel, a ring whose size is a power of two
+	mask  int
+	start int     // ring index of the oldest input kept
+	kept  int     // input frames kept
+	t     float64 // position of the next output, in frames from start
+	coefs []float64
+}
+
+// Creates a resampler from inRate to outRate for the given number of channels.
+func NewResampler(channels int, inRate, outRate float64, quality ResampleQuality) (*Resampler, error) {
+	if channels <= 0 || inRate <= 0 || outRate <= 0 {
+		return nil, ErrorInvalidParameter
+	}
+	if quality < 0 || int(quality) >= len(resampleQualities) {
+		return nil, fmt.Errorf("unknown resample quality %d", quality)
+	}
+
+	p := resampleQualities[quality]
+	r := &Resampler{
+		inRate:  inRate,
+		outRate: outRate,
+		quality: quality,
+		kernel:  kernelFor(quality),
+		zeros:   float64(p.zeros),
+		res:     float64(p.resolution),
+		step:    inRate / outRate,
+	}
+	// When downsampling, the cutoff moves down to the output's Nyquist and the kernel widens to match.
+	r.fc = r.kernel.cutoff * min(1, outRate/inRate)
+	r.half = int(math.Ceil(r.zeros / r.fc))
+	r.coefs = make([]float64, 2*r.half)
+
+	// Start with 2*half samples of silence, so that output keeps pace with input from the first Write.
+	r.hist = make([][]float32, channels)
+	r.grow(4 * r.half)
+	r.kept = 2 * r.half
+	r.t = float64(r.half)
+	return r, nil
+}
+
+// Makes room in the ring for frames more input than it keeps between a Read that used up the input and the next
+// Write, so that writing that much at a time never allocates.
+func (r *Resampler) Reserve(frames int) {
+	r.grow(2*r.half + int(math.Ceil(r.step)) + 1 + frames)
+}
+
+// Grows the ring to hold at least size frames, keeping what it holds.
+func (r *Resampler) grow(size int) {
+	if r.hist[0] != nil && size <= len(r.hist[0]) {
+		return
+	}
+	size = 1 << bits.Len(uint(size-1))
+	for ch, x := range r.hist {
+		h := make([]float32, size)
+		if x != nil {
+			n := copy(h, x[r.start:min(r.start+r.kept, len(x))])
+			copy(h[n:r.kept], x)
+		}
+		r.hist[ch] = h
+	}
+	r.start = 0
+	r.mask = size - 1
+}
+
+func (r *Resampler) InputRate() float64  { return r.inRate }
+func (r *Resampler) OutputRate() float64 { return r.outRate }
+
+// Scales the conversion ratio to factor times inRate/outRate, for following a clock that drifts from its
+// nominal rate. The filter is not redesigned, so factor should stay within a fraction of a percent of 1.
+func (r *Resampler) AdjustRatio(factor float64) { r.step = r.inRate / r.outRate * factor }
+
+// Returns the delay of the output relative to the input, in output frames.
+func (r *Resampler) LatencyFrames() float64 { return float64(r.half) / r.step }
+
+// Returns the delay of the output relative to the input.
+func (r *Resampler) Latency() time.Duration {
+	return time.Duration(float64(r.half) / r.inRate * float64(time.Second))
+}
+
+// Appends len(src[0]) frames of input, one slice per channel. The ring grows if it has no room for them; see
+// Reserve.
+func (r *Resampler) Write(src [][]float32) {
+	r.grow(r.kept + len(src[0]))
+	end := (r.start + r.kept) & r.mask
+	for ch, s := range src {
+		n := copy(r.hist[ch][end:], s)
+		copy(r.hist[ch], s[n:])
+	}
+	r.kept += len(src[0])
+}
+
+// Produces up to len(dst[0]) frames of output, one slice per channel, from the input written so far. Returns
+// the number of frames produced; fewer than asked for means more input is needed.
+func (r *Resampler) Read(dst [][]float32) (n int) {
+	table := r.kernel.table
+	scale := r.fc * r.res
+	for ; n < len(dst[0]); n++ {
+		centre := int(r.t)
+		if centre+r.half >= r.kept {
+			break
+		}
+
+		first := centre - r.half + 1
+		sum := 0.0
+		for j := range r.coefs {
+			u := math.Abs(r.t-float64(first+j)) * scale
+			h := 0.0
+			if i := int(u); i < len(table)-1 {
+				f := u - float64(i)
+				h = table[i] + f*(table[i+1]-table[i])
+			}
+			r.coefs[j] = h
+			sum += h
+		}
+		// Normalizing every phase to unity gain keeps DC exact.
+		at := r.start + first
+		for ch, x := range r.hist {
+			y := 0.0
+			for j, h := range r.coefs {
+				y += h * float64(x[(at+j)&r.mask])
+			}
+			dst[ch][n] = float32(y / sum)
+		}
+		r.t += r.step
+	}
+
+	// Drop input that no future output needs.
+	if drop := int(r.t) - r.half; drop > 0 {
+		r.start = (r.start + drop) & r.mask
+		r.kept -= drop
+		r.t -= float64(drop)
+	}
+	return n
+}
+
+// Returns how many input frames have been written but not yet consumed by output.
+func (r *Resampler) Pending() float64 { return float64(r.kept-r.half) - r.t }
+
+// Returns how many output frames the input written so far can produce.
+func (r *Resampler) Available() (n int) {
+	// Step exactly as Read does, so that the two always agree.
+	for t := r.t; int(t)+r.half < r.kept; t += r.step {
+		n++
+	}
+	return n
+}
+
+// The Resampler of a ResampledOutput or ResampledInput, made for the stream's sample rate. When that changes,
+// the driver thread carries on with the old one while a goroutine designs the new one, which allocates, and
+// swaps the new one in once it is ready.
+type streamResampler struct {
+	current  atomic.Pointer[ratedResampler]
+	next     atomic.Pointer[ratedResampler]
+	building atomic.Bool
+	failed   atomic.Uint64 // math.Float64bits of the last rate build failed at, so that it is not tried again
+	build    func(streamRate float64) (*Resampler, error)
+}
+
+type ratedResampler struct {
+	*Resampler
+	streamRate float64
+}
+
+// Builds the resampler for the stream's current rate. Every resampler built has room for the stream's buffers.
+func newStreamResampler(s *Stream, build func(streamRate float64) (*Resampler, error)) (*streamResampler, error) {
+	sr := &streamResampler{build: func(streamRate float64) (*Resampler, error) {
+		r, err := build(streamRate)
+		if err == nil {
+			r.Reserve(s.BufferSize())
+		}
+		return r, err
+	}}
+	r, err := sr.build(s.SampleRate())
+	if err != nil {
+		return nil, err
+	}
+	sr.current.Store(&ratedResampler{r, s.SampleRate()})
+	return sr, nil
+}
+
+func (sr *streamResampler) Latency() time.Duration { return sr.current.Load().Latency() }
+
+// Called on the driver thread: returns the resampler to use for a block at rate.
+func (sr *streamResampler) forRate(rate float64) *Resampler {
+	cur := sr.current.Load()
+	if cur.streamRate == rate {
+		return cur.Resampler
+	}
+	if next := sr.next.Load(); next != nil && next.streamRate == rate && sr.next.CompareAndSwap(next, nil) {
+		sr.current.Store(next)
+		return next.Resampler
+	}
+	if math.Float64bits(rate) != sr.failed.Load() && sr.building.CompareAndSwap(false, true) {
+		go func() {
+			defer sr.building.Store(false)
+			r, err := sr.build(rate)
+			if err != nil {
+				// At a rate it cannot resample at, the old resampler carries on.
+				sr.failed.Store(math.Float64bits(rate))
+				return
+			}
+			sr.next.Store(&ratedResampler{r, rate})
+		}()
+	}
+	return cur.Resampler
+}
+
+// ResampledOutput plays audio at a source rate of its own on outputs of a stream, mixing it into whatever else
+// is playing. The source side calls Write from any one goroutine; the driver thread resamples what has been
+// written. When the source falls behind, the outputs get silence and Underruns counts the buffer.
+type ResampledOutput struct {
+	stream    *Stream
+	outputs   []int
+	fifo      *frameRing
+	resampler *streamResampler
+
+	// Only touched on the driver thread:
+	in, out [][]float32
+	view    [][]float32
+
+	underruns atomic.Int64
+}
+
+// Creates a ResampledOutput for len(outputs) channels of audio at sourceRate, buffering up to a second of it,
+// and adds it to the stream's processors.
+func NewResampledOutput(s *Stream, sourceRate float64, quality ResampleQuality, outputs ...int) (*ResampledOutput, error) {
+	for _, ch := range outputs {
+		if ch < 0 || ch >= len(s.Outputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	r, err := newStreamResampler(s, func(streamRate float64) (*Resampler, error) {
+		return NewResampler(len(outputs), sourceRate, streamRate, quality)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o := &ResampledOutput{
+		stream:    s,
+		outputs:   outputs,
+		fifo:      newFrameRing(len(outputs), int(sourceRate)),
+		resampler: r,
+		in:        makeFloatBuffers(len(outputs), s.BufferSize()),
+		out:       makeFloatBuffers(len(outputs), s.BufferSize()),
+		view:      make([][]float32, len(outputs)),
+	}
+	s.AddProcessor(o)
+	return o, nil
+}
+
+// Queues len(frames[0]) frames, one slice per output. Returns how many fitted in the buffer.
+func (o *ResampledOutput) Write(frames [][]float32) int { return o.fifo.write(frames) }
+
+// Returns how many frames Write can accept.
+func (o *ResampledOutput) Free() int { return o.fifo.size - o.fifo.available() }
+
+func (o *ResampledOutput) Underruns() int64 { return o.underruns.Load() }
+
+// Returns the delay added by resampling.
+func (o *ResampledOutput) Latency() time.Duration { return o.resampler.Latency() }
+
+func (o *ResampledOutput) Process(b *Block) {
+	r := o.resampler.forRate(b.SampleRate)
+
+	n := 0
+	for n < b.Frames {
+		for ch := range o.view {
+			o.view[ch] = o.out[ch][n:b.Frames]
+		}
+		if n += r.Read(o.view); n == b.Frames {
+			break
+		}
+
+		got := o.fifo.readInto(o.in)
+		if got == 0 {
+			o.underruns.Add(1)
+			break
+		}
+		for ch := range o.view {
+			o.view[ch] = o.in[ch][:got]
+		}
+		r.Write(o.view)
+	}
+
+	for ch, output := range o.outputs {
+		dst := b.Out[output]
+		for i, x := range o.out[ch][:n] {
+			dst[i] += x
+		}
+	}
+}
+
+// ResampledInput delivers inputs of a stream at a rate of its own. The driver thread resamples the inputs into a
+// buffer that the consumer drains with Read from any one goroutine. When the consumer falls behind, frames are
+// dropped and Overruns counts the buffer.
+type ResampledInput struct {
+	inputs    []int
+	fifo      *frameRing
+	resampler *streamResampler
+
+	// Only touched on the driver thread:
+	out  [][]float32
+	view [][]float32
+
+	overruns atomic.Int64
+}
+
+// Creates a ResampledInput for the given inputs at targetRate, buffering up to a second of it, and adds it to
+// the stream's taps.
+func NewResampledInput(s *Stream, targetRate float64, quality ResampleQuality, inputs ...int) (*ResampledInput, error) {
+	for _, ch := range inputs {
+		if ch < 0 || ch >= len(s.Inputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	r, err := newStreamResampler(s, func(streamRate float64) (*Resampler, error) {
+		return NewResampler(len(inputs), streamRate, targetRate, quality)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	in := &ResampledInput{
+		inputs:    inputs,
+		fifo:      newFrameRing(len(inputs), int(targetRate)),
+		resampler: r,
+		out:       makeFloatBuffers(len(inputs), s.BufferSize()),
+		view:      make([][]float32, len(inputs)),
+	}
+	s.AddTap(in)
+	return in, nil
+}
+
+// Removes up to len(frames[0]) frames, one slice per input. Returns how many there were.
+func (in *ResampledInput) Read(frames [][]float32) int { return in.fifo.readInto(frames) }
+
+// Returns how many frames Read can return.
+func (in *ResampledInput) Available() int { return in.fifo.available() }
+
+func (in *ResampledInput) Overruns() int64 { return in.overruns.Load() }
+
+// Returns the delay added by resampling.
+func (in *ResampledInput) Latency() time.Duration { return in.resampler.Latency() }
+
+func (in *ResampledInput) Process(b *Block) {
+	r := in.resampler.forRate(b.SampleRate)
+
+	for ch, input := range in.inputs {
+		in.view[ch] = b.In[input]
+	}
+	r.Write(in.view)
+
+	overrun := false
+	for {
+		n := r.Read(in.out)
+		if n == 0 {
+			break
+		}
+		for ch := range in.view {
+			in.view[ch] = in.out[ch][:n]
+		}
+		if in.fifo.write(in.view) < n {
+			overrun = true
+		}
+	}
+	if overrun {
+		in.overruns.Add(1)
+	}
+}
@@ -0,0 +1,251 @@
+package asio
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func sineFrames(freq, rate float64, n int) []float32 {
+	x := make([]float32, n)
+	for i := range x {
+		x[i] = float32(math.Sin(2 * math.Pi * freq * float64(i) / rate))
+	}
+	return x
+}
+
+// Returns the amplitude of the component of x at freq, measured through a Blackman-Harris window.
+func toneAmplitude(x []float32, freq, rate float64) float64 {
+	var sum complex128
+	var wsum float64
+	n := float64(len(x) - 1)
+	for i, v := range x {
+		p := 2 * math.Pi * float64(i) / n
+		w := 0.35875 - 0.48829*math.Cos(p) + 0.14128*math.Cos(2*p) - 0.01168*math.Cos(3*p)
+		sum += complex(float64(v)*w, 0) * cmplx.Exp(complex(0, -2*math.Pi*freq*float64(i)/rate))
+		wsum += w
+	}
+	return 2 * cmplx.Abs(sum) / wsum
+}
+
+// Resamples a whole mono signal, dropping the leading latency.
+func resampleAll(t *testing.T, x []float32, inRate, outRate float64, q ResampleQuality) []float32 {
+	r, err := NewResampler(1, inRate, outRate, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([][]float32{x})
+	out := make([]float32, r.Available())
+	if n := r.Read([][]float32{out}); n != len(out) {
+		t.Fatalf("Read = %d, Available = %d", n, len(out))
+	}
+	return out[int(r.LatencyFrames())+1:]
+}
+
+var resampleRates = [][2]float64{{48000, 44100}, {44100, 48000}, {96000, 44100}}
+
+func TestResamplePassband(t *testing.T) {
+	for q := LowQuality; q <= BestQuality; q++ {
+		edge := 0.8 // of the lower Nyquist
+		if q == LowQuality {
+			edge = 0.7
+		}
+		for _, rates := range resampleRates {
+			nyquist := min(rates[0], rates[1]) / 2
+			worst := 0.0
+			for _, f := range []float64{20, 1000, 0.3 * nyquist, 0.5 * nyquist, 0.65 * nyquist, edge * nyquist} {
+				out := resampleAll(t, sineFrames(f, rates[0], 12000), rates[0], rates[1], q)
+				gain := 20 * math.Log10(toneAmplitude(out, f, rates[1]))
+				worst = max(worst, math.Abs(gain))
+			}
+			if worst > 0.05 {
+				t.Errorf("quality %d, %v -> %v: passband ripple %.3f dB", q, rates[0], rates[1], worst)
+			}
+		}
+	}
+}
+
+func TestResampleAliasing(t *testing.T) {
+	// Tones the output cannot represent, and where what is left of them lands.
+	tones := []struct{ in, out, tone, alias float64 }{
+		{48000, 44100, 22932, 21168},
+		{48000, 44100, 23760, 20340},
+		{96000, 44100, 26460, 17640},
+		{96000, 44100, 47520, 3420},
+		{44100, 48000, 21609, 22491}, // the image above the input's Nyquist
+	}
+	for q := LowQuality; q <= BestQuality; q++ {
+		limit := -resampleQualities[q].attenuation + 6
+		for _, tone := range tones {
+			y := resampleAll(t, sineFrames(tone.tone, tone.in, 12000), tone.in, tone.out, q)
+			level := 20 * math.Log10(toneAmplitude(y, tone.alias, tone.out))
+			if level > limit {
+				t.Errorf("quality %d, %v -> %v: %.0f Hz leaves %.1f dB at %.0f Hz, want below %.0f dB",
+					q, tone.in, tone.out, tone.tone, level, tone.alias, limit)
+			}
+		}
+	}
+}
+
+func TestResampleStreaming(t *testing.T) {
+	x := sineFrames(997, 48000, 20000)
+	want := resampleAll(t, x, 48000, 44100, HighQuality)
+
+	r, _ := NewResampler(1, 48000, 44100, HighQuality)
+	var got []float32
+	buf := make([]float32, 300)
+	sizes := []int{1, 64, 511, 7, 4096, 33}
+	for off, i := 0, 0; off < len(x); i++ {
+		n := min(sizes[i%len(sizes)], len(x)-off)
+		r.Write([][]float32{x[off : off+n]})
+		off += n
+		for {
+			k := r.Read([][]float32{buf[:sizes[(i+1)%len(sizes)]%300+1]})
+			got = append(got, buf[:k]...)
+			if k == 0 {
+				break
+			}
+		}
+	}
+	got = got[int(r.LatencyFrames())+1:]
+
+	if len(got) != len(want) {
+		t.Fatalf("streamed %d frames, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(float64(got[i]-want[i])) > 1e-6 {
+			t.Fatalf("frame %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResampleLatency(t *testing.T) {
+	for _, rates := range resampleRates {
+		r, _ := NewResampler(1, rates[0], rates[1], MediumQuality)
+		x := make([]float32, 4000)
+		x[1000] = 1
+		r.Write([][]float32{x})
+		y := make([]float32, r.Available())
+		r.Read([][]float32{y})
+
+		peak := 0
+		for i := range y {
+			if math.Abs(float64(y[i])) > math.Abs(float64(y[peak])) {
+				peak = i
+			}
+		}
+		want := 1000*rates[1]/rates[0] + r.LatencyFrames()
+		if math.Abs(float64(peak)-want) > 1 {
+			t.Errorf("%v -> %v: impulse at %d, want %.1f", rates[0], rates[1], peak, want)
+		}
+		if d := r.Latency().Seconds() * rates[1]; math.Abs(d-r.LatencyFrames()) > 0.01 {
+			t.Errorf("Latency = %v", r.Latency())
+		}
+	}
+}
+
+func TestResamplerNoAllocs(t *testing.T) {
+	for _, rates := range [][2]float64{{44100, 48000}, {48000, 44100}, {96000, 44100}} {
+		r, err := NewResampler(2, rates[0], rates[1], MediumQuality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Reserve(256)
+		in := makeFloatBuffers(2, 256)
+		out := makeFloatBuffers(2, 256)
+		if allocs := testing.AllocsPerRun(100, func() {
+			r.Write(in)
+			for r.Read(out) == 256 {
+			}
+		}); allocs != 0 {
+			t.Errorf("%v: %v allocations per buffer", rates, allocs)
+		}
+	}
+}
+
+func TestResampledStream(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.SetSampleRate(44100)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = 0.5 * float32(math.Sin(2*math.Pi*1000*float64(pos+int64(i))/44100))
+		}
+	}
+	s := newTestStream(t, drv)
+
+	out, err := NewResampledOutput(s, 48000, MediumQuality, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := sineFrames(1000, 48000, 48000)
+	for i := range src {
+		src[i] *= 0.5
+	}
+	if n := out.Write([][]float32{src}); n != 48000 {
+		t.Fatalf("Write = %d", n)
+	}
+	in, err := NewResampledInput(s, 48000, MediumQuality, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var played []float32
+	s.AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[0]...) }))
+	for i := 0; i < 100; i++ {
+		drv.Step()
+	}
+	if out.Underruns() != 0 || in.Overruns() != 0 {
+		t.Errorf("%d underruns, %d overruns", out.Underruns(), in.Overruns())
+	}
+	if a := toneAmplitude(played[4096:], 1000, 44100); math.Abs(a-0.5) > 1e-3 {
+		t.Errorf("played amplitude %v", a)
+	}
+
+	captured := make([]float32, in.Available())
+	in.Read([][]float32{captured})
+	if want := 100 * 256 * 48000 / 44100; math.Abs(float64(len(captured)-want)) > 100 {
+		t.Errorf("captured %d frames, want about %d", len(captured), want)
+	}
+	if a := toneAmplitude(captured[4096:], 1000, 48000); math.Abs(a-0.5) > 1e-3 {
+		t.Errorf("captured amplitude %v", a)
+	}
+}
+
+func TestResampledRateChange(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Faults = NewFaultScenario().ChangeRate(3, 44100)
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := NewResampledOutput(s, 48000, LowQuality, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := NewResampledInput(s, 48000, LowQuality, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outLatency, inLatency := out.Latency(), in.Latency()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Read while the driver thread replaces the resamplers.
+	waitFor(t, "the new rate", func() bool { return out.Latency() != outLatency && in.Latency() != inLatency })
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rate no resampler takes keeps the old one.
+	outLatency, inLatency = out.Latency(), in.Latency()
+	b := &Block{
+		In:     [][]float32{make([]float32, 256)},
+		Out:    [][]float32{make([]float32, 256)},
+		Frames: 256,
+	}
+	out.Process(b)
+	in.Process(b)
+	if out.Latency() != outLatency || in.Latency() != inLatency {
+		t.Error("the resamplers changed at rate 0")
+	}
+}
@@ -0,0 +1,61 @@
+package asio
+
+import "sync/atomic"
+
+// A single-producer, single-consumer FIFO of multichannel frames. One goroutine may write while another reads
+// without locking, so it can sit between the driver thread and anything else.
+type frameRing struct {
+	channels int
+	size     int       // capacity in frames
+	buf      []float32 // interleaved frames
+
+	written atomic.Int64 // frames ever written
+	read    atomic.Int64 // frames ever read
+}
+
+func newFrameRing(channels, frames int) *frameRing {
+	return &frameRing{
+		channels: channels,
+		size:     frames,
+		buf:      make([]float32, channels*frames),
+	}
+}
+
+// Returns the number of frames that can be read.
+func (r *frameRing) available() int { return int(r.written.Load() - r.read.Load()) }
+
+// Appends up to len(src[0]) frames, one slice per channel. Returns how many fitted.
+func (r *frameRing) write(src [][]float32) int {
+	w := r.written.Load()
+	n := min(len(src[0]), r.size-int(w-r.read.Load()))
+	for i := 0; i < n; i++ {
+		frame := r.buf[int((w+int64(i))%int64(r.size))*r.channels:]
+		for ch, s := range src {
+			frame[ch] = s[i]
+		}
+	}
+	r.written.Store(w + int64(n))
+	return n
+}
+
+// Removes up to len(dst[0]) frames into dst, one slice per channel. Returns how many there were.
+func (r *frameRing) readInto(dst [][]float32) int {
+	rd := r.read.Load()
+	n := min(len(dst[0]), int(r.written.Load()-rd))
+	for i := 0; i < n; i++ {
+		frame := r.buf[int((rd+int64(i))%int64(r.size))*r.channels:]
+		for ch, d := range dst {
+			d[i] = frame[ch]
+		}
+	}
+	r.read.Store(rd + int64(n))
+	return n
+}
+
+// Drops up to n frames from the read side. Returns how many there were.
+func (r *frameRing) skip(n int) int {
+	rd := r.read.Load()
+	n = min(n, int(r.written.Load()-rd))
+	r.read.Store(rd + int64(n))
+	return n
+}
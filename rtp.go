@@ -0,0 +1,640 @@
+package asio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RTP carries AES67 audio: a 12 byte header (version 2, payload type, sequence number, timestamp and source
+// identifier, big-endian) followed by interleaved big-endian linear PCM. Timestamps count samples of the media
+// clock, which here is the stream's sample position plus an offset announced in the SDP; AES67 devices derive
+// it from PTP instead, so the two only agree when the sample clocks are locked to the same reference.
+const (
+	RTPPort = 5004
+
+	rtpHeaderSize  = 12
+	rtpVersion     = 2 << 6
+	rtpMaxPayload  = 1440 // fits an Ethernet frame with IPv4 and UDP headers
+	rtpPayloadType = 96   // the first dynamic type
+)
+
+// RTPEncoding is an RTP linear PCM payload format.
+type RTPEncoding int
+
+const (
+	RTPL24 RTPEncoding = iota // 24 bit, the AES67 default
+	RTPL16                    // 16 bit
+)
+
+func (e RTPEncoding) String() string {
+	if e == RTPL16 {
+		return "L16"
+	}
+	return "L24"
+}
+
+func (e RTPEncoding) sampleType() SampleType {
+	if e == RTPL16 {
+		return ASIOSTInt16MSB
+	}
+	return ASIOSTInt24MSB
+}
+
+// Returns the number of frames in a packet of packetTime at sampleRate, checking that it fits.
+func rtpPacketFrames(packetTime time.Duration, sampleRate float64, channels int, e RTPEncoding) (int, error) {
+	frames := int(packetTime.Seconds()*sampleRate + 0.5)
+	if channels <= 0 || frames <= 0 || frames*channels*e.sampleType().BytesPerSample() > rtpMaxPayload {
+		return 0, ErrorInvalidParameter
+	}
+	return frames, nil
+}
+
+// Joins host and a port that defaults to RTPPort.
+func resolveRTPAddr(address string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(RTPPort))
+	}
+	return net.ResolveUDPAddr("udp", address)
+}
+
+// SDP describes an RTP audio session as AES67 devices announce it, with the local clock as the reference.
+type SDP struct {
+	SessionName      string
+	SessionID        uint64
+	Origin           string // address of the sender
+	Address          string // destination address; for a multicast group, receivers join it
+	Port             int
+	PayloadType      int
+	Encoding         RTPEncoding
+	SampleRate       float64
+	Channels         int
+	PacketTime       time.Duration
+	MediaClockOffset uint32 // RTP timestamp at sample position 0
+}
+
+// Formats the description, with CRLF line endings.
+func (d SDP) String() string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\r\n")
+	}
+	connection := d.Address
+	if ip := net.ParseIP(d.Address); ip != nil && ip.IsMulticast() {
+		connection += "/32" // the TTL
+	}
+	ptime := float64(d.PacketTime) / float64(time.Millisecond)
+
+	line("v=0")
+	line("o=- %d %d IN IP4 %s", d.SessionID, d.SessionID, d.Origin)
+	line("s=%s", d.SessionName)
+	line("c=IN IP4 %s", connection)
+	line("t=0 0")
+	line("m=audio %d RTP/AVP %d", d.Port, d.PayloadType)
+	line("a=rtpmap:%d %s/%d/%d", d.PayloadType, d.Encoding, int(d.SampleRate), d.Channels)
+	line("a=ptime:%s", strconv.FormatFloat(ptime, 'f', -1, 64))
+	line("a=ts-refclk:local")
+	line("a=mediaclk:direct=%d", d.MediaClockOffset)
+	line("a=recvonly")
+	return b.String()
+}
+
+// Parses a description of a session with one L16 or L24 audio stream.
+func ParseSDP(text string) (d SDP, err error) {
+	bad := func(line string) (SDP, error) { return SDP{}, fmt.Errorf("SDP: bad line %q", line) }
+	d.PacketTime = time.Millisecond
+	rtpmap := false
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'o':
+			f := strings.Fields(value)
+			if len(f) != 6 {
+				return bad(line)
+			}
+			d.SessionID, _ = strconv.ParseUint(f[1], 10, 64)
+			d.Origin = f[5]
+		case 's':
+			d.SessionName = value
+		case 'c':
+			f := strings.Fields(value)
+			if len(f) != 3 {
+				return bad(line)
+			}
+			d.Address, _, _ = strings.Cut(f[2], "/")
+		case 'm':
+			if _, err := fmt.Sscanf(value, "audio %d RTP/AVP %d", &d.Port, &d.PayloadType); err != nil {
+				return bad(line)
+			}
+		case 'a':
+			attr, v, _ := strings.Cut(value, ":")
+			switch attr {
+			case "rtpmap":
+				var pt, rate int
+				var format string
+				if _, err := fmt.Sscanf(v, "%d %s", &pt, &format); err != nil || pt != d.PayloadType {
+					continue
+				}
+				f := strings.Split(format, "/")
+				if len(f) < 2 || f[0] != "L16" && f[0] != "L24" {
+					return bad(line)
+				}
+				if rate, err = strconv.Atoi(f[1]); err != nil {
+					return bad(line)
+				}
+				d.Encoding, d.SampleRate, d.Channels = RTPL24, float64(rate), 1
+				if f[0] == "L16" {
+					d.Encoding = RTPL16
+				}
+				if len(f) > 2 {
+					if d.Channels, err = strconv.Atoi(f[2]); err != nil {
+						return bad(line)
+					}
+				}
+				rtpmap = true
+			case "ptime":
+				ms, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return bad(line)
+				}
+				d.PacketTime = time.Duration(ms * float64(time.Millisecond))
+			case "mediaclk":
+				offset, ok := strings.CutPrefix(v, "direct=")
+				n, err := strconv.ParseUint(offset, 10, 32)
+				if !ok || err != nil {
+					return bad(line)
+				}
+				d.MediaClockOffset = uint32(n)
+			}
+		}
+	}
+	if !rtpmap || d.Address == "" {
+		return SDP{}, errors.New("SDP: no L16 or L24 audio stream")
+	}
+	return d, nil
+}
+
+// Returns options for a receiver of the session, playing its channels on outputs. Address is the session's
+// destination, which suits multicast; a unicast receiver listens on its own address instead.
+func (d SDP) ReceiverOptions(outputs ...int) RTPReceiverOptions {
+	return RTPReceiverOptions{
+		Address:          net.JoinHostPort(d.Address, strconv.Itoa(d.Port)),
+		Encoding:         d.Encoding,
+		Channels:         d.Channels,
+		PayloadType:      d.PayloadType,
+		MediaClockOffset: d.MediaClockOffset,
+		Outputs:          outputs,
+	}
+}
+
+// RTPSenderOptions configure an RTPSender.
+type RTPSenderOptions struct {
+	Address          string        // host:port to send to, usually a multicast group; the port defaults to RTPPort
+	SessionName      string        // for the SDP; defaults to the driver's name
+	Encoding         RTPEncoding   // defaults to RTPL24
+	PacketTime       time.Duration // defaults to 1ms
+	PayloadType      int           // defaults to 96
+	MediaClockOffset uint32        // added to the sample position to make RTP timestamps
+	Inputs           []int         // indexes into the stream's Inputs, in the order they are sent
+}
+
+// RTPSender sends inputs of a stream as an AES67-style RTP stream, timestamped by the sample position. The
+// driver thread packs the inputs into packets from a pool; a goroutine of the sender's own sends them. If that
+// falls behind and the pool runs out, the rest of the buffer is dropped and Overruns counts it.
+type RTPSender struct {
+	stream *Stream
+	conn   *net.UDPConn
+	addr   *net.UDPAddr
+	inputs []int
+	opts   RTPSenderOptions
+	frames int // per packet
+	ssrc   uint32
+	sdp    SDP
+
+	// Only touched on the driver thread:
+	cur  *rtpPacket
+	next int64 // sample position the current packet continues at
+
+	full chan *rtpPacket
+	free chan *rtpPacket
+	stop chan struct{}
+	wg   sync.WaitGroup
+	err  error
+
+	sent     atomic.Int64
+	overruns atomic.Int64
+}
+
+type rtpPacket struct {
+	timestamp uint32
+	n         int       // frames filled
+	samples   []float32 // interleaved
+	data      []byte
+}
+
+// Creates a sender and adds it to the stream's taps.
+func NewRTPSender(s *Stream, opts RTPSenderOptions) (*RTPSender, error) {
+	if opts.SessionName == "" {
+		opts.SessionName = s.Driver().GetDriverName()
+	}
+	if opts.PacketTime <= 0 {
+		opts.PacketTime = time.Millisecond
+	}
+	if opts.PayloadType == 0 {
+		opts.PayloadType = rtpPayloadType
+	}
+	for _, ch := range opts.Inputs {
+		if ch < 0 || ch >= len(s.Inputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	frames, err := rtpPacketFrames(opts.PacketTime, s.SampleRate(), len(opts.Inputs), opts.Encoding)
+	if err != nil || opts.PayloadType < 0 || opts.PayloadType > 127 {
+		return nil, ErrorInvalidParameter
+	}
+	addr, err := resolveRTPAddr(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	snd := &RTPSender{
+		stream: s,
+		conn:   conn,
+		addr:   addr,
+		inputs: opts.Inputs,
+		opts:   opts,
+		frames: frames,
+		ssrc:   rand.Uint32(),
+		stop:   make(chan struct{}),
+	}
+	snd.sdp = SDP{
+		SessionName:      opts.SessionName,
+		SessionID:        uint64(snd.ssrc),
+		Origin:           outboundIP(addr),
+		Address:          addr.IP.String(),
+		Port:             addr.Port,
+		PayloadType:      opts.PayloadType,
+		Encoding:         opts.Encoding,
+		SampleRate:       s.SampleRate(),
+		Channels:         len(opts.Inputs),
+		PacketTime:       time.Duration(float64(frames) / s.SampleRate() * float64(time.Second)),
+		MediaClockOffset: opts.MediaClockOffset,
+	}
+
+	// Room for a tenth of a second, and at least a few buffers.
+	count := max(int(s.SampleRate())/10, 4*s.BufferSize()) / frames
+	snd.full = make(chan *rtpPacket, count)
+	snd.free = make(chan *rtpPacket, count)
+	size := len(opts.Inputs) * frames
+	for i := 0; i < count; i++ {
+		snd.free <- &rtpPacket{
+			samples: make([]float32, size),
+			data:    make([]byte, rtpHeaderSize+size*opts.Encoding.sampleType().BytesPerSample()),
+		}
+	}
+
+	snd.wg.Add(1)
+	go snd.send()
+	s.AddTap(snd)
+	return snd, nil
+}
+
+// Returns the local address packets to addr would be sent from, for the SDP's origin.
+func outboundIP(addr *net.UDPAddr) string {
+	if conn, err := net.DialUDP("udp", nil, addr); err == nil {
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP.String()
+	}
+	return "0.0.0.0"
+}
+
+// Returns the session description to announce to receivers.
+func (snd *RTPSender) SDP() SDP { return snd.sdp }
+
+func (snd *RTPSender) Process(b *Block) {
+	if snd.cur != nil && b.SamplePosition != snd.next {
+		// The sample position jumped; drop the packet it interrupted.
+		snd.free <- snd.cur
+		snd.cur = nil
+	}
+	snd.next = b.SamplePosition + int64(b.Frames)
+
+	channels := len(snd.inputs)
+	for i := 0; i < b.Frames; {
+		if snd.cur == nil {
+			select {
+			case snd.cur = <-snd.free:
+			default:
+				snd.overruns.Add(1)
+				return
+			}
+			snd.cur.n = 0
+			snd.cur.timestamp = uint32(b.SamplePosition+int64(i)) + snd.opts.MediaClockOffset
+		}
+		p := snd.cur
+		take := min(snd.frames-p.n, b.Frames-i)
+		for ch, input := range snd.inputs {
+			for j, x := range b.In[input][i : i+take] {
+				p.samples[(p.n+j)*channels+ch] = x
+			}
+		}
+		p.n += take
+		i += take
+		if p.n == snd.frames {
+			snd.full <- p
+			snd.cur = nil
+		}
+	}
+}
+
+func (snd *RTPSender) send() {
+	defer snd.wg.Done()
+
+	st := snd.opts.Encoding.sampleType()
+	var seq uint16
+	put := func(p *rtpPacket) {
+		h := p.data[:rtpHeaderSize]
+		h[0] = rtpVersion
+		h[1] = byte(snd.opts.PayloadType)
+		binary.BigEndian.PutUint16(h[2:], seq)
+		binary.BigEndian.PutUint32(h[4:], p.timestamp)
+		binary.BigEndian.PutUint32(h[8:], snd.ssrc)
+		encodeSamples(p.data[rtpHeaderSize:], p.samples, st)
+		if _, err := snd.conn.WriteToUDP(p.data, snd.addr); err != nil {
+			if snd.err == nil {
+				snd.err = err
+			}
+		} else {
+			snd.sent.Add(1)
+		}
+		seq++
+		snd.free <- p
+	}
+	for {
+		select {
+		case p := <-snd.full:
+			put(p)
+		case <-snd.stop:
+			for {
+				select {
+				case p := <-snd.full:
+					put(p)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Returns the number of packets sent.
+func (snd *RTPSender) Sent() int64 { return snd.sent.Load() }
+
+// Returns how many buffers were cut short because packets could not be sent fast enough.
+func (snd *RTPSender) Overruns() int64 { return snd.overruns.Load() }
+
+// Stops sending, after sending what is queued. It returns the first error sending a packet, if any.
+func (snd *RTPSender) Close() error {
+	snd.stream.RemoveTap(snd)
+	close(snd.stop)
+	snd.wg.Wait()
+	snd.conn.Close()
+	return snd.err
+}
+
+// RTPReceiverOptions configure an RTPReceiver.
+type RTPReceiverOptions struct {
+	Address          string        // host:port to listen on; a multicast group is joined, and ":0" picks a port
+	Interface        string        // the network interface to join a multicast group on; empty lets the system choose
+	Encoding         RTPEncoding   // defaults to RTPL24
+	Channels         int           // in the RTP stream; defaults to len(Outputs)
+	PayloadType      int           // defaults to 96
+	MediaClockOffset uint32        // RTP timestamp at sample position 0
+	LinkOffset       time.Duration // from a packet's timestamp to when it plays; defaults to 10ms
+	Outputs          []int         // indexes into the stream's Outputs; channel i of the RTP stream plays on Outputs[i]
+}
+
+// RTPReceiver plays an RTP stream to outputs of a stream, mixing it into whatever else is playing. Each sample
+// plays exactly the link offset after its timestamp, taking the stream's sample position plus MediaClockOffset
+// as the media clock, so the link offset must cover the sender's buffer, the network and a buffer of the
+// receiver's. Samples that never arrive play as silence.
+//
+// If the clocks do not agree after all, packets keep arriving outside the playout buffer; the receiver then
+// re-anchors so that the latest packet plays the link offset after it arrived.
+type RTPReceiver struct {
+	stream     *Stream
+	conn       *net.UDPConn
+	opts       RTPReceiverOptions
+	linkOffset int64 // in frames
+	margin     int64 // frames before the read position that are not written any more
+
+	ring    []float32 // interleaved frames by sample position modulo size
+	size    int64
+	written atomic.Int64 // counts writes, so that the driver thread sees them
+	readPos atomic.Int64 // sample position the driver thread reads next
+
+	// Only touched by the receiving goroutine:
+	shift   int64 // from extended RTP timestamp to sample position
+	misses  int   // packets in a row outside the playout buffer
+	nextSeq uint16
+	haveSeq bool
+
+	wg sync.WaitGroup
+
+	received atomic.Int64
+	lost     atomic.Int64
+	late     atomic.Int64
+}
+
+// Packets in a row outside the playout buffer that make the receiver re-anchor.
+const rtpRelockMisses = 8
+
+// Creates a receiver listening on the options' address, and adds it to the stream's processors.
+func NewRTPReceiver(s *Stream, opts RTPReceiverOptions) (*RTPReceiver, error) {
+	if opts.Channels == 0 {
+		opts.Channels = len(opts.Outputs)
+	}
+	if opts.PayloadType == 0 {
+		opts.PayloadType = rtpPayloadType
+	}
+	if opts.LinkOffset <= 0 {
+		opts.LinkOffset = 10 * time.Millisecond
+	}
+	if len(opts.Outputs) == 0 || opts.Channels <= 0 {
+		return nil, ErrorInvalidParameter
+	}
+	for _, ch := range opts.Outputs {
+		if ch < 0 || ch >= len(s.Outputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	addr, err := resolveRTPAddr(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		var ifi *net.Interface
+		if opts.Interface != "" {
+			if ifi, err = net.InterfaceByName(opts.Interface); err != nil {
+				return nil, err
+			}
+		}
+		conn, err = net.ListenMulticastUDP("udp", ifi, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	linkOffset := int64(opts.LinkOffset.Seconds()*s.SampleRate() + 0.5)
+	size := int64(s.SampleRate()) + linkOffset
+	r := &RTPReceiver{
+		stream:     s,
+		conn:       conn,
+		opts:       opts,
+		linkOffset: linkOffset,
+		margin:     int64(s.BufferSize()),
+		ring:       make([]float32, size*int64(len(opts.Outputs))),
+		size:       size,
+		shift:      linkOffset - int64(opts.MediaClockOffset),
+	}
+	r.readPos.Store(s.Position())
+
+	r.wg.Add(1)
+	go r.receive()
+	s.AddProcessor(r)
+	return r, nil
+}
+
+// Returns the address the receiver listens on.
+func (r *RTPReceiver) Addr() net.Addr { return r.conn.LocalAddr() }
+
+func (r *RTPReceiver) receive() {
+	defer r.wg.Done()
+
+	st := r.opts.Encoding.sampleType()
+	frameBytes := r.opts.Channels * st.BytesPerSample()
+	packet := make([]byte, rtpHeaderSize+rtpMaxPayload+64)
+	samples := make([]float32, len(packet)/st.BytesPerSample())
+
+	for {
+		n, _, err := r.conn.ReadFromUDP(packet)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		p := packet[:n]
+		if n < rtpHeaderSize || p[0]&0xC0 != rtpVersion || int(p[1]&0x7F) != r.opts.PayloadType {
+			continue
+		}
+		// Skip the contributing sources and any extension header.
+		offset := rtpHeaderSize + 4*int(p[0]&0x0F)
+		if p[0]&0x10 != 0 && n >= offset+4 {
+			offset += 4 + 4*int(binary.BigEndian.Uint16(p[offset+2:]))
+		}
+		if offset > n {
+			continue
+		}
+		frames := (n - offset) / frameBytes
+		if frames == 0 {
+			continue
+		}
+		seq := binary.BigEndian.Uint16(p[2:])
+		timestamp := binary.BigEndian.Uint32(p[4:])
+
+		gap := int16(seq - r.nextSeq)
+		if r.haveSeq && gap > 0 {
+			r.lost.Add(int64(gap))
+		}
+		if !r.haveSeq || gap >= 0 || gap < -1000 {
+			// Reordered packets leave the expected sequence number alone.
+			r.nextSeq, r.haveSeq = seq+1, true
+		}
+		r.received.Add(1)
+
+		samples := samples[:frames*r.opts.Channels]
+		decodeSamples(samples, p[offset:], st)
+		r.place(timestamp, frames, samples)
+	}
+}
+
+// Writes a packet's frames where they play.
+func (r *RTPReceiver) place(timestamp uint32, frames int, samples []float32) {
+	read := r.readPos.Load()
+	// Extend the timestamp to 64 bits around the one playing now.
+	expected := read - r.shift
+	ext := expected + int64(int32(timestamp-uint32(expected)))
+	pos := ext + r.shift
+
+	if pos < read+r.margin || pos+int64(frames) > read+r.size {
+		if pos < read+r.margin {
+			r.late.Add(1)
+		}
+		if r.misses++; r.misses < rtpRelockMisses {
+			return
+		}
+		// Anchor on this packet: it arrived at about the read position.
+		r.shift = read + r.linkOffset - ext
+		pos = read + r.linkOffset
+	}
+	r.misses = 0
+
+	channels, outputs := r.opts.Channels, len(r.opts.Outputs)
+	for i := 0; i < frames; i++ {
+		slot := r.ring[(pos+int64(i))%r.size*int64(outputs):]
+		for ch := 0; ch < min(channels, outputs); ch++ {
+			slot[ch] = samples[i*channels+ch]
+		}
+	}
+	r.written.Add(1)
+}
+
+func (r *RTPReceiver) Process(b *Block) {
+	r.written.Load() // see the receiving goroutine's writes
+	outputs := len(r.opts.Outputs)
+	for i := 0; i < b.Frames; i++ {
+		slot := r.ring[(b.SamplePosition+int64(i))%r.size*int64(outputs):]
+		for ch, output := range r.opts.Outputs {
+			b.Out[output][i] += slot[ch]
+			slot[ch] = 0
+		}
+	}
+	r.readPos.Store(b.SamplePosition + int64(b.Frames))
+}
+
+// Returns the number of packets received.
+func (r *RTPReceiver) Received() int64 { return r.received.Load() }
+
+// Returns the number of packets that never arrived, going by the sequence numbers.
+func (r *RTPReceiver) Lost() int64 { return r.lost.Load() }
+
+// Returns the number of packets that arrived too late to play.
+func (r *RTPReceiver) Late() int64 { return r.late.Load() }
+
+// Stops receiving and removes the receiver from the stream.
+func (r *RTPReceiver) Close() error {
+	r.stream.RemoveProcessor(r)
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
@@ -0,0 +1,241 @@
+package asio
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A sender and a receiver over localhost, driven in lockstep so that their sample positions agree.
+type rtpLink struct {
+	txDrv, rxDrv *SimDriver
+	snd          *RTPSender
+	r            *RTPReceiver
+	played       [][]float32
+}
+
+func newRTPLink(t *testing.T, send RTPSenderOptions, recv RTPReceiverOptions) *rtpLink {
+	l := &rtpLink{txDrv: NewSimDriver(2, 0), rxDrv: NewSimDriver(0, 2)}
+	l.txDrv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = vbanRamp(pos + int64(i))
+			if channel == 1 {
+				buf[i] = -buf[i]
+			}
+		}
+	}
+	tx := newTestStream(t, l.txDrv)
+	rx := newTestStream(t, l.rxDrv)
+	l.played = make([][]float32, 2)
+	rx.AddTap(ProcessorFunc(func(b *Block) {
+		for ch := range l.played {
+			l.played[ch] = append(l.played[ch], b.Out[ch]...)
+		}
+	}))
+
+	var err error
+	recv.Address = "127.0.0.1:0"
+	if l.r, err = NewRTPReceiver(rx, recv); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.r.Close() })
+	send.Address = l.r.Addr().String()
+	if l.snd, err = NewRTPSender(tx, send); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.snd.Close() })
+	return l
+}
+
+func (l *rtpLink) step(t *testing.T, buffers int) {
+	t.Helper()
+	for i := 0; i < buffers; i++ {
+		l.txDrv.Step()
+		packets := l.snd.Sent() + int64(256/l.snd.frames)
+		waitFor(t, "the packets", func() bool { return l.r.Received() >= packets })
+		l.rxDrv.Step()
+	}
+}
+
+func TestRTPPlayout(t *testing.T) {
+	for _, encoding := range []RTPEncoding{RTPL24, RTPL16} {
+		// The offset makes the timestamps wrap a few buffers in.
+		const offset = 1<<32 - 1000
+		l := newRTPLink(t,
+			RTPSenderOptions{Encoding: encoding, MediaClockOffset: offset, Inputs: []int{0, 1}},
+			RTPReceiverOptions{Encoding: encoding, MediaClockOffset: offset, LinkOffset: 8 * time.Millisecond, Outputs: []int{1, 0}})
+		l.step(t, 40)
+
+		const link = 384 // 8ms
+		for i, x := range l.played[1] {
+			want := float32(0)
+			if i >= link {
+				want = vbanRamp(int64(i - link))
+			}
+			if x != want || l.played[0][i] != -want {
+				t.Fatalf("%v: frame %d is %v %v, want %v", encoding, i, x, l.played[0][i], want)
+			}
+		}
+		if l.r.Lost() != 0 || l.r.Late() != 0 || l.snd.Overruns() != 0 {
+			t.Errorf("%v: %d lost, %d late, %d overruns", encoding, l.r.Lost(), l.r.Late(), l.snd.Overruns())
+		}
+	}
+}
+
+func TestRTPReanchor(t *testing.T) {
+	// The receiver's idea of the media clock is a second off, so every packet misses the playout buffer
+	// until it re-anchors.
+	l := newRTPLink(t,
+		RTPSenderOptions{MediaClockOffset: 48000, Inputs: []int{0}},
+		RTPReceiverOptions{Outputs: []int{0}})
+	l.step(t, 20)
+
+	if late := l.r.Late(); late != 0 {
+		t.Errorf("%d late", late)
+	}
+	start := 0
+	for start < len(l.played[0]) && l.played[0][start] == 0 {
+		start++
+	}
+	if start > 2*256+480 {
+		t.Fatalf("nothing played for %d frames", start)
+	}
+	delay := int64(start) - int64((l.played[0][start]+0.5)*4096)
+	for i := start; i < len(l.played[0]); i++ {
+		if want := vbanRamp(int64(i) - delay); l.played[0][i] != want {
+			t.Fatalf("frame %d is %v, want %v", i, l.played[0][i], want)
+		}
+	}
+}
+
+// Builds a mono L16 packet of 48 frames at level v.
+func rtpTestPacket(seq uint16, timestamp uint32, v float32) []byte {
+	p := make([]byte, rtpHeaderSize+2*48)
+	p[0], p[1] = rtpVersion, rtpPayloadType
+	binary.BigEndian.PutUint16(p[2:], seq)
+	binary.BigEndian.PutUint32(p[4:], timestamp)
+	binary.BigEndian.PutUint32(p[8:], 1234)
+	for i := 0; i < 48; i++ {
+		binary.BigEndian.PutUint16(p[rtpHeaderSize+2*i:], uint16(int16(v*(1<<15))))
+	}
+	return p
+}
+
+func TestRTPLossAndLate(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+	var played []float32
+	s.AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[0]...) }))
+	r, err := NewRTPReceiver(s, RTPReceiverOptions{Address: "127.0.0.1:0", Encoding: RTPL16, Outputs: []int{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := 0; i < 4; i++ {
+		drv.Step()
+	}
+
+	conn, err := net.DialUDP("udp", nil, r.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Position 1024 plays next, and packets for it have to be in before the buffer after it. With the 10ms
+	// link offset, timestamp 800 plays at 1280.
+	for _, p := range [][]byte{
+		rtpTestPacket(10, 800, 0.25),
+		rtpTestPacket(11, 848, 0.5),
+		rtpTestPacket(14, 992, 0.75), // 12 and 13 lost
+		rtpTestPacket(15, 700, 1),    // due at 1180, too late
+	} {
+		if _, err := conn.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the packets", func() bool { return r.Received() == 4 })
+	if r.Lost() != 2 || r.Late() != 1 {
+		t.Errorf("%d lost and %d late, want 2 and 1", r.Lost(), r.Late())
+	}
+
+	drv.Step()
+	drv.Step()
+	for i, x := range played[1024:] {
+		pos := 1024 + i
+		want := float32(0)
+		switch {
+		case pos >= 1280 && pos < 1328:
+			want = 0.25
+		case pos >= 1328 && pos < 1376:
+			want = 0.5
+		case pos >= 1472 && pos < 1520:
+			want = 0.75
+		}
+		if x != want {
+			t.Fatalf("frame %d is %v, want %v", pos, x, want)
+		}
+	}
+}
+
+func TestSDP(t *testing.T) {
+	d := SDP{
+		SessionName:      "Stage box",
+		SessionID:        42,
+		Origin:           "192.168.1.10",
+		Address:          "239.69.1.2",
+		Port:             5004,
+		PayloadType:      97,
+		Encoding:         RTPL24,
+		SampleRate:       48000,
+		Channels:         8,
+		PacketTime:       time.Millisecond / 8,
+		MediaClockOffset: 123456,
+	}
+	text := d.String()
+	for _, line := range []string{
+		"o=- 42 42 IN IP4 192.168.1.10\r\n",
+		"c=IN IP4 239.69.1.2/32\r\n",
+		"m=audio 5004 RTP/AVP 97\r\n",
+		"a=rtpmap:97 L24/48000/8\r\n",
+		"a=ptime:0.125\r\n",
+		"a=mediaclk:direct=123456\r\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("no %q in\n%s", line, text)
+		}
+	}
+	parsed, err := ParseSDP(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != d {
+		t.Errorf("parsed %+v, want %+v", parsed, d)
+	}
+	opts := parsed.ReceiverOptions(0, 1)
+	if opts.Address != "239.69.1.2:5004" || opts.Channels != 8 || opts.PayloadType != 97 || opts.MediaClockOffset != 123456 {
+		t.Errorf("receiver options %+v", opts)
+	}
+
+	if _, err := ParseSDP("v=0\r\nm=video 5000 RTP/AVP 96\r\n"); err == nil {
+		t.Error("no audio stream accepted")
+	}
+}
+
+func TestRTPSenderSDP(t *testing.T) {
+	drv := NewSimDriver(2, 0)
+	s := newTestStream(t, drv)
+	snd, err := NewRTPSender(s, RTPSenderOptions{Address: "127.0.0.1:6000", Encoding: RTPL16, Inputs: []int{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snd.Close()
+	d := snd.SDP()
+	if d.Address != "127.0.0.1" || d.Port != 6000 || d.Channels != 2 || d.PacketTime != time.Millisecond ||
+		d.Encoding != RTPL16 || d.SampleRate != 48000 || d.SessionName != drv.Name {
+		t.Errorf("SDP %+v", d)
+	}
+	if _, err := NewRTPSender(s, RTPSenderOptions{Address: "127.0.0.1", PacketTime: 20 * time.Millisecond, Inputs: []int{0, 1}}); err == nil {
+		t.Error("oversized packets accepted")
+	}
+}
@@ -0,0 +1,272 @@
+package asio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// Returns the number of bytes one sample occupies in a driver buffer. For the DSD types ASIO counts buffer
+// sizes in bytes, so a "sample" is one byte holding eight 1-bit samples (or one 8-bit sample for NER8).
+func (st SampleType) BytesPerSample() int {
+	switch st {
+	case ASIOSTInt16MSB, ASIOSTInt16LSB:
+		return 2
+	case ASIOSTInt24MSB, ASIOSTInt24LSB:
+		return 3
+	case ASIOSTInt32MSB, ASIOSTInt32LSB, ASIOSTFloat32MSB, ASIOSTFloat32LSB,
+		ASIOSTInt32MSB16, ASIOSTInt32MSB18, ASIOSTInt32MSB20, ASIOSTInt32MSB24,
+		ASIOSTInt32LSB16, ASIOSTInt32LSB18, ASIOSTInt32LSB20, ASIOSTInt32LSB24:
+		return 4
+	case ASIOSTFloat64MSB, ASIOSTFloat64LSB:
+		return 8
+	case ASIOSTDSDInt8LSB1, ASIOSTDSDInt8MSB1, ASIOSTDSDInt8NER8:
+		return 1
+	}
+	return 0
+}
+
+// Returns the number of significant bits in one sample, e.g. 24 for ASIOSTInt32LSB24.
+func (st SampleType) BitsPerSample() int {
+	switch st {
+	case ASIOSTInt16MSB, ASIOSTInt16LSB:
+		return 16
+	case ASIOSTInt24MSB, ASIOSTInt24LSB:
+		return 24
+	case ASIOSTInt32MSB, ASIOSTInt32LSB, ASIOSTFloat32MSB, ASIOSTFloat32LSB:
+		return 32
+	case ASIOSTFloat64MSB, ASIOSTFloat64LSB:
+		return 64
+	case ASIOSTDSDInt8LSB1, ASIOSTDSDInt8MSB1:
+		return 1
+	case ASIOSTDSDInt8NER8:
+		return 8
+	}
+	return int(alignedBits(st))
+}
+
+func (st SampleType) IsFloat() bool {
+	switch st {
+	case ASIOSTFloat32MSB, ASIOSTFloat64MSB, ASIOSTFloat32LSB, ASIOSTFloat64LSB:
+		return true
+	}
+	return false
+}
+
+func (st SampleType) IsBigEndian() bool {
+	return st >= ASIOSTInt16MSB && st <= ASIOSTInt32MSB24
+}
+
+// Reports whether st is one of the DSD types.
+func (st SampleType) IsDSD() bool {
+	switch st {
+	case ASIOSTDSDInt8LSB1, ASIOSTDSDInt8MSB1, ASIOSTDSDInt8NER8:
+		return true
+	}
+	return false
+}
+
+var sampleTypeNames = map[SampleType]string{
+	ASIOSTInt16MSB:    "ASIOSTInt16MSB",
+	ASIOSTInt24MSB:    "ASIOSTInt24MSB",
+	ASIOSTInt32MSB:    "ASIOSTInt32MSB",
+	ASIOSTFloat32MSB:  "ASIOSTFloat32MSB",
+	ASIOSTFloat64MSB:  "ASIOSTFloat64MSB",
+	ASIOSTInt32MSB16:  "ASIOSTInt32MSB16",
+	ASIOSTInt32MSB18:  "ASIOSTInt32MSB18",
+	ASIOSTInt32MSB20:  "ASIOSTInt32MSB20",
+	ASIOSTInt32MSB24:  "ASIOSTInt32MSB24",
+	ASIOSTInt16LSB:    "ASIOSTInt16LSB",
+	ASIOSTInt24LSB:    "ASIOSTInt24LSB",
+	ASIOSTInt32LSB:    "ASIOSTInt32LSB",
+	ASIOSTFloat32LSB:  "ASIOSTFloat32LSB",
+	ASIOSTFloat64LSB:  "ASIOSTFloat64LSB",
+	ASIOSTInt32LSB16:  "ASIOSTInt32LSB16",
+	ASIOSTInt32LSB18:  "ASIOSTInt32LSB18",
+	ASIOSTInt32LSB20:  "ASIOSTInt32LSB20",
+	ASIOSTInt32LSB24:  "ASIOSTInt32LSB24",
+	ASIOSTDSDInt8LSB1: "ASIOSTDSDInt8LSB1",
+	ASIOSTDSDInt8MSB1: "ASIOSTDSDInt8MSB1",
+	ASIOSTDSDInt8NER8: "ASIOSTDSDInt8NER8",
+}
+
+func (st SampleType) String() string {
+	if name, ok := sampleTypeNames[st]; ok {
+		return name
+	}
+	return fmt.Sprintf("SampleType(%d)", int32(st))
+}
+
+// Returns the number of significant bits of the 32 bit aligned types, or 0 for other types.
+func alignedBits(st SampleType) uint {
+	switch st {
+	case ASIOSTInt32MSB16, ASIOSTInt32LSB16:
+		return 16
+	case ASIOSTInt32MSB18, ASIOSTInt32LSB18:
+		return 18
+	case ASIOSTInt32MSB20, ASIOSTInt32LSB20:
+		return 20
+	case ASIOSTInt32MSB24, ASIOSTInt32LSB24:
+		return 24
+	}
+	return 0
+}
+
+// Views a driver buffer as bytes.
+func bufferBytes(p *int32, size int) []byte {
+	if p == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), size)
+}
+
+// Converts len(dst) samples of type st from the driver buffer src to float32 in [-1, 1).
+// Non-PCM types decode as silence.
+func decodeSamples(dst []float32, src []byte, st SampleType) {
+	switch st {
+	case ASIOSTInt16LSB:
+		for i := range dst {
+			dst[i] = float32(int16(binary.LittleEndian.Uint16(src[2*i:]))) / (1 << 15)
+		}
+	case ASIOSTInt16MSB:
+		for i := range dst {
+			dst[i] = float32(int16(binary.BigEndian.Uint16(src[2*i:]))) / (1 << 15)
+		}
+	case ASIOSTInt24LSB:
+		for i := range dst {
+			b := src[3*i : 3*i+3]
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			dst[i] = float32(v) / (1 << 23)
+		}
+	case ASIOSTInt24MSB:
+		for i := range dst {
+			b := src[3*i : 3*i+3]
+			v := int32(uint32(b[2])<<8|uint32(b[1])<<16|uint32(b[0])<<24) >> 8
+			dst[i] = float32(v) / (1 << 23)
+		}
+	case ASIOSTInt32LSB:
+		for i := range dst {
+			dst[i] = float32(float64(int32(binary.LittleEndian.Uint32(src[4*i:]))) / (1 << 31))
+		}
+	case ASIOSTInt32MSB:
+		for i := range dst {
+			dst[i] = float32(float64(int32(binary.BigEndian.Uint32(src[4*i:]))) / (1 << 31))
+		}
+	case ASIOSTFloat32LSB:
+		for i := range dst {
+			dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(src[4*i:]))
+		}
+	case ASIOSTFloat32MSB:
+		for i := range dst {
+			dst[i] = math.Float32frombits(binary.BigEndian.Uint32(src[4*i:]))
+		}
+	case ASIOSTFloat64LSB:
+		for i := range dst {
+			dst[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(src[8*i:])))
+		}
+	case ASIOSTFloat64MSB:
+		for i := range dst {
+			dst[i] = float32(math.Float64frombits(binary.BigEndian.Uint64(src[8*i:])))
+		}
+	case ASIOSTInt32LSB16, ASIOSTInt32LSB18, ASIOSTInt32LSB20, ASIOSTInt32LSB24:
+		bits := alignedBits(st)
+		scale := float32(int32(1) << (bits - 1))
+		for i := range dst {
+			v := int32(binary.LittleEndian.Uint32(src[4*i:])<<(32-bits)) >> (32 - bits)
+			dst[i] = float32(v) / scale
+		}
+	case ASIOSTInt32MSB16, ASIOSTInt32MSB18, ASIOSTInt32MSB20, ASIOSTInt32MSB24:
+		bits := alignedBits(st)
+		scale := float32(int32(1) << (bits - 1))
+		for i := range dst {
+			v := int32(binary.BigEndian.Uint32(src[4*i:])<<(32-bits)) >> (32 - bits)
+			dst[i] = float32(v) / scale
+		}
+	default:
+		for i := range dst {
+			dst[i] = 0
+		}
+	}
+}
+
+// Scales x by 2^(bits-1) and rounds it to the nearest integer that fits in bits.
+func quantize(x float64, bits uint) int32 {
+	scale := float64(int64(1) << (bits - 1))
+	v := math.Floor(x*scale + 0.5)
+	if v > scale-1 {
+		v = scale - 1
+	} else if v < -scale {
+		v = -scale
+	}
+	return int32(v)
+}
+
+// Converts the float32 samples in src to type st in the driver buffer dst, clipping at full scale.
+// Non-PCM types are left untouched.
+func encodeSamples(dst []byte, src []float32, st SampleType) {
+	encodeDithered(dst, src, st, nil)
+}
+
+// Like encodeSamples, quantizing integer types with d if it is not nil.
+func encodeDithered(dst []byte, src []float32, st SampleType, d *ditherer) {
+	q := quantize
+	if d != nil {
+		q = d.quantize
+	}
+
+	switch st {
+	case ASIOSTInt16LSB:
+		for i, x := range src {
+			binary.LittleEndian.PutUint16(dst[2*i:], uint16(q(float64(x), 16)))
+		}
+	case ASIOSTInt16MSB:
+		for i, x := range src {
+			binary.BigEndian.PutUint16(dst[2*i:], uint16(q(float64(x), 16)))
+		}
+	case ASIOSTInt24LSB:
+		for i, x := range src {
+			v := q(float64(x), 24)
+			dst[3*i], dst[3*i+1], dst[3*i+2] = byte(v), byte(v>>8), byte(v>>16)
+		}
+	case ASIOSTInt24MSB:
+		for i, x := range src {
+			v := q(float64(x), 24)
+			dst[3*i], dst[3*i+1], dst[3*i+2] = byte(v>>16), byte(v>>8), byte(v)
+		}
+	case ASIOSTInt32LSB:
+		for i, x := range src {
+			binary.LittleEndian.PutUint32(dst[4*i:], uint32(q(float64(x), 32)))
+		}
+	case ASIOSTInt32MSB:
+		for i, x := range src {
+			binary.BigEndian.PutUint32(dst[4*i:], uint32(q(float64(x), 32)))
+		}
+	case ASIOSTFloat32LSB:
+		for i, x := range src {
+			binary.LittleEndian.PutUint32(dst[4*i:], math.Float32bits(x))
+		}
+	case ASIOSTFloat32MSB:
+		for i, x := range src {
+			binary.BigEndian.PutUint32(dst[4*i:], math.Float32bits(x))
+		}
+	case ASIOSTFloat64LSB:
+		for i, x := range src {
+			binary.LittleEndian.PutUint64(dst[8*i:], math.Float64bits(float64(x)))
+		}
+	case ASIOSTFloat64MSB:
+		for i, x := range src {
+			binary.BigEndian.PutUint64(dst[8*i:], math.Float64bits(float64(x)))
+		}
+	case ASIOSTInt32LSB16, ASIOSTInt32LSB18, ASIOSTInt32LSB20, ASIOSTInt32LSB24:
+		bits := alignedBits(st)
+		for i, x := range src {
+			binary.LittleEndian.PutUint32(dst[4*i:], uint32(q(float64(x), bits)))
+		}
+	case ASIOSTInt32MSB16, ASIOSTInt32MSB18, ASIOSTInt32MSB20, ASIOSTInt32MSB24:
+		bits := alignedBits(st)
+		for i, x := range src {
+			binary.BigEndian.PutUint32(dst[4*i:], uint32(q(float64(x), bits)))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package asio
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An EventFunc runs on the driver thread during the buffer its event falls in. offset is the event's frame
+// within the Block, so that it can take effect from exactly that sample. It must not block.
+type EventFunc func(b *Block, offset int)
+
+// An Event is a scheduled EventFunc.
+type Event struct {
+	Position int64 // sample position the event is due at
+
+	fn        EventFunc
+	seq       uint64 // orders events at the same position by when they were scheduled
+	cancelled atomic.Bool
+	next      *Event // in the scheduler's inbox
+}
+
+// Stops the event from running if it has not run yet.
+func (e *Event) Cancel() { e.cancelled.Store(true) }
+
+// A LateEvent is reported for an event that was scheduled for a buffer that had already been processed.
+type LateEvent struct {
+	Event    *Event
+	Position int64 // the first sample position it could still have run at
+}
+
+// Returns how many frames too late the event was scheduled.
+func (l LateEvent) Frames() int64 { return l.Position - l.Event.Position }
+
+// Scheduler runs events at exact sample positions of a stream. Events may be scheduled from any goroutine,
+// either at a sample position or at a time, which is converted to a sample position through the driver's own
+// mapping of sample positions to system time. An event due before the buffer being processed when it is first
+// seen is late: it does not run, and is counted and reported on LateEvents instead.
+type Scheduler struct {
+	stream *Stream
+	inbox  atomic.Pointer[Event] // newest first
+	seq    atomic.Uint64
+	late   atomic.Int64
+	lates  chan LateEvent
+
+	// Only touched on the driver thread:
+	queue eventQueue
+	clock clockEstimator
+
+	mu      sync.Mutex // guards mapping; the driver thread only ever tries to take it
+	mapping timeMapping
+}
+
+// How the driver's sample positions relate to its system time and to the wall clock, as of one buffer switch.
+type timeMapping struct {
+	valid      bool
+	position   int64
+	systemTime int64   // nanoseconds on the driver's clock
+	rate       float64 // samples per second of system time
+	wallOffset int64   // wall clock minus system time, in nanoseconds
+}
+
+// Creates a scheduler and adds it to the stream's processors. Add it before any processor its events affect.
+func NewScheduler(s *Stream) *Scheduler {
+	sc := &Scheduler{
+		stream: s,
+		lates:  make(chan LateEvent, 64),
+		queue:  make(eventQueue, 0, 64),
+	}
+	s.AddProcessor(sc)
+	return sc
+}
+
+// Schedules fn to run at sample position pos.
+func (sc *Scheduler) At(pos int64, fn EventFunc) *Event {
+	e := &Event{Position: pos, fn: fn, seq: sc.seq.Add(1)}
+	for {
+		e.next = sc.inbox.Load()
+		if sc.inbox.CompareAndSwap(e.next, e) {
+			return e
+		}
+	}
+}
+
+// Schedules fn to run at the sample due at t on the driver's system clock, in nanoseconds as in
+// ASIOTime.TimeInfo.SystemTime.
+func (sc *Scheduler) AtSystemTime(t int64, fn EventFunc) (*Event, error) {
+	m, err := sc.currentMapping()
+	if err != nil {
+		return nil, err
+	}
+	return sc.At(m.positionAt(t), fn), nil
+}
+
+// Schedules fn to run at the sample that plays or is recorded at wall clock time t. The driver's system clock
+// need not share the wall clock's epoch; their offset is measured at each buffer switch.
+func (sc *Scheduler) AtTime(t time.Time, fn EventFunc) (*Event, error) {
+	m, err := sc.currentMapping()
+	if err != nil {
+		return nil, err
+	}
+	return sc.At(m.positionAt(t.UnixNano()-m.wallOffset), fn), nil
+}
+
+// Returns the sample position due at t on the driver's system clock.
+func (m *timeMapping) positionAt(t int64) int64 {
+	return m.position + int64(math.Round(float64(t-m.systemTime)/1e9*m.rate))
+}
+
+func (sc *Scheduler) currentMapping() (timeMapping, error) {
+	sc.mu.Lock()
+	m := sc.mapping
+	sc.mu.Unlock()
+	if m.valid {
+		return m, nil
+	}
+
+	// No buffer switch yet; ask the driver.
+	pos, sysTime, err := sc.stream.drv.GetSamplePosition()
+	if err != nil {
+		return m, err
+	}
+	return timeMapping{
+		valid:      true,
+		position:   pos,
+		systemTime: sysTime,
+		rate:       sc.stream.SampleRate(),
+		wallOffset: time.Now().UnixNano() - sysTime,
+	}, nil
+}
+
+// Returns how many events were late.
+func (sc *Scheduler) Late() int64 { return sc.late.Load() }
+
+// Returns a channel that receives late events. Reports that find it full are dropped, but still counted by
+// Late.
+func (sc *Scheduler) LateEvents() <-chan LateEvent { return sc.lates }
+
+func (sc *Scheduler) Process(b *Block) {
+	sc.updateMapping(b)
+
+	for e := sc.inbox.Swap(nil); e != nil; {
+		next := e.next
+		e.next = nil
+		heap.Push(&sc.queue, e)
+		e = next
+	}
+
+	end := b.SamplePosition + int64(b.Frames)
+	for len(sc.queue) > 0 && sc.queue[0].Position < end {
+		e := heap.Pop(&sc.queue).(*Event)
+		if e.cancelled.Load() {
+			continue
+		}
+		if e.Position < b.SamplePosition {
+			sc.late.Add(1)
+			select {
+			case sc.lates <- LateEvent{Event: e, Position: b.SamplePosition}:
+			default:
+			}
+			continue
+		}
+		e.fn(b, int(e.Position-b.SamplePosition))
+	}
+}
+
+func (sc *Scheduler) updateMapping(b *Block) {
+	const valid = SystemTimeValid | SamplePositionValid
+	if b.Time == nil || b.Time.TimeInfo.Flags&valid != valid {
+		return
+	}
+	sc.clock.add(b.Time)
+	rate := sc.clock.rate()
+	if rate == 0 {
+		rate = b.SampleRate
+	}
+
+	// The callback runs a little after its system time, never before, so the smallest offset is the best
+	// estimate. Let it rise slowly in case either clock is adjusted.
+	offset := time.Now().UnixNano() - b.Time.TimeInfo.SystemTime
+
+	if !sc.mu.TryLock() {
+		return
+	}
+	m := &sc.mapping
+	if !m.valid || offset < m.wallOffset {
+		m.wallOffset = offset
+	} else {
+		m.wallOffset += (offset - m.wallOffset) / 256
+	}
+	m.valid = true
+	m.position = b.Time.TimeInfo.SamplePosition
+	m.systemTime = b.Time.TimeInfo.SystemTime
+	m.rate = rate
+	sc.mu.Unlock()
+}
+
+// A min-heap of events by position, then by scheduling order.
+type eventQueue []*Event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].Position != q[j].Position {
+		return q[i].Position < q[j].Position
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*Event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
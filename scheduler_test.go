@@ -0,0 +1,169 @@
+package asio
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Returns a stream with a scheduler, and everything its first output plays.
+func newEventStream(t *testing.T, drv *SimDriver) (*Stream, *Scheduler, *[]float32) {
+	s := newTestStream(t, drv)
+	sc := NewScheduler(s)
+	var played []float32
+	s.AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[0]...) }))
+	return s, sc, &played
+}
+
+func impulse(v float32) EventFunc {
+	return func(b *Block, offset int) { b.Out[0][offset] += v }
+}
+
+func TestSchedulerOffsets(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	_, sc, played := newEventStream(t, drv)
+
+	positions := []int64{0, 100, 255, 256, 1000, 4095}
+	for i, pos := range positions {
+		sc.At(pos, impulse(float32(i+1)))
+	}
+	sc.At(5000, impulse(100)).Cancel()
+	for i := 0; i < 20; i++ {
+		drv.Step()
+	}
+
+	want := make([]float32, len(*played))
+	for i, pos := range positions {
+		want[pos] = float32(i + 1)
+	}
+	for i := range want {
+		if (*played)[i] != want[i] {
+			t.Errorf("sample %d = %v, want %v", i, (*played)[i], want[i])
+		}
+	}
+	if sc.Late() != 0 {
+		t.Errorf("%d late", sc.Late())
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s, sc, _ := newEventStream(t, drv)
+
+	var order []int
+	for i := 0; i < 5; i++ {
+		sc.At(300, func(b *Block, offset int) { order = append(order, i) })
+	}
+	sc.At(299, func(b *Block, offset int) { order = append(order, -1) })
+	drv.Step()
+	drv.Step()
+	if want := []int{-1, 0, 1, 2, 3, 4}; len(order) != len(want) {
+		t.Fatalf("order %v, want %v", order, want)
+	} else {
+		for i := range want {
+			if order[i] != want[i] {
+				t.Fatalf("order %v, want %v", order, want)
+			}
+		}
+	}
+	if s.Position() != 512 {
+		t.Errorf("Position = %d", s.Position())
+	}
+}
+
+func TestSchedulerLate(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	_, sc, played := newEventStream(t, drv)
+	for i := 0; i < 4; i++ {
+		drv.Step()
+	}
+
+	e := sc.At(700, impulse(1))
+	sc.At(1024, impulse(2))
+	drv.Step()
+
+	if sc.Late() != 1 {
+		t.Fatalf("%d late, want 1", sc.Late())
+	}
+	select {
+	case l := <-sc.LateEvents():
+		if l.Event != e || l.Position != 1024 || l.Frames() != 324 {
+			t.Errorf("late event at %d, %d frames late", l.Position, l.Frames())
+		}
+	default:
+		t.Error("no late event reported")
+	}
+	for i, x := range *played {
+		want := float32(0)
+		if i == 1024 {
+			want = 2
+		}
+		if x != want {
+			t.Errorf("sample %d = %v, want %v", i, x, want)
+		}
+	}
+}
+
+func TestSchedulerSystemTime(t *testing.T) {
+	for _, ppm := range []float64{0, 150, -200} {
+		drv := NewSimDriver(0, 1)
+		drv.ClockPPM = ppm
+		_, sc, played := newEventStream(t, drv)
+		for i := 0; i < 40; i++ {
+			drv.Step()
+		}
+
+		// Two seconds after the start on the driver's clock.
+		target := drv.started.UnixNano() + 2e9
+		if _, err := sc.AtSystemTime(target, impulse(1)); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 400; i++ {
+			drv.Step()
+		}
+
+		want := int(math.Round(2 * 48000 * (1 + ppm/1e6)))
+		got := -1
+		for i, x := range *played {
+			if x != 0 {
+				got = i
+			}
+		}
+		if got != want {
+			t.Errorf("ClockPPM %v: event at %d, want %d", ppm, got, want)
+		}
+	}
+}
+
+func TestSchedulerTime(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sc := NewScheduler(s)
+	ran := make(chan int64, 1)
+
+	if _, err := sc.AtTime(time.Now(), impulse(1)); err != ErrorSPNotAdvancing {
+		t.Errorf("AtTime before Start: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	at := time.Now().Add(100 * time.Millisecond)
+	if _, err := sc.AtTime(at, func(b *Block, offset int) { ran <- b.SamplePosition + int64(offset) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pos := <-ran:
+		want := at.Sub(drv.started).Seconds() * 48000
+		if math.Abs(float64(pos)-want) > 48000*0.02 {
+			t.Errorf("event at %d, want about %.0f", pos, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event did not run")
+	}
+}
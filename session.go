@@ -0,0 +1,760 @@
+package asio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionConfig describes a session: the driver, how it runs, the channels its stream opens, the routing
+// between them and what to record. LoadSession reads one from JSON:
+//
+//	{
+//	  "driver": "ASIO4ALL v2",
+//	  "sampleRate": 48000,
+//	  "bufferSize": "preferred",
+//	  "clockSource": "Internal",
+//	  "inputs": ["In 1", "In 2"],
+//	  "outputs": [0, 1],
+//	  "routes": [{"in": "In 1", "out": 0, "gain": -6}, {"in": "In 2", "out": 1, "mute": true}],
+//	  "outputGains": [{"out": 1, "gain": -3}],
+//	  "recorders": [{"path": "take.wav", "inputs": ["In 1", "In 2"], "sample": "s24le"}]
+//	}
+//
+// Channels and clock sources are given by name, or by their number in the driver. The clock source, routes
+// and output gains can change while the session runs, with Session.Reload; the rest is structural, and only
+// changes by applying the session again.
+type SessionConfig struct {
+	Driver      string            `json:"driver"`                // a key of ListDrivers
+	SampleRate  float64           `json:"sampleRate,omitempty"`  // 0 keeps the driver's
+	BufferSize  SessionBufferSize `json:"bufferSize"`            // defaults to "preferred"
+	ClockSource *SessionRef       `json:"clockSource,omitempty"` // nil keeps the driver's
+	Inputs      []SessionRef      `json:"inputs,omitempty"`      // nil opens every input
+	Outputs     []SessionRef      `json:"outputs,omitempty"`     // nil opens every output
+	Routes      []SessionRoute    `json:"routes,omitempty"`
+	OutputGains []SessionOutput   `json:"outputGains,omitempty"`
+	Recorders   []SessionRecorder `json:"recorders,omitempty"`
+}
+
+// SessionRef names a channel or a clock source in a SessionConfig. In JSON it is a string for a name, or a
+// number for the driver's channel number or clock source index.
+type SessionRef struct {
+	Name  string
+	Index int // when Name is empty
+
+	bad json.RawMessage // neither
+}
+
+// SessionBufferSize is a buffer size policy. In JSON it is "preferred", "min" or "max" for the driver's
+// preferred, minimum or maximum buffer size, or a number of frames.
+type SessionBufferSize struct {
+	Policy string // when Frames is 0; empty means "preferred"
+	Frames int
+
+	bad json.RawMessage
+}
+
+// SessionRoute sets a crosspoint of the session's Matrix. Crosspoints without a route are off.
+type SessionRoute struct {
+	In   SessionRef `json:"in"`
+	Out  SessionRef `json:"out"`
+	Gain *float64   `json:"gain,omitempty"` // dB; defaults to 0
+	Mute bool       `json:"mute,omitempty"`
+	Solo bool       `json:"solo,omitempty"`
+}
+
+// SessionOutput sets the gain and mute of an output of the session's Matrix. Outputs without one are at 0 dB.
+type SessionOutput struct {
+	Out  SessionRef `json:"out"`
+	Gain float64    `json:"gain,omitempty"` // dB
+	Mute bool       `json:"mute,omitempty"`
+}
+
+// SessionRecorder records inputs to a WAV file, from when the stream starts until the session is closed.
+type SessionRecorder struct {
+	Path   string       `json:"path"`             // created, or truncated; relative to the working directory
+	Inputs []SessionRef `json:"inputs,omitempty"` // nil records every input of the session
+	Sample string       `json:"sample,omitempty"` // s16le (the default), s24le or f32le
+}
+
+// SessionError is a problem with a session configuration, in the field it names.
+type SessionError struct {
+	Field string // e.g. "routes[2].in"; empty when the problem is not in one field
+	Err   error
+}
+
+func (e *SessionError) Error() string {
+	if e.Field == "" {
+		return "session: " + e.Err.Error()
+	}
+	return "session: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *SessionError) Unwrap() error { return e.Err }
+
+// Returned, in a SessionError, by Session.Reload for a change to a structural field.
+var ErrSessionStructural = errors.New("cannot change while the session runs; apply the session again")
+
+func sessionError(field string, format string, args ...any) error {
+	return &SessionError{field, fmt.Errorf(format, args...)}
+}
+
+func (r *SessionRef) UnmarshalJSON(b []byte) error {
+	*r = SessionRef{}
+	if err := json.Unmarshal(b, &r.Name); err == nil && r.Name != "" {
+		return nil
+	}
+	if err := json.Unmarshal(b, &r.Index); err != nil || r.Index < 0 {
+		*r = SessionRef{bad: append(json.RawMessage(nil), b...)}
+	}
+	return nil
+}
+
+func (r SessionRef) MarshalJSON() ([]byte, error) {
+	if r.Name != "" {
+		return json.Marshal(r.Name)
+	}
+	return json.Marshal(r.Index)
+}
+
+func (r SessionRef) String() string {
+	if r.Name != "" {
+		return strconv.Quote(r.Name)
+	}
+	return strconv.Itoa(r.Index)
+}
+
+func (bs *SessionBufferSize) UnmarshalJSON(b []byte) error {
+	*bs = SessionBufferSize{}
+	if err := json.Unmarshal(b, &bs.Policy); err == nil {
+		switch bs.Policy {
+		case "preferred", "min", "max":
+			return nil
+		}
+	} else if err := json.Unmarshal(b, &bs.Frames); err == nil && bs.Frames > 0 {
+		return nil
+	}
+	*bs = SessionBufferSize{bad: append(json.RawMessage(nil), b...)}
+	return nil
+}
+
+func (bs SessionBufferSize) MarshalJSON() ([]byte, error) {
+	if bs.Frames != 0 {
+		return json.Marshal(bs.Frames)
+	}
+	if bs.Policy == "" {
+		return json.Marshal("preferred")
+	}
+	return json.Marshal(bs.Policy)
+}
+
+// Reads a session configuration from JSON and checks what can be checked without the driver.
+func LoadSession(r io.Reader) (*SessionConfig, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	cfg := new(SessionConfig)
+	if err := dec.Decode(cfg); err != nil {
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntax):
+			return nil, sessionError("", "line %d: %v", 1+bytes.Count(data[:syntax.Offset], []byte("\n")), err)
+		case errors.As(err, &typ):
+			return nil, sessionError(typ.Field, "%s is not a %v", typ.Value, typ.Type)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+			return nil, sessionError(field, "unknown field")
+		}
+		return nil, &SessionError{Err: err}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Reads a session configuration from a JSON file.
+func LoadSessionFile(path string) (*SessionConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := LoadSession(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Checks what can be checked without the driver.
+func (cfg *SessionConfig) validate() error {
+	ref := func(field string, r *SessionRef) error {
+		if r.bad != nil {
+			return sessionError(field, "%s is not a name or a number", r.bad)
+		}
+		return nil
+	}
+	refs := func(field string, list []SessionRef) error {
+		for i := range list {
+			if err := ref(fmt.Sprintf("%s[%d]", field, i), &list[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if cfg.Driver == "" {
+		return sessionError("driver", "missing")
+	}
+	if cfg.SampleRate < 0 || math.IsNaN(cfg.SampleRate) {
+		return sessionError("sampleRate", "%v is not a sample rate", cfg.SampleRate)
+	}
+	if cfg.BufferSize.bad != nil {
+		return sessionError("bufferSize", `%s is not "preferred", "min", "max" or a number of frames`,
+			cfg.BufferSize.bad)
+	}
+	if cfg.ClockSource != nil {
+		if err := ref("clockSource", cfg.ClockSource); err != nil {
+			return err
+		}
+	}
+	if err := refs("inputs", cfg.Inputs); err != nil {
+		return err
+	}
+	if err := refs("outputs", cfg.Outputs); err != nil {
+		return err
+	}
+	for i := range cfg.Routes {
+		r := &cfg.Routes[i]
+		if err := ref(fmt.Sprintf("routes[%d].in", i), &r.In); err != nil {
+			return err
+		}
+		if err := ref(fmt.Sprintf("routes[%d].out", i), &r.Out); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.OutputGains {
+		if err := ref(fmt.Sprintf("outputGains[%d].out", i), &cfg.OutputGains[i].Out); err != nil {
+			return err
+		}
+	}
+	paths := make(map[string]int)
+	for i, rec := range cfg.Recorders {
+		field := fmt.Sprintf("recorders[%d]", i)
+		if rec.Path == "" {
+			return sessionError(field+".path", "missing")
+		}
+		if j, ok := paths[rec.Path]; ok {
+			return sessionError(field+".path", "the same file as recorders[%d]", j)
+		}
+		paths[rec.Path] = i
+		if _, ok := monitorSamples[rec.Sample]; !ok && rec.Sample != "" {
+			return sessionError(field+".sample", "unknown sample format %q", rec.Sample)
+		}
+		if err := refs(field+".inputs", rec.Inputs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the key of drivers that name matches: the same key, or failing that the one key that differs only
+// in case.
+func matchDriver(name string, drivers []string) (string, error) {
+	var folded []string
+	for _, key := range drivers {
+		if key == name {
+			return key, nil
+		}
+		if strings.EqualFold(key, name) {
+			folded = append(folded, key)
+		}
+	}
+	if len(folded) == 1 {
+		return folded[0], nil
+	}
+	sort.Strings(drivers)
+	return "", sessionError("driver", "no driver %q; installed are %q", name, drivers)
+}
+
+// Session is a stream set up from a SessionConfig, with a Matrix for its routes and recorders.
+type Session struct {
+	Stream    *Stream
+	Matrix    *Matrix
+	Recorders []*WAVRecorder
+
+	drv         Driver
+	files       []*os.File
+	closeDriver func() error
+
+	mu     sync.Mutex
+	config *SessionConfig
+
+	stopWatch chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Applies a session configuration to an initialized driver: sets its clock source, creates its stream, routes
+// its matrix and creates its recorders. Nothing is changed if the configuration does not fit the driver, a
+// recorder's file cannot be opened or the stream cannot be created: the clock source and sample rate are set
+// back and no file is truncated. Only a failure to write a recorder's header leaves the driver as configured
+// and the files before it truncated. The stream is not started, and cfg.Driver is not checked against the
+// driver; OpenSession does that on Windows.
+func ApplySession(drv Driver, cfg *SessionConfig) (sess *Session, err error) {
+	if err = cfg.validate(); err != nil {
+		return nil, err
+	}
+	numIn, numOut, err := drv.GetChannels()
+	if err != nil {
+		return nil, err
+	}
+	opts := StreamOptions{SampleRate: cfg.SampleRate}
+	if opts.Inputs, err = driverChannels(drv, "inputs", cfg.Inputs, numIn, true); err != nil {
+		return nil, err
+	}
+	if opts.Outputs, err = driverChannels(drv, "outputs", cfg.Outputs, numOut, false); err != nil {
+		return nil, err
+	}
+	if cfg.SampleRate != 0 {
+		if err := drv.CanSampleRate(cfg.SampleRate); err != nil {
+			return nil, sessionError("sampleRate", "the driver cannot run at %v Hz: %v", cfg.SampleRate, err)
+		}
+	}
+	if opts.BufferSize, err = sessionBufferSize(drv, cfg.BufferSize); err != nil {
+		return nil, err
+	}
+	clock, current, err := sessionClockSource(drv, cfg.ClockSource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the routes and recorders against the channels the stream will open before changing anything.
+	inputs, err := channelInfos(drv, opts.Inputs, numIn, true)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := channelInfos(drv, opts.Outputs, numOut, false)
+	if err != nil {
+		return nil, err
+	}
+	points, masters, err := sessionRouting(cfg, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	recInputs := make([][]int, len(cfg.Recorders))
+	samples := make([]SampleType, len(cfg.Recorders))
+	for i, rec := range cfg.Recorders {
+		if recInputs[i], err = streamChannels(fmt.Sprintf("recorders[%d].inputs", i), rec.Inputs, inputs,
+			"input"); err != nil {
+			return nil, err
+		}
+		if rec.Sample == "" {
+			rec.Sample = "s16le"
+		}
+		samples[i] = monitorSamples[rec.Sample]
+	}
+
+	// NewStream sets the sample rate before it can fail.
+	rate, err := drv.GetSampleRate()
+	if err != nil {
+		return nil, err
+	}
+	files, created, err := openRecorderFiles(cfg.Recorders)
+	if err != nil {
+		return nil, err
+	}
+	undo := func() {
+		closeRecorderFiles(files, created)
+		if now, err := drv.GetSampleRate(); err == nil && now != rate {
+			drv.SetSampleRate(rate)
+		}
+		if clock >= 0 && current >= 0 && current != clock {
+			drv.SetClockSource(current)
+		}
+	}
+
+	if clock >= 0 {
+		if err := drv.SetClockSource(clock); err != nil {
+			undo()
+			return nil, sessionError("clockSource", "%v", err)
+		}
+	}
+	s, err := NewStream(drv, opts)
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	sess = &Session{
+		Stream:    s,
+		Matrix:    NewMatrix(s, DefaultRampTime),
+		drv:       drv,
+		files:     files,
+		config:    cfg,
+		stopWatch: make(chan struct{}),
+	}
+	sess.route(points, masters)
+
+	for i, f := range files {
+		if err := f.Truncate(0); err != nil {
+			sess.Close()
+			return nil, &SessionError{fmt.Sprintf("recorders[%d].path", i), err}
+		}
+		r, err := NewWAVRecorder(s, f, samples[i], recInputs[i]...)
+		if err != nil {
+			sess.Close()
+			return nil, &SessionError{fmt.Sprintf("recorders[%d]", i), err}
+		}
+		sess.Recorders = append(sess.Recorders, r)
+	}
+	return sess, nil
+}
+
+// Resolves refs to the driver's channel numbers; nil stays nil, for every channel.
+func driverChannels(drv Driver, field string, refs []SessionRef, count int, isInput bool) ([]int, error) {
+	if refs == nil {
+		return nil, nil
+	}
+	all, err := channelInfos(drv, nil, count, isInput)
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]int, len(refs))
+	seen := make(map[int]int)
+	for i, ref := range refs {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if ref.Name != "" {
+			if channels[i], err = findChannel(all, ref.Name); err != nil {
+				return nil, &SessionError{f, err}
+			}
+		} else if ref.Index >= count {
+			return nil, sessionError(f, "no channel %d; the driver has %d", ref.Index, count)
+		} else {
+			channels[i] = ref.Index
+		}
+		if j, ok := seen[channels[i]]; ok {
+			return nil, sessionError(f, "the same channel as %s[%d]", field, j)
+		}
+		seen[channels[i]] = i
+	}
+	return channels, nil
+}
+
+// Resolves refs to indexes into the stream's channels, infos; nil is every channel.
+func streamChannels(field string, refs []SessionRef, infos []*ChannelInfo, what string) ([]int, error) {
+	if refs == nil {
+		channels := make([]int, len(infos))
+		for i := range channels {
+			channels[i] = i
+		}
+		return channels, nil
+	}
+	channels := make([]int, len(refs))
+	for i, ref := range refs {
+		ch, err := streamChannel(ref, infos, what)
+		if err != nil {
+			return nil, &SessionError{fmt.Sprintf("%s[%d]", field, i), err}
+		}
+		channels[i] = ch
+	}
+	return channels, nil
+}
+
+func streamChannel(ref SessionRef, infos []*ChannelInfo, what string) (int, error) {
+	for i, info := range infos {
+		if ref.Name != "" && info.Name == ref.Name || ref.Name == "" && info.Channel == ref.Index {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s %s in the session", what, ref)
+}
+
+func sessionBufferSize(drv Driver, bs SessionBufferSize) (int, error) {
+	minSize, maxSize, preferred, granularity, err := drv.GetBufferSize()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case bs.Frames == 0 && bs.Policy == "min":
+		return minSize, nil
+	case bs.Frames == 0 && bs.Policy == "max":
+		return maxSize, nil
+	case bs.Frames == 0:
+		return preferred, nil
+	}
+	n := bs.Frames
+	ok := n >= minSize && n <= maxSize
+	switch {
+	case granularity == -1:
+		ok = ok && n&(n-1) == 0
+	case granularity == 0:
+		ok = n == preferred
+	default:
+		ok = ok && (n-minSize)%granularity == 0
+	}
+	if !ok {
+		return 0, sessionError("bufferSize", "the driver cannot use %d frames; it takes %d to %d, granularity %d",
+			n, minSize, maxSize, granularity)
+	}
+	return n, nil
+}
+
+// Returns the index of the clock source ref names, or -1 for none.
+// Also returns the driver's current clock source, or -1 if it reports none.
+func sessionClockSource(drv Driver, ref *SessionRef) (clock, current int, err error) {
+	if ref == nil {
+		return -1, -1, nil
+	}
+	sources, err := drv.GetClockSources()
+	if err != nil {
+		return 0, 0, err
+	}
+	clock, current = -1, -1
+	var names []string
+	for _, src := range sources {
+		if clock < 0 && (ref.Name != "" && src.Name == ref.Name || ref.Name == "" && src.Index == ref.Index) {
+			clock = src.Index
+		}
+		if src.IsCurrentSource {
+			current = src.Index
+		}
+		names = append(names, src.Name)
+	}
+	if clock < 0 {
+		return 0, 0, sessionError("clockSource", "no clock source %s; the driver has %q", ref, names)
+	}
+	return clock, current, nil
+}
+
+// Opens the recorders' files for writing, creating those that do not exist but truncating none, so that a path
+// that cannot be written fails before anything has changed. Also returns the paths created. On failure, the
+// files opened are closed and those created removed.
+func openRecorderFiles(recs []SessionRecorder) (files []*os.File, created []string, err error) {
+	for i, rec := range recs {
+		_, statErr := os.Stat(rec.Path)
+		f, err := os.OpenFile(rec.Path, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			closeRecorderFiles(files, created)
+			return nil, nil, &SessionError{fmt.Sprintf("recorders[%d].path", i), err}
+		}
+		files = append(files, f)
+		if errors.Is(statErr, os.ErrNotExist) {
+			created = append(created, rec.Path)
+		}
+	}
+	return files, created, nil
+}
+
+// Closes the files openRecorderFiles opened and removes those it created.
+func closeRecorderFiles(files []*os.File, created []string) {
+	for _, f := range files {
+		f.Close()
+	}
+	for _, path := range created {
+		os.Remove(path)
+	}
+}
+
+// Works out the crosspoints, [out*numIn+in], and output stages of the matrix from cfg.
+func sessionRouting(cfg *SessionConfig, inputs, outputs []*ChannelInfo) (points, masters []crosspoint, err error) {
+	points = make([]crosspoint, len(inputs)*len(outputs))
+	for i := range points {
+		points[i].gain = math.Inf(-1)
+	}
+	routed := make(map[int]int)
+	for i, r := range cfg.Routes {
+		in, err := streamChannel(r.In, inputs, "input")
+		if err != nil {
+			return nil, nil, &SessionError{fmt.Sprintf("routes[%d].in", i), err}
+		}
+		out, err := streamChannel(r.Out, outputs, "output")
+		if err != nil {
+			return nil, nil, &SessionError{fmt.Sprintf("routes[%d].out", i), err}
+		}
+		k := out*len(inputs) + in
+		if j, ok := routed[k]; ok {
+			return nil, nil, sessionError(fmt.Sprintf("routes[%d]", i), "the same crosspoint as routes[%d]", j)
+		}
+		routed[k] = i
+		points[k] = crosspoint{0, r.Mute, r.Solo}
+		if r.Gain != nil {
+			points[k].gain = *r.Gain
+		}
+	}
+
+	masters = make([]crosspoint, len(outputs))
+	set := make(map[int]int)
+	for i, o := range cfg.OutputGains {
+		out, err := streamChannel(o.Out, outputs, "output")
+		if err != nil {
+			return nil, nil, &SessionError{fmt.Sprintf("outputGains[%d].out", i), err}
+		}
+		if j, ok := set[out]; ok {
+			return nil, nil, sessionError(fmt.Sprintf("outputGains[%d]", i), "the same output as outputGains[%d]", j)
+		}
+		set[out] = i
+		masters[out] = crosspoint{o.Gain, o.Mute, false}
+	}
+	return points, masters, nil
+}
+
+// Sets the crosspoints and outputs of the matrix that differ from points and masters, so that those that do
+// not change do not ramp.
+func (sess *Session) route(points, masters []crosspoint) {
+	m := sess.Matrix
+	for o := 0; o < m.numOut; o++ {
+		for i := 0; i < m.numIn; i++ {
+			want := points[o*m.numIn+i]
+			gain, mute, solo, _ := m.Crosspoint(i, o)
+			if gain != want.gain {
+				m.SetGain(i, o, want.gain)
+			}
+			if mute != want.mute {
+				m.SetMute(i, o, want.mute)
+			}
+			if solo != want.solo {
+				m.SetSolo(i, o, want.solo)
+			}
+		}
+		gain, mute, _ := m.Output(o)
+		if gain != masters[o].gain {
+			m.SetOutputGain(o, masters[o].gain)
+		}
+		if mute != masters[o].mute {
+			m.SetOutputMute(o, masters[o].mute)
+		}
+	}
+}
+
+// Returns the configuration the session runs with.
+func (sess *Session) Config() *SessionConfig {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.config
+}
+
+// Changes the clock source, routes and output gains of a running session to those of cfg. If anything else
+// differs, it fails with ErrSessionStructural in a SessionError naming the field; if anything does not fit
+// the session, nothing is changed.
+func (sess *Session) Reload(cfg *SessionConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	old := sess.config
+	for _, f := range []struct {
+		field    string
+		old, new any
+	}{
+		{"driver", old.Driver, cfg.Driver},
+		{"sampleRate", old.SampleRate, cfg.SampleRate},
+		{"bufferSize", old.BufferSize, cfg.BufferSize},
+		{"inputs", old.Inputs, cfg.Inputs},
+		{"outputs", old.Outputs, cfg.Outputs},
+		{"recorders", old.Recorders, cfg.Recorders},
+	} {
+		if !reflect.DeepEqual(f.old, f.new) {
+			return &SessionError{f.field, ErrSessionStructural}
+		}
+	}
+
+	clock := -1
+	if !reflect.DeepEqual(old.ClockSource, cfg.ClockSource) {
+		var err error
+		if clock, _, err = sessionClockSource(sess.drv, cfg.ClockSource); err != nil {
+			return err
+		}
+	}
+	points, masters, err := sessionRouting(cfg, sess.Stream.Inputs(), sess.Stream.Outputs())
+	if err != nil {
+		return err
+	}
+
+	if clock >= 0 {
+		if err := sess.drv.SetClockSource(clock); err != nil {
+			return sessionError("clockSource", "%v", err)
+		}
+	}
+	sess.route(points, masters)
+	sess.config = cfg
+	return nil
+}
+
+// Reloads the session from the file at path whenever its modification time changes, checking every interval,
+// until the session is closed. Errors loading or reloading the file, which leave the session as it was, are
+// passed to report if it is not nil.
+func (sess *Session) Watch(path string, interval time.Duration, report func(error)) {
+	modified := func() time.Time {
+		if fi, err := os.Stat(path); err == nil {
+			return fi.ModTime()
+		}
+		return time.Time{}
+	}
+	last := modified()
+
+	sess.wg.Add(1)
+	go func() {
+		defer sess.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sess.stopWatch:
+				return
+			case <-ticker.C:
+			}
+			t := modified()
+			if t.IsZero() || t.Equal(last) {
+				continue
+			}
+			last = t
+			cfg, err := LoadSessionFile(path)
+			if err == nil {
+				err = sess.Reload(cfg)
+			}
+			if err != nil && report != nil {
+				report(err)
+			}
+		}
+	}()
+}
+
+// Stops watching, closes the stream, completes and closes the recordings, and closes the driver if the session
+// opened it. Every step is taken even if an earlier one fails; it returns all their errors, joined.
+func (sess *Session) Close() error {
+	sess.closeOnce.Do(func() {
+		close(sess.stopWatch)
+		sess.wg.Wait()
+
+		errs := []error{sess.Stream.Close()} // first, so that the recorders get no more buffers
+		for _, r := range sess.Recorders {
+			errs = append(errs, r.Close())
+		}
+		for _, f := range sess.files {
+			errs = append(errs, f.Close())
+		}
+		if sess.closeDriver != nil {
+			errs = append(errs, sess.closeDriver())
+		}
+		sess.closeErr = errors.Join(errs...)
+	})
+	return sess.closeErr
+}
@@ -0,0 +1,254 @@
+package asio
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadSession(t *testing.T, config string) *SessionConfig {
+	t.Helper()
+	cfg, err := LoadSession(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func sessionField(err error) string {
+	var serr *SessionError
+	if !errors.As(err, &serr) {
+		return "not a SessionError"
+	}
+	return serr.Field
+}
+
+const testSession = `{
+	"driver": "Simulated ASIO",
+	"sampleRate": 96000,
+	"bufferSize": 128,
+	"clockSource": "Internal",
+	"inputs": ["In 2", 0],
+	"outputs": [3, "Out 1"],
+	"routes": [{"in": 0, "out": "Out 1", "gain": -6}, {"in": "In 2", "out": 3, "mute": true}],
+	"outputGains": [{"out": 3, "gain": -3}],
+	"recorders": [{"path": "REC", "inputs": [0], "sample": "f32le"}]
+}`
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	rec := filepath.Join(dir, "take.wav")
+	config := strings.Replace(testSession, "REC", filepath.ToSlash(rec), 1)
+	cfg := loadSession(t, config)
+
+	drv := NewSimDriver(4, 4)
+	drv.Manual = true
+	sess, err := ApplySession(drv, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	s := sess.Stream
+	var names []string
+	for _, info := range append(s.Inputs(), s.Outputs()...) {
+		names = append(names, info.Name)
+	}
+	if s.SampleRate() != 96000 || s.BufferSize() != 128 || strings.Join(names, ",") != "In 2,In 1,Out 4,Out 1" {
+		t.Errorf("stream at %v, %d frames, channels %v", s.SampleRate(), s.BufferSize(), names)
+	}
+	m := sess.Matrix
+	if gain, mute, _, _ := m.Crosspoint(1, 1); gain != -6 || mute {
+		t.Errorf("In 1 to Out 1: %v %v", gain, mute)
+	}
+	if gain, mute, _, _ := m.Crosspoint(0, 0); gain != 0 || !mute {
+		t.Errorf("In 2 to Out 4: %v %v", gain, mute)
+	}
+	if gain, _, _, _ := m.Crosspoint(1, 0); !math.IsInf(gain, -1) {
+		t.Errorf("In 1 to Out 4 is on at %v", gain)
+	}
+	if gain, mute, _ := m.Output(0); gain != -3 || mute {
+		t.Errorf("Out 4: %v %v", gain, mute)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		drv.Step()
+	}
+
+	// Routing changes on reload; the sample rate cannot.
+	cfg2 := loadSession(t, strings.Replace(config, `"gain": -6`, `"gain": -12, "solo": true`, 1))
+	if err := sess.Reload(cfg2); err != nil {
+		t.Fatal(err)
+	}
+	if gain, _, solo, _ := m.Crosspoint(1, 1); gain != -12 || !solo || sess.Config() != cfg2 {
+		t.Errorf("after reload, In 1 to Out 1: %v %v", gain, solo)
+	}
+	err = sess.Reload(loadSession(t, strings.Replace(config, "96000", "48000", 1)))
+	if !errors.Is(err, ErrSessionStructural) || sessionField(err) != "sampleRate" {
+		t.Errorf("changing the sample rate: %v", err)
+	}
+	err = sess.Reload(loadSession(t, strings.Replace(config, `"out": "Out 1"`, `"out": "Out 2"`, 1)))
+	if sessionField(err) != "routes[0].out" {
+		t.Errorf("routing to an output not in the session: %v", err)
+	}
+	if gain, _, _, _ := m.Crosspoint(1, 1); gain != -12 || sess.Config() != cfg2 {
+		t.Errorf("a failed reload changed the session")
+	}
+
+	// Watching picks up changes to the file.
+	path := filepath.Join(dir, "session.json")
+	write := func(config string, at time.Time) {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, at, at)
+	}
+	write(config, time.Now())
+	reports := make(chan error, 10)
+	sess.Watch(path, 5*time.Millisecond, func(err error) { reports <- err })
+	write(strings.Replace(config, `"gain": -3`, `"gain": -20`, 1), time.Now().Add(time.Minute))
+	waitFor(t, "the reload", func() bool { gain, _, _ := m.Output(0); return gain == -20 })
+	write(`{"driver": 7}`, time.Now().Add(2*time.Minute))
+	select {
+	case err := <-reports:
+		if sessionField(err) != "driver" {
+			t.Errorf("reloading a bad file: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no error reported for a bad file")
+	}
+
+	if err := sess.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(rec); err != nil || fi.Size() != 44+4*128*4 {
+		t.Errorf("recording: %v", err)
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	for _, c := range []struct {
+		config, field string
+	}{
+		{`{}`, "driver"},
+		{`{"driver": "x", "colour": 1}`, "colour"},
+		{`{"driver": "x", "sampleRate": "fast"}`, "sampleRate"},
+		{`{"driver": "x", "bufferSize": "huge"}`, "bufferSize"},
+		{`{"driver": "x", "bufferSize": -64}`, "bufferSize"},
+		{`{"driver": "x", "inputs": [-1]}`, "inputs[0]"},
+		{`{"driver": "x", "routes": [{"in": "a", "out": true}]}`, "routes[0].out"},
+		{`{"driver": "x", "recorders": [{"path": "a", "sample": "u8"}]}`, "recorders[0].sample"},
+		{`{"driver": "x", "recorders": [{"path": "a"}, {"path": "a"}]}`, "recorders[1].path"},
+		{"{\"driver\": \"x\",\n\"routes\": [}", ""},
+	} {
+		_, err := LoadSession(strings.NewReader(c.config))
+		if err == nil || sessionField(err) != c.field {
+			t.Errorf("%s: %v, want an error in %q", c.config, err, c.field)
+		}
+		if c.field == "" && !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("syntax error without its line: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		config, field string
+	}{
+		{`"inputs": ["In 9"]`, "inputs[0]"},
+		{`"outputs": [7]`, "outputs[0]"},
+		{`"outputs": [1, "Out 2"]`, "outputs[1]"},
+		{`"sampleRate": 12345`, "sampleRate"},
+		{`"sampleRate": 96000, "bufferSize": 100`, "bufferSize"},
+		{`"sampleRate": 96000, "clockSource": "Word Clock"`, "clockSource"},
+		{`"inputs": ["In 1"], "routes": [{"in": "In 3", "out": 0}]`, "routes[0].in"},
+		{`"routes": [{"in": 0, "out": 0}, {"in": "In 1", "out": "Out 1"}]`, "routes[1]"},
+		{`"outputGains": [{"out": "Out 5"}]`, "outputGains[0].out"},
+		{`"inputs": [0], "recorders": [{"path": "x.wav", "inputs": [1]}]`, "recorders[0].inputs[0]"},
+	} {
+		drv := NewSimDriver(4, 4)
+		_, err := ApplySession(drv, loadSession(t, `{"driver": "Simulated ASIO", `+c.config+`}`))
+		if sessionField(err) != c.field {
+			t.Errorf("%s: %v, want an error in %q", c.config, err, c.field)
+		}
+		if rate, _ := drv.GetSampleRate(); rate != 48000 {
+			t.Errorf("%s: a failed session changed the sample rate", c.config)
+		}
+	}
+}
+
+// A SimDriver with a second clock source.
+type clockDriver struct {
+	*SimDriver
+	current int
+}
+
+func (d *clockDriver) GetClockSources() ([]ClockSource, error) {
+	sources := []ClockSource{
+		{Index: 0, AssociatedChannel: -1, AssociatedGroup: -1, Name: "Internal"},
+		{Index: 1, AssociatedChannel: -1, AssociatedGroup: -1, Name: "Word Clock"},
+	}
+	sources[d.current].IsCurrentSource = true
+	return sources, nil
+}
+
+func (d *clockDriver) SetClockSource(reference int) error {
+	d.current = reference
+	return nil
+}
+
+func TestSessionFailureChangesNothing(t *testing.T) {
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "keep.wav")
+	if err := os.WriteFile(keep, []byte("keep"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "new.wav")
+
+	for _, c := range []struct {
+		second, field string
+		faults        *FaultScenario
+	}{
+		{filepath.Join(dir, "missing", "x.wav"), "recorders[1].path", nil},
+		{fresh, "", NewFaultScenario().FailCalls("CreateBuffers", ErrorHWMalfunction, 0, 1)},
+	} {
+		drv := &clockDriver{SimDriver: NewSimDriver(1, 1)}
+		drv.Faults = c.faults
+		cfg := loadSession(t, `{"driver": "Simulated ASIO", "sampleRate": 96000, "clockSource": "Word Clock", "recorders": [
+			{"path": "`+filepath.ToSlash(keep)+`"}, {"path": "`+filepath.ToSlash(c.second)+`"}]}`)
+		_, err := ApplySession(drv, cfg)
+		if c.field != "" && sessionField(err) != c.field || c.field == "" && !errors.Is(err, ErrorHWMalfunction) {
+			t.Errorf("%s: %v, want an error in %q", c.second, err, c.field)
+		}
+		if drv.current != 0 {
+			t.Errorf("%s: the clock source was left changed", c.second)
+		}
+		if rate, _ := drv.GetSampleRate(); rate != 48000 {
+			t.Errorf("%s: the sample rate was left at %v", c.second, rate)
+		}
+		if b, _ := os.ReadFile(keep); string(b) != "keep" {
+			t.Errorf("%s: the first recorder's file was truncated", c.second)
+		}
+		if _, err := os.Stat(fresh); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: the file created was left behind", c.second)
+		}
+	}
+}
+
+func TestMatchDriver(t *testing.T) {
+	drivers := []string{"ASIO4ALL v2", "Focusrite USB ASIO", "focusrite usb asio "}
+	if key, err := matchDriver("Focusrite USB ASIO", drivers); key != "Focusrite USB ASIO" || err != nil {
+		t.Errorf("exact: %q %v", key, err)
+	}
+	if key, err := matchDriver("asio4all V2", drivers); key != "ASIO4ALL v2" || err != nil {
+		t.Errorf("case-insensitive: %q %v", key, err)
+	}
+	if _, err := matchDriver("Dante", drivers); sessionField(err) != "driver" {
+		t.Errorf("unknown driver: %v", err)
+	}
+}
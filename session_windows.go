@@ -0,0 +1,36 @@
+//go:build windows
+
+package asio
+
+import "errors"
+
+// Opens the driver that cfg.Driver names, matched against the keys of ListDrivers, and applies the session
+// to it. Closing the session closes the driver.
+func OpenSession(cfg *SessionConfig) (*Session, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	drivers, err := ListDrivers()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(drivers))
+	for key := range drivers {
+		keys = append(keys, key)
+	}
+	key, err := matchDriver(cfg.Driver, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	drv := drivers[key]
+	if err = drv.Open(); err != nil {
+		return nil, err
+	}
+	sess, err := ApplySession(drv.ASIO, cfg)
+	if err != nil {
+		return nil, errors.Join(err, drv.Close())
+	}
+	sess.closeDriver = drv.Close
+	return sess, nil
+}
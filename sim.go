@@ -0,0 +1,600 @@
+package asio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// SimDriver is a Driver with no hardware behind it. It runs its buffer switches from a goroutine paced by the
+// wall clock, or, when Manual is set, only when Step is called so that tests are deterministic.
+type SimDriver struct {
+	Name       string
+	NumInputs  int
+	NumOutputs int
+	SampleType SampleType
+
+	MinSize, MaxSize, PreferredSize, Granularity int
+
+	// Channels per ChannelGroup; 0 puts every channel in group 0.
+	GroupSize int
+
+	// Latencies reported in addition to the buffer size.
+	InputLatency, OutputLatency int
+
+	// When set, buffer switches only happen on Step.
+	Manual bool
+
+	// Fills an input channel's samples for the buffer starting at pos. Inputs are silent if nil.
+	Input func(channel int, pos int64, buf []float32)
+
+	// When set, each opened output is fed back into the input with the same channel index, LoopbackDelay
+	// samples later, as if by a loopback cable. The delay is never shorter than one buffer.
+	Loopback      bool
+	LoopbackDelay int
+
+	// When set, the driver can be switched to DSD with SetIoFormat. Its channels then use DSDSampleType and it
+	// runs at DSD64Rate, DSD128Rate or DSD256Rate.
+	SupportsDSD   bool
+	DSDSampleType SampleType
+
+	// Fills an input channel's driver buffer directly, after Input. Unlike Input it works for DSD.
+	RawInput func(channel int, pos int64, buf []byte)
+
+	// Receives each output channel's driver buffer once the host has filled it.
+	RawOutput func(channel int, pos int64, buf []byte)
+
+	// When set, the driver can read timecode, enabled with AsioEnableTimeCodeRead. It then reports timecode
+	// running at nominal speed, TimeCodeStart samples ahead of the sample position, to a host that answers
+	// AsioSupportsTimeCode.
+	SupportsTimeCode bool
+	TimeCodeStart    int64
+
+	// The error of the simulated sample clock in parts per million: the driver reports its nominal rate, but
+	// its samples are due at SampleRate*(1+ClockPPM/1e6) per second of system time, as with a real crystal.
+	ClockPPM float64
+
+	// Misbehaviour scripted for testing hosts; nil behaves.
+	Faults *FaultScenario
+
+	mu           sync.Mutex
+	sampleRate   float64
+	ioFormat     IoFormatType
+	timeCodeRead bool
+	callbacks    Callbacks
+	buffers      []BufferInfo
+	memory       [][2][]byte
+	bufferSize   int
+	running      bool
+	index        int
+	position     int64
+	started      time.Time
+	pacing       *pacer // nil when Manual or stopped
+	scratch      []float32
+	loop         map[int][]float32 // output channel -> ring of recent output samples
+
+	// Serializes buffer switches between Step and the pacing goroutine.
+	switchMu sync.Mutex
+}
+
+var _ Driver = (*SimDriver)(nil)
+
+// Creates a simulated driver running float32 channels at 48kHz.
+func NewSimDriver(numInputs, numOutputs int) *SimDriver {
+	return &SimDriver{
+		Name:          "Simulated ASIO",
+		NumInputs:     numInputs,
+		NumOutputs:    numOutputs,
+		SampleType:    ASIOSTFloat32LSB,
+		MinSize:       32,
+		MaxSize:       4096,
+		PreferredSize: 256,
+		Granularity:   -1,
+		DSDSampleType: ASIOSTDSDInt8MSB1,
+		sampleRate:    48000,
+	}
+}
+
+func (d *SimDriver) Init(sysHandle uintptr) (ok bool) { return d.Faults.call("Init") == nil }
+func (d *SimDriver) GetDriverName() string            { return d.Name }
+func (d *SimDriver) GetDriverVersion() int32          { return 1 }
+func (d *SimDriver) GetErrorMessage() string          { return d.Faults.errorMessage() }
+func (d *SimDriver) OutputReady() bool                { return false }
+
+func (d *SimDriver) ControlPanel() (err error) {
+	if err = d.Faults.call("ControlPanel"); err != nil {
+		return err
+	}
+	return ErrorNotPresent
+}
+
+func (d *SimDriver) GetChannels() (numInputChannels, numOutputChannels int, err error) {
+	if err = d.Faults.call("GetChannels"); err != nil {
+		return 0, 0, err
+	}
+	return d.NumInputs, d.NumOutputs, nil
+}
+
+func (d *SimDriver) GetLatencies() (inputLatency, outputLatency int, err error) {
+	if err = d.Faults.call("GetLatencies"); err != nil {
+		return 0, 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	size := d.bufferSize
+	if size == 0 {
+		size = d.PreferredSize
+	}
+	return size + d.InputLatency, size + d.OutputLatency, nil
+}
+
+func (d *SimDriver) GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
+	if err = d.Faults.call("GetBufferSize"); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return d.MinSize, d.MaxSize, d.PreferredSize, d.Granularity, nil
+}
+
+func (d *SimDriver) CanSampleRate(sampleRate float64) (err error) {
+	if err = d.Faults.call("CanSampleRate"); err != nil {
+		return err
+	}
+	return d.canSampleRate(sampleRate)
+}
+
+func (d *SimDriver) canSampleRate(sampleRate float64) error {
+	d.mu.Lock()
+	dsd := d.ioFormat == DSDFormat
+	d.mu.Unlock()
+
+	if dsd {
+		switch sampleRate {
+		case DSD64Rate, DSD128Rate, DSD256Rate:
+			return nil
+		}
+		return ErrorNoClock
+	}
+	switch sampleRate {
+	case 44100, 48000, 88200, 96000, 176400, 192000:
+		return nil
+	}
+	return ErrorNoClock
+}
+
+// The sample type of every channel in the current IoFormat. Called with d.mu held.
+func (d *SimDriver) sampleType() SampleType {
+	if d.ioFormat == DSDFormat {
+		return d.DSDSampleType
+	}
+	return d.SampleType
+}
+
+func (d *SimDriver) GetSampleRate() (sampleRate float64, err error) {
+	if err = d.Faults.call("GetSampleRate"); err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sampleRate, nil
+}
+
+func (d *SimDriver) SetSampleRate(sampleRate float64) (err error) {
+	if err = d.Faults.call("SetSampleRate"); err != nil {
+		return err
+	}
+	if err = d.canSampleRate(sampleRate); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	changed := d.sampleRate != sampleRate
+	d.sampleRate = sampleRate
+	cb := d.callbacks.SampleRateDidChange
+	d.mu.Unlock()
+
+	if changed && cb != nil {
+		cb(sampleRate)
+	}
+	return nil
+}
+
+func (d *SimDriver) GetClockSources() (sources []ClockSource, err error) {
+	if err = d.Faults.call("GetClockSources"); err != nil {
+		return nil, err
+	}
+	return []ClockSource{{Index: 0, AssociatedChannel: -1, AssociatedGroup: -1, IsCurrentSource: true, Name: "Internal"}}, nil
+}
+
+func (d *SimDriver) SetClockSource(reference int) (err error) {
+	if err = d.Faults.call("SetClockSource"); err != nil {
+		return err
+	}
+	if reference != 0 {
+		return ErrorInvalidParameter
+	}
+	return nil
+}
+
+func (d *SimDriver) GetSamplePosition() (samplePosition, systemTime int64, err error) {
+	if err = d.Faults.call("GetSamplePosition"); err != nil {
+		return 0, 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.running {
+		return 0, 0, ErrorSPNotAdvancing
+	}
+	return d.position, d.systemTime(d.position), nil
+}
+
+// The system time at which the sample at pos is due, in nanoseconds.
+func (d *SimDriver) systemTime(pos int64) int64 {
+	return d.started.UnixNano() + int64(float64(pos)/d.clockRate()*1e9)
+}
+
+// The rate at which samples actually pass, in samples per second of system time.
+func (d *SimDriver) clockRate() float64 { return d.sampleRate * (1 + d.ClockPPM/1e6) }
+
+func (d *SimDriver) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
+	if err = d.Faults.call("GetChannelInfo"); err != nil {
+		return nil, err
+	}
+	count, prefix := d.NumOutputs, "Out"
+	if isInput {
+		count, prefix = d.NumInputs, "In"
+	}
+	if channel < 0 || channel >= count {
+		return nil, ErrorInvalidParameter
+	}
+
+	d.mu.Lock()
+	active := false
+	for _, buf := range d.buffers {
+		if buf.Channel == channel && buf.IsInput == isInput {
+			active = true
+		}
+	}
+	sampleType := d.sampleType()
+	d.mu.Unlock()
+
+	group := 0
+	if d.GroupSize > 0 {
+		group = channel / d.GroupSize
+	}
+	return &ChannelInfo{
+		Channel:      channel,
+		IsInput:      isInput,
+		IsActive:     active,
+		ChannelGroup: group,
+		SampleType:   sampleType,
+		Name:         fmt.Sprintf("%s %d", prefix, channel+1),
+	}, nil
+}
+
+func (d *SimDriver) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+	if err = d.Faults.call("CreateBuffers"); err != nil {
+		return err
+	}
+	if bufferSize < d.MinSize || bufferSize > d.MaxSize {
+		return ErrorInvalidMode
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.buffers != nil {
+		return ErrorInvalidMode
+	}
+	if len(bufferDescriptors) == 0 {
+		return ErrorInvalidParameter
+	}
+	for _, desc := range bufferDescriptors {
+		count := d.NumOutputs
+		if desc.IsInput {
+			count = d.NumInputs
+		}
+		if desc.Channel < 0 || desc.Channel >= count {
+			return ErrorInvalidParameter
+		}
+	}
+
+	size := bufferSize * d.sampleType().BytesPerSample()
+	d.memory = make([][2][]byte, len(bufferDescriptors))
+	for i := range bufferDescriptors {
+		// Back the buffers with []int32 so they are aligned like driver memory.
+		for j := 0; j < 2; j++ {
+			words := make([]int32, (size+3)/4)
+			d.memory[i][j] = unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), size)
+			bufferDescriptors[i].Buffers[j] = &words[0]
+		}
+	}
+
+	d.buffers = append([]BufferInfo(nil), bufferDescriptors...)
+	d.bufferSize = bufferSize
+	d.callbacks = callbacks
+	d.scratch = make([]float32, bufferSize)
+	d.loop = make(map[int][]float32)
+	if d.Loopback {
+		for _, desc := range bufferDescriptors {
+			if !desc.IsInput {
+				d.loop[desc.Channel] = make([]float32, d.loopbackDelay()+bufferSize)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *SimDriver) DisposeBuffers() (err error) {
+	if err = d.Faults.call("DisposeBuffers"); err != nil {
+		return err
+	}
+	d.halt()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.buffers == nil {
+		return ErrorInvalidMode
+	}
+	d.buffers = nil
+	d.memory = nil
+	d.loop = nil
+	d.bufferSize = 0
+	d.callbacks = Callbacks{}
+	return nil
+}
+
+func (d *SimDriver) Start() (err error) {
+	if err = d.Faults.call("Start"); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.buffers == nil {
+		return ErrorInvalidMode
+	}
+	if d.running {
+		return nil
+	}
+	d.running = true
+	d.index = 0
+	d.position = 0
+	d.started = time.Now()
+
+	if !d.Manual {
+		period := time.Duration(float64(d.bufferSize) / d.clockRate() * float64(time.Second))
+		d.pacing = startPacer(func(p *pacer) { d.run(p, period) })
+	}
+	return nil
+}
+
+func (d *SimDriver) Stop() (err error) {
+	if err = d.Faults.call("Stop"); err != nil {
+		return err
+	}
+	d.halt()
+	return nil
+}
+
+// Stops the buffer switches, without the faults Stop may be scripted with.
+func (d *SimDriver) halt() {
+	d.mu.Lock()
+	if !d.running {
+		d.mu.Unlock()
+		return
+	}
+	d.running = false
+	pacing := d.pacing
+	d.pacing = nil
+	d.mu.Unlock()
+
+	if pacing != nil {
+		pacing.halt()
+	}
+}
+
+func (d *SimDriver) Future(selector int32, opt unsafe.Pointer) (err error) {
+	if err = d.Faults.call("Future"); err != nil {
+		return err
+	}
+	switch selector {
+	case AsioCanDoIoFormat, AsioSetIoFormat, AsioGetIoFormat:
+		if !d.SupportsDSD {
+			return ErrorNotPresent
+		}
+		return d.ioFormatFuture(selector, (*IoFormat)(opt))
+	case AsioCanTimeCode, AsioEnableTimeCodeRead, AsioDisableTimeCodeRead:
+		if !d.SupportsTimeCode {
+			return ErrorNotPresent
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if selector != AsioCanTimeCode {
+			d.timeCodeRead = selector == AsioEnableTimeCodeRead
+		}
+		return nil
+	}
+	return ErrorNotPresent
+}
+
+func (d *SimDriver) ioFormatFuture(selector int32, f *IoFormat) error {
+	if f == nil {
+		return ErrorInvalidParameter
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch selector {
+	case AsioGetIoFormat:
+		f.FormatType = d.ioFormat
+		return nil
+	case AsioCanDoIoFormat:
+		if f.FormatType != PCMFormat && f.FormatType != DSDFormat {
+			return ErrorNotPresent
+		}
+		return nil
+	}
+
+	if f.FormatType != PCMFormat && f.FormatType != DSDFormat {
+		return ErrorInvalidParameter
+	}
+	if d.buffers != nil {
+		return ErrorInvalidMode
+	}
+	if f.FormatType != d.ioFormat {
+		d.ioFormat = f.FormatType
+		d.sampleRate = 44100
+		if f.FormatType == DSDFormat {
+			d.sampleRate = DSD64Rate
+		}
+	}
+	return nil
+}
+
+func (d *SimDriver) run(p *pacer, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.step(d.Step)
+		}
+	}
+}
+
+// Performs one buffer switch: fills the input buffers, calls the host back and advances the sample position.
+func (d *SimDriver) Step() (err error) {
+	d.switchMu.Lock()
+	defer d.switchMu.Unlock()
+
+	d.mu.Lock()
+	if !d.running {
+		d.mu.Unlock()
+		return ErrorInvalidMode
+	}
+	faults := d.Faults.bufferSwitch()
+	rateChanged := faults.rate != 0 && faults.rate != d.sampleRate
+	if rateChanged {
+		// Keep the system time continuous across the change.
+		elapsed := float64(d.position) / d.clockRate()
+		d.sampleRate = faults.rate
+		d.started = d.started.Add(time.Duration((elapsed - float64(d.position)/d.clockRate()) * 1e9))
+	}
+	cb := d.callbacks
+	d.mu.Unlock()
+
+	if rateChanged && cb.SampleRateDidChange != nil {
+		cb.SampleRateDidChange(faults.rate)
+	}
+	for _, selector := range faults.messages {
+		if cb.Message != nil {
+			cb.Message(selector, 0, 0, nil)
+		}
+	}
+	if faults.delay > 0 {
+		time.Sleep(faults.delay)
+	}
+
+	d.mu.Lock()
+	if !d.running {
+		// The host stopped in answer to a message.
+		d.mu.Unlock()
+		return nil
+	}
+	if faults.drop {
+		d.index ^= 1
+		d.position += int64(d.bufferSize)
+		d.mu.Unlock()
+		return nil
+	}
+	index, pos, cb, timeCode := d.index, d.position, d.callbacks, d.timeCodeRead
+	st := d.sampleType()
+	params := ASIOTime{TimeInfo: TimeInfo{
+		Speed:          1,
+		SystemTime:     d.systemTime(pos),
+		SamplePosition: pos,
+		SampleRate:     d.sampleRate,
+		Flags:          SystemTimeValid | SamplePositionValid | SampleRateValid | SpeedValid,
+	}}
+	for i, buf := range d.buffers {
+		if !buf.IsInput {
+			continue
+		}
+		clear(d.scratch)
+		if d.Input != nil {
+			d.Input(buf.Channel, pos, d.scratch)
+		}
+		if ring, ok := d.loop[buf.Channel]; ok {
+			delay := int64(d.loopbackDelay())
+			for j := range d.scratch {
+				if src := pos + int64(j) - delay; src >= 0 {
+					d.scratch[j] += ring[src%int64(len(ring))]
+				}
+			}
+		}
+		encodeSamples(d.memory[i][index], d.scratch, st)
+		if d.RawInput != nil {
+			d.RawInput(buf.Channel, pos, d.memory[i][index])
+		}
+	}
+	d.mu.Unlock()
+
+	if timeCode && cb.Message != nil && cb.Message(AsioSupportsTimeCode, 0, 0, nil) == 1 {
+		params.TimeCode = TimeCode{
+			Speed:           1,
+			TimeCodeSamples: d.TimeCodeStart + pos,
+			Flags:           TcValid | TcRunning | TcOnspeed | TcSpeedValid,
+		}
+	}
+	if cb.BufferSwitchTimeInfo != nil {
+		cb.BufferSwitchTimeInfo(&params, int32(index), true)
+	} else if cb.BufferSwitch != nil {
+		cb.BufferSwitch(index, true)
+	}
+
+	d.mu.Lock()
+	for i, buf := range d.buffers {
+		if !buf.IsInput && d.RawOutput != nil {
+			d.RawOutput(buf.Channel, pos, d.memory[i][index])
+		}
+		ring, ok := d.loop[buf.Channel]
+		if buf.IsInput || !ok {
+			continue
+		}
+		decodeSamples(d.scratch, d.memory[i][index], st)
+		for j, x := range d.scratch {
+			ring[(pos+int64(j))%int64(len(ring))] = x
+		}
+	}
+	d.index ^= 1
+	d.position += int64(d.bufferSize)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *SimDriver) loopbackDelay() int {
+	return max(d.LoopbackDelay, d.bufferSize)
+}
+
+// Sends an asioMessage to the host, as a driver would to request a reset or report an overload.
+func (d *SimDriver) Message(selector, value int32) int32 {
+	d.mu.Lock()
+	cb := d.callbacks.Message
+	d.mu.Unlock()
+
+	if cb == nil {
+		return 0
+	}
+	return cb(selector, value, 0, nil)
+}
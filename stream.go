@@ -0,0 +1,473 @@
+package asio
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// A Block is one buffer switch worth of audio converted to float32. In and Out are indexed like the stream's
+// Inputs and Outputs. Out starts zeroed on every buffer switch and is written back to the driver after all
+// processors have run. A Block is only valid for the duration of the Process call.
+type Block struct {
+	In  [][]float32
+	Out [][]float32
+
+	Frames            int
+	SampleRate        float64
+	SamplePosition    int64 // sample position of the first frame
+	Time              *ASIOTime
+	DoubleBufferIndex int
+
+	rawIn  [][]byte
+	rawOut [][]byte
+}
+
+// Returns the driver's buffer for input i in its native SampleType. This is how DSD channels, which have no
+// float representation, are read.
+func (b *Block) RawInput(i int) []byte { return b.rawIn[i] }
+
+// Returns the driver's buffer for output i in its native SampleType. PCM outputs are overwritten from Out after
+// the processors run; DSD outputs start as DSD silence and are left as the processors wrote them.
+func (b *Block) RawOutput(i int) []byte { return b.rawOut[i] }
+
+// A Processor is called on the driver's thread once per buffer switch. It must not block.
+type Processor interface {
+	Process(b *Block)
+}
+
+// ProcessorFunc adapts a function to a Processor.
+type ProcessorFunc func(b *Block)
+
+func (f ProcessorFunc) Process(b *Block) { f(b) }
+
+type StreamOptions struct {
+	Inputs     []int   // input channel indices to open; nil opens every input
+	Outputs    []int   // output channel indices to open; nil opens every output
+	BufferSize int     // 0 uses the driver's preferred buffer size
+	SampleRate float64 // 0 keeps the driver's current sample rate
+	DSD        bool    // switch the driver to DSD mode with AsioSetIoFormat first
+	TimeCode   bool    // have the driver read timecode into each Block's Time with AsioEnableTimeCodeRead
+}
+
+// Stream is a high-level wrapper around a Driver's buffers. It converts the selected channels to and from
+// float32 and runs processors over them on every buffer switch.
+type Stream struct {
+	drv Driver
+
+	inputs  []*ChannelInfo
+	outputs []*ChannelInfo
+	buffers []BufferInfo // inputs followed by outputs
+	raw     [][2][]byte  // byte views of buffers
+
+	bufferSize  int
+	sampleRate  atomic.Uint64 // math.Float64bits
+	outputReady bool
+	timeCode    bool
+	dsd         bool
+	prevFormat  IoFormatType // the driver's format before it was switched to DSD
+
+	block      Block
+	dithers    []atomic.Pointer[ditherer] // per output
+	processors atomic.Pointer[[]Processor]
+	taps       atomic.Pointer[[]Processor]
+
+	position       atomic.Int64
+	overloads      atomic.Int64
+	latencyChanges atomic.Int64
+	resetRequested atomic.Bool
+
+	mu      sync.Mutex
+	running bool
+	created bool
+
+	stopContext func() bool // stops closing the stream when its context is done
+	closeOnce   sync.Once
+	closeErr    error
+	done        chan struct{}
+}
+
+// Creates buffers for the selected channels of drv. The driver must already be initialized.
+func NewStream(drv Driver, opts StreamOptions) (s *Stream, err error) {
+	return NewStreamContext(context.Background(), drv, opts)
+}
+
+// Like NewStream, but the stream is closed, stopped and its buffers disposed, once ctx is done. Done tells when
+// that has happened, and Close then returns what went wrong. Nothing is created if ctx is already done.
+func NewStreamContext(ctx context.Context, drv Driver, opts StreamOptions) (s *Stream, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	s = &Stream{drv: drv, done: make(chan struct{})}
+	// On failure, switch the driver back; s itself is nil by then.
+	defer func(s *Stream) {
+		if err != nil {
+			s.restoreDriver()
+		}
+	}(s)
+
+	if opts.DSD {
+		if s.prevFormat, err = GetIoFormat(drv); err != nil || s.prevFormat == FormatInvalid {
+			s.prevFormat = PCMFormat
+		}
+		if err = SetIoFormat(drv, DSDFormat); err != nil {
+			return nil, err
+		}
+		s.dsd = true
+	}
+	if opts.TimeCode {
+		if err = drv.Future(AsioCanTimeCode, nil); err != nil {
+			return nil, err
+		}
+		if err = drv.Future(AsioEnableTimeCodeRead, nil); err != nil {
+			return nil, err
+		}
+		s.timeCode = true
+	}
+	if opts.SampleRate != 0 {
+		if err = drv.SetSampleRate(opts.SampleRate); err != nil {
+			return nil, err
+		}
+	}
+	rate, err := drv.GetSampleRate()
+	if err != nil {
+		return nil, err
+	}
+	s.sampleRate.Store(math.Float64bits(rate))
+
+	numIn, numOut, err := drv.GetChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.inputs, err = channelInfos(drv, opts.Inputs, numIn, true); err != nil {
+		return nil, err
+	}
+	if s.outputs, err = channelInfos(drv, opts.Outputs, numOut, false); err != nil {
+		return nil, err
+	}
+
+	minSize, maxSize, preferredSize, _, err := drv.GetBufferSize()
+	if err != nil {
+		return nil, err
+	}
+	s.bufferSize = preferredSize
+	if opts.BufferSize != 0 {
+		if opts.BufferSize < minSize || opts.BufferSize > maxSize {
+			return nil, ErrorInvalidParameter
+		}
+		s.bufferSize = opts.BufferSize
+	}
+
+	s.buffers = make([]BufferInfo, 0, len(s.inputs)+len(s.outputs))
+	for _, info := range s.inputs {
+		s.buffers = append(s.buffers, BufferInfo{Channel: info.Channel, IsInput: true})
+	}
+	for _, info := range s.outputs {
+		s.buffers = append(s.buffers, BufferInfo{Channel: info.Channel, IsInput: false})
+	}
+
+	err = drv.CreateBuffers(s.buffers, s.bufferSize, Callbacks{
+		BufferSwitch:         s.bufferSwitch,
+		SampleRateDidChange:  s.sampleRateDidChange,
+		Message:              s.message,
+		BufferSwitchTimeInfo: s.bufferSwitchTimeInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.created = true
+
+	s.raw = make([][2][]byte, len(s.buffers))
+	for i, buf := range s.buffers {
+		size := s.bufferSize * s.channel(i).SampleType.BytesPerSample()
+		s.raw[i] = [2][]byte{bufferBytes(buf.Buffers[0], size), bufferBytes(buf.Buffers[1], size)}
+	}
+
+	s.block.In = makeFloatBuffers(len(s.inputs), s.bufferSize)
+	s.block.Out = makeFloatBuffers(len(s.outputs), s.bufferSize)
+	s.block.Frames = s.bufferSize
+	s.block.rawIn = make([][]byte, len(s.inputs))
+	s.block.rawOut = make([][]byte, len(s.outputs))
+	s.dithers = make([]atomic.Pointer[ditherer], len(s.outputs))
+
+	// The host should only call outputReady() if the driver supports it:
+	s.outputReady = drv.OutputReady()
+
+	s.stopContext = context.AfterFunc(ctx, func() { s.Close() })
+	return s, nil
+}
+
+func channelInfos(drv Driver, channels []int, count int, isInput bool) (infos []*ChannelInfo, err error) {
+	if channels == nil {
+		channels = make([]int, count)
+		for i := range channels {
+			channels[i] = i
+		}
+	}
+
+	infos = make([]*ChannelInfo, 0, len(channels))
+	for _, ch := range channels {
+		if ch < 0 || ch >= count {
+			return nil, ErrorInvalidParameter
+		}
+		info, err := drv.GetChannelInfo(ch, isInput)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func makeFloatBuffers(channels, frames int) [][]float32 {
+	bufs := make([][]float32, channels)
+	for i := range bufs {
+		bufs[i] = make([]float32, frames)
+	}
+	return bufs
+}
+
+// Returns the ChannelInfo for the i'th entry of s.buffers.
+func (s *Stream) channel(i int) *ChannelInfo {
+	if i < len(s.inputs) {
+		return s.inputs[i]
+	}
+	return s.outputs[i-len(s.inputs)]
+}
+
+func (s *Stream) Driver() Driver { return s.drv }
+
+// The opened input channels, in the order of Block.In.
+func (s *Stream) Inputs() []*ChannelInfo { return s.inputs }
+
+// The opened output channels, in the order of Block.Out.
+func (s *Stream) Outputs() []*ChannelInfo { return s.outputs }
+
+func (s *Stream) BufferSize() int { return s.bufferSize }
+
+func (s *Stream) SampleRate() float64 { return math.Float64frombits(s.sampleRate.Load()) }
+
+// The sample position following the most recently processed buffer.
+func (s *Stream) Position() int64 { return s.position.Load() }
+
+// The number of overloads the driver has reported.
+func (s *Stream) Overloads() int64 { return s.overloads.Load() }
+
+// Reports whether the driver has asked to be reset since the stream was created.
+func (s *Stream) ResetRequested() bool { return s.resetRequested.Load() }
+
+// The number of times the driver has reported that its latencies changed; call Latencies for the new ones.
+func (s *Stream) LatencyChanges() int64 { return s.latencyChanges.Load() }
+
+func (s *Stream) Latencies() (inputLatency, outputLatency int, err error) {
+	return s.drv.GetLatencies()
+}
+
+// Appends p to the processors that run on every buffer switch. Processors run in the order they were added
+// and may read In and read or write Out.
+func (s *Stream) AddProcessor(p Processor) { appendProcessor(&s.processors, p) }
+
+// Appends p to the taps. Taps run after every processor and must treat the Block as read-only.
+func (s *Stream) AddTap(p Processor) { appendProcessor(&s.taps, p) }
+
+// Removes p from the processors. p must be comparable, e.g. a pointer.
+func (s *Stream) RemoveProcessor(p Processor) { removeProcessor(&s.processors, p) }
+
+// Removes p from the taps. p must be comparable, e.g. a pointer.
+func (s *Stream) RemoveTap(p Processor) { removeProcessor(&s.taps, p) }
+
+// Copy-on-write so the driver thread never takes a lock.
+func appendProcessor(list *atomic.Pointer[[]Processor], p Processor) {
+	for {
+		old := list.Load()
+		var next []Processor
+		if old != nil {
+			next = append(next, (*old)...)
+		}
+		next = append(next, p)
+		if list.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+func removeProcessor(list *atomic.Pointer[[]Processor], p Processor) {
+	for {
+		old := list.Load()
+		if old == nil {
+			return
+		}
+		next := make([]Processor, 0, len(*old))
+		for _, q := range *old {
+			if q != p {
+				next = append(next, q)
+			}
+		}
+		if list.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+func (s *Stream) Start() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return nil
+	}
+	if !s.created {
+		return ErrorInvalidMode // closed
+	}
+	if err = s.drv.Start(); err != nil {
+		return err
+	}
+	s.running = true
+	return nil
+}
+
+func (s *Stream) Stop() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return nil
+	}
+	s.running = false
+	return s.drv.Stop()
+}
+
+// Stops the stream and disposes its buffers, even if stopping fails, then switches off timecode reading and
+// switches the driver back to the IO format it had, where the stream switched them. The driver itself stays
+// open. It returns the errors of every step, joined; closing again only returns them again.
+func (s *Stream) Close() (err error) {
+	s.closeOnce.Do(func() {
+		s.stopContext()
+		stopErr := s.Stop()
+
+		s.mu.Lock()
+		var disposeErr error
+		if s.created {
+			s.created = false
+			disposeErr = s.drv.DisposeBuffers()
+		}
+		s.mu.Unlock()
+
+		s.closeErr = errors.Join(stopErr, disposeErr, s.restoreDriver())
+		close(s.done)
+	})
+	return s.closeErr
+}
+
+// Switches off what NewStreamContext switched on in the driver: timecode reading, and DSD. Called once the
+// buffers are gone.
+func (s *Stream) restoreDriver() error {
+	var errs []error
+	if s.timeCode {
+		errs = append(errs, s.drv.Future(AsioDisableTimeCodeRead, nil))
+	}
+	if s.dsd {
+		errs = append(errs, SetIoFormat(s.drv, s.prevFormat))
+	}
+	return errors.Join(errs...)
+}
+
+// Returns a channel that is closed once the stream has been closed, by Close or by its context.
+func (s *Stream) Done() <-chan struct{} { return s.done }
+
+func (s *Stream) bufferSwitch(doubleBufferIndex int, directProcess bool) {
+	var params ASIOTime
+	s.process(&params, doubleBufferIndex)
+}
+
+func (s *Stream) bufferSwitchTimeInfo(params *ASIOTime, doubleBufferIndex int32, directProcess bool) *ASIOTime {
+	s.process(params, int(doubleBufferIndex))
+	return params
+}
+
+func (s *Stream) process(params *ASIOTime, index int) {
+	if params.TimeInfo.Flags&SamplePositionValid == 0 {
+		// Old-style bufferSwitch; ask the driver where we are.
+		if pos, sysTime, err := s.drv.GetSamplePosition(); err == nil {
+			params.TimeInfo.SamplePosition = pos
+			params.TimeInfo.SystemTime = sysTime
+			params.TimeInfo.Flags |= SamplePositionValid | SystemTimeValid
+		} else {
+			params.TimeInfo.SamplePosition = s.position.Load()
+		}
+	}
+
+	b := &s.block
+	b.SampleRate = s.SampleRate()
+	b.SamplePosition = params.TimeInfo.SamplePosition
+	b.Time = params
+	b.DoubleBufferIndex = index
+
+	for i, info := range s.inputs {
+		b.rawIn[i] = s.raw[i][index]
+		decodeSamples(b.In[i], b.rawIn[i], info.SampleType)
+	}
+	for i, info := range s.outputs {
+		b.rawOut[i] = s.raw[len(s.inputs)+i][index]
+		clear(b.Out[i])
+		if info.SampleType.IsDSD() {
+			fillDSDSilence(b.rawOut[i], info.SampleType)
+		}
+	}
+
+	if list := s.processors.Load(); list != nil {
+		for _, p := range *list {
+			p.Process(b)
+		}
+	}
+	if list := s.taps.Load(); list != nil {
+		for _, p := range *list {
+			p.Process(b)
+		}
+	}
+
+	for i, info := range s.outputs {
+		encodeDithered(b.rawOut[i], b.Out[i], info.SampleType, s.dithers[i].Load())
+	}
+
+	s.position.Store(b.SamplePosition + int64(b.Frames))
+	if s.outputReady {
+		s.drv.OutputReady()
+	}
+}
+
+func (s *Stream) sampleRateDidChange(rate float64) {
+	s.sampleRate.Store(math.Float64bits(rate))
+}
+
+func (s *Stream) message(selector, value int32, message uintptr, opt *float64) int32 {
+	switch selector {
+	case AsioSelectorSupported:
+		switch value {
+		case AsioResetRequest, AsioEngineVersion, AsioResyncRequest, AsioLatenciesChanged,
+			AsioSupportsTimeInfo, AsioSupportsTimeCode, AsioOverload:
+			return 1
+		}
+	case AsioEngineVersion:
+		return 2
+	case AsioResetRequest:
+		s.resetRequested.Store(true)
+		return 1
+	case AsioLatenciesChanged:
+		s.latencyChanges.Add(1)
+		return 1
+	case AsioResyncRequest, AsioSupportsTimeInfo:
+		return 1
+	case AsioSupportsTimeCode:
+		if s.timeCode {
+			return 1
+		}
+	case AsioOverload:
+		s.overloads.Add(1)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package asio
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreamContext(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := NewStreamContext(ctx, drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "buffer switches", func() bool { return s.Position() > 0 })
+
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the stream was not closed")
+	}
+	drv.mu.Lock()
+	running, buffers := drv.running, drv.buffers
+	drv.mu.Unlock()
+	if running || buffers != nil {
+		t.Errorf("driver left running %v, with buffers %v", running, buffers)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close after cancelling: %v", err)
+	}
+	if err := s.Start(); err != ErrorInvalidMode {
+		t.Errorf("Start after closing: %v", err)
+	}
+
+	// Nothing is created under a context that is already done.
+	if _, err := NewStreamContext(ctx, drv, StreamOptions{}); err != context.Canceled {
+		t.Errorf("cancelled context: %v", err)
+	}
+	if err := drv.Start(); err != ErrorInvalidMode {
+		t.Errorf("the driver has buffers: %v", err)
+	}
+}
+
+func TestStreamCloseErrors(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Manual = true
+	drv.Faults = NewFaultScenario().
+		FailCalls("Stop", ErrorHWMalfunction, 0, 1).
+		FailCalls("DisposeBuffers", ErrorInvalidMode, 0, 1)
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Close()
+	if !errors.Is(err, ErrorHWMalfunction) || !errors.Is(err, ErrorInvalidMode) {
+		t.Fatalf("Close: %v, want both teardown errors", err)
+	}
+	if again := s.Close(); again != err {
+		t.Errorf("closing again: %v", again)
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done is open after Close")
+	}
+}
+
+func TestStreamPartialFailure(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	drv.Faults = NewFaultScenario().FailCalls("CreateBuffers", ErrorHWMalfunction, 0, 1)
+	if _, err := NewStream(drv, StreamOptions{}); err != ErrorHWMalfunction {
+		t.Fatalf("NewStream: %v", err)
+	}
+
+	// The driver is left as it was, so a second attempt works.
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+}
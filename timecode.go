@@ -0,0 +1,298 @@
+package asio
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// FrameRate is a SMPTE timecode frame rate.
+type FrameRate int
+
+const (
+	FrameRate24     FrameRate = iota // film
+	FrameRate25                      // PAL
+	FrameRate2997DF                  // NTSC, 30000/1001 fps, drop frame
+	FrameRate2997                    // NTSC, 30000/1001 fps, non-drop frame
+	FrameRate30
+)
+
+// Returns the real number of frames per second.
+func (r FrameRate) FPS() float64 {
+	switch r {
+	case FrameRate24:
+		return 24
+	case FrameRate25:
+		return 25
+	case FrameRate2997DF, FrameRate2997:
+		return 30000.0 / 1001
+	}
+	return 30
+}
+
+// Returns the number of frame labels per second.
+func (r FrameRate) Nominal() int {
+	switch r {
+	case FrameRate24:
+		return 24
+	case FrameRate25:
+		return 25
+	}
+	return 30
+}
+
+// Reports whether the rate skips frame labels 0 and 1 at the start of every minute not divisible by ten.
+func (r FrameRate) DropFrame() bool { return r == FrameRate2997DF }
+
+func (r FrameRate) String() string {
+	switch r {
+	case FrameRate24:
+		return "24"
+	case FrameRate25:
+		return "25"
+	case FrameRate2997DF:
+		return "29.97DF"
+	case FrameRate2997:
+		return "29.97"
+	}
+	return "30"
+}
+
+// Timecode is an SMPTE timecode label, HH:MM:SS:FF, at a frame rate.
+type Timecode struct {
+	Hours, Minutes, Seconds, Frames int
+	Rate                            FrameRate
+}
+
+const framesPerDay = 24 * 60 * 60
+
+// Returns the timecode of frame n counted from 00:00:00:00, wrapping at 24 hours.
+func TimecodeFromFrame(n int64, rate FrameRate) Timecode {
+	fps := int64(rate.Nominal())
+	if rate.DropFrame() {
+		// Real frames per 24 hours, less two labels a minute except every tenth minute.
+		const per10Min, perMin = 17982, 1798
+		day := int64(framesPerDay*30 - 2*(24*60-24*6))
+		n %= day
+		if n < 0 {
+			n += day
+		}
+		tens, rem := n/per10Min, n%per10Min
+		n += 18 * tens
+		if rem > 1 {
+			n += 2 * ((rem - 2) / perMin)
+		}
+	} else {
+		day := framesPerDay * fps
+		n %= day
+		if n < 0 {
+			n += day
+		}
+	}
+	return Timecode{
+		Hours:   int(n / (3600 * fps)),
+		Minutes: int(n / (60 * fps) % 60),
+		Seconds: int(n / fps % 60),
+		Frames:  int(n % fps),
+		Rate:    rate,
+	}
+}
+
+// Returns the timecode of the frame playing at a time given in samples from 00:00:00:00.
+func TimecodeFromSamples(samples int64, sampleRate float64, rate FrameRate) Timecode {
+	return TimecodeFromFrame(int64(math.Floor(float64(samples)*rate.FPS()/sampleRate)), rate)
+}
+
+// Returns the number of frames since 00:00:00:00.
+func (tc Timecode) Frame() int64 {
+	fps := int64(tc.Rate.Nominal())
+	n := (int64(tc.Hours)*3600+int64(tc.Minutes)*60+int64(tc.Seconds))*fps + int64(tc.Frames)
+	if tc.Rate.DropFrame() {
+		minutes := int64(tc.Hours)*60 + int64(tc.Minutes)
+		n -= 2 * (minutes - minutes/10)
+	}
+	return n
+}
+
+// Returns the time of the start of the frame in samples from 00:00:00:00.
+func (tc Timecode) Samples(sampleRate float64) int64 {
+	return int64(math.Round(float64(tc.Frame()) * sampleRate / tc.Rate.FPS()))
+}
+
+// Returns the timecode n frames later.
+func (tc Timecode) Add(n int64) Timecode { return TimecodeFromFrame(tc.Frame()+n, tc.Rate) }
+
+// Reports whether the fields are in range and, for drop frame, not a skipped label.
+func (tc Timecode) Valid() bool {
+	if tc.Hours < 0 || tc.Hours > 23 || tc.Minutes < 0 || tc.Minutes > 59 || tc.Seconds < 0 || tc.Seconds > 59 ||
+		tc.Frames < 0 || tc.Frames >= tc.Rate.Nominal() {
+		return false
+	}
+	return !tc.Rate.DropFrame() || tc.Seconds != 0 || tc.Frames > 1 || tc.Minutes%10 == 0
+}
+
+// Formats the timecode as HH:MM:SS:FF, or HH:MM:SS;FF for drop frame.
+func (tc Timecode) String() string {
+	sep := ':'
+	if tc.Rate.DropFrame() {
+		sep = ';'
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%02d", tc.Hours, tc.Minutes, tc.Seconds, sep, tc.Frames)
+}
+
+// Parses HH:MM:SS:FF, with any of ':', ';' or '.' before the frames, at the given rate.
+func ParseTimecode(s string, rate FrameRate) (tc Timecode, err error) {
+	var sep rune
+	tc.Rate = rate
+	if _, err = fmt.Sscanf(s, "%2d:%2d:%2d%c%2d", &tc.Hours, &tc.Minutes, &tc.Seconds, &sep, &tc.Frames); err != nil {
+		return Timecode{}, fmt.Errorf("bad timecode %q: %v", s, err)
+	}
+	if (sep != ':' && sep != ';' && sep != '.') || !tc.Valid() {
+		return Timecode{}, fmt.Errorf("bad timecode %q", s)
+	}
+	return tc, nil
+}
+
+// Chase follows an external timecode, either the driver's own (see StreamOptions.TimeCode) or LTC on an input,
+// and relates it to the stream's sample positions. It locks once a few readings in a row agree with each other,
+// and loses lock when the timecode jumps or stops arriving for half a second.
+type Chase struct {
+	stream     *Stream
+	rate       FrameRate
+	sampleRate float64
+
+	// Only touched on the driver thread:
+	decoder *LTCDecoder // nil when chasing the driver's timecode
+	input   int
+	last    chaseReading
+	agreed  int
+	speed   float64
+
+	mu    sync.Mutex // guards state; the driver thread only ever tries to take it
+	state chaseState
+}
+
+type chaseReading struct {
+	valid    bool
+	samples  float64 // timecode, in samples from 00:00:00:00
+	position int64   // stream sample position it was read at
+}
+
+type chaseState struct {
+	chaseReading
+	locked bool
+	speed  float64
+}
+
+// Readings this many in a row that agree to within a quarter frame lock the chase.
+const chaseLockReadings = 4
+
+// Chases the driver's timecode. The stream must have been opened with StreamOptions.TimeCode, or there is no
+// timecode to chase and NewChase returns ErrorInvalidMode.
+func NewChase(s *Stream, rate FrameRate) (*Chase, error) {
+	if !s.timeCode {
+		return nil, ErrorInvalidMode
+	}
+	c := &Chase{stream: s, rate: rate, sampleRate: s.SampleRate(), speed: 1}
+	s.AddTap(c)
+	return c, nil
+}
+
+// Chases LTC on input, an index into the stream's Inputs.
+func NewLTCChase(s *Stream, input int, rate FrameRate) (*Chase, error) {
+	if input < 0 || input >= len(s.Inputs()) {
+		return nil, ErrorInvalidParameter
+	}
+	c := &Chase{stream: s, rate: rate, sampleRate: s.SampleRate(), speed: 1, input: input}
+	c.decoder = NewLTCDecoder(s.SampleRate(), rate)
+	s.AddTap(c)
+	return c, nil
+}
+
+func (c *Chase) Process(b *Block) {
+	if c.decoder != nil {
+		for _, f := range c.decoder.Decode(b.In[c.input], b.SamplePosition) {
+			c.observe(float64(f.Timecode.Samples(c.sampleRate)), f.Position, 0)
+		}
+	} else if tc := b.Time.TimeCode; tc.Flags&TcValid != 0 {
+		speed := 0.0
+		if tc.Flags&TcSpeedValid != 0 {
+			speed = tc.Speed
+		}
+		if tc.Flags&TcRunning == 0 || tc.Flags&TcStill != 0 {
+			speed = 0
+		} else if speed == 0 {
+			speed = 1
+		}
+		c.observe(float64(tc.TimeCodeSamples), b.SamplePosition, speed)
+	}
+	c.publish()
+}
+
+// Takes a reading of the timecode at a sample position. speed is 0 if the source does not say.
+func (c *Chase) observe(samples float64, pos int64, speed float64) {
+	tolerance := c.sampleRate / c.rate.FPS() / 4
+	if c.last.valid && pos > c.last.position {
+		elapsed := float64(pos - c.last.position)
+		measured := (samples - c.last.samples) / elapsed
+		if math.Abs(samples-(c.last.samples+elapsed*c.speed)) <= tolerance {
+			c.agreed++
+		} else {
+			c.agreed = 0
+		}
+		switch {
+		case speed != 0:
+			c.speed = speed
+		case c.agreed > 0:
+			c.speed += (measured - c.speed) / 8
+		default:
+			c.speed = measured
+		}
+	}
+	c.last = chaseReading{valid: true, samples: samples, position: pos}
+}
+
+func (c *Chase) publish() {
+	if !c.mu.TryLock() {
+		return
+	}
+	c.state = chaseState{chaseReading: c.last, locked: c.agreed >= chaseLockReadings-1, speed: c.speed}
+	c.mu.Unlock()
+}
+
+func (c *Chase) current() (chaseState, bool) {
+	c.mu.Lock()
+	st := c.state
+	c.mu.Unlock()
+	fresh := st.valid && float64(c.stream.Position()-st.position) < c.sampleRate/2
+	return st, fresh
+}
+
+// Reports whether the chase is locked to a running timecode.
+func (c *Chase) Locked() bool {
+	st, fresh := c.current()
+	return fresh && st.locked
+}
+
+// Returns the timecode at the stream's current position, and whether the chase is locked.
+func (c *Chase) Timecode() (Timecode, bool) {
+	st, fresh := c.current()
+	if !st.valid {
+		return Timecode{Rate: c.rate}, false
+	}
+	samples := st.samples + float64(c.stream.Position()-st.position)*st.speed
+	return TimecodeFromSamples(int64(math.Floor(samples+0.5)), c.sampleRate, c.rate), fresh && st.locked
+}
+
+// Returns the timecode in samples minus the stream's sample position at the latest reading: what to add to a
+// sample position to get the timecode there, at nominal speed.
+func (c *Chase) Offset() int64 {
+	st, _ := c.current()
+	return int64(math.Round(st.samples)) - st.position
+}
+
+// Returns the speed of the timecode relative to the stream's clock: 1 when they run together, 0 when stopped.
+func (c *Chase) Speed() float64 {
+	st, _ := c.current()
+	return st.speed
+}
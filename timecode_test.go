@@ -0,0 +1,148 @@
+package asio
+
+import "testing"
+
+func TestTimecodeFrames(t *testing.T) {
+	tests := []struct {
+		frame int64
+		rate  FrameRate
+		want  string
+	}{
+		{0, FrameRate25, "00:00:00:00"},
+		{25*3600 + 24, FrameRate25, "01:00:00:24"},
+		{24 * 86400, FrameRate24, "00:00:00:00"},
+		{1799, FrameRate2997DF, "00:00:59;29"},
+		{1800, FrameRate2997DF, "00:01:00;02"},
+		{17982, FrameRate2997DF, "00:10:00;00"},
+		{17982*6 - 1, FrameRate2997DF, "00:59:59;29"},
+		{107892, FrameRate2997DF, "01:00:00;00"},
+		{1800, FrameRate2997, "00:01:00:00"},
+		{-1, FrameRate30, "23:59:59:29"},
+	}
+	for _, test := range tests {
+		tc := TimecodeFromFrame(test.frame, test.rate)
+		if tc.String() != test.want {
+			t.Errorf("frame %d at %v = %v, want %v", test.frame, test.rate, tc, test.want)
+		}
+		parsed, err := ParseTimecode(test.want, test.rate)
+		if err != nil || parsed != tc {
+			t.Errorf("ParseTimecode(%q) = %v, %v", test.want, parsed, err)
+		}
+	}
+
+	for rate := FrameRate24; rate <= FrameRate30; rate++ {
+		day := TimecodeFromFrame(0, rate).Add(-1).Frame() + 1
+		for n := int64(0); n < 2*day; n += 997 {
+			if tc := TimecodeFromFrame(n, rate); !tc.Valid() || tc.Frame() != n%day {
+				t.Fatalf("frame %d at %v: %v is frame %d", n, rate, tc, tc.Frame())
+			}
+		}
+	}
+
+	if _, err := ParseTimecode("00:01:00;01", FrameRate2997DF); err == nil {
+		t.Error("parsed a dropped frame label")
+	}
+	if _, err := ParseTimecode("00:00:00:25", FrameRate25); err == nil {
+		t.Error("parsed frame 25 at 25 fps")
+	}
+}
+
+func TestTimecodeSamples(t *testing.T) {
+	tc, _ := ParseTimecode("10:00:00;00", FrameRate2997DF)
+	samples := tc.Samples(48000)
+	if got := TimecodeFromSamples(samples, 48000, FrameRate2997DF); got != tc {
+		t.Errorf("TimecodeFromSamples(%d) = %v", samples, got)
+	}
+	if got := TimecodeFromSamples(samples-1, 48000, FrameRate2997DF); got != tc.Add(-1) {
+		t.Errorf("one sample earlier = %v", got)
+	}
+	// Drop frame keeps timecode within a frame of the clock: ten hours is 36000 seconds, give or take.
+	if seconds := float64(samples) / 48000; seconds < 35999.96 || seconds > 36000.04 {
+		t.Errorf("10:00:00;00 is %v seconds", seconds)
+	}
+}
+
+func TestChaseDriverTimecode(t *testing.T) {
+	start, _ := ParseTimecode("09:59:58:10", FrameRate25)
+	drv := NewSimDriver(0, 1)
+	drv.Manual = true
+	drv.SupportsTimeCode = true
+	drv.TimeCodeStart = start.Samples(48000)
+	s, err := NewStream(drv, StreamOptions{TimeCode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Start()
+	c, err := NewChase(s, FrameRate25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Locked() {
+		t.Error("locked before any timecode")
+	}
+	for i := 0; i < 600; i++ {
+		drv.Step()
+	}
+	tc, locked := c.Timecode()
+	want := TimecodeFromSamples(drv.TimeCodeStart+600*256, 48000, FrameRate25)
+	if !locked || tc != want || !c.Locked() {
+		t.Errorf("Timecode = %v, %v; want %v, locked", tc, locked, want)
+	}
+	if c.Offset() != drv.TimeCodeStart || c.Speed() != 1 {
+		t.Errorf("Offset = %d, Speed = %v", c.Offset(), c.Speed())
+	}
+}
+
+func TestTimecodeOptIn(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	if _, err := NewStream(drv, StreamOptions{TimeCode: true}); err != ErrorNotPresent {
+		t.Errorf("driver without timecode: %v", err)
+	}
+
+	// Without the option the host does not ask for timecode, so the driver leaves it out.
+	drv.SupportsTimeCode = true
+	drv.Future(AsioEnableTimeCodeRead, nil)
+	s := newTestStream(t, drv)
+	var flags TimeCodeFlags
+	s.AddTap(ProcessorFunc(func(b *Block) { flags |= b.Time.TimeCode.Flags }))
+	drv.Step()
+	if flags != 0 {
+		t.Errorf("timecode flags %v without the option", flags)
+	}
+	if _, err := NewChase(s, FrameRate25); err != ErrorInvalidMode {
+		t.Errorf("chasing a stream without timecode: %v", err)
+	}
+}
+
+func TestTimecodeReadDisabled(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	drv.Manual = true
+	drv.SupportsTimeCode = true
+	reading := func() bool {
+		drv.mu.Lock()
+		defer drv.mu.Unlock()
+		return drv.timeCodeRead
+	}
+
+	if _, err := NewStream(drv, StreamOptions{TimeCode: true, BufferSize: 1}); err != ErrorInvalidParameter {
+		t.Fatalf("a bad buffer size: %v", err)
+	}
+	if reading() {
+		t.Error("a stream that failed to open left timecode reading on")
+	}
+	s, err := NewStream(drv, StreamOptions{TimeCode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reading() {
+		t.Fatal("timecode reading not enabled")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if reading() {
+		t.Error("a closed stream left timecode reading on")
+	}
+}
@@ -0,0 +1,790 @@
+package asio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// A trace is a header followed by records, each prefixed with its length as a uvarint:
+//
+//	header  "ASIOTRACE" 0, version byte, start time in Unix nanoseconds varint, audio byte
+//	record  kind byte, name byte, call uvarint, time µs uvarint, duration µs uvarint,
+//	        args value, results value, error value, inputs value, outputs value
+//
+// Values are tagged with a byte: 'i' varint, 'f' float64 little-endian, 's' and 'b' uvarint length and the
+// bytes of a string or []byte, 'T' and 'F' for booleans, 'N' for nil, and 'l' uvarint count and the values
+// of a list. The name is an index into traceNames.
+const (
+	traceMagic   = "ASIOTRACE\x00"
+	traceVersion = 1
+)
+
+// TraceKind tells the calls a host makes on a driver from the callbacks a driver makes to the host.
+type TraceKind byte
+
+const (
+	TraceCall     TraceKind = 'C'
+	TraceCallback TraceKind = 'B'
+)
+
+// The methods of Driver, then the callbacks, by their ASIO names.
+var traceNames = []string{"Init", "GetDriverName", "GetDriverVersion", "GetErrorMessage", "Start", "Stop",
+	"GetChannels", "GetLatencies", "GetBufferSize", "CanSampleRate", "GetSampleRate", "SetSampleRate",
+	"GetClockSources", "SetClockSource", "GetSamplePosition", "GetChannelInfo", "CreateBuffers",
+	"DisposeBuffers", "ControlPanel", "Future", "OutputReady",
+	"bufferSwitch", "bufferSwitchTimeInfo", "sampleRateDidChange", "asioMessage"}
+
+type traceName byte
+
+const (
+	traceInit traceName = iota
+	traceGetDriverName
+	traceGetDriverVersion
+	traceGetErrorMessage
+	traceStart
+	traceStop
+	traceGetChannels
+	traceGetLatencies
+	traceGetBufferSize
+	traceCanSampleRate
+	traceGetSampleRate
+	traceSetSampleRate
+	traceGetClockSources
+	traceSetClockSource
+	traceGetSamplePosition
+	traceGetChannelInfo
+	traceCreateBuffers
+	traceDisposeBuffers
+	traceControlPanel
+	traceFuture
+	traceOutputReady
+	traceBufferSwitch
+	traceBufferSwitchTimeInfo
+	traceSampleRateDidChange
+	traceAsioMessage
+)
+
+// TraceEvent is a call or callback read from a trace.
+type TraceEvent struct {
+	Kind     TraceKind
+	Name     string        // the Driver method, or the ASIO name of the callback, e.g. "bufferSwitchTimeInfo"
+	Call     int64         // a call's number, from 1; for a callback, the call in progress when it came, or 0
+	Time     time.Duration // since the trace started
+	Duration time.Duration
+	Args     []any // int64, float64, bool, string, []byte, nil, and []any of them
+	Results  []any
+	Err      error
+	In, Out  [][]byte // the input and output buffers of a buffer switch, if the trace has audio
+}
+
+// TraceOptions configure a TraceDriver.
+type TraceOptions struct {
+	Audio bool // also record every input and output buffer of every buffer switch
+}
+
+// TraceDriver is a Driver that passes every call on to another driver and records it in a trace, with its
+// arguments, results and timing; likewise every callback from the driver, and optionally the audio of every
+// buffer switch. ReplayDriver plays a trace back.
+//
+// Records are written to the trace on a goroutine of the TraceDriver's own; if it falls behind, they are
+// dropped and Dropped counts them.
+type TraceDriver struct {
+	drv   Driver
+	audio bool
+	start time.Time
+
+	calls   atomic.Int64 // made so far
+	current atomic.Int64 // the call in progress, or 0
+	bufs    atomic.Pointer[traceBuffers]
+
+	w         *bufio.Writer
+	full      chan []byte
+	free      chan []byte
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	err       error
+
+	dropped atomic.Int64
+}
+
+// The driver's buffers, for recording their audio.
+type traceBuffers struct {
+	infos []BufferInfo
+	raw   [][2][]byte
+}
+
+// Records queued for writing before more are dropped.
+const traceQueue = 256
+
+var _ Driver = (*TraceDriver)(nil)
+
+// Creates a TraceDriver for drv, writing its trace to w. Close ends the trace.
+func NewTraceDriver(drv Driver, w io.Writer, opts TraceOptions) (*TraceDriver, error) {
+	t := &TraceDriver{
+		drv:   drv,
+		audio: opts.Audio,
+		start: time.Now(),
+		w:     bufio.NewWriterSize(w, 1<<16),
+		full:  make(chan []byte, traceQueue),
+		free:  make(chan []byte, traceQueue),
+		stop:  make(chan struct{}),
+	}
+	header := append([]byte(traceMagic), traceVersion)
+	header = binary.AppendVarint(header, t.start.UnixNano())
+	header = append(header, byte(bool_int32(opts.Audio)))
+	if _, err := t.w.Write(header); err != nil {
+		return nil, err
+	}
+	for i := 0; i < traceQueue; i++ {
+		t.free <- make([]byte, 0, 256)
+	}
+
+	t.wg.Add(1)
+	go t.write()
+	return t, nil
+}
+
+// Returns how many records were dropped because the trace could not be written fast enough.
+func (t *TraceDriver) Dropped() int64 { return t.dropped.Load() }
+
+func (t *TraceDriver) write() {
+	defer t.wg.Done()
+
+	var n [binary.MaxVarintLen64]byte
+	put := func(rec []byte) {
+		if t.err == nil {
+			if _, t.err = t.w.Write(n[:binary.PutUvarint(n[:], uint64(len(rec)))]); t.err == nil {
+				_, t.err = t.w.Write(rec)
+			}
+		}
+		t.free <- rec[:0]
+		if len(t.full) == 0 && t.err == nil {
+			t.err = t.w.Flush()
+		}
+	}
+	for {
+		select {
+		case rec := <-t.full:
+			put(rec)
+		case <-t.stop:
+			for {
+				select {
+				case rec := <-t.full:
+					put(rec)
+				default:
+					if t.err == nil {
+						t.err = t.w.Flush()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// Takes a record buffer from the pool, or counts the record as dropped.
+func (t *TraceDriver) record(kind TraceKind, name traceName, call int64, start time.Time) traceEncoder {
+	select {
+	case b := <-t.free:
+		e := traceEncoder(append(b, byte(kind), byte(name)))
+		e = binary.AppendUvarint(e, uint64(call))
+		e = binary.AppendUvarint(e, uint64(start.Sub(t.start).Microseconds()))
+		return binary.AppendUvarint(e, uint64(time.Since(start).Microseconds()))
+	default:
+		t.dropped.Add(1)
+		return nil
+	}
+}
+
+// Records a call made with args that returned results and err.
+func (t *TraceDriver) call(name traceName, args []any, do func() ([]any, error)) {
+	call := t.calls.Add(1)
+	t.current.Store(call)
+	start := time.Now()
+	results, err := do()
+	t.current.CompareAndSwap(call, 0)
+
+	e := t.record(TraceCall, name, call, start)
+	if e == nil {
+		return
+	}
+	e.values(args)
+	e.values(results)
+	e.error(err)
+	e.nil()
+	e.nil()
+	t.full <- e
+}
+
+func (t *TraceDriver) Init(sysHandle uintptr) (ok bool) {
+	t.call(traceInit, []any{int64(sysHandle)}, func() ([]any, error) {
+		ok = t.drv.Init(sysHandle)
+		return []any{ok}, nil
+	})
+	return
+}
+
+func (t *TraceDriver) GetDriverName() (name string) {
+	t.call(traceGetDriverName, nil, func() ([]any, error) {
+		name = t.drv.GetDriverName()
+		return []any{name}, nil
+	})
+	return
+}
+
+func (t *TraceDriver) GetDriverVersion() (version int32) {
+	t.call(traceGetDriverVersion, nil, func() ([]any, error) {
+		version = t.drv.GetDriverVersion()
+		return []any{int64(version)}, nil
+	})
+	return
+}
+
+func (t *TraceDriver) GetErrorMessage() (msg string) {
+	t.call(traceGetErrorMessage, nil, func() ([]any, error) {
+		msg = t.drv.GetErrorMessage()
+		return []any{msg}, nil
+	})
+	return
+}
+
+func (t *TraceDriver) Start() (err error) {
+	t.call(traceStart, nil, func() ([]any, error) {
+		err = t.drv.Start()
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) Stop() (err error) {
+	t.call(traceStop, nil, func() ([]any, error) {
+		err = t.drv.Stop()
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetChannels() (numInputChannels, numOutputChannels int, err error) {
+	t.call(traceGetChannels, nil, func() ([]any, error) {
+		numInputChannels, numOutputChannels, err = t.drv.GetChannels()
+		return []any{int64(numInputChannels), int64(numOutputChannels)}, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetLatencies() (inputLatency, outputLatency int, err error) {
+	t.call(traceGetLatencies, nil, func() ([]any, error) {
+		inputLatency, outputLatency, err = t.drv.GetLatencies()
+		return []any{int64(inputLatency), int64(outputLatency)}, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetBufferSize() (minSize, maxSize, preferredSize, granularity int, err error) {
+	t.call(traceGetBufferSize, nil, func() ([]any, error) {
+		minSize, maxSize, preferredSize, granularity, err = t.drv.GetBufferSize()
+		return []any{int64(minSize), int64(maxSize), int64(preferredSize), int64(granularity)}, err
+	})
+	return
+}
+
+func (t *TraceDriver) CanSampleRate(sampleRate float64) (err error) {
+	t.call(traceCanSampleRate, []any{sampleRate}, func() ([]any, error) {
+		err = t.drv.CanSampleRate(sampleRate)
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetSampleRate() (sampleRate float64, err error) {
+	t.call(traceGetSampleRate, nil, func() ([]any, error) {
+		sampleRate, err = t.drv.GetSampleRate()
+		return []any{sampleRate}, err
+	})
+	return
+}
+
+func (t *TraceDriver) SetSampleRate(sampleRate float64) (err error) {
+	t.call(traceSetSampleRate, []any{sampleRate}, func() ([]any, error) {
+		err = t.drv.SetSampleRate(sampleRate)
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetClockSources() (sources []ClockSource, err error) {
+	t.call(traceGetClockSources, nil, func() ([]any, error) {
+		sources, err = t.drv.GetClockSources()
+		list := make([]any, len(sources))
+		for i, src := range sources {
+			list[i] = []any{int64(src.Index), int64(src.AssociatedChannel), int64(src.AssociatedGroup),
+				src.IsCurrentSource, src.Name}
+		}
+		return []any{list}, err
+	})
+	return
+}
+
+func (t *TraceDriver) SetClockSource(reference int) (err error) {
+	t.call(traceSetClockSource, []any{int64(reference)}, func() ([]any, error) {
+		err = t.drv.SetClockSource(reference)
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetSamplePosition() (samplePosition, systemTime int64, err error) {
+	t.call(traceGetSamplePosition, nil, func() ([]any, error) {
+		samplePosition, systemTime, err = t.drv.GetSamplePosition()
+		return []any{samplePosition, systemTime}, err
+	})
+	return
+}
+
+func (t *TraceDriver) GetChannelInfo(channel int, isInput bool) (info *ChannelInfo, err error) {
+	t.call(traceGetChannelInfo, []any{int64(channel), isInput}, func() ([]any, error) {
+		info, err = t.drv.GetChannelInfo(channel, isInput)
+		if info == nil {
+			return []any{nil}, err
+		}
+		return []any{[]any{int64(info.Channel), info.IsInput, info.IsActive, int64(info.ChannelGroup),
+			int64(info.SampleType), info.Name}}, err
+	})
+	return
+}
+
+func (t *TraceDriver) CreateBuffers(bufferDescriptors []BufferInfo, bufferSize int, callbacks Callbacks) (err error) {
+	descs := make([]any, len(bufferDescriptors))
+	for i, desc := range bufferDescriptors {
+		descs[i] = []any{int64(desc.Channel), desc.IsInput}
+	}
+	given := []any{callbacks.BufferSwitch != nil, callbacks.SampleRateDidChange != nil, callbacks.Message != nil,
+		callbacks.BufferSwitchTimeInfo != nil}
+
+	t.call(traceCreateBuffers, []any{descs, int64(bufferSize), given}, func() ([]any, error) {
+		err = t.drv.CreateBuffers(bufferDescriptors, bufferSize, t.callbacks(callbacks))
+		if err != nil {
+			return nil, err
+		}
+
+		// The size in bytes of each buffer, for a replay to allocate, and for recording the audio.
+		bufs := &traceBuffers{infos: append([]BufferInfo(nil), bufferDescriptors...)}
+		sizes := make([]any, len(bufferDescriptors))
+		for i, desc := range bufferDescriptors {
+			size := 0
+			if info, err := t.drv.GetChannelInfo(desc.Channel, desc.IsInput); err == nil {
+				size = bufferSize * info.SampleType.BytesPerSample()
+			}
+			sizes[i] = int64(size)
+			bufs.raw = append(bufs.raw, [2][]byte{bufferBytes(desc.Buffers[0], size), bufferBytes(desc.Buffers[1], size)})
+		}
+		t.bufs.Store(bufs)
+		return []any{sizes}, nil
+	})
+	return
+}
+
+func (t *TraceDriver) DisposeBuffers() (err error) {
+	t.call(traceDisposeBuffers, nil, func() ([]any, error) {
+		err = t.drv.DisposeBuffers()
+		t.bufs.Store(nil)
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) ControlPanel() (err error) {
+	t.call(traceControlPanel, nil, func() ([]any, error) {
+		err = t.drv.ControlPanel()
+		return nil, err
+	})
+	return
+}
+
+func (t *TraceDriver) Future(selector int32, opt unsafe.Pointer) (err error) {
+	// The arguments of the io format selectors are recorded; those of others are opaque.
+	format := func() any {
+		switch selector {
+		case AsioCanDoIoFormat, AsioSetIoFormat, AsioGetIoFormat:
+			if opt != nil {
+				return int64((*IoFormat)(opt).FormatType)
+			}
+		}
+		return nil
+	}
+	t.call(traceFuture, []any{int64(selector), format()}, func() ([]any, error) {
+		err = t.drv.Future(selector, opt)
+		return []any{format()}, err
+	})
+	return
+}
+
+func (t *TraceDriver) OutputReady() (ready bool) {
+	t.call(traceOutputReady, nil, func() ([]any, error) {
+		ready = t.drv.OutputReady()
+		return []any{ready}, nil
+	})
+	return
+}
+
+// Returns callbacks for the driver that record each call of host's before passing it on.
+func (t *TraceDriver) callbacks(host Callbacks) Callbacks {
+	var cb Callbacks
+	if host.BufferSwitch != nil {
+		cb.BufferSwitch = func(doubleBufferIndex int, directProcess bool) {
+			start := time.Now()
+			host.BufferSwitch(doubleBufferIndex, directProcess)
+			if e := t.record(TraceCallback, traceBufferSwitch, t.current.Load(), start); e != nil {
+				e.list(2)
+				e.int(int64(doubleBufferIndex))
+				e.bool(directProcess)
+				t.finishSwitch(e, doubleBufferIndex)
+			}
+		}
+	}
+	if host.BufferSwitchTimeInfo != nil {
+		cb.BufferSwitchTimeInfo = func(params *ASIOTime, doubleBufferIndex int32, directProcess bool) *ASIOTime {
+			start := time.Now()
+			var p ASIOTime
+			if params != nil {
+				p = *params
+			}
+			ret := host.BufferSwitchTimeInfo(params, doubleBufferIndex, directProcess)
+			if e := t.record(TraceCallback, traceBufferSwitchTimeInfo, t.current.Load(), start); e != nil {
+				e.list(3)
+				e.int(int64(doubleBufferIndex))
+				e.bool(directProcess)
+				e.list(8)
+				e.float(p.TimeInfo.Speed)
+				e.int(p.TimeInfo.SystemTime)
+				e.int(p.TimeInfo.SamplePosition)
+				e.float(p.TimeInfo.SampleRate)
+				e.int(int64(p.TimeInfo.Flags))
+				e.float(p.TimeCode.Speed)
+				e.int(p.TimeCode.TimeCodeSamples)
+				e.int(int64(p.TimeCode.Flags))
+				t.finishSwitch(e, int(doubleBufferIndex))
+			}
+			return ret
+		}
+	}
+	if host.SampleRateDidChange != nil {
+		cb.SampleRateDidChange = func(rate float64) {
+			start := time.Now()
+			host.SampleRateDidChange(rate)
+			if e := t.record(TraceCallback, traceSampleRateDidChange, t.current.Load(), start); e != nil {
+				e.list(1)
+				e.float(rate)
+				e.nil()
+				e.nil()
+				e.nil()
+				e.nil()
+				t.full <- e
+			}
+		}
+	}
+	if host.Message != nil {
+		cb.Message = func(selector, value int32, message uintptr, opt *float64) int32 {
+			start := time.Now()
+			ret := host.Message(selector, value, message, opt)
+			if e := t.record(TraceCallback, traceAsioMessage, t.current.Load(), start); e != nil {
+				e.list(3)
+				e.int(int64(selector))
+				e.int(int64(value))
+				if opt != nil {
+					e.float(*opt)
+				} else {
+					e.nil()
+				}
+				e.list(1)
+				e.int(int64(ret))
+				e.nil()
+				e.nil()
+				e.nil()
+				t.full <- e
+			}
+			return ret
+		}
+	}
+	return cb
+}
+
+// Completes the record of a buffer switch with the audio of its half of the buffers, and queues it.
+func (t *TraceDriver) finishSwitch(e traceEncoder, index int) {
+	e.nil()
+	e.nil()
+	bufs := t.bufs.Load()
+	if !t.audio || bufs == nil || index < 0 || index > 1 {
+		e.nil()
+		e.nil()
+		t.full <- e
+		return
+	}
+	for _, input := range []bool{true, false} {
+		n := 0
+		for _, info := range bufs.infos {
+			if info.IsInput == input {
+				n++
+			}
+		}
+		e.list(n)
+		for i, info := range bufs.infos {
+			if info.IsInput == input {
+				e.bytes(bufs.raw[i][index])
+			}
+		}
+	}
+	t.full <- e
+}
+
+// Stops tracing and writes out what is queued. Neither the driver nor the trace's io.Writer is closed; calls
+// and callbacks made after Close are passed on but not recorded.
+func (t *TraceDriver) Close() error {
+	t.closeOnce.Do(func() { close(t.stop) })
+	t.wg.Wait()
+	return t.err
+}
+
+// Appends the values of a trace record.
+type traceEncoder []byte
+
+func (e *traceEncoder) int(v int64) {
+	*e = binary.AppendVarint(append(*e, 'i'), v)
+}
+
+func (e *traceEncoder) float(v float64) {
+	*e = binary.LittleEndian.AppendUint64(append(*e, 'f'), math.Float64bits(v))
+}
+
+func (e *traceEncoder) bool(v bool) {
+	if v {
+		*e = append(*e, 'T')
+	} else {
+		*e = append(*e, 'F')
+	}
+}
+
+func (e *traceEncoder) nil() { *e = append(*e, 'N') }
+
+func (e *traceEncoder) list(n int) {
+	*e = binary.AppendUvarint(append(*e, 'l'), uint64(n))
+}
+
+func (e *traceEncoder) bytes(b []byte) {
+	*e = append(binary.AppendUvarint(append(*e, 'b'), uint64(len(b))), b...)
+}
+
+func (e *traceEncoder) string(s string) {
+	*e = append(binary.AppendUvarint(append(*e, 's'), uint64(len(s))), s...)
+}
+
+func (e *traceEncoder) value(v any) {
+	switch v := v.(type) {
+	case int64:
+		e.int(v)
+	case float64:
+		e.float(v)
+	case bool:
+		e.bool(v)
+	case string:
+		e.string(v)
+	case []byte:
+		e.bytes(v)
+	case []any:
+		e.values(v)
+	default:
+		e.nil()
+	}
+}
+
+func (e *traceEncoder) values(vs []any) {
+	e.list(len(vs))
+	for _, v := range vs {
+		e.value(v)
+	}
+}
+
+// Records an error as nil, or its ASIO error code and message; 0 for errors from elsewhere.
+func (e *traceEncoder) error(err error) {
+	if err == nil {
+		e.nil()
+		return
+	}
+	var code int64
+	var ae *Error
+	if errors.As(err, &ae) {
+		code = int64(ae.errno)
+	}
+	e.list(2)
+	e.int(code)
+	e.string(err.Error())
+}
+
+var errTraceCorrupt = errors.New("trace: corrupt")
+
+// Reads the events of a trace, and when it started.
+func ReadTrace(r io.Reader) (events []TraceEvent, start time.Time, err error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(traceMagic)]) != traceMagic {
+		return nil, time.Time{}, errors.New("trace: not a trace")
+	}
+	if header[len(traceMagic)] != traceVersion {
+		return nil, time.Time{}, fmt.Errorf("trace: unsupported version %d", header[len(traceMagic)])
+	}
+	nanos, err := binary.ReadVarint(br)
+	if err != nil {
+		return nil, time.Time{}, errTraceCorrupt
+	}
+	start = time.Unix(0, nanos)
+	if _, err := br.ReadByte(); err != nil {
+		return nil, time.Time{}, errTraceCorrupt
+	}
+
+	var rec []byte
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return events, start, nil
+		} else if err != nil || size > 1<<30 {
+			return events, start, errTraceCorrupt
+		}
+		if uint64(cap(rec)) < size {
+			rec = make([]byte, size)
+		}
+		rec = rec[:size]
+		if _, err := io.ReadFull(br, rec); err != nil {
+			// A trace that was not closed may end in the middle of a record.
+			return events, start, nil
+		}
+		ev, err := decodeTraceEvent(rec)
+		if err != nil {
+			return events, start, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func decodeTraceEvent(rec []byte) (ev TraceEvent, err error) {
+	d := traceDecoder{b: rec}
+	ev.Kind = TraceKind(d.byte())
+	if name := int(d.byte()); name < len(traceNames) {
+		ev.Name = traceNames[name]
+	} else {
+		ev.Name = fmt.Sprintf("unknown%d", name)
+	}
+	ev.Call = int64(d.uvarint())
+	ev.Time = time.Duration(d.uvarint()) * time.Microsecond
+	ev.Duration = time.Duration(d.uvarint()) * time.Microsecond
+	ev.Args, _ = d.value().([]any)
+	ev.Results, _ = d.value().([]any)
+	if e, ok := d.value().([]any); ok && len(e) == 2 {
+		code, _ := e[0].(int64)
+		msg, _ := e[1].(string)
+		if known, ok := knownErrors[int32(code)]; ok {
+			ev.Err = known
+		} else if code != 0 {
+			ev.Err = &Error{errno: int32(code), msg: msg}
+		} else {
+			ev.Err = errors.New(msg)
+		}
+	}
+	for _, audio := range []*[][]byte{&ev.In, &ev.Out} {
+		if list, ok := d.value().([]any); ok {
+			*audio = make([][]byte, len(list))
+			for i, b := range list {
+				(*audio)[i], _ = b.([]byte)
+			}
+		}
+	}
+	if d.bad {
+		return ev, errTraceCorrupt
+	}
+	return ev, nil
+}
+
+type traceDecoder struct {
+	b   []byte
+	bad bool
+}
+
+func (d *traceDecoder) byte() byte {
+	if len(d.b) == 0 {
+		d.bad = true
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *traceDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.bad = true
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *traceDecoder) value() any {
+	switch tag := d.byte(); tag {
+	case 'i':
+		v, n := binary.Varint(d.b)
+		if n <= 0 {
+			d.bad = true
+			return nil
+		}
+		d.b = d.b[n:]
+		return v
+	case 'f':
+		if len(d.b) < 8 {
+			d.bad = true
+			return nil
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+		d.b = d.b[8:]
+		return v
+	case 'T':
+		return true
+	case 'F':
+		return false
+	case 'N':
+		return nil
+	case 's', 'b':
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.bad = true
+			return nil
+		}
+		v := d.b[:n:n]
+		d.b = d.b[n:]
+		if tag == 's' {
+			return string(v)
+		}
+		return append([]byte(nil), v...)
+	case 'l':
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.bad = true
+			return nil
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = d.value()
+		}
+		return list
+	}
+	d.bad = true
+	return nil
+}
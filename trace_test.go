@@ -0,0 +1,203 @@
+package asio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+type recordedBlock struct {
+	pos int64
+	in  [][]float32
+}
+
+// Records the inputs and positions of the blocks s processes.
+func recordBlocks(s *Stream) *[]recordedBlock {
+	var blocks []recordedBlock
+	s.AddTap(ProcessorFunc(func(b *Block) {
+		in := make([][]float32, len(b.In))
+		for i := range b.In {
+			in[i] = slices.Clone(b.In[i])
+		}
+		blocks = append(blocks, recordedBlock{b.SamplePosition, in})
+	}))
+	return &blocks
+}
+
+func TestTraceReplay(t *testing.T) {
+	sim := NewSimDriver(2, 2)
+	sim.Manual = true
+	sim.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			buf[i] = vbanRamp(pos+int64(i)) * float32(channel+1)
+		}
+	}
+	var trace bytes.Buffer
+	drv, err := NewTraceDriver(sim, &trace, TraceOptions{Audio: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traced := recordBlocks(s)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			sim.Message(AsioResetRequest, 0)
+		}
+		if err := sim.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if drv.Dropped() != 0 {
+		t.Errorf("%d records dropped", drv.Dropped())
+	}
+
+	events, _, err := ReadTrace(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var switches, messages int
+	for _, ev := range events {
+		switch ev.Name {
+		case "bufferSwitchTimeInfo":
+			switches++
+			if len(ev.In) != 2 || len(ev.Out) != 2 || len(ev.In[0]) != 256*4 {
+				t.Fatalf("buffer switch without its audio: %d in, %d out", len(ev.In), len(ev.Out))
+			}
+		case "asioMessage":
+			if traceInt(ev.Args, 0) == int64(AsioResetRequest) {
+				messages++
+			}
+		case "CreateBuffers":
+			if ev.Err != nil || len(traceList(ev.Args, 0)) != 4 || traceInt(ev.Args, 1) != 256 {
+				t.Errorf("CreateBuffers%v: %v", ev.Args, ev.Err)
+			}
+		}
+	}
+	if switches != 10 || messages != 1 {
+		t.Errorf("%d buffer switches and %d reset requests traced", switches, messages)
+	}
+
+	// A truncated trace reads up to its last whole record.
+	part, _, err := ReadTrace(bytes.NewReader(trace.Bytes()[:trace.Len()-3]))
+	if err != nil || len(part) != len(events)-1 {
+		t.Errorf("truncated trace: %d events, %v", len(part), err)
+	}
+
+	replay, err := NewReplayDriver(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Manual = true
+	rs, err := NewStream(replay, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := recordBlocks(rs)
+	if err := rs.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if err := replay.Step(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !rs.ResetRequested() {
+		t.Error("the reset request was not replayed")
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*replayed) != len(*traced) {
+		t.Fatalf("%d blocks replayed, %d traced", len(*replayed), len(*traced))
+	}
+	for i, b := range *replayed {
+		want := (*traced)[i]
+		if b.pos != want.pos || len(b.in) != 2 || !slices.Equal(b.in[0], want.in[0]) ||
+			!slices.Equal(b.in[1], want.in[1]) {
+			t.Fatalf("block %d at %d differs from the traced one at %d", i, b.pos, want.pos)
+		}
+	}
+	if d := replay.Divergences(); len(d) != 0 {
+		t.Errorf("divergences: %v", d)
+	}
+
+	// A call the traced host never made.
+	if _, err := replay.GetChannelInfo(7, true); !errors.Is(err, ErrorNotPresent) || len(replay.Divergences()) != 1 {
+		t.Errorf("an untraced call: %v, divergences %v", err, replay.Divergences())
+	}
+	if err := replay.Step(); err != ErrorInvalidMode {
+		t.Errorf("stepping a stopped replay: %v", err)
+	}
+}
+
+func TestReplayPaced(t *testing.T) {
+	sim := NewSimDriver(1, 1)
+	var trace bytes.Buffer
+	drv, err := NewTraceDriver(sim, &trace, TraceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStream(drv, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	waitFor(t, "the traced stream", func() bool { return s.Position() >= 2048 })
+	s.Close()
+	drv.Close()
+
+	replay, err := NewReplayDriver(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewStream(replay, StreamOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	if err := rs.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the replayed stream", func() bool { return rs.Position() >= 2048 })
+}
+
+func TestReplayStopFromCallback(t *testing.T) {
+	sim := NewSimDriver(1, 1)
+	sim.Faults = NewFaultScenario().ResetRequest(2)
+	var trace bytes.Buffer
+	drv, err := NewTraceDriver(sim, &trace, TraceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stopOnReset(t, drv); err != nil {
+		t.Fatal(err)
+	}
+	drv.Close()
+
+	replay, err := NewReplayDriver(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stopOnReset(t, replay); err != nil {
+		t.Fatal(err)
+	}
+	if d := replay.Divergences(); len(d) != 0 {
+		t.Errorf("diverged: %v", d)
+	}
+}
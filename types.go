@@ -0,0 +1,350 @@
+package asio
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Special ASIO error values:
+const (
+	ASE_OK      = 0          // This value will be returned whenever the call succeeded
+	ASE_SUCCESS = 0x3f4847a0 // unique success return value for ASIOFuture calls
+)
+
+// Known ASIO error values:
+const (
+	ASE_NotPresent       = -1000 + iota // hardware input or output is not present or available
+	ASE_HWMalfunction                   // hardware is malfunctioning (can be returned by any ASIO function)
+	ASE_InvalidParameter                // input parameter invalid
+	ASE_InvalidMode                     // hardware is in a bad mode or used in a bad mode
+	ASE_SPNotAdvancing                  // hardware is not running when sample position is inquired
+	ASE_NoClock                         // sample clock or rate cannot be determined or is not present
+	ASE_NoMemory                        // not enough memory for completing the request
+)
+
+type Error struct {
+	errno int32
+	msg   string
+}
+
+// Fixed instances of errors:
+var (
+	ErrorNotPresent       = &Error{errno: ASE_NotPresent, msg: "hardware input or output is not present or available"}
+	ErrorHWMalfunction    = &Error{errno: ASE_HWMalfunction, msg: "hardware is malfunctioning (can be returned by any ASIO function)"}
+	ErrorInvalidParameter = &Error{errno: ASE_InvalidParameter, msg: "input parameter invalid"}
+	ErrorInvalidMode      = &Error{errno: ASE_InvalidMode, msg: "hardware is in a bad mode or used in a bad mode"}
+	ErrorSPNotAdvancing   = &Error{errno: ASE_SPNotAdvancing, msg: "hardware is not running when sample position is inquired"}
+	ErrorNoClock          = &Error{errno: ASE_NoClock, msg: "sample clock or rate cannot be determined or is not present"}
+	ErrorNoMemory         = &Error{errno: ASE_NoMemory, msg: "not enough memory for completing the request"}
+)
+
+// Mapping of known ASIO error values to Errors:
+var knownErrors map[int32]*Error = map[int32]*Error{
+	ASE_NotPresent:       ErrorNotPresent,
+	ASE_HWMalfunction:    ErrorHWMalfunction,
+	ASE_InvalidParameter: ErrorInvalidParameter,
+	ASE_InvalidMode:      ErrorInvalidMode,
+	ASE_SPNotAdvancing:   ErrorSPNotAdvancing,
+	ASE_NoClock:          ErrorNoClock,
+	ASE_NoMemory:         ErrorNoMemory,
+}
+
+func (err *Error) Error() string {
+	return err.msg
+}
+
+type SampleType int32
+
+const (
+	ASIOSTInt16MSB   SampleType = 0
+	ASIOSTInt24MSB   SampleType = 1 // used for 20 bits as well
+	ASIOSTInt32MSB   SampleType = 2
+	ASIOSTFloat32MSB SampleType = 3 // IEEE 754 32 bit float
+	ASIOSTFloat64MSB SampleType = 4 // IEEE 754 64 bit double float
+
+	// these are used for 32 bit data buffer, with different alignment of the data inside
+	// 32 bit PCI bus systems can be more easily used with these
+	ASIOSTInt32MSB16 SampleType = 8  // 32 bit data with 16 bit alignment
+	ASIOSTInt32MSB18 SampleType = 9  // 32 bit data with 18 bit alignment
+	ASIOSTInt32MSB20 SampleType = 10 // 32 bit data with 20 bit alignment
+	ASIOSTInt32MSB24 SampleType = 11 // 32 bit data with 24 bit alignment
+
+	ASIOSTInt16LSB   SampleType = 16
+	ASIOSTInt24LSB   SampleType = 17 // used for 20 bits as well
+	ASIOSTInt32LSB   SampleType = 18
+	ASIOSTFloat32LSB SampleType = 19 // IEEE 754 32 bit float, as found on Intel x86 architecture
+	ASIOSTFloat64LSB SampleType = 20 // IEEE 754 64 bit double float, as found on Intel x86 architecture
+
+	// these are used for 32 bit data buffer, with different alignment of the data inside
+	// 32 bit PCI bus systems can more easily used with these
+	ASIOSTInt32LSB16 SampleType = 24 // 32 bit data with 18 bit alignment
+	ASIOSTInt32LSB18 SampleType = 25 // 32 bit data with 18 bit alignment
+	ASIOSTInt32LSB20 SampleType = 26 // 32 bit data with 20 bit alignment
+	ASIOSTInt32LSB24 SampleType = 27 // 32 bit data with 24 bit alignment
+
+	//	ASIO DSD format.
+	ASIOSTDSDInt8LSB1 SampleType = 32 // DSD 1 bit data, 8 samples per byte. First sample in Least significant bit.
+	ASIOSTDSDInt8MSB1 SampleType = 33 // DSD 1 bit data, 8 samples per byte. First sample in Most significant bit.
+	ASIOSTDSDInt8NER8 SampleType = 40 // DSD 8 bit data, 1 sample per byte. No Endianness required.
+)
+
+type rawChannelInfo struct {
+	Channel      int32
+	IsInput      int32
+	IsActive     int32
+	ChannelGroup int32
+	SampleType   SampleType
+	Name         [32]byte
+
+	// NOTE(jsd): for struct layout, `long` is `int32` regardless of `uintptr` size.
+
+	//	long channel;			// on input, channel index
+	//	ASIOBool isInput;		// on input
+	//	ASIOBool isActive;		// on exit
+	//	long channelGroup;		// dto
+	//	ASIOSampleType type;	// dto
+	//	char name[32];			// dto
+}
+
+type ChannelInfo struct {
+	Channel      int
+	IsInput      bool
+	IsActive     bool
+	ChannelGroup int
+	SampleType   SampleType
+	Name         string // trimmed of trailing NULs
+}
+
+func (info *ChannelInfo) String() string {
+	dir := "OUT"
+	if info.IsInput {
+		dir = "IN"
+	}
+	active := ""
+	if info.IsActive {
+		active = ", active"
+	}
+	return fmt.Sprintf("%s%d %q (group %d, %s%s)", dir, info.Channel+1, info.Name, info.ChannelGroup, info.SampleType, active)
+}
+
+type rawBufferInfo struct {
+	isInput int32     // input
+	channel int32     // input
+	buffers [2]*int32 // output
+
+	//	ASIOBool isInput;			// on input:  ASIOTrue: input, else output
+	//	long channelNum;			// on input:  channel index
+	//	void *buffers[2];			// on output: double buffer addresses
+}
+
+type BufferInfo struct {
+	Channel int
+	IsInput bool
+	Buffers [2]*int32 // double buffers - may need to recast based on sample type (int32 most popular; ASIOSTInt32LSB)
+}
+
+type rawASIOSamples struct {
+	hi uint32
+	lo uint32
+}
+
+func (s rawASIOSamples) int64() int64 {
+	return int64(uint64(s.hi)<<32 | uint64(s.lo))
+}
+
+func samplesFromInt64(v int64) rawASIOSamples {
+	return rawASIOSamples{hi: uint32(uint64(v) >> 32), lo: uint32(uint64(v))}
+}
+
+type rawASIOTime struct { // both input/output
+	reserved [4]int32
+	timeInfo struct {
+		speed          float64
+		systemTime     rawASIOSamples
+		samplePosition rawASIOSamples
+		sampleRate     float64
+		flags          uint32
+		reserved       [12]byte
+	}
+	timeCode struct {
+		speed           float64
+		timeCodeSamples rawASIOSamples
+		flags           uint32
+		future          [64]byte
+	}
+
+	//	long reserved[4];                       // must be 0
+	//	struct AsioTimeInfo     timeInfo;       // required
+	//	struct ASIOTimeCode     timeCode;       // optional, evaluated if (timeCode.flags & kTcValid)
+}
+
+type ASIOTime struct {
+	TimeInfo TimeInfo
+	TimeCode TimeCode // optional, evaluated if (TimeCode.Flags & TcValid)
+}
+
+type TimeInfo struct {
+	Speed          float64 // absolute speed (1. = nominal)
+	SystemTime     int64   // system time related to SamplePosition, in nanoseconds
+	SamplePosition int64
+	SampleRate     float64 // current rate
+	Flags          TimeInfoFlags
+}
+
+type TimeInfoFlags uint32
+
+const (
+	SystemTimeValid     TimeInfoFlags = 1 << 0 // must always be valid
+	SamplePositionValid TimeInfoFlags = 1 << 1 // must always be valid
+	SampleRateValid     TimeInfoFlags = 1 << 2
+	SpeedValid          TimeInfoFlags = 1 << 3
+	SampleRateChanged   TimeInfoFlags = 1 << 4
+	ClockSourceChanged  TimeInfoFlags = 1 << 5
+)
+
+type TimeCode struct {
+	Speed           float64 // speed relation (fraction of nominal speed); 0. or 1. if not supported
+	TimeCodeSamples int64   // time in samples
+	Flags           TimeCodeFlags
+}
+
+type TimeCodeFlags uint32
+
+const (
+	TcValid      TimeCodeFlags = 1 << 0
+	TcRunning    TimeCodeFlags = 1 << 1
+	TcReverse    TimeCodeFlags = 1 << 2
+	TcOnspeed    TimeCodeFlags = 1 << 3
+	TcStill      TimeCodeFlags = 1 << 4
+	TcSpeedValid TimeCodeFlags = 1 << 8
+)
+
+func (raw *rawASIOTime) toASIOTime(t *ASIOTime) {
+	t.TimeInfo = TimeInfo{
+		Speed:          raw.timeInfo.speed,
+		SystemTime:     raw.timeInfo.systemTime.int64(),
+		SamplePosition: raw.timeInfo.samplePosition.int64(),
+		SampleRate:     raw.timeInfo.sampleRate,
+		Flags:          TimeInfoFlags(raw.timeInfo.flags),
+	}
+	t.TimeCode = TimeCode{
+		Speed:           raw.timeCode.speed,
+		TimeCodeSamples: raw.timeCode.timeCodeSamples.int64(),
+		Flags:           TimeCodeFlags(raw.timeCode.flags),
+	}
+}
+
+func (raw *rawASIOTime) fromASIOTime(t *ASIOTime) {
+	raw.timeInfo.speed = t.TimeInfo.Speed
+	raw.timeInfo.systemTime = samplesFromInt64(t.TimeInfo.SystemTime)
+	raw.timeInfo.samplePosition = samplesFromInt64(t.TimeInfo.SamplePosition)
+	raw.timeInfo.sampleRate = t.TimeInfo.SampleRate
+	raw.timeInfo.flags = uint32(t.TimeInfo.Flags)
+	raw.timeCode.speed = t.TimeCode.Speed
+	raw.timeCode.timeCodeSamples = samplesFromInt64(t.TimeCode.TimeCodeSamples)
+	raw.timeCode.flags = uint32(t.TimeCode.Flags)
+}
+
+// asioMessage selectors:
+const (
+	AsioSelectorSupported    int32 = 1 + iota // selector in <value>, returns 1 if supported, 0 otherwise
+	AsioEngineVersion                         // returns engine (host) asio implementation version, 2 or higher
+	AsioResetRequest                          // request driver reset
+	AsioBufferSizeChange                      // not yet supported, use AsioResetRequest instead
+	AsioResyncRequest                         // the driver went out of sync; request to re-start the engine
+	AsioLatenciesChanged                      // the drivers latencies have changed
+	AsioSupportsTimeInfo                      // host supports bufferSwitchTimeInfo
+	AsioSupportsTimeCode                      // host is interested in time code info
+	AsioMMCCommand                            // unused
+	AsioSupportsInputMonitor                  // host supports input monitoring
+	AsioSupportsInputGain                     // unused and undefined
+	AsioSupportsInputMeter                    // unused and undefined
+	AsioSupportsOutputGain                    // unused and undefined
+	AsioSupportsOutputMeter                   // unused and undefined
+	AsioOverload                              // driver detected an overload
+)
+
+// future() selectors:
+const (
+	AsioEnableTimeCodeRead  int32 = 1 + iota // no arguments
+	AsioDisableTimeCodeRead                  // no arguments
+	AsioSetInputMonitor                      // ASIOInputMonitor* in params
+	AsioTransport                            // ASIOTransportParameters* in params
+	AsioSetInputGain                         // *ChannelControls in params, apply gain
+	AsioGetInputMeter                        // *ChannelControls in params, fill meter
+	AsioSetOutputGain                        // *ChannelControls in params, apply gain
+	AsioGetOutputMeter                       // *ChannelControls in params, fill meter
+	AsioCanInputMonitor                      // no arguments for AsioCanXXX selectors
+	AsioCanTimeInfo
+	AsioCanTimeCode
+	AsioCanTransport
+	AsioCanInputGain
+	AsioCanInputMeter
+	AsioCanOutputGain
+	AsioCanOutputMeter
+	AsioOptionalOne
+
+	AsioSetIoFormat   int32 = 0x23111961 // *IoFormat in params
+	AsioGetIoFormat   int32 = 0x23111983 // *IoFormat in params
+	AsioCanDoIoFormat int32 = 0x23112004 // *IoFormat in params
+)
+
+// Used with AsioSetInputGain, AsioGetInputMeter, AsioSetOutputGain and AsioGetOutputMeter.
+type ChannelControls struct {
+	Channel int32 // on input, channel index
+	IsInput int32 // on input
+	Gain    int32 // on input, ranges 0 thru 0x7fffffff
+	Meter   int32 // on return, ranges 0 thru 0x7fffffff
+	future  [32]byte
+}
+
+type rawClockSource struct {
+	Index             int32
+	AssociatedChannel int32
+	AssociatedGroup   int32
+	IsCurrentSource   int32
+	Name              [32]byte
+
+	//	long index;					// as used for ASIOSetClockSource()
+	//	long associatedChannel;		// for instance, S/PDIF or AES/EBU
+	//	long associatedGroup;		// see channel groups (ASIOGetChannelInfo())
+	//	ASIOBool isCurrentSource;	// ASIOTrue if this is the current clock source
+	//	char name[32];				// for user selection
+}
+
+type ClockSource struct {
+	Index             int
+	AssociatedChannel int
+	AssociatedGroup   int
+	IsCurrentSource   bool
+	Name              string
+}
+
+type Callbacks struct {
+	BufferSwitch func(doubleBufferIndex int, directProcess bool)
+
+	SampleRateDidChange func(rate float64)
+
+	Message func(selector, value int32, message uintptr, opt *float64) int32
+
+	BufferSwitchTimeInfo func(params *ASIOTime, doubleBufferIndex int32, directProcess bool) *ASIOTime
+}
+
+func bool_int32(a bool) int32 {
+	if a {
+		return 1
+	}
+	return 0
+}
+
+func int32_bool(a int32) bool {
+	return a != 0
+}
+
+// Converts a NUL-terminated C string buffer to a string.
+func cstring(b []byte) string {
+	if lz := bytes.IndexByte(b, 0); lz >= 0 {
+		return string(b[:lz])
+	}
+	return string(b)
+}
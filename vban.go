@@ -0,0 +1,474 @@
+package asio
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VBAN carries audio over UDP, one packet of up to 256 interleaved frames at a time, each with a 28 byte header:
+// "VBAN", the sample rate index and sub-protocol, frames-1, channels-1, the data format and codec, a 16 byte
+// stream name and a frame counter. Everything is little-endian.
+const (
+	VBANPort = 6980
+
+	vbanHeaderSize = 28
+	vbanMaxFrames  = 256
+	vbanMaxData    = 1436
+	vbanMaxPacket  = vbanHeaderSize + vbanMaxData
+	vbanNameSize   = 16
+
+	vbanProtocolAudio = 0x00 // in the top 3 bits of byte 4
+	vbanCodecPCM      = 0x00 // in the top 4 bits of byte 7
+)
+
+// The sample rates VBAN can carry, by index.
+var vbanRates = [...]float64{
+	6000, 12000, 24000, 48000, 96000, 192000, 384000,
+	8000, 16000, 32000, 64000, 128000, 256000, 512000,
+	11025, 22050, 44100, 88200, 176400, 352800, 705600,
+}
+
+func vbanRateIndex(sampleRate float64) (int, bool) {
+	for i, r := range vbanRates {
+		if r == sampleRate {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// VBANFormat is the sample format of VBAN audio, by its code on the wire.
+type VBANFormat int
+
+const (
+	VBANInt16   VBANFormat = 1
+	VBANInt24   VBANFormat = 2
+	VBANInt32   VBANFormat = 3
+	VBANFloat32 VBANFormat = 4
+	VBANFloat64 VBANFormat = 5
+)
+
+// Returns the ASIO sample type with the same layout, and false if the format is not supported.
+func (f VBANFormat) sampleType() (SampleType, bool) {
+	switch f {
+	case VBANInt16:
+		return ASIOSTInt16LSB, true
+	case VBANInt24:
+		return ASIOSTInt24LSB, true
+	case VBANInt32:
+		return ASIOSTInt32LSB, true
+	case VBANFloat32:
+		return ASIOSTFloat32LSB, true
+	case VBANFloat64:
+		return ASIOSTFloat64LSB, true
+	}
+	return 0, false
+}
+
+type vbanHeader struct {
+	rate     int // index into vbanRates
+	frames   int
+	channels int
+	format   VBANFormat
+	name     string
+	counter  uint32
+}
+
+func (h *vbanHeader) put(p []byte) {
+	copy(p, "VBAN")
+	p[4] = byte(h.rate) | vbanProtocolAudio
+	p[5] = byte(h.frames - 1)
+	p[6] = byte(h.channels - 1)
+	p[7] = byte(h.format) | vbanCodecPCM
+	clear(p[8 : 8+vbanNameSize])
+	copy(p[8:8+vbanNameSize], h.name)
+	binary.LittleEndian.PutUint32(p[24:], h.counter)
+}
+
+// Reads the header of an audio packet. It fails for anything but uncompressed audio in a supported format
+// with as much data as the header says.
+func parseVBANHeader(p []byte) (h vbanHeader, ok bool) {
+	if len(p) < vbanHeaderSize || string(p[:4]) != "VBAN" || p[4]&0xE0 != vbanProtocolAudio || p[7]&0xF0 != vbanCodecPCM {
+		return h, false
+	}
+	h = vbanHeader{
+		rate:     int(p[4] & 0x1F),
+		frames:   int(p[5]) + 1,
+		channels: int(p[6]) + 1,
+		format:   VBANFormat(p[7] & 0x07),
+		counter:  binary.LittleEndian.Uint32(p[24:]),
+	}
+	name := p[8 : 8+vbanNameSize]
+	for i, c := range name {
+		if c == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	h.name = string(name)
+
+	st, supported := h.format.sampleType()
+	if h.rate >= len(vbanRates) || !supported {
+		return h, false
+	}
+	return h, len(p) >= vbanHeaderSize+h.frames*h.channels*st.BytesPerSample()
+}
+
+// Checks a stream name and sample rate. An empty name is allowed only where accept is set.
+func checkVBANStream(name string, sampleRate float64, accept bool) error {
+	if len(name) > vbanNameSize || name == "" && !accept {
+		return errors.New("VBAN: stream name must be 1 to 16 bytes")
+	}
+	if _, ok := vbanRateIndex(sampleRate); !ok {
+		return errors.New("VBAN: unsupported sample rate")
+	}
+	return nil
+}
+
+// VBANSenderOptions configure a VBANSender.
+type VBANSenderOptions struct {
+	Address    string     // host:port to send to; the port defaults to VBANPort
+	StreamName string     // defaults to "Stream1"
+	Format     VBANFormat // defaults to VBANInt16
+	Inputs     []int      // indexes into the stream's Inputs, in the order they are sent
+}
+
+// VBANSender sends inputs of a stream as a VBAN stream. The driver thread only queues the inputs; packets are
+// sent from a goroutine of the sender's own. If that falls behind, input buffers are dropped and Overruns
+// counts them.
+type VBANSender struct {
+	stream *Stream
+	conn   *net.UDPConn
+	addr   *net.UDPAddr
+	inputs []int
+	header vbanHeader
+	fifo   *frameRing
+
+	// Only touched on the driver thread:
+	view [][]float32
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+	err  error
+
+	sent     atomic.Int64
+	overruns atomic.Int64
+}
+
+// Creates a sender and adds it to the stream's taps.
+func NewVBANSender(s *Stream, opts VBANSenderOptions) (*VBANSender, error) {
+	if opts.StreamName == "" {
+		opts.StreamName = "Stream1"
+	}
+	if opts.Format == 0 {
+		opts.Format = VBANInt16
+	}
+	if err := checkVBANStream(opts.StreamName, s.SampleRate(), false); err != nil {
+		return nil, err
+	}
+	st, supported := opts.Format.sampleType()
+	channels := len(opts.Inputs)
+	if !supported || channels == 0 || channels*st.BytesPerSample() > vbanMaxData {
+		return nil, ErrorInvalidParameter
+	}
+	for _, ch := range opts.Inputs {
+		if ch < 0 || ch >= len(s.Inputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	addr, err := resolveVBANAddr(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := min(vbanMaxFrames, vbanMaxData/(channels*st.BytesPerSample()))
+	snd := &VBANSender{
+		stream: s,
+		conn:   conn,
+		addr:   addr,
+		inputs: opts.Inputs,
+		header: vbanHeader{frames: frames, channels: channels, format: opts.Format, name: opts.StreamName},
+		fifo:   newFrameRing(channels, max(int(s.SampleRate())/4, 2*s.BufferSize())),
+		view:   make([][]float32, channels),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	snd.wg.Add(1)
+	go snd.send()
+	s.AddTap(snd)
+	return snd, nil
+}
+
+func resolveVBANAddr(address string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(VBANPort))
+	}
+	return net.ResolveUDPAddr("udp", address)
+}
+
+func (snd *VBANSender) Process(b *Block) {
+	for ch, input := range snd.inputs {
+		snd.view[ch] = b.In[input]
+	}
+	if snd.fifo.write(snd.view) < b.Frames {
+		snd.overruns.Add(1)
+	}
+	select {
+	case snd.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (snd *VBANSender) send() {
+	defer snd.wg.Done()
+
+	h := snd.header
+	st, _ := h.format.sampleType()
+	frames := makeFloatBuffers(h.channels, h.frames)
+	interleaved := make([]float32, h.channels*h.frames)
+	packet := make([]byte, vbanHeaderSize+len(interleaved)*st.BytesPerSample())
+	for {
+		select {
+		case <-snd.wake:
+		case <-snd.stop:
+			return
+		}
+		for snd.fifo.available() >= h.frames {
+			snd.fifo.readInto(frames)
+			for ch, f := range frames {
+				for i, x := range f {
+					interleaved[i*h.channels+ch] = x
+				}
+			}
+			h.rate, _ = vbanRateIndex(snd.stream.SampleRate())
+			h.put(packet)
+			encodeSamples(packet[vbanHeaderSize:], interleaved, st)
+			if _, err := snd.conn.WriteToUDP(packet, snd.addr); err != nil {
+				if snd.err == nil {
+					snd.err = err
+				}
+				continue
+			}
+			h.counter++
+			snd.sent.Add(1)
+		}
+	}
+}
+
+// Returns the number of packets sent.
+func (snd *VBANSender) Sent() int64 { return snd.sent.Load() }
+
+// Returns how many input buffers were dropped because packets could not be sent fast enough.
+func (snd *VBANSender) Overruns() int64 { return snd.overruns.Load() }
+
+// Stops sending. It returns the first error sending a packet, if any.
+func (snd *VBANSender) Close() error {
+	snd.stream.RemoveTap(snd)
+	close(snd.stop)
+	snd.wg.Wait()
+	snd.conn.Close()
+	return snd.err
+}
+
+// VBANReceiverOptions configure a VBANReceiver.
+type VBANReceiverOptions struct {
+	Address    string        // local host:port to listen on; the port defaults to VBANPort, and ":0" picks one
+	StreamName string        // the stream to play; empty plays whichever stream arrives
+	Outputs    []int         // indexes into the stream's Outputs; channel i of the VBAN stream plays on Outputs[i]
+	Latency    time.Duration // held in the jitter buffer; defaults to 20ms, and is never less than a buffer
+}
+
+// VBANReceiver plays a VBAN stream to outputs of a stream, mixing it into whatever else is playing. Packets are
+// received on a goroutine of the receiver's own into a jitter buffer that the driver thread plays from once it
+// holds the latency. Packets lost on the way are played as silence so that the rest keep their timing; if the
+// jitter buffer runs dry, the outputs get silence until it has filled up again, and Underruns counts the buffer.
+// Packets of other streams, at another sample rate or in formats other than uncompressed PCM are ignored.
+type VBANReceiver struct {
+	stream  *Stream
+	conn    *net.UDPConn
+	name    string
+	outputs []int
+	fifo    *frameRing
+	target  int // frames to hold in the jitter buffer
+
+	// Only touched on the driver thread:
+	out     [][]float32
+	playing bool
+
+	wg sync.WaitGroup
+
+	received  atomic.Int64
+	lost      atomic.Int64
+	underruns atomic.Int64
+	overruns  atomic.Int64
+}
+
+// The largest gap in the frame counter filled with silence. Beyond it, the sender is taken to have restarted.
+const vbanMaxGap = 64
+
+// Creates a receiver listening on the options' address, and adds it to the stream's processors.
+func NewVBANReceiver(s *Stream, opts VBANReceiverOptions) (*VBANReceiver, error) {
+	if err := checkVBANStream(opts.StreamName, s.SampleRate(), true); err != nil {
+		return nil, err
+	}
+	if len(opts.Outputs) == 0 {
+		return nil, ErrorInvalidParameter
+	}
+	for _, ch := range opts.Outputs {
+		if ch < 0 || ch >= len(s.Outputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	if opts.Latency <= 0 {
+		opts.Latency = 20 * time.Millisecond
+	}
+	addr, err := resolveVBANAddr(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	target := max(int(opts.Latency.Seconds()*s.SampleRate()), s.BufferSize())
+	r := &VBANReceiver{
+		stream:  s,
+		conn:    conn,
+		name:    opts.StreamName,
+		fifo:    newFrameRing(len(opts.Outputs), max(int(s.SampleRate()), 4*target)),
+		target:  target,
+		outputs: opts.Outputs,
+		out:     makeFloatBuffers(len(opts.Outputs), s.BufferSize()),
+	}
+
+	r.wg.Add(1)
+	go r.receive()
+	s.AddProcessor(r)
+	return r, nil
+}
+
+// Returns the address the receiver listens on.
+func (r *VBANReceiver) Addr() net.Addr { return r.conn.LocalAddr() }
+
+func (r *VBANReceiver) receive() {
+	defer r.wg.Done()
+
+	packet := make([]byte, vbanMaxPacket)
+	interleaved := make([]float32, vbanMaxData/2)
+	frames := makeFloatBuffers(len(r.outputs), vbanMaxFrames)
+	silence := makeFloatBuffers(len(r.outputs), vbanMaxFrames)
+	view := make([][]float32, len(r.outputs))
+	var next uint32 // the frame counter expected next
+	started := false
+
+	for {
+		n, _, err := r.conn.ReadFromUDP(packet)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		h, ok := parseVBANHeader(packet[:n])
+		if !ok || r.name != "" && h.name != r.name || vbanRates[h.rate] != r.stream.SampleRate() {
+			continue
+		}
+
+		if gap := int32(h.counter - next); started && gap < 0 && gap > -vbanMaxGap {
+			// Late, or a duplicate; its place has been played or filled already.
+			continue
+		} else if started && gap > 0 && gap <= vbanMaxGap {
+			r.lost.Add(int64(gap))
+			for i := int32(0); i < gap; i++ {
+				r.write(silence, h.frames, view)
+			}
+		}
+		started, next = true, h.counter+1
+		r.received.Add(1)
+
+		st, _ := h.format.sampleType()
+		samples := interleaved[:h.frames*h.channels]
+		decodeSamples(samples, packet[vbanHeaderSize:], st)
+		for ch, f := range frames {
+			f = f[:h.frames]
+			if ch >= h.channels {
+				clear(f)
+				continue
+			}
+			for i := range f {
+				f[i] = samples[i*h.channels+ch]
+			}
+		}
+		r.write(frames, h.frames, view)
+	}
+}
+
+// Queues the first n frames of src in the jitter buffer.
+func (r *VBANReceiver) write(src [][]float32, n int, view [][]float32) {
+	for ch := range view {
+		view[ch] = src[ch][:n]
+	}
+	if r.fifo.write(view) < n {
+		r.overruns.Add(1)
+	}
+}
+
+func (r *VBANReceiver) Process(b *Block) {
+	available := r.fifo.available()
+	if !r.playing {
+		if available < r.target {
+			return
+		}
+		r.playing = true
+		r.fifo.skip(available - r.target)
+	} else if available > 2*r.target+b.Frames {
+		// The sender's clock runs fast, or packets came in a burst; drop back to the latency.
+		r.fifo.skip(available - r.target)
+	}
+
+	for ch := range r.out {
+		r.out[ch] = r.out[ch][:b.Frames]
+	}
+	n := r.fifo.readInto(r.out)
+	if n < b.Frames {
+		r.underruns.Add(1)
+		r.playing = false
+	}
+	for ch, output := range r.outputs {
+		dst := b.Out[output]
+		for i, x := range r.out[ch][:n] {
+			dst[i] += x
+		}
+	}
+}
+
+// Returns the number of packets played.
+func (r *VBANReceiver) Received() int64 { return r.received.Load() }
+
+// Returns the number of packets that never arrived, or arrived too late, and were played as silence.
+func (r *VBANReceiver) Lost() int64 { return r.lost.Load() }
+
+// Returns how many buffers the jitter buffer ran dry in.
+func (r *VBANReceiver) Underruns() int64 { return r.underruns.Load() }
+
+// Returns how many packets did not fit in the jitter buffer.
+func (r *VBANReceiver) Overruns() int64 { return r.overruns.Load() }
+
+// Stops receiving and removes the receiver from the stream.
+func (r *VBANReceiver) Close() error {
+	r.stream.RemoveProcessor(r)
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
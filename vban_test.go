@@ -0,0 +1,174 @@
+package asio
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Waits up to a second for cond to hold.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A ramp every VBAN format carries exactly.
+func vbanRamp(pos int64) float32 { return float32(pos%4096)/4096 - 0.5 }
+
+func TestVBANRoundTrip(t *testing.T) {
+	for _, format := range []VBANFormat{VBANInt16, VBANInt24, VBANInt32, VBANFloat32, VBANFloat64} {
+		txDrv := NewSimDriver(2, 0)
+		txDrv.Input = func(channel int, pos int64, buf []float32) {
+			for i := range buf {
+				buf[i] = vbanRamp(pos + int64(i))
+				if channel == 1 {
+					buf[i] = -buf[i]
+				}
+			}
+		}
+		tx := newTestStream(t, txDrv)
+		rxDrv := NewSimDriver(0, 3)
+		rx := newTestStream(t, rxDrv)
+		var played [3][]float32
+		rx.AddTap(ProcessorFunc(func(b *Block) {
+			for ch := range played {
+				played[ch] = append(played[ch], b.Out[ch]...)
+			}
+		}))
+
+		r, err := NewVBANReceiver(rx, VBANReceiverOptions{Address: "127.0.0.1:0", StreamName: "Mics", Outputs: []int{2, 0}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snd, err := NewVBANSender(tx, VBANSenderOptions{
+			Address:    r.Addr().String(),
+			StreamName: "Mics",
+			Format:     format,
+			Inputs:     []int{0, 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 40; i++ {
+			txDrv.Step()
+			packets := int64((i + 1) * 256 / snd.header.frames)
+			waitFor(t, "the packets", func() bool { return r.Received() == packets })
+			rxDrv.Step()
+		}
+		if err := snd.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+
+		start := 0
+		for start < len(played[2]) && played[2][start] == 0 {
+			start++
+		}
+		if start > 4*256 {
+			t.Fatalf("format %d: nothing played for %d frames", format, start)
+		}
+		delay := int64(start) - int64((played[2][start]+0.5)*4096)
+		for i := start; i < len(played[2]); i++ {
+			want := vbanRamp(int64(i) - delay)
+			if played[2][i] != want || played[0][i] != -want || played[1][i] != 0 {
+				t.Fatalf("format %d: frame %d is %v %v %v, want %v", format, i, played[2][i], played[0][i], played[1][i], want)
+			}
+		}
+		if r.Lost() != 0 || r.Underruns() != 0 || snd.Overruns() != 0 || snd.Sent() != r.Received() {
+			t.Errorf("format %d: %d lost, %d underruns, %d overruns, %d sent", format, r.Lost(), r.Underruns(), snd.Overruns(), snd.Sent())
+		}
+	}
+}
+
+// Builds a mono 16 bit packet of 256 frames at level v.
+func vbanTestPacket(name string, counter uint32, sampleRate float64, v float32) []byte {
+	rate, _ := vbanRateIndex(sampleRate)
+	h := vbanHeader{rate: rate, frames: 256, channels: 1, format: VBANInt16, name: name, counter: counter}
+	p := make([]byte, vbanHeaderSize+2*256)
+	h.put(p)
+	for i := 0; i < 256; i++ {
+		binary.LittleEndian.PutUint16(p[vbanHeaderSize+2*i:], uint16(int16(v*(1<<15))))
+	}
+	return p
+}
+
+func TestVBANReceiverLossAndFiltering(t *testing.T) {
+	drv := NewSimDriver(0, 1)
+	s := newTestStream(t, drv)
+	var played []float32
+	s.AddTap(ProcessorFunc(func(b *Block) { played = append(played, b.Out[0]...) }))
+	r, err := NewVBANReceiver(s, VBANReceiverOptions{Address: "127.0.0.1:0", StreamName: "Mics", Outputs: []int{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	conn, err := net.DialUDP("udp", nil, r.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, p := range [][]byte{
+		vbanTestPacket("Mics", 0, 48000, 1.0/64),
+		vbanTestPacket("Other", 1, 48000, 0.5),
+		vbanTestPacket("Mics", 1, 48000, 2.0/64),
+		vbanTestPacket("Mics", 2, 48000, 3.0/64),
+		vbanTestPacket("Mics", 3, 44100, 0.5),
+		vbanTestPacket("Mics", 4, 48000, 5.0/64),
+		vbanTestPacket("Mics", 3, 48000, 0.5), // too late
+		[]byte("VBAN but not really"),
+		vbanTestPacket("Mics", 5, 48000, 6.0/64),
+	} {
+		if _, err := conn.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the packets", func() bool { return r.Received() == 5 })
+	if r.Lost() != 1 {
+		t.Errorf("%d lost, want 1", r.Lost())
+	}
+
+	// Six packets' worth is queued, including the silence for the lost one, and the latency is 960 frames.
+	levels := []float32{1, 2, 3, 0, 5, 6}
+	for i := 0; i < 4; i++ {
+		drv.Step()
+	}
+	for i, x := range played {
+		want := float32(0)
+		if f := 6*256 - 960 + i; f < 6*256 {
+			want = levels[f/256] / 64
+		}
+		if x != want {
+			t.Fatalf("frame %d is %v, want %v", i, x, want)
+		}
+	}
+	if r.Underruns() != 1 {
+		t.Errorf("%d underruns, want 1", r.Underruns())
+	}
+}
+
+func TestVBANOptions(t *testing.T) {
+	drv := NewSimDriver(1, 1)
+	s := newTestStream(t, drv)
+	for _, opts := range []VBANSenderOptions{
+		{Address: "127.0.0.1", StreamName: "a name that is too long"},
+		{Address: "127.0.0.1", Inputs: []int{1}},
+		{Address: "127.0.0.1", Inputs: []int{0}, Format: 7},
+	} {
+		if snd, err := NewVBANSender(s, opts); err == nil {
+			snd.Close()
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+	if _, err := NewVBANReceiver(s, VBANReceiverOptions{Address: "127.0.0.1:0"}); err == nil {
+		t.Error("receiver without outputs accepted")
+	}
+}
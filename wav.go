@@ -0,0 +1,139 @@
+package asio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// The sample types a WAVRecorder can write.
+var wavSampleTypes = map[SampleType]bool{
+	ASIOSTInt16LSB:   true,
+	ASIOSTInt24LSB:   true,
+	ASIOSTInt32LSB:   true,
+	ASIOSTFloat32LSB: true,
+}
+
+// WAVRecorder records inputs of a stream to a WAV file. The file is written on its own goroutine; if it
+// falls behind, input buffers are dropped and Overruns counts them.
+type WAVRecorder struct {
+	stream *Stream
+	w      io.WriteSeeker
+	inputs []int
+	st     SampleType
+	queue  recordQueue[[][]float32]
+
+	// Only touched by the queue's goroutine until it is closed:
+	bw          *bufio.Writer
+	interleaved []float32
+	data        []byte
+	err         error
+
+	frames atomic.Int64
+}
+
+// Starts recording the given inputs to w as st, one of ASIOSTInt16LSB, ASIOSTInt24LSB, ASIOSTInt32LSB or
+// ASIOSTFloat32LSB, inputs[i] to file channel i, and adds the recorder to the stream's taps. w must be
+// positioned at the start of the file.
+func NewWAVRecorder(s *Stream, w io.WriteSeeker, st SampleType, inputs ...int) (*WAVRecorder, error) {
+	if !wavSampleTypes[st] || len(inputs) == 0 {
+		return nil, ErrorInvalidParameter
+	}
+	for _, ch := range inputs {
+		if ch < 0 || ch >= len(s.Inputs()) {
+			return nil, ErrorInvalidParameter
+		}
+	}
+	if _, err := w.Write(wavStreamHeader(len(inputs), s.SampleRate(), st)); err != nil {
+		return nil, err
+	}
+
+	// Half a second of buffers, to ride out slow writes.
+	queue := max(recordQueueBuffers, int(s.SampleRate())/2/s.BufferSize())
+	r := &WAVRecorder{
+		stream:      s,
+		w:           w,
+		inputs:      inputs,
+		st:          st,
+		bw:          bufio.NewWriterSize(w, 1<<16),
+		interleaved: make([]float32, len(inputs)*s.BufferSize()),
+	}
+	r.data = make([]byte, len(r.interleaved)*st.BytesPerSample())
+	r.queue.start(queue, func() [][]float32 { return makeFloatBuffers(len(inputs), s.BufferSize()) }, r.put)
+
+	s.AddTap(r)
+	return r, nil
+}
+
+func (r *WAVRecorder) put(blk [][]float32) {
+	if r.err != nil {
+		return
+	}
+	frames := len(blk[0])
+	for ch, f := range blk {
+		for i, x := range f {
+			r.interleaved[i*len(blk)+ch] = x
+		}
+	}
+	size := frames * len(blk)
+	encodeSamples(r.data, r.interleaved[:size], r.st)
+	if _, r.err = r.bw.Write(r.data[:size*r.st.BytesPerSample()]); r.err == nil {
+		r.frames.Add(int64(frames))
+	}
+}
+
+func (r *WAVRecorder) Process(b *Block) {
+	r.queue.queue(func(blk [][]float32) {
+		for i, in := range r.inputs {
+			blk[i] = blk[i][:b.Frames]
+			copy(blk[i], b.In[in])
+		}
+	})
+}
+
+// Returns the number of frames written to the file so far.
+func (r *WAVRecorder) Frames() int64 { return r.frames.Load() }
+
+// Returns how many input buffers were dropped because the file could not be written fast enough.
+func (r *WAVRecorder) Overruns() int64 { return r.queue.overruns.Load() }
+
+// Stops recording, writes what is queued, including a buffer switch still under way, and fills in the sizes in
+// the WAV header, completing the file. A recording too long for them keeps the largest sizes, as a stream
+// would. The underlying file is not closed.
+func (r *WAVRecorder) Close() error {
+	r.stream.RemoveTap(r)
+	r.queue.close()
+	if r.err == nil {
+		r.err = r.bw.Flush()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	size := uint64(r.frames.Load()) * uint64(len(r.inputs)*r.st.BytesPerSample())
+	pad := size & 1 // chunks are padded to an even size
+	if pad != 0 {
+		if _, err := r.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if size+pad+36 > 0xFFFFFFFF {
+		return nil
+	}
+	var b [4]byte
+	for _, f := range []struct {
+		offset int64
+		size   uint64
+	}{{4, size + pad + 36}, {40, size}} {
+		binary.LittleEndian.PutUint32(b[:], uint32(f.size))
+		if _, err := r.w.Seek(f.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := r.w.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	_, err := r.w.Seek(0, io.SeekEnd)
+	return err
+}
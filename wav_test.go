@@ -0,0 +1,69 @@
+package asio
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAVRecorder(t *testing.T) {
+	drv := NewSimDriver(2, 2)
+	drv.Input = func(channel int, pos int64, buf []float32) {
+		for i := range buf {
+			v := vbanRamp(pos + int64(i))
+			if channel == 1 {
+				v = -v
+			}
+			buf[i] = v
+		}
+	}
+	s := newTestStream(t, drv)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "rec.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewWAVRecorder(s, f, ASIOSTInt24LSB, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		drv.Step()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Frames() != 2560 || r.Overruns() != 0 {
+		t.Errorf("%d frames, %d overruns", r.Frames(), r.Overruns())
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 2560 * 2 * 3
+	le := binary.LittleEndian
+	if len(data) != 44+size || string(data[:4]) != "RIFF" || le.Uint32(data[4:]) != uint32(36+size) ||
+		le.Uint16(data[22:]) != 2 || le.Uint32(data[24:]) != 48000 || le.Uint16(data[34:]) != 24 ||
+		le.Uint32(data[40:]) != uint32(size) {
+		t.Fatalf("%d bytes, header % x", len(data), data[:44])
+	}
+	samples := make([]float32, 2560*2)
+	decodeSamples(samples, data[44:], ASIOSTInt24LSB)
+	for i := 0; i < 2560; i++ {
+		want := vbanRamp(int64(i))
+		if math.Abs(float64(samples[2*i]+want)) > 1e-6 || math.Abs(float64(samples[2*i+1]-want)) > 1e-6 {
+			t.Fatalf("frame %d: %v, want [%v %v]", i, samples[2*i:2*i+2], -want, want)
+		}
+	}
+
+	if _, err := NewWAVRecorder(s, f, ASIOSTInt32MSB, 0); err != ErrorInvalidParameter {
+		t.Errorf("big-endian samples: %v", err)
+	}
+	if _, err := NewWAVRecorder(s, f, ASIOSTInt16LSB, 2); err != ErrorInvalidParameter {
+		t.Errorf("input 2: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package asio
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Just enough of RFC 6455 WebSockets to serve a browser: the handshake, unfragmented and fragmented text and
+// binary messages, ping and close.
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	wsMaxMessage = 1 << 16
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWSProtocol = errors.New("websocket: protocol error")
+
+type wsConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // masks what it sends, as clients must
+
+	wmu sync.Mutex // serializes frames
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Completes the opening handshake of a WebSocket request and takes over its connection. On failure it has
+// replied with an error status.
+func upgradeWebSocket(w http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || !headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errWSProtocol
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errWSProtocol
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot take over the connection", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// Writes one frame with the final bit set.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var header [14]byte
+	header[0] = 0x80 | opcode
+	n := 2
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n = 10
+	}
+	if c.client {
+		header[1] |= 0x80
+		key := header[n : n+4]
+		rand.Read(key)
+		n += 4
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ key[i%4]
+		}
+		payload = masked
+	}
+	if _, err := c.conn.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// Sends a text message.
+func (c *wsConn) writeText(msg []byte) error { return c.writeFrame(wsText, msg) }
+
+// Reads the next text or binary message, answering pings on the way. It returns io.EOF once the peer has
+// closed the connection, after replying to its close frame.
+func (c *wsConn) readMessage() (opcode byte, msg []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			return 0, nil, io.EOF
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, errWSProtocol
+			}
+			opcode = op
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, errWSProtocol
+			}
+		default:
+			return 0, nil, errWSProtocol
+		}
+		if len(msg)+len(payload) > wsMaxMessage {
+			return 0, nil, errors.New("websocket: message too long")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return opcode, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	masked := header[1]&0x80 != 0
+	if header[0]&0x70 != 0 || masked == c.client {
+		// Reserved bits, or masking the wrong way round.
+		return false, 0, nil, errWSProtocol
+	}
+	size := uint64(header[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > wsMaxMessage || opcode >= wsClose && (size > 125 || !fin) {
+		return false, 0, nil, errWSProtocol
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// Sends a close frame and closes the connection.
+func (c *wsConn) close() error {
+	c.writeFrame(wsClose, []byte{0x03, 0xE8}) // 1000, normal closure
+	return c.conn.Close()
+}
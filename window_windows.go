@@ -0,0 +1,91 @@
+package asio
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32, _ = syscall.LoadLibrary("user32.dll")
+
+	procCreateWindowExW, _  = syscall.GetProcAddress(user32, "CreateWindowExW")
+	procPeekMessageW, _     = syscall.GetProcAddress(user32, "PeekMessageW")
+	procTranslateMessage, _ = syscall.GetProcAddress(user32, "TranslateMessage")
+	procDispatchMessageW, _ = syscall.GetProcAddress(user32, "DispatchMessageW")
+)
+
+const (
+	hwndMessage = ^uintptr(2) // HWND_MESSAGE, (HWND)-3
+	pmRemove    = 1
+)
+
+type winMSG struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+	private uint32
+}
+
+var (
+	driverWindowOnce sync.Once
+	driverWindowHWND uintptr
+	driverWindowErr  error
+)
+
+// Returns the hidden message-only window that drivers opened without a window of the host's get, creating it in
+// the driver apartment, whose thread dispatches its messages, on first use.
+func driverWindow() (uintptr, error) {
+	driverWindowOnce.Do(func() {
+		err := comApartment.Do(func() { driverWindowHWND, driverWindowErr = createMessageWindow() })
+		if err != nil {
+			driverWindowErr = err
+		}
+	})
+	return driverWindowHWND, driverWindowErr
+}
+
+func createMessageWindow() (uintptr, error) {
+	class, err := syscall.UTF16PtrFromString("STATIC")
+	if err != nil {
+		return 0, err
+	}
+	title, err := syscall.UTF16PtrFromString("go-asio")
+	if err != nil {
+		return 0, err
+	}
+	hwnd, _, errno := syscall.Syscall12(procCreateWindowExW, 12,
+		0,
+		uintptr(unsafe.Pointer(class)),
+		uintptr(unsafe.Pointer(title)),
+		0,
+		0, 0, 0, 0,
+		hwndMessage,
+		0,
+		0,
+		0)
+	if hwnd == 0 {
+		if errno != 0 {
+			return 0, errno
+		}
+		return 0, errors.New("asio: could not create a message window")
+	}
+	return hwnd, nil
+}
+
+// Dispatches every message waiting for the windows of the calling thread.
+func pumpMessages() {
+	var msg winMSG
+	for {
+		r1, _, _ := syscall.Syscall6(procPeekMessageW, 5, uintptr(unsafe.Pointer(&msg)), 0, 0, 0, pmRemove, 0)
+		if r1 == 0 {
+			return
+		}
+		syscall.Syscall(procTranslateMessage, 1, uintptr(unsafe.Pointer(&msg)), 0, 0)
+		syscall.Syscall(procDispatchMessageW, 1, uintptr(unsafe.Pointer(&msg)), 0, 0)
+	}
+}